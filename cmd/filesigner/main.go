@@ -0,0 +1,243 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/BurntSushi/toml"
+	arg "github.com/alexflint/go-arg"
+	"github.com/ndau/ndaumath/pkg/signature"
+	"github.com/pkg/errors"
+)
+
+type args struct {
+	Keys   string `arg:"positional,required" help:"TOML file of private keys"`
+	Socket string `arg:"-s" help:"serve requests on this unix socket instead of signing a single request from stdin"`
+	Log    string `arg:"-l" help:"append a line describing each signed tx to this file"`
+}
+
+func (args) Description() string {
+	return `
+	A reference external signer for the ndau tool.
+
+	It reads private keys from a file, and signs txs with them using the ndau
+	tool's external signer protocol. It performs no approval or policy checks,
+	so it is only suitable for tests and development.
+
+	The keys file is keyed by account address or name:
+
+		[accounts.ndaxxxx]
+		keys = ["npvtayjadtcbid...", "npvtayjadtcbie..."]
+
+	Configure the ndau tool to use it in signers.toml, next to ndautool.toml:
+
+		[signers.myaccount]
+		command = "filesigner /path/to/keys.toml"
+
+	or, to serve over a socket:
+
+		filesigner -s /tmp/signer.sock /path/to/keys.toml
+
+		[signers.myaccount]
+		socket = "/tmp/signer.sock"
+`
+}
+
+// keysFile is the layout of the keys file
+type keysFile struct {
+	Accounts map[string]struct {
+		Keys []signature.PrivateKey `toml:"keys"`
+	} `toml:"accounts"`
+}
+
+// request is a signing request from the ndau tool
+type request struct {
+	Version       int    `json:"version"`
+	Account       string `json:"account"`
+	Name          string `json:"name,omitempty"`
+	Role          string `json:"role"`
+	Keys          int    `json:"keys"`
+	TxType        string `json:"txtype"`
+	Summary       string `json:"summary"`
+	SignableBytes []byte `json:"signable_bytes"`
+}
+
+// response is returned to the ndau tool
+type response struct {
+	Signatures []signature.Signature `json:"signatures"`
+	Error      string                `json:"error,omitempty"`
+}
+
+type signer struct {
+	keys keysFile
+	log  io.Writer
+}
+
+func check(err error, context string) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, errors.Wrap(err, context))
+		os.Exit(1)
+	}
+}
+
+// filterK selects the keys whose bits are set in k, treating a negative k as all keys
+func filterK(keys []signature.PrivateKey, k int) []signature.PrivateKey {
+	if k < 0 {
+		return keys
+	}
+	out := make([]signature.PrivateKey, 0, len(keys))
+	for idx := 0; k > 0 && idx < len(keys); idx++ {
+		if k&1 > 0 {
+			out = append(out, keys[idx])
+		}
+		k >>= 1
+	}
+	return out
+}
+
+// sign handles a single request
+func (s *signer) sign(req request) response {
+	if req.Version != 1 {
+		return response{Error: fmt.Sprintf("unsupported protocol version %d", req.Version)}
+	}
+	acct, ok := s.keys.Accounts[req.Account]
+	if !ok && req.Name != "" {
+		acct, ok = s.keys.Accounts[req.Name]
+	}
+	if !ok {
+		return response{Error: fmt.Sprintf("no keys for %s", req.Account)}
+	}
+
+	keys := filterK(acct.Keys, req.Keys)
+	if len(keys) == 0 {
+		return response{Error: "no keys selected"}
+	}
+	resp := response{}
+	for _, key := range keys {
+		resp.Signatures = append(resp.Signatures, key.Sign(req.SignableBytes))
+	}
+	if s.log != nil {
+		fmt.Fprintf(s.log, "%s: signed with %d keys: %s\n", req.Account, len(keys), req.Summary)
+	}
+	return resp
+}
+
+// handle reads a request from r and writes the response to w
+func (s *signer) handle(r io.Reader, w io.Writer) error {
+	line, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "reading request")
+	}
+	req := request{}
+	resp := response{}
+	if err = json.Unmarshal(line, &req); err != nil {
+		resp.Error = errors.Wrap(err, "parsing request").Error()
+	} else {
+		resp = s.sign(req)
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return errors.Wrap(err, "marshaling response")
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// listenUnix listens on a unix socket for the signer's owner alone.
+//
+// Anyone who can connect can get txs signed, so the socket must never be
+// reachable by others, even briefly: it is created in a private directory,
+// made private itself, and only then moved to path.
+func listenUnix(path string) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".sock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// the socket won't be at tmp by the time it's closed
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	err = os.Chmod(tmp, 0600)
+	if err == nil {
+		os.Remove(path)
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func (s *signer) serve(path string) error {
+	ln, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			// closed by signal
+			return nil
+		}
+		err = s.handle(conn, conn)
+		conn.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+func main() {
+	var args args
+	arg.MustParse(&args)
+
+	data, err := ioutil.ReadFile(args.Keys)
+	check(err, "reading keys")
+	s := signer{}
+	err = toml.Unmarshal(data, &s.keys)
+	check(err, "parsing keys")
+
+	if args.Log != "" {
+		f, err := os.OpenFile(args.Log, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		check(err, "opening log")
+		defer f.Close()
+		s.log = f
+	}
+
+	if args.Socket != "" {
+		check(s.serve(args.Socket), "serving")
+		return
+	}
+	check(s.handle(os.Stdin, os.Stdout), "signing")
+}
@@ -0,0 +1,103 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ndau/ndaumath/pkg/signature"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	pub1, pvt1, err := signature.Generate(signature.Ed25519, nil)
+	require.NoError(t, err)
+	pub2, pvt2, err := signature.Generate(signature.Ed25519, nil)
+	require.NoError(t, err)
+
+	s := signer{}
+	s.keys.Accounts = map[string]struct {
+		Keys []signature.PrivateKey `toml:"keys"`
+	}{
+		"ndaaccount": {Keys: []signature.PrivateKey{pvt1, pvt2}},
+	}
+
+	msg := []byte("signable bytes")
+	cases := []struct {
+		name    string
+		keys    int
+		wantPub []signature.PublicKey
+		wantErr bool
+	}{
+		{"all keys", -1, []signature.PublicKey{pub1, pub2}, false},
+		{"first key", 1, []signature.PublicKey{pub1}, false},
+		{"second key", 2, []signature.PublicKey{pub2}, false},
+		{"no keys", 0, nil, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reqjs, err := json.Marshal(request{
+				Version:       1,
+				Account:       "ndaaccount",
+				Keys:          tc.keys,
+				SignableBytes: msg,
+			})
+			require.NoError(t, err)
+
+			out := new(bytes.Buffer)
+			err = s.handle(bytes.NewReader(reqjs), out)
+			require.NoError(t, err)
+
+			resp := response{}
+			require.NoError(t, json.Unmarshal(out.Bytes(), &resp))
+			if tc.wantErr {
+				require.NotEmpty(t, resp.Error)
+				return
+			}
+			require.Empty(t, resp.Error)
+			require.Len(t, resp.Signatures, len(tc.wantPub))
+			for idx, pub := range tc.wantPub {
+				require.True(t, pub.Verify(msg, resp.Signatures[idx]))
+			}
+		})
+	}
+}
+
+func TestUnknownAccount(t *testing.T) {
+	s := signer{}
+	resp := s.sign(request{Version: 1, Account: "ndanobody"})
+	require.NotEmpty(t, resp.Error)
+}
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "signer.sock")
+	// a stale socket from a signer which didn't exit cleanly
+	require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+
+	ln, err := listenUnix(path)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.ModeSocket, fi.Mode().Type())
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// the private directory it was created in is gone
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
@@ -69,6 +69,18 @@ func getAccount(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd
 			getAccountQuery(verbose, emitJSON, compact),
 		)
 
+		cmd.Command(
+			"project",
+			"project this account's EAI over time, optionally for a hypothetical lock or balance",
+			getAccountProject(verbose),
+		)
+
+		cmd.Command(
+			"export",
+			"export this account's transaction history as a ledger",
+			getAccountExport(verbose),
+		)
+
 		cmd.Command(
 			"change-recourse-period",
 			"change the recourse period for outbound transfers from this account",
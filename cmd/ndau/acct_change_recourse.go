@@ -14,7 +14,6 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
 	"github.com/pkg/errors"
 )
 
@@ -36,16 +35,18 @@ func getAccountChangeRecourse(verbose *bool, keys *int, emitJSON, compact *bool)
 			if !hasAd {
 				orQuit(errors.New("No such account found"))
 			}
-			if ad.Validation == nil {
+			if !hasValidation(ad) {
 				orQuit(errors.New("Address validation key not set"))
 			}
 
+			signer := accountSigner(ad, *keys)
 			cep := ndau.NewChangeRecoursePeriod(
 				ad.Address,
 				duration,
 				sequence(config, ad.Address),
-				ad.ValidationPrivateK(*keys)...,
+				signer.Keys()...,
 			)
+			signer.Sign(cep)
 
 			if *verbose {
 				fmt.Printf(
@@ -56,7 +57,7 @@ func getAccountChangeRecourse(verbose *bool, keys *int, emitJSON, compact *bool)
 				)
 			}
 
-			resp, err := tool.SendCommit(tmnode(config.Node, emitJSON, compact), cep)
+			resp, err := sendTx(tmnode(config.Node, emitJSON, compact), cep)
 			finish(*verbose, resp, err, "change-recourse-period")
 		}
 	}
@@ -13,14 +13,11 @@ import (
 	"fmt"
 
 	cli "github.com/jawher/mow.cli"
-	"github.com/ndau/metanode/pkg/meta/app/code"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
 	config "github.com/ndau/ndau/pkg/tool.config"
 	"github.com/ndau/ndaumath/pkg/signature"
 	math "github.com/ndau/ndaumath/pkg/types"
 	"github.com/pkg/errors"
-	rpc "github.com/tendermint/tendermint/rpc/core/types"
 )
 
 func getAccountCreateChild(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd) {
@@ -58,7 +55,7 @@ func getAccountCreateChild(verbose *bool, keys *int, emitJSON, compact *bool) fu
 			}
 
 			// Transaction validation would catch this, but it's helpful to catch it early.
-			if len(parentAcct.Validation) == 0 {
+			if !hasValidation(parentAcct) {
 				orQuit(errors.New("Parent account has no validation rules"))
 			}
 
@@ -86,6 +83,7 @@ func getAccountCreateChild(verbose *bool, keys *int, emitJSON, compact *bool) fu
 			newChildKeys, err := childAcct.MakeValidationKey(nil)
 			orQuit(err)
 
+			signer := accountSigner(parentAcct, *keys)
 			cca := ndau.NewCreateChildAccount(
 				parentAcct.Address,
 				childAcct.Address,
@@ -96,16 +94,17 @@ func getAccountCreateChild(verbose *bool, keys *int, emitJSON, compact *bool) fu
 				childAcct.ValidationScript,
 				getDelegate(),
 				sequence(conf, parentAcct.Address),
-				parentAcct.ValidationPrivateK(*keys)...,
+				signer.Keys()...,
 			)
+			signer.Sign(cca)
 
-			resp, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), cca)
+			resp, err := sendCommitTx(tmnode(conf.Node, emitJSON, compact), cca)
 
 			// Only persist this change if there was no error.
-			if err == nil && code.ReturnCode(resp.(*rpc.ResultBroadcastTxCommit).DeliverTx.Code) == code.OK {
+			if err == nil && committedOK(resp) {
 				childAcct.Validation = []config.Keypair{*newChildKeys}
 				conf.SetAccount(*childAcct)
-				err = conf.Save()
+				err = saveConfig(conf)
 				orQuit(errors.Wrap(err, "saving config"))
 			}
 			finish(*verbose, resp, err, "account create child")
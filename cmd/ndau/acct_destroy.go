@@ -56,7 +56,7 @@ func getAccountDestroy(verbose *bool) func(*cli.Cmd) {
 			delete(conf.Accounts, acct.Name)
 			delete(conf.Accounts, acct.Address.String())
 
-			orQuit(conf.Save())
+			orQuit(saveConfig(conf))
 
 			if *verbose {
 				fmt.Println("destroyed acct:", *name)
@@ -0,0 +1,405 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cli "github.com/jawher/mow.cli"
+	"github.com/ndau/ndau/pkg/ndau"
+	"github.com/ndau/ndaumath/pkg/address"
+	math "github.com/ndau/ndaumath/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// historyPage is the number of history items requested at once
+const historyPage = 100
+
+// apiTimeout limits each request to the ndauapi
+const apiTimeout = 30 * time.Second
+
+// A ledgerEntry is a single change to an account's balance
+//
+// For every entry, the change in balance is Amount - Fee - SIB + EAI.
+type ledgerEntry struct {
+	Timestamp    math.Timestamp `json:"timestamp"`
+	Height       int64          `json:"height"`
+	Offset       int            `json:"offset"`
+	TxHash       string         `json:"txhash"`
+	TxType       string         `json:"txtype"`
+	Counterparty string         `json:"counterparty,omitempty"`
+	Amount       math.Ndau      `json:"amount"`
+	Fee          math.Ndau      `json:"fee"`
+	SIB          math.Ndau      `json:"sib"`
+	EAI          math.Ndau      `json:"eai"`
+	Balance      math.Ndau      `json:"balance"`
+}
+
+var ledgerHeader = []string{
+	"timestamp", "height", "offset", "txhash", "txtype", "counterparty",
+	"amount", "fee", "sib", "eai", "balance",
+}
+
+func (e ledgerEntry) record() []string {
+	return []string{
+		e.Timestamp.String(),
+		strconv.FormatInt(e.Height, 10),
+		strconv.Itoa(e.Offset),
+		e.TxHash,
+		e.TxType,
+		e.Counterparty,
+		e.Amount.String(),
+		e.Fee.String(),
+		e.SIB.String(),
+		e.EAI.String(),
+		e.Balance.String(),
+	}
+}
+
+// historyItem is an item of the ndauapi's account history
+type historyItem struct {
+	Balance   math.Ndau
+	Timestamp string
+	TxHash    string
+	Height    int64
+}
+
+// txDetail is the ndauapi's description of a tx
+type txDetail struct {
+	BlockHeight int64
+	TxOffset    int
+	Fee         uint64
+	SIB         uint64
+	TxHash      string
+	TxType      string
+	TxData      json.RawMessage
+	Timestamp   string
+}
+
+type apiClient struct {
+	base *url.URL
+	http *http.Client
+}
+
+func newAPIClient(api string) (*apiClient, error) {
+	base, err := url.Parse(api)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing api address")
+	}
+	return &apiClient{base: base, http: &http.Client{Timeout: apiTimeout}}, nil
+}
+
+func (c *apiClient) get(out interface{}, path string, query url.Values) error {
+	u := *c.base
+	u.Path = strings.TrimRight(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+	resp, err := c.http.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "reading response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s: %s", u.String(), resp.Status, strings.TrimSpace(string(data)))
+	}
+	return errors.Wrap(json.Unmarshal(data, out), "decoding "+path)
+}
+
+// history retrieves an account's complete balance history
+func (c *apiClient) history(addr address.Address) ([]historyItem, error) {
+	return pageHistory(func(after int64) ([]historyItem, bool, error) {
+		page := struct {
+			Items []historyItem
+			Next  string
+		}{}
+		err := c.get(&page, "/account/history/"+addr.String(), url.Values{
+			"limit": {strconv.Itoa(historyPage)},
+			"after": {strconv.FormatInt(after, 10)},
+		})
+		return page.Items, page.Next != "", errors.Wrap(err, "getting account history")
+	})
+}
+
+// pageHistory collects every page of an account's history
+//
+// The ndauapi pages by block height, so a page can end partway through the
+// txs of a block. The next page is therefore requested from the block
+// before that one, and the txs which were already collected are skipped.
+func pageHistory(fetch func(after int64) ([]historyItem, bool, error)) ([]historyItem, error) {
+	var items []historyItem
+	var lastHeight int64
+	// hashes of the txs collected at lastHeight
+	seen := make(map[string]bool)
+	for {
+		after := int64(0)
+		if lastHeight > 0 {
+			after = lastHeight - 1
+		}
+		page, more, err := fetch(after)
+		if err != nil {
+			return nil, err
+		}
+		progress := false
+		for _, item := range page {
+			if item.Height < lastHeight || (item.Height == lastHeight && seen[item.TxHash]) {
+				continue
+			}
+			items = append(items, item)
+			if item.Height != lastHeight {
+				lastHeight = item.Height
+				seen = make(map[string]bool)
+			}
+			seen[item.TxHash] = true
+			progress = true
+		}
+		if !more || len(page) == 0 {
+			return items, nil
+		}
+		if !progress {
+			return nil, fmt.Errorf("block %d has more txs for this account than fit in a page of history", lastHeight)
+		}
+	}
+}
+
+func (c *apiClient) tx(hash string) (*txDetail, error) {
+	td := new(txDetail)
+	err := c.get(td, "/transaction/detail/"+url.PathEscape(hash), nil)
+	return td, errors.Wrap(err, "getting tx "+hash)
+}
+
+// ledger converts an account's history to ledger entries
+func ledger(addr address.Address, items []historyItem, details map[string]*txDetail) ([]ledgerEntry, error) {
+	entries := make([]ledgerEntry, 0, len(items))
+	for _, item := range items {
+		td := details[item.TxHash]
+		if td == nil {
+			return nil, fmt.Errorf("no details for tx %s", item.TxHash)
+		}
+		ts, err := math.ParseTimestamp(item.Timestamp)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing timestamp of "+item.TxHash)
+		}
+		entries = append(entries, ledgerEntry{
+			Timestamp: ts,
+			Height:    item.Height,
+			Offset:    td.TxOffset,
+			TxHash:    item.TxHash,
+			TxType:    td.TxType,
+			Balance:   item.Balance,
+		})
+		entry := &entries[len(entries)-1]
+
+		tx, err := ndau.TxFromName(td.TxType)
+		if err != nil {
+			return nil, errors.Wrap(err, item.TxHash)
+		}
+		if err = json.Unmarshal(td.TxData, tx); err != nil {
+			return nil, errors.Wrap(err, "decoding tx "+item.TxHash)
+		}
+
+		// the account which signs a tx pays its fees
+		var payer address.Address
+		switch t := tx.(type) {
+		case *ndau.Transfer:
+			payer = t.Source
+			entry.Amount, entry.Counterparty = transferAmount(addr, t.Source, t.Destination, t.Qty)
+		case *ndau.TransferAndLock:
+			payer = t.Source
+			entry.Amount, entry.Counterparty = transferAmount(addr, t.Source, t.Destination, t.Qty)
+		case *ndau.CreditEAI:
+			payer = t.Node
+			entry.Counterparty = t.Node.String()
+		case *ndau.Stake:
+			payer = t.Target
+			entry.Counterparty = t.StakeTo.String()
+		case *ndau.Unstake:
+			payer = t.Target
+			entry.Counterparty = t.StakeTo.String()
+		default:
+			// most other txs name the account they act on as the target
+			var target struct {
+				Target address.Address `json:"target"`
+			}
+			if err = json.Unmarshal(td.TxData, &target); err != nil {
+				return nil, errors.Wrap(err, "decoding target of tx "+item.TxHash)
+			}
+			payer = target.Target
+		}
+		if payer == addr {
+			entry.Fee = math.Ndau(td.Fee)
+			entry.SIB = math.Ndau(td.SIB)
+		}
+	}
+
+	// stable ordering: history is ordered by height, but not by offset
+	// within a block
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Height != entries[j].Height {
+			return entries[i].Height < entries[j].Height
+		}
+		return entries[i].Offset < entries[j].Offset
+	})
+
+	// whatever isn't explained by the tx itself is EAI for CreditEAI, and
+	// an unclassified change to the amount otherwise
+	prev := math.Ndau(0)
+	for i := range entries {
+		e := &entries[i]
+		change := e.Balance - prev - e.Amount + e.Fee + e.SIB
+		if e.TxType == "CreditEAI" {
+			e.EAI = change
+		} else {
+			e.Amount += change
+		}
+		prev = e.Balance
+	}
+	return entries, nil
+}
+
+// transferAmount is the signed amount of a transfer from addr's perspective,
+// and the other party to it
+func transferAmount(addr, src, dest address.Address, qty math.Ndau) (math.Ndau, string) {
+	if src == addr {
+		return -qty, dest.String()
+	}
+	return qty, src.String()
+}
+
+// parseDate parses a date or RFC3339 timestamp
+//
+// Bare dates are expanded to cover the whole day: to the beginning of the day
+// if start is set, and to the end of it otherwise.
+func parseDate(s string, start bool) (math.Timestamp, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return math.TimestampFrom(t)
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: expected YYYY-MM-DD or RFC3339 timestamp", s)
+	}
+	if !start {
+		t = t.Add(24*time.Hour - time.Microsecond)
+	}
+	return math.TimestampFrom(t)
+}
+
+func writeLedger(w io.Writer, entries []ledgerEntry, asJSON bool) error {
+	if asJSON {
+		js, err := jsonify(entries)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, js)
+		return err
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(ledgerHeader)
+	for _, e := range entries {
+		cw.Write(e.record())
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func getAccountExport(verbose *bool) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = fmt.Sprintf(
+			"%s [--api=<URL>] [--from=<DATE>] [--to=<DATE>] [--json] [-o=<PATH>]",
+			getAddressSpec(""),
+		)
+
+		var (
+			getAddress = getAddressClosure(cmd, "")
+			api        = cmd.StringOpt("api", "", "ndauapi address (default: the active profile's)")
+			fromS      = cmd.StringOpt("from", "", "omit entries before this date")
+			toS        = cmd.StringOpt("to", "", "omit entries after this date")
+			emitJSON   = cmd.BoolOpt("json", false, "emit JSON instead of CSV")
+			outPath    = cmd.StringOpt("o out", "", "write the ledger to this file (default: stdout)")
+		)
+
+		cmd.Action = func() {
+			addr := getAddress()
+
+			var from, to *math.Timestamp
+			if *fromS != "" {
+				ts, err := parseDate(*fromS, true)
+				orQuit(errors.Wrap(err, "parsing --from"))
+				from = &ts
+			}
+			if *toS != "" {
+				ts, err := parseDate(*toS, false)
+				orQuit(errors.Wrap(err, "parsing --to"))
+				to = &ts
+			}
+
+			if *api == "" {
+				// selects the active profile
+				getConfig()
+				var err error
+				*api, err = apiAddress()
+				orQuit(err)
+			}
+			client, err := newAPIClient(*api)
+			orQuit(err)
+
+			// the whole history is needed even when filtering by date, so
+			// that amounts can be computed from changes in balance
+			items, err := client.history(addr)
+			orQuit(err)
+			details := make(map[string]*txDetail, len(items))
+			for idx, item := range items {
+				if _, ok := details[item.TxHash]; ok {
+					continue
+				}
+				if *verbose {
+					fmt.Fprintf(os.Stderr, "fetching tx %d of %d\n", idx+1, len(items))
+				}
+				details[item.TxHash], err = client.tx(item.TxHash)
+				orQuit(err)
+			}
+			entries, err := ledger(addr, items, details)
+			orQuit(err)
+
+			filtered := entries[:0]
+			for _, e := range entries {
+				if from != nil && e.Timestamp.Compare(*from) < 0 {
+					continue
+				}
+				if to != nil && e.Timestamp.Compare(*to) > 0 {
+					continue
+				}
+				filtered = append(filtered, e)
+			}
+
+			out := io.Writer(os.Stdout)
+			if *outPath != "" {
+				f, err := os.Create(*outPath)
+				orQuit(err)
+				defer f.Close()
+				out = f
+			}
+			orQuit(writeLedger(out, filtered, *emitJSON))
+		}
+	}
+}
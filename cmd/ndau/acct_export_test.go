@@ -0,0 +1,180 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	metatx "github.com/ndau/metanode/pkg/meta/transaction"
+	"github.com/ndau/ndau/pkg/ndau"
+	"github.com/ndau/ndaumath/pkg/address"
+	math "github.com/ndau/ndaumath/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAPIHistory serves items in pages of at most limit, the way the
+// ndauapi does: each page holds the items above the requested height
+func fakeAPIHistory(items []historyItem, limit int) func(int64) ([]historyItem, bool, error) {
+	return func(after int64) ([]historyItem, bool, error) {
+		var page []historyItem
+		for _, item := range items {
+			if item.Height > after {
+				if len(page) == limit {
+					return page, true, nil
+				}
+				page = append(page, item)
+			}
+		}
+		return page, false, nil
+	}
+}
+
+func TestPageHistory(t *testing.T) {
+	// blocks 2 and 5 have several txs for the account; a page of 7 ends
+	// partway through block 5
+	var items []historyItem
+	for i, height := range []int64{1, 2, 2, 2, 3, 4, 5, 5, 6, 7} {
+		items = append(items, historyItem{Height: height, TxHash: fmt.Sprintf("tx%d", i)})
+	}
+
+	for _, limit := range []int{4, 7, 10} {
+		t.Run(fmt.Sprintf("limit %d", limit), func(t *testing.T) {
+			got, err := pageHistory(fakeAPIHistory(items, limit))
+			require.NoError(t, err)
+			require.Equal(t, items, got)
+		})
+	}
+
+	t.Run("block larger than a page", func(t *testing.T) {
+		_, err := pageHistory(fakeAPIHistory(items, 2))
+		require.Error(t, err)
+	})
+}
+
+func TestTransferAmount(t *testing.T) {
+	a := testAddress(t, 1)
+	b := testAddress(t, 2)
+	tests := []struct {
+		name         string
+		src, dest    address.Address
+		wantAmount   math.Ndau
+		counterparty address.Address
+	}{
+		{"outgoing", a, b, -5, b},
+		{"incoming", b, a, 5, b},
+		{"to self", a, a, -5, a},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amount, other := transferAmount(a, tt.src, tt.dest, 5)
+			require.Equal(t, tt.wantAmount, amount)
+			require.Equal(t, tt.counterparty.String(), other)
+		})
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	ts := func(t time.Time) math.Timestamp {
+		out, err := math.TimestampFrom(t)
+		if err != nil {
+			panic(err)
+		}
+		return out
+	}
+	tests := []struct {
+		s       string
+		start   bool
+		want    math.Timestamp
+		wantErr bool
+	}{
+		{"2020-03-01", true, ts(day), false},
+		{"2020-03-01", false, ts(day.Add(24*time.Hour - time.Microsecond)), false},
+		{"2020-03-01T12:00:00Z", true, ts(day.Add(12 * time.Hour)), false},
+		{"2020-03-01T12:00:00Z", false, ts(day.Add(12 * time.Hour)), false},
+		{"2020-03-01T12:00:00+01:00", true, ts(day.Add(11 * time.Hour)), false},
+		{"03/01/2020", true, 0, true},
+		{"", true, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %t", tt.s, tt.start), func(t *testing.T) {
+			got, err := parseDate(tt.s, tt.start)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLedger(t *testing.T) {
+	a := testAddress(t, 1)
+	b := testAddress(t, 2)
+	node := testAddress(t, 3)
+	ts := math.Timestamp(1000000).String()
+	tsv, err := math.ParseTimestamp(ts)
+	require.NoError(t, err)
+
+	detail := func(tx metatx.Transactable, height int64, offset int, fee, sib uint64) *txDetail {
+		data, err := json.Marshal(tx)
+		require.NoError(t, err)
+		return &txDetail{
+			BlockHeight: height,
+			TxOffset:    offset,
+			Fee:         fee,
+			SIB:         sib,
+			TxType:      metatx.NameOf(tx),
+			TxData:      data,
+		}
+	}
+	details := map[string]*txDetail{
+		"in":     detail(&ndau.Transfer{Source: b, Destination: a, Qty: 1000}, 1, 0, 7, 0),
+		"out":    detail(&ndau.Transfer{Source: a, Destination: b, Qty: 300}, 2, 0, 10, 2),
+		"lock":   detail(&ndau.Lock{Target: a, Period: 1}, 3, 0, 5, 0),
+		"credit": detail(&ndau.CreditEAI{Node: node}, 3, 1, 1, 0),
+	}
+	// history isn't ordered by offset within a block
+	items := []historyItem{
+		{Balance: 1000, Timestamp: ts, TxHash: "in", Height: 1},
+		{Balance: 688, Timestamp: ts, TxHash: "out", Height: 2},
+		{Balance: 733, Timestamp: ts, TxHash: "credit", Height: 3},
+		{Balance: 683, Timestamp: ts, TxHash: "lock", Height: 3},
+	}
+
+	got, err := ledger(a, items, details)
+	require.NoError(t, err)
+	require.Equal(t, []ledgerEntry{
+		// b pays the fee for its own transfer
+		{Timestamp: tsv, Height: 1, TxHash: "in", TxType: "Transfer", Counterparty: b.String(), Amount: 1000, Balance: 1000},
+		{Timestamp: tsv, Height: 2, TxHash: "out", TxType: "Transfer", Counterparty: b.String(), Amount: -300, Fee: 10, SIB: 2, Balance: 688},
+		{Timestamp: tsv, Height: 3, TxHash: "lock", TxType: "Lock", Fee: 5, Balance: 683},
+		// the node pays the fee for crediting EAI
+		{Timestamp: tsv, Height: 3, Offset: 1, TxHash: "credit", TxType: "CreditEAI", Counterparty: node.String(), EAI: 50, Balance: 733},
+	}, got)
+
+	t.Run("missing details", func(t *testing.T) {
+		_, err := ledger(a, append(items, historyItem{Timestamp: ts, TxHash: "unknown", Height: 4}), details)
+		require.Error(t, err)
+	})
+	t.Run("bad tx data", func(t *testing.T) {
+		bad := map[string]*txDetail{"in": {TxType: "Lock", TxData: json.RawMessage(`{"target": 5}`)}}
+		_, err := ledger(a, items[:1], bad)
+		require.Error(t, err)
+	})
+	t.Run("bad timestamp", func(t *testing.T) {
+		_, err := ledger(a, []historyItem{{Timestamp: "yesterday", TxHash: "in", Height: 1}}, details)
+		require.Error(t, err)
+	})
+}
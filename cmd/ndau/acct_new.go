@@ -30,7 +30,7 @@ func getAccountNew(verbose *bool) func(*cli.Cmd) {
 			config := getConfig()
 			err := config.CreateAccount(*name, *hd)
 			orQuit(errors.Wrap(err, "Failed to create identity"))
-			err = config.Save()
+			err = saveConfig(config)
 			orQuit(errors.Wrap(err, "saving config"))
 		}
 	}
@@ -58,7 +58,7 @@ func getAccountRecover(verbose *bool) func(*cli.Cmd) {
 			config := getConfig()
 			err := config.RecoverAccount(*name, *phrase, *lang)
 			orQuit(errors.Wrap(err, "failed to recover identity"))
-			err = config.Save()
+			err = saveConfig(config)
 			orQuit(errors.Wrap(err, "saving config"))
 			if *verbose {
 				fmt.Println("OK")
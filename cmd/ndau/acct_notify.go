@@ -14,7 +14,6 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
 )
 
 func getNotify(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd) {
@@ -29,7 +28,7 @@ func getNotify(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd)
 			if !hasAcct {
 				orQuit(fmt.Errorf("No such account: %s", *name))
 			}
-			if acct.Validation == nil {
+			if !hasValidation(acct) {
 				orQuit(fmt.Errorf("Validation key for %s not set", *name))
 			}
 
@@ -40,13 +39,15 @@ func getNotify(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd)
 				)
 			}
 
+			signer := accountSigner(acct, *keys)
 			tx := ndau.NewNotify(
 				acct.Address,
 				sequence(conf, acct.Address),
-				acct.ValidationPrivateK(*keys)...,
+				signer.Keys()...,
 			)
+			signer.Sign(tx)
 
-			resp, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), tx)
+			resp, err := sendTx(tmnode(conf.Node, emitJSON, compact), tx)
 			finish(*verbose, resp, err, "notify")
 		}
 	}
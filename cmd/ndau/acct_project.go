@@ -0,0 +1,288 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"fmt"
+	"time"
+
+	cli "github.com/jawher/mow.cli"
+	"github.com/ndau/ndau/pkg/ndau/backing"
+	"github.com/ndau/ndau/pkg/tool"
+	"github.com/ndau/ndaumath/pkg/constants"
+	"github.com/ndau/ndaumath/pkg/eai"
+	math "github.com/ndau/ndaumath/pkg/types"
+	"github.com/ndau/ndaumath/pkg/unsigned"
+	sv "github.com/ndau/system_vars/pkg/system_vars"
+	"github.com/pkg/errors"
+)
+
+// projectionRow is the projected state of an account at a point in time
+type projectionRow struct {
+	Time      math.Timestamp `json:"time"`
+	Age       math.Duration  `json:"weightedAverageAge"`
+	Locked    bool           `json:"locked"`
+	EAI       math.Ndau      `json:"eai"`
+	LockBonus math.Ndau      `json:"lockBonus"`
+	Fees      math.Ndau      `json:"fees"`
+	Net       math.Ndau      `json:"net"`
+	Balance   math.Ndau      `json:"balance"`
+}
+
+// projection is the projected EAI of an account over a period
+type projection struct {
+	Balance      math.Ndau       `json:"balance"`
+	From         math.Timestamp  `json:"from"`
+	LastEAICalc  math.Timestamp  `json:"lastEAIUpdate"`
+	Lock         *backing.Lock   `json:"lock"`
+	Hypothetical bool            `json:"hypothetical"`
+	NotifyOn     *math.Timestamp `json:"notifyOn,omitempty"`
+	UnlocksOn    *math.Timestamp `json:"unlocksOn,omitempty"`
+	Rows         []projectionRow `json:"projection"`
+}
+
+// getRateTable retrieves a rate table from the chain's system variables
+func getRateTable(svs map[string][]byte, name string) (eai.RateTable, error) {
+	data, ok := svs[name]
+	if !ok {
+		return nil, fmt.Errorf("sysvar %s not set", name)
+	}
+	rt := eai.RateTable{}
+	_, err := rt.UnmarshalMsg(data)
+	return rt, errors.Wrap(err, "decoding "+name)
+}
+
+// project the EAI of an account with the given state
+//
+// This assumes that the account's balance changes only by the accrual of
+// EAI, and that EAI is credited only at the end of the horizon. It uses the
+// same calculation as the chain does when crediting EAI.
+//
+// The lock takes effect at lockFrom: EAI accrued before then earns no lock
+// bonus. For the account's own lock, that is its last EAI update; for a
+// hypothetical new lock, it is the start of the projection.
+func project(
+	balance math.Ndau,
+	lastEAICalc, lastWAAUpdate math.Timestamp,
+	waa math.Duration,
+	lock *backing.Lock,
+	lockFrom math.Timestamp,
+	unlocked eai.RateTable,
+	fees sv.EAIFeeTable,
+	from math.Timestamp,
+	horizon, step math.Duration,
+) ([]projectionRow, error) {
+	if step <= 0 {
+		return nil, errors.New("step must be positive")
+	}
+
+	// the portion of each ndau of EAI which the account keeps
+	awardPerNdau := math.Ndau(constants.QuantaPerUnit)
+	for _, fee := range fees {
+		awardPerNdau -= fee.Fee
+	}
+
+	var rows []projectionRow
+	for offset := step; ; offset += step {
+		if offset > horizon {
+			offset = horizon
+		}
+		at := from.Add(offset)
+		age := waa + at.Since(lastWAAUpdate)
+
+		// EAI accrued before the lock takes effect, and the time from which
+		// the lock applies; later EAI compounds on top of the earlier EAI
+		var before math.Ndau
+		since := lastEAICalc
+		if lock != nil && lockFrom.Compare(lastEAICalc) > 0 {
+			since = lockFrom
+			if since.Compare(at) > 0 {
+				since = at
+			}
+			var err error
+			before, err = eai.Calculate(balance, since, lastEAICalc, age-at.Since(since), nil, unlocked, true)
+			if err != nil {
+				return nil, errors.Wrap(err, "calculating eai")
+			}
+		}
+
+		gross, err := eai.Calculate(balance+before, at, since, age, lock, unlocked, true)
+		if err != nil {
+			return nil, errors.Wrap(err, "calculating eai")
+		}
+		gross += before
+		var bonus math.Ndau
+		if lock != nil {
+			base, err := eai.Calculate(balance+before, at, since, age, nil, unlocked, true)
+			if err != nil {
+				return nil, errors.Wrap(err, "calculating eai")
+			}
+			bonus = gross - before - base
+		}
+		net, err := unsigned.MulDiv(uint64(gross), uint64(awardPerNdau), constants.QuantaPerUnit)
+		if err != nil {
+			return nil, errors.Wrap(err, "calculating fees")
+		}
+
+		rows = append(rows, projectionRow{
+			Time:      at,
+			Age:       age,
+			Locked:    lock != nil && (lock.UnlocksOn == nil || at.Compare(*lock.UnlocksOn) < 0),
+			EAI:       gross,
+			LockBonus: bonus,
+			Fees:      gross - math.Ndau(net),
+			Net:       math.Ndau(net),
+			Balance:   balance + math.Ndau(net),
+		})
+		if offset >= horizon {
+			break
+		}
+	}
+	return rows, nil
+}
+
+func getAccountProject(verbose *bool) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = fmt.Sprintf(
+			"%s [--horizon=<duration>] [--step=<duration>] [--lock=<period>] [--balance=<ndau>] [--notify=<duration>] [--json]",
+			getAddressSpec(""),
+		)
+
+		var (
+			getAddress = getAddressClosure(cmd, "")
+			horizonS   = cmd.StringOpt("horizon", "1y", "project this far into the future")
+			stepS      = cmd.StringOpt("step", "1m", "interval between projected rows")
+			lockS      = cmd.StringOpt("lock", "", "what if the account were newly locked for this period")
+			balanceS   = cmd.StringOpt("balance", "", "what if the account had this many ndau")
+			notifyS    = cmd.StringOpt("notify", "", "what if the account notified after this long")
+			emitJSON   = cmd.BoolOpt("json", false, "emit the projection as JSON")
+		)
+
+		cmd.Action = func() {
+			addr := getAddress()
+			horizon, err := math.ParseDuration(*horizonS)
+			orQuit(errors.Wrap(err, "parsing horizon"))
+			step, err := math.ParseDuration(*stepS)
+			orQuit(errors.Wrap(err, "parsing step"))
+
+			conf := getConfig()
+			node := tmnode(conf.Node, nil, nil)
+			ad, _, err := tool.GetAccount(node, addr)
+			orQuit(errors.Wrap(err, "getting account"))
+			svs, _, err := tool.Sysvars(node, sv.UnlockedRateTableName, sv.LockedRateTableName, sv.EAIFeeTableName)
+			orQuit(errors.Wrap(err, "getting sysvars"))
+			unlocked, err := getRateTable(svs, sv.UnlockedRateTableName)
+			orQuit(err)
+			locked, err := getRateTable(svs, sv.LockedRateTableName)
+			orQuit(err)
+			fees := sv.EAIFeeTable{}
+			if data, ok := svs[sv.EAIFeeTableName]; ok {
+				_, err = fees.UnmarshalMsg(data)
+				orQuit(errors.Wrap(err, "decoding "+sv.EAIFeeTableName))
+			}
+
+			now, err := math.TimestampFrom(time.Now())
+			orQuit(err)
+
+			// the account's own lock has applied since its last EAI update
+			lockFrom := ad.LastEAIUpdate
+			p := projection{
+				Balance:     ad.Balance,
+				From:        now,
+				LastEAICalc: ad.LastEAIUpdate,
+				Lock:        ad.Lock,
+			}
+			if *balanceS != "" {
+				p.Balance, err = math.ParseNdau(*balanceS)
+				orQuit(errors.Wrap(err, "parsing balance"))
+				p.Hypothetical = true
+			}
+			if *lockS != "" {
+				period, err := math.ParseDuration(*lockS)
+				orQuit(errors.Wrap(err, "parsing lock period"))
+				p.Lock = backing.NewLock(period, locked)
+				lockFrom = now
+				p.Hypothetical = true
+			}
+			if *notifyS != "" {
+				if p.Lock == nil {
+					orQuit(errors.New("cannot notify: account is not locked"))
+				}
+				if p.Lock.UnlocksOn != nil {
+					orQuit(errors.New("cannot notify: account has already notified"))
+				}
+				after, err := math.ParseDuration(*notifyS)
+				orQuit(errors.Wrap(err, "parsing notify delay"))
+				// don't modify the account's own lock
+				l := *p.Lock
+				notifyOn := now.Add(after)
+				orQuit(l.Notify(notifyOn, 0))
+				p.Lock = &l
+				p.NotifyOn = &notifyOn
+				p.Hypothetical = true
+			}
+			if p.Lock != nil {
+				p.UnlocksOn = p.Lock.UnlocksOn
+			}
+
+			p.Rows, err = project(
+				p.Balance,
+				ad.LastEAIUpdate, ad.LastWAAUpdate,
+				ad.WeightedAverageAge,
+				p.Lock, lockFrom,
+				unlocked, fees,
+				now, horizon, step,
+			)
+			orQuit(err)
+
+			if *emitJSON {
+				js, err := jsonify(p)
+				orQuit(err)
+				fmt.Println(js)
+				return
+			}
+
+			if p.Hypothetical {
+				fmt.Println("HYPOTHETICAL projection")
+			}
+			fmt.Printf("balance %s ndau; last EAI update %s\n", p.Balance, p.LastEAICalc)
+			switch {
+			case p.Lock == nil:
+				fmt.Println("unlocked")
+			case p.UnlocksOn == nil:
+				fmt.Printf(
+					"locked for %s with bonus %s; if notified now, unlocks on %s\n",
+					p.Lock.NoticePeriod, p.Lock.Bonus, now.Add(p.Lock.NoticePeriod),
+				)
+			default:
+				fmt.Printf("notified; unlocks on %s\n", p.UnlocksOn)
+			}
+			if p.NotifyOn != nil {
+				fmt.Printf("notifying on %s\n", p.NotifyOn)
+			}
+			fmt.Println()
+			fmt.Printf("%-20s %-12s %-6s %16s %16s %14s %16s %18s\n",
+				"time", "age", "locked", "eai", "lock bonus", "fees", "net", "balance",
+			)
+			for _, row := range p.Rows {
+				fmt.Printf("%-20s %-12s %-6t %16s %16s %14s %16s %18s\n",
+					row.Time.AsTime().UTC().Format("2006-01-02T15:04:05Z"),
+					row.Age,
+					row.Locked,
+					row.EAI,
+					row.LockBonus,
+					row.Fees,
+					row.Net,
+					row.Balance,
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"testing"
+
+	"github.com/ndau/ndau/pkg/ndau/backing"
+	"github.com/ndau/ndaumath/pkg/constants"
+	"github.com/ndau/ndaumath/pkg/eai"
+	math "github.com/ndau/ndaumath/pkg/types"
+	sv "github.com/ndau/system_vars/pkg/system_vars"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProject(t *testing.T) {
+	// flat rates keep the expected EAI simple: 1000 ndau continuously
+	// compounded at r for t years earns 1000 * (e^(r*t) - 1)
+	unlocked := eai.RateTable{{From: 0, Rate: eai.RateFromPercent(10)}}
+	bonus := eai.RateTable{{From: 0, Rate: eai.RateFromPercent(5)}}
+	const balance = 1000 * constants.QuantaPerUnit
+	from := math.Timestamp(10 * math.Year)
+	quarter := math.Duration(90 * math.Day)
+
+	notified := func() *backing.Lock {
+		l := backing.NewLock(180*math.Day, bonus)
+		require.NoError(t, l.Notify(from, 0))
+		return l
+	}
+
+	cases := []struct {
+		name        string
+		lastEAICalc math.Timestamp
+		lock        func() *backing.Lock
+		lockFrom    math.Timestamp
+		fees        sv.EAIFeeTable
+		horizon     math.Duration
+		step        math.Duration
+		wantTimes   []math.Duration
+		wantLocked  []bool
+		// EAI and lock bonus of the last row, in ndau
+		wantEAI   float64
+		wantBonus float64
+	}{
+		{
+			name:    "unlocked",
+			horizon: math.Year, step: math.Year,
+			wantTimes:  []math.Duration{math.Year},
+			wantLocked: []bool{false},
+			wantEAI:    105.17091808,
+		},
+		{
+			name:    "locked",
+			lock:    func() *backing.Lock { return backing.NewLock(math.Year, bonus) },
+			horizon: math.Year, step: math.Year,
+			wantTimes:  []math.Duration{math.Year},
+			wantLocked: []bool{true},
+			wantEAI:    161.83424273,
+			wantBonus:  56.66332465,
+		},
+		{
+			name:    "notified lock crosses UnlocksOn",
+			lock:    notified,
+			horizon: math.Year, step: quarter,
+			wantTimes:  []math.Duration{quarter, 2 * quarter, 3 * quarter, 4 * quarter, math.Year},
+			wantLocked: []bool{true, false, false, false, false},
+			// 180 days at 15%, then 185 days at 10%
+			wantEAI:   132.76045497,
+			wantBonus: 27.58953689,
+		},
+		{
+			name: "fees",
+			fees: sv.EAIFeeTable{
+				{Fee: constants.QuantaPerUnit / 20},
+				{Fee: constants.QuantaPerUnit / 20},
+			},
+			horizon: math.Year, step: math.Year,
+			wantTimes:  []math.Duration{math.Year},
+			wantLocked: []bool{false},
+			wantEAI:    105.17091808,
+		},
+		{
+			name:    "step does not divide horizon",
+			horizon: math.Year, step: quarter,
+			wantTimes:  []math.Duration{quarter, 2 * quarter, 3 * quarter, 4 * quarter, math.Year},
+			wantLocked: []bool{false, false, false, false, false},
+			wantEAI:    105.17091808,
+		},
+		{
+			name:    "step beyond horizon",
+			horizon: math.Year, step: 2 * math.Year,
+			wantTimes:  []math.Duration{math.Year},
+			wantLocked: []bool{false},
+			wantEAI:    105.17091808,
+		},
+		{
+			name:        "zero horizon",
+			lastEAICalc: from.Sub(math.Year),
+			horizon:     0, step: quarter,
+			wantTimes:  []math.Duration{0},
+			wantLocked: []bool{false},
+			wantEAI:    105.17091808,
+		},
+		{
+			name:        "lock since last EAI update",
+			lastEAICalc: from.Sub(math.Year),
+			lock:        func() *backing.Lock { return backing.NewLock(math.Year, bonus) },
+			lockFrom:    from.Sub(math.Year),
+			horizon:     math.Year, step: math.Year,
+			wantTimes:  []math.Duration{math.Year},
+			wantLocked: []bool{true},
+			// two years at 15%
+			wantEAI:   349.85880758,
+			wantBonus: 128.45604942,
+		},
+		{
+			name:        "hypothetical lock from projection start",
+			lastEAICalc: from.Sub(math.Year),
+			lock:        func() *backing.Lock { return backing.NewLock(math.Year, bonus) },
+			lockFrom:    from,
+			horizon:     math.Year, step: math.Year,
+			wantTimes:  []math.Duration{math.Year},
+			wantLocked: []bool{true},
+			// a year at 10%, then a year at 15%
+			wantEAI:   284.02541669,
+			wantBonus: 62.62265853,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lastEAICalc := tc.lastEAICalc
+			if lastEAICalc == 0 {
+				lastEAICalc = from
+			}
+			var lock *backing.Lock
+			if tc.lock != nil {
+				lock = tc.lock()
+			}
+			rows, err := project(
+				balance,
+				lastEAICalc, lastEAICalc, 0,
+				lock, tc.lockFrom,
+				unlocked, tc.fees,
+				from, tc.horizon, tc.step,
+			)
+			require.NoError(t, err)
+			require.Len(t, rows, len(tc.wantTimes))
+
+			awardPerNdau := int64(constants.QuantaPerUnit)
+			for _, fee := range tc.fees {
+				awardPerNdau -= int64(fee.Fee)
+			}
+			for i, row := range rows {
+				require.Equal(t, from.Add(tc.wantTimes[i]), row.Time, "row %d", i)
+				require.Equal(t, tc.wantLocked[i], row.Locked, "row %d", i)
+				require.Equal(t, row.EAI, row.Fees+row.Net, "row %d", i)
+				require.Equal(t, int64(row.EAI)*awardPerNdau/constants.QuantaPerUnit, int64(row.Net), "row %d", i)
+				require.Equal(t, math.Ndau(balance)+row.Net, row.Balance, "row %d", i)
+				if lock == nil {
+					require.Zero(t, row.LockBonus, "row %d", i)
+				}
+				if i > 0 {
+					require.True(t, row.EAI > rows[i-1].EAI, "row %d", i)
+				}
+			}
+			last := rows[len(rows)-1]
+			require.InDelta(t, tc.wantEAI, float64(last.EAI)/constants.QuantaPerUnit, 0.001)
+			require.InDelta(t, tc.wantBonus, float64(last.LockBonus)/constants.QuantaPerUnit, 0.001)
+		})
+	}
+
+	t.Run("step must be positive", func(t *testing.T) {
+		for _, step := range []math.Duration{0, -math.Day} {
+			_, err := project(balance, from, from, 0, nil, 0, unlocked, nil, from, math.Year, step)
+			require.Error(t, err)
+		}
+	})
+}
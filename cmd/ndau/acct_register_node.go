@@ -15,7 +15,6 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
 )
 
 func getRegisterNode(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd) {
@@ -37,7 +36,7 @@ func getRegisterNode(verbose *bool, keys *int, emitJSON, compact *bool) func(*cl
 			if !hasAcct {
 				orQuit(fmt.Errorf("No such account: %s", *name))
 			}
-			if len(acct.Validation) == 0 {
+			if !hasValidation(acct) {
 				orQuit(fmt.Errorf("Validation key for %s not set", *name))
 			}
 
@@ -51,13 +50,15 @@ func getRegisterNode(verbose *bool, keys *int, emitJSON, compact *bool) func(*cl
 				)
 			}
 
+			signer := accountSigner(acct, *keys)
 			tx := ndau.NewRegisterNode(
 				acct.Address, script, acct.Ownership.Public,
 				sequence(conf, acct.Address),
-				acct.ValidationPrivateK(*keys)...,
+				signer.Keys()...,
 			)
+			signer.Sign(tx)
 
-			resp, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), tx)
+			resp, err := sendTx(tmnode(conf.Node, emitJSON, compact), tx)
 			finish(*verbose, resp, err, "notify")
 		}
 	}
@@ -15,7 +15,6 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
 	"github.com/pkg/errors"
 )
 
@@ -42,18 +41,20 @@ func getSetStakeRules(verbose *bool, keys *int, emitJSON, compact *bool) func(*c
 				fmt.Printf("Script b64: %s\n       hex: %x\n", *scriptB64, rules)
 			}
 
+			signer := accountSigner(acct, *keys)
 			tx := ndau.NewSetStakeRules(
 				acct.Address,
 				rules,
 				sequence(conf, acct.Address),
-				acct.ValidationPrivateK(*keys)...,
+				signer.Keys()...,
 			)
+			signer.Sign(tx)
 
 			if *verbose {
 				fmt.Printf("%#v\n", tx)
 			}
 
-			resp, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), tx)
+			resp, err := sendTx(tmnode(conf.Node, emitJSON, compact), tx)
 
 			finish(*verbose, resp, err, "account set-stake-rules")
 		}
@@ -11,13 +11,10 @@ package main
 
 import (
 	cli "github.com/jawher/mow.cli"
-	"github.com/ndau/metanode/pkg/meta/app/code"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
 	config "github.com/ndau/ndau/pkg/tool.config"
 	"github.com/ndau/ndaumath/pkg/signature"
 	"github.com/pkg/errors"
-	rpc "github.com/tendermint/tendermint/rpc/core/types"
 )
 
 func getAccountSetValidation(verbose, emitJSON, compact *bool) func(*cli.Cmd) {
@@ -36,22 +33,24 @@ func getAccountSetValidation(verbose, emitJSON, compact *bool) func(*cli.Cmd) {
 			newKeys, err := acct.MakeValidationKey(nil)
 			orQuit(err)
 
+			signer := ownershipSigner(acct)
 			ca := ndau.NewSetValidation(
 				acct.Address,
 				acct.Ownership.Public,
 				[]signature.PublicKey{newKeys.Public},
 				acct.ValidationScript,
 				sequence(conf, acct.Address),
-				acct.Ownership.Private,
+				signer.Keys()...,
 			)
+			signer.Sign(ca)
 
-			resp, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), ca)
+			resp, err := sendCommitTx(tmnode(conf.Node, emitJSON, compact), ca)
 
 			// only persist this change if there was no error
-			if err == nil && code.ReturnCode(resp.(*rpc.ResultBroadcastTxCommit).DeliverTx.Code) == code.OK {
+			if err == nil && committedOK(resp) {
 				acct.Validation = []config.Keypair{*newKeys}
 				conf.SetAccount(*acct)
-				err = conf.Save()
+				err = saveConfig(conf)
 				orQuit(errors.Wrap(err, "saving config"))
 			}
 			finish(*verbose, resp, err, "account set-validation")
@@ -14,7 +14,6 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
 )
 
 func getStake(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd) {
@@ -37,7 +36,7 @@ func getStake(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd)
 			if !hasAcct {
 				orQuit(fmt.Errorf("No such account: %s", *acctName))
 			}
-			if len(acct.Validation) == 0 {
+			if !hasValidation(acct) {
 				orQuit(fmt.Errorf("Validation key for %s not set", *acctName))
 			}
 
@@ -55,16 +54,18 @@ func getStake(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd)
 				)
 			}
 
+			signer := accountSigner(acct, *keys)
 			tx := ndau.NewStake(
 				acct.Address,
 				rules,
 				staketo,
 				qty,
 				sequence(conf, acct.Address),
-				acct.ValidationPrivateK(*keys)...,
+				signer.Keys()...,
 			)
+			signer.Sign(tx)
 
-			resp, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), tx)
+			resp, err := sendTx(tmnode(conf.Node, emitJSON, compact), tx)
 			finish(*verbose, resp, err, "notify")
 		}
 	}
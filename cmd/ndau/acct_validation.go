@@ -14,13 +14,10 @@ import (
 	"fmt"
 
 	cli "github.com/jawher/mow.cli"
-	"github.com/ndau/metanode/pkg/meta/app/code"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
 	config "github.com/ndau/ndau/pkg/tool.config"
 	"github.com/ndau/ndaumath/pkg/signature"
 	"github.com/pkg/errors"
-	rpc "github.com/tendermint/tendermint/rpc/core/types"
 )
 
 func getAccountValidation(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd) {
@@ -68,7 +65,7 @@ func getReset(verbose *bool, name *string, keys *int, emitJSON, compact *bool) f
 				orQuit(errors.New("No such account"))
 			}
 
-			if len(acct.Validation) == 0 {
+			if !hasValidation(acct) {
 				orQuit(errors.New("account doesn't have validation keys"))
 			}
 
@@ -76,21 +73,23 @@ func getReset(verbose *bool, name *string, keys *int, emitJSON, compact *bool) f
 			newkeys, err := acct.MakeValidationKey(&keypath)
 			orQuit(errors.Wrap(err, "failed to generate new validation key"))
 
+			signer := accountSigner(acct, *keys)
 			cv := ndau.NewChangeValidation(
 				acct.Address,
 				[]signature.PublicKey{newkeys.Public},
 				acct.ValidationScript,
 				sequence(conf, acct.Address),
-				acct.ValidationPrivateK(*keys)...,
+				signer.Keys()...,
 			)
+			signer.Sign(cv)
 
-			resp, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), cv)
+			resp, err := sendCommitTx(tmnode(conf.Node, emitJSON, compact), cv)
 
 			// only persist this change if there was no error
-			if err == nil && code.ReturnCode(resp.(*rpc.ResultBroadcastTxCommit).DeliverTx.Code) == code.OK {
+			if err == nil && committedOK(resp) {
 				acct.Validation = []config.Keypair{*newkeys}
 				conf.SetAccount(*acct)
-				err = conf.Save()
+				err = saveConfig(conf)
 				orQuit(errors.Wrap(err, "saving config"))
 			}
 			finish(*verbose, resp, err, "account validation reset")
@@ -111,7 +110,7 @@ func getAdd(verbose *bool, name *string, keys *int, emitJSON, compact *bool) fun
 				orQuit(errors.New("No such account"))
 			}
 
-			if len(acct.Validation) == 0 {
+			if !hasValidation(acct) {
 				orQuit(errors.New("account doesn't have validation keys"))
 			}
 
@@ -119,21 +118,23 @@ func getAdd(verbose *bool, name *string, keys *int, emitJSON, compact *bool) fun
 			newkeys, err := acct.MakeValidationKey(&keypath)
 			orQuit(errors.Wrap(err, "failed to generate new validation key"))
 
+			signer := accountSigner(acct, *keys)
 			cv := ndau.NewChangeValidation(
 				acct.Address,
-				append(acct.ValidationPublic(), newkeys.Public),
+				append(validationKeys(conf, acct.Address), newkeys.Public),
 				acct.ValidationScript,
 				sequence(conf, acct.Address),
-				acct.ValidationPrivateK(*keys)...,
+				signer.Keys()...,
 			)
+			signer.Sign(cv)
 
-			resp, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), cv)
+			resp, err := sendCommitTx(tmnode(conf.Node, emitJSON, compact), cv)
 
 			// only persist this change if there was no error
-			if err == nil && code.ReturnCode(resp.(*rpc.ResultBroadcastTxCommit).DeliverTx.Code) == code.OK {
+			if err == nil && committedOK(resp) {
 				acct.Validation = append(acct.Validation, *newkeys)
 				conf.SetAccount(*acct)
-				err = conf.Save()
+				err = saveConfig(conf)
 				orQuit(errors.Wrap(err, "saving config"))
 			}
 			finish(*verbose, resp, err, "account validation add")
@@ -160,7 +161,7 @@ func getRecover(verbose *bool, name *string) func(*cli.Cmd) {
 
 			acct.Validation = []config.Keypair{*newkeys}
 			conf.SetAccount(*acct)
-			err = conf.Save()
+			err = saveConfig(conf)
 			orQuit(errors.Wrap(err, "saving config"))
 
 			finish(*verbose, nil, err, "account validation recover")
@@ -181,7 +182,7 @@ func getSetScript(verbose *bool, name *string, keys *int, emitJSON, compact *boo
 				orQuit(errors.New("No such account"))
 			}
 
-			if len(acct.Validation) == 0 {
+			if !hasValidation(acct) {
 				orQuit(errors.New("account doesn't have validation keys"))
 			}
 
@@ -192,25 +193,27 @@ func getSetScript(verbose *bool, name *string, keys *int, emitJSON, compact *boo
 				fmt.Printf("Script b64: %s\n       hex: %x\n", *scriptB64, script)
 			}
 
+			signer := accountSigner(acct, *keys)
 			cv := ndau.NewChangeValidation(
 				acct.Address,
-				acct.ValidationPublic(),
+				validationKeys(conf, acct.Address),
 				script,
 				sequence(conf, acct.Address),
-				acct.ValidationPrivateK(*keys)...,
+				signer.Keys()...,
 			)
+			signer.Sign(cv)
 
 			if *verbose {
 				fmt.Printf("%#v\n", cv)
 			}
 
-			resp, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), cv)
+			resp, err := sendCommitTx(tmnode(conf.Node, emitJSON, compact), cv)
 
 			// only persist this change if there was no error
-			if err == nil && code.ReturnCode(resp.(*rpc.ResultBroadcastTxCommit).DeliverTx.Code) == code.OK {
+			if err == nil && committedOK(resp) {
 				acct.ValidationScript = script
 				conf.SetAccount(*acct)
-				err = conf.Save()
+				err = saveConfig(conf)
 				orQuit(errors.Wrap(err, "saving config"))
 			}
 			finish(*verbose, resp, err, "account validation add")
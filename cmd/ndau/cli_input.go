@@ -123,8 +123,6 @@ func getInputClosure(cmd *cli.Cmd, name string, singleton bool, verbose *bool) f
 			if *verbose {
 				fmt.Println(name, "input is json -> msgp")
 			}
-			inbuf := bytes.NewBuffer(data)
-			outbuf := &bytes.Buffer{}
 			typeHints := make(map[string][]string)
 			if typesIn != nil && len(*typesIn) > 0 {
 				if *verbose {
@@ -133,9 +131,8 @@ func getInputClosure(cmd *cli.Cmd, name string, singleton bool, verbose *bool) f
 				err = json.Unmarshal([]byte(*typesIn), &typeHints)
 				orQuit(err)
 			}
-			err = json2msgp.ConvertStream(inbuf, outbuf, typeHints)
+			out, err := jsonToMsgp(data, typeHints)
 			orQuit(err)
-			out := outbuf.Bytes()
 			if *verbose {
 				fmt.Printf("%s input is %d bytes long\n%x\n", name, len(out), out)
 			}
@@ -149,3 +146,13 @@ func getInputClosure(cmd *cli.Cmd, name string, singleton bool, verbose *bool) f
 		}
 	}
 }
+
+// jsonToMsgp converts JSON data to msgp, using json2msgp type hints
+func jsonToMsgp(data []byte, typeHints map[string][]string) ([]byte, error) {
+	outbuf := &bytes.Buffer{}
+	err := json2msgp.ConvertStream(bytes.NewBuffer(data), outbuf, typeHints)
+	if err != nil {
+		return nil, err
+	}
+	return outbuf.Bytes(), nil
+}
@@ -0,0 +1,493 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	cli "github.com/jawher/mow.cli"
+	config "github.com/ndau/ndau/pkg/tool.config"
+	"github.com/pkg/errors"
+)
+
+// A compNode is a command in the command tree, as far as completion is concerned
+type compNode struct {
+	// patterns are the space-separated command paths, including aliases,
+	// which lead to this command
+	patterns []string
+	// commands are the names of subcommands, including aliases
+	commands []string
+	descs    map[string]string
+	// flags take no value; values take one
+	flags  []string
+	values []string
+	// addresses are the options which take an account address
+	addresses []string
+	// names is set if the command takes an account name argument
+	names bool
+	// args is the number of positional arguments
+	args int
+
+	children []*compNode
+}
+
+var (
+	helpEntryRE = regexp.MustCompile(`^\s+(\S.*?)(?:\s{2,}(.*))?$`)
+	valueOptRE  = regexp.MustCompile(`(--?[A-Za-z0-9][\w-]*)=<`)
+	nameArgRE   = regexp.MustCompile(`^(\w+_)?NAME$`)
+	// options may be given in a spec without a value placeholder, but any
+	// non-boolean default reveals that they take one
+	valueDefaultRE = regexp.MustCompile(`\(default (?:[^tf)]|t[^r]|f[^a])`)
+)
+
+// helpFor runs this binary to get the help text of a command
+func helpFor(exe string, path []string) (string, error) {
+	out := new(bytes.Buffer)
+	c := exec.Command(exe, append(path, "--help")...)
+	c.Stdout = out
+	c.Stderr = out
+	err := c.Run()
+	return out.String(), errors.Wrap(err, strings.Join(path, " "))
+}
+
+// parseHelp extracts what completion needs from a command's help text
+func parseHelp(help string) *compNode {
+	node := &compNode{descs: make(map[string]string)}
+	section := ""
+	for _, line := range strings.Split(help, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "Usage:"):
+			for _, m := range valueOptRE.FindAllStringSubmatch(trimmed, -1) {
+				node.values = append(node.values, m[1])
+			}
+			continue
+		case trimmed == "Arguments:", trimmed == "Options:", trimmed == "Commands:":
+			section = trimmed
+			continue
+		case trimmed == "":
+			continue
+		}
+		m := helpEntryRE.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		names := strings.Split(m[1], ", ")
+		switch section {
+		case "Commands:":
+			for _, name := range names {
+				node.commands = append(node.commands, name)
+				node.descs[name] = m[2]
+			}
+		case "Arguments:":
+			node.args++
+			if nameArgRE.MatchString(m[1]) {
+				node.names = true
+			}
+		case "Options:":
+			for _, name := range names {
+				node.descs[name] = m[2]
+			}
+		}
+	}
+
+	// now that the value options are known, classify all options
+	section = ""
+	isValue := make(map[string]bool)
+	for _, v := range node.values {
+		isValue[v] = true
+	}
+	for _, line := range strings.Split(help, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasSuffix(trimmed, ":") {
+			section = trimmed
+			continue
+		}
+		m := helpEntryRE.FindStringSubmatch(line)
+		if section != "Options:" || m == nil {
+			continue
+		}
+		names := strings.Split(m[1], ", ")
+		value := valueDefaultRE.MatchString(m[2])
+		for _, name := range names {
+			value = value || isValue[name]
+		}
+		for _, name := range names {
+			switch {
+			case !value:
+				node.flags = append(node.flags, name)
+			case !isValue[name]:
+				// the short or long form of a value option
+				node.values = append(node.values, name)
+			}
+		}
+		if value && strings.HasSuffix(names[len(names)-1], "address") {
+			node.addresses = append(node.addresses, names...)
+		}
+	}
+	return node
+}
+
+// buildCompTree walks the command tree of this binary
+func buildCompTree(exe string, path []string, patterns []string) (*compNode, error) {
+	help, err := helpFor(exe, path)
+	if err != nil {
+		return nil, err
+	}
+	node := parseHelp(help)
+	node.patterns = patterns
+
+	// group aliases, which are listed together with the same description
+	seen := make(map[string]bool)
+	for _, name := range node.commands {
+		if seen[name] || name == "help" {
+			continue
+		}
+		var aliases []string
+		for _, other := range node.commands {
+			if other == name || node.descs[other] == node.descs[name] && !seen[other] && sameEntry(help, name, other) {
+				aliases = append(aliases, other)
+				seen[other] = true
+			}
+		}
+		var childPatterns []string
+		for _, p := range patterns {
+			for _, alias := range aliases {
+				childPatterns = append(childPatterns, strings.TrimSpace(p+" "+alias))
+			}
+		}
+		// arguments which precede a subcommand must be present to reach it
+		childPath := append([]string{}, path...)
+		for i := 0; i < node.args; i++ {
+			childPath = append(childPath, "_")
+		}
+		child, err := buildCompTree(exe, append(childPath, name), childPatterns)
+		if err != nil {
+			return nil, err
+		}
+		node.children = append(node.children, child)
+	}
+	return node, nil
+}
+
+// sameEntry is true if both names are listed on the same line of the help
+func sameEntry(help, a, b string) bool {
+	for _, line := range strings.Split(help, "\n") {
+		m := helpEntryRE.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		names := strings.Split(m[1], ", ")
+		if contains(names, a) && contains(names, b) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// walk visits every node of the tree
+func (n *compNode) walk(visit func(*compNode)) {
+	visit(n)
+	for _, child := range n.children {
+		child.walk(visit)
+	}
+}
+
+// specCase emits the shell assignments describing each command, in the
+// subset of syntax shared by bash and zsh
+func specCases(w io.Writer, root *compNode) {
+	fmt.Fprintln(w, "_ndau_spec() {")
+	fmt.Fprintln(w, "\tcmds= flags= vals= addrs= args=")
+	fmt.Fprintln(w, "\tcase \"$1\" in")
+	root.walk(func(n *compNode) {
+		quoted := make([]string, len(n.patterns))
+		for i, p := range n.patterns {
+			quoted[i] = fmt.Sprintf("%q", p)
+		}
+		args := ""
+		if n.names {
+			args = "name"
+		}
+		fmt.Fprintf(w,
+			"\t%s) cmds=%q flags=%q vals=%q addrs=%q args=%q ;;\n",
+			strings.Join(quoted, "|"),
+			strings.Join(n.commands, " "),
+			strings.Join(n.flags, " "),
+			strings.Join(n.values, " "),
+			strings.Join(n.addresses, " "),
+			args,
+		)
+	})
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "}")
+}
+
+const bashCompletion = `
+_ndau() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	local cmdpath="" cmds flags vals addrs args i w
+	_ndau_spec ""
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		w=${COMP_WORDS[i]}
+		if [[ " $vals " == *" $w "* ]]; then
+			((i++))
+			continue
+		fi
+		[[ $w == -* ]] && continue
+		if [[ " $cmds " == *" $w "* ]]; then
+			cmdpath="${cmdpath:+$cmdpath }$w"
+			_ndau_spec "$cmdpath"
+		fi
+	done
+
+	if [[ " $addrs " == *" $prev "* ]]; then
+		COMPREPLY=($(compgen -W "$(ndau completion accounts --addresses 2>/dev/null)" -- "$cur"))
+	elif [[ " $vals " == *" $prev "* ]]; then
+		COMPREPLY=($(compgen -f -- "$cur"))
+	elif [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "$flags $vals" -- "$cur"))
+	elif [[ -n $args ]]; then
+		COMPREPLY=($(compgen -W "$cmds $(ndau completion accounts 2>/dev/null)" -- "$cur"))
+	else
+		COMPREPLY=($(compgen -W "$cmds" -- "$cur"))
+	fi
+}
+complete -F _ndau ndau
+`
+
+const zshCompletion = `
+_ndau() {
+	local cur=${words[CURRENT]} prev=${words[CURRENT-1]}
+	local cmdpath="" cmds flags vals addrs args i w
+	_ndau_spec ""
+	for ((i = 2; i < CURRENT; i++)); do
+		w=${words[i]}
+		if [[ " $vals " == *" $w "* ]]; then
+			((i++))
+			continue
+		fi
+		[[ $w == -* ]] && continue
+		if [[ " $cmds " == *" $w "* ]]; then
+			cmdpath="${cmdpath:+$cmdpath }$w"
+			_ndau_spec "$cmdpath"
+		fi
+	done
+
+	if [[ " $addrs " == *" $prev "* ]]; then
+		compadd -- ${(f)"$(ndau completion accounts --addresses 2>/dev/null)"}
+	elif [[ " $vals " == *" $prev "* ]]; then
+		_files
+	elif [[ $cur == -* ]]; then
+		compadd -- ${=flags} ${=vals}
+	else
+		compadd -- ${=cmds}
+		[[ -n $args ]] && compadd -- ${(f)"$(ndau completion accounts 2>/dev/null)"}
+	fi
+}
+
+# when autoloaded from fpath, this file is the body of _ndau
+if [[ $funcstack[1] == _ndau ]]; then
+	_ndau "$@"
+else
+	compdef _ndau ndau
+fi
+`
+
+const fishCompletion = `
+function __ndau_cmdpath
+	set -l tokens (commandline -opc)
+	set -l cmdpath ''
+	set -l skip 0
+	__ndau_spec ''
+	for w in $tokens[2..-1]
+		if test $skip = 1
+			set skip 0
+			continue
+		end
+		if contains -- $w $__ndau_vals
+			set skip 1
+			continue
+		end
+		string match -q -- '-*' $w; and continue
+		if contains -- $w $__ndau_cmds
+			set cmdpath (string trim -- "$cmdpath $w")
+			__ndau_spec $cmdpath
+		end
+	end
+	echo $cmdpath
+end
+
+function __ndau_at
+	test (__ndau_cmdpath) = "$argv[1]"
+end
+
+complete -c ndau -f
+`
+
+func writeBash(w io.Writer, root *compNode) {
+	fmt.Fprintln(w, "# bash completion for ndau; generated by ndau completion bash")
+	specCases(w, root)
+	fmt.Fprint(w, bashCompletion)
+}
+
+func writeZsh(w io.Writer, root *compNode) {
+	fmt.Fprintln(w, "#compdef ndau")
+	fmt.Fprintln(w, "# zsh completion for ndau; generated by ndau completion zsh")
+	specCases(w, root)
+	fmt.Fprint(w, zshCompletion)
+}
+
+// fishQuote quotes a string for fish
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func writeFish(w io.Writer, root *compNode) {
+	fmt.Fprintln(w, "# fish completion for ndau; generated by ndau completion fish")
+	fmt.Fprintln(w, "function __ndau_spec")
+	fmt.Fprintln(w, "\tset -g __ndau_cmds")
+	fmt.Fprintln(w, "\tset -g __ndau_vals")
+	fmt.Fprintln(w, "\tswitch \"$argv\"")
+	root.walk(func(n *compNode) {
+		quoted := make([]string, len(n.patterns))
+		for i, p := range n.patterns {
+			quoted[i] = fishQuote(p)
+		}
+		fmt.Fprintf(w, "\tcase %s\n", strings.Join(quoted, " "))
+		if len(n.commands) > 0 {
+			fmt.Fprintf(w, "\t\tset -g __ndau_cmds %s\n", strings.Join(n.commands, " "))
+		}
+		if len(n.values) > 0 {
+			fmt.Fprintf(w, "\t\tset -g __ndau_vals %s\n", strings.Join(n.values, " "))
+		}
+	})
+	fmt.Fprintln(w, "\tend")
+	fmt.Fprintln(w, "end")
+	fmt.Fprint(w, fishCompletion)
+
+	root.walk(func(n *compNode) {
+		for _, pattern := range n.patterns {
+			cond := fishQuote("__ndau_at " + fishQuote(pattern))
+			for _, name := range n.commands {
+				fmt.Fprintf(w, "complete -c ndau -n %s -a %s -d %s\n", cond, name, fishQuote(n.descs[name]))
+			}
+			if n.names {
+				fmt.Fprintf(w, "complete -c ndau -n %s -a '(ndau completion accounts 2>/dev/null)'\n", cond)
+			}
+			for _, opt := range append(append([]string{}, n.flags...), n.values...) {
+				spec := "-l " + strings.TrimPrefix(opt, "--")
+				if !strings.HasPrefix(opt, "--") {
+					spec = "-s " + strings.TrimPrefix(opt, "-")
+				}
+				switch {
+				case contains(n.addresses, opt):
+					spec += " -x -a '(ndau completion accounts --addresses 2>/dev/null)'"
+				case contains(n.values, opt):
+					spec += " -r -F"
+				}
+				fmt.Fprintf(w, "complete -c ndau -n %s %s -d %s\n", cond, spec, fishQuote(n.descs[opt]))
+			}
+		}
+	})
+}
+
+// completionConfig loads the config without prompting for a passphrase
+//
+// If the keystore is locked, there is nothing to complete.
+func completionConfig() *config.Config {
+	if keystoreExists() {
+		if _, ok := os.LookupEnv(passphraseEnv); !ok {
+			if _, err := agentRequest("ping"); err != nil {
+				return nil
+			}
+		}
+	}
+	conf, err := loadConfig()
+	if err != nil {
+		return nil
+	}
+	conf, err = applyProfile(conf)
+	if err != nil {
+		return nil
+	}
+	return conf
+}
+
+func getCompletion(verbose *bool) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.LongDesc = `Generate a shell completion script.
+
+    bash: source <(ndau completion bash)
+    zsh:  ndau completion zsh > "${fpath[1]}/_ndau"
+    fish: ndau completion fish > ~/.config/fish/completions/ndau.fish
+
+Account names and addresses are completed from the config. If the keystore
+is encrypted, they are only completed while it is unlocked.`
+
+		for _, shell := range []struct {
+			name  string
+			write func(io.Writer, *compNode)
+		}{
+			{"bash", writeBash},
+			{"zsh", writeZsh},
+			{"fish", writeFish},
+		} {
+			write := shell.write
+			cmd.Command(shell.name, "generate "+shell.name+" completion", func(cmd *cli.Cmd) {
+				cmd.Action = func() {
+					exe, err := os.Executable()
+					orQuit(errors.Wrap(err, "finding ndau executable"))
+					root, err := buildCompTree(exe, nil, []string{""})
+					orQuit(errors.Wrap(err, "reading command tree"))
+					write(os.Stdout, root)
+				}
+			})
+		}
+
+		cmd.Command("accounts", "list account names for completion", func(cmd *cli.Cmd) {
+			cmd.Hidden = true
+			addresses := cmd.BoolOpt("addresses", false, "list addresses instead of names")
+			cmd.Action = func() {
+				conf := completionConfig()
+				if conf == nil {
+					return
+				}
+				var out []string
+				for _, acct := range conf.GetAccounts() {
+					if *addresses {
+						out = append(out, acct.Address.String())
+					} else if acct.Name != "" {
+						out = append(out, acct.Name)
+					}
+				}
+				sort.Strings(out)
+				for _, s := range out {
+					fmt.Println(s)
+				}
+			}
+		})
+	}
+}
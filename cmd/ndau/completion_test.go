@@ -0,0 +1,164 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cli "github.com/jawher/mow.cli"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// completionAppEnv makes the test binary act as completionApp, so that
+// completion can read real help text from it
+const completionAppEnv = "NDAU_COMPLETION_TEST_APP"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(completionAppEnv) != "" {
+		completionApp().Run(append([]string{"ndau"}, os.Args[1:]...))
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// completionApp is a small command tree in the style of ndau's
+func completionApp() *cli.Cli {
+	app := cli.App("ndau", "test app")
+	app.Spec = "[-v] [--profile=<NAME>]"
+	app.BoolOpt("v verbose", false, "emit additional output")
+	app.StringOpt("profile", "", "use this profile")
+
+	app.Command("account a", "manage accounts", func(cmd *cli.Cmd) {
+		cmd.Command("list", "list known accounts", func(cmd *cli.Cmd) {
+			cmd.Spec = "[--addresses]"
+			cmd.BoolOpt("addresses", false, "list addresses instead of names")
+			cmd.Action = func() {}
+		})
+		cmd.Command("lock", "lock an account", func(cmd *cli.Cmd) {
+			cmd.Spec = "NAME DURATION [-e=<ADDRESS>] [--keys]"
+			cmd.StringArg("NAME", "", "account name")
+			cmd.StringArg("DURATION", "", "lock period")
+			cmd.StringOpt("e eai-address", "", "send EAI to this address")
+			cmd.IntOpt("keys", 1, "number of keys to sign with")
+			cmd.Action = func() {}
+		})
+	})
+	app.Command("transfer", "transfer ndau", func(cmd *cli.Cmd) {
+		cmd.Spec = "FROM_NAME [--file]"
+		cmd.StringArg("FROM_NAME", "", "paying account")
+		cmd.StringOpt("file", "tx.json", "write the tx here")
+		cmd.Action = func() {}
+	})
+	return app
+}
+
+// testHelp reads help text from the test binary acting as completionApp
+func testHelp(t *testing.T, path ...string) string {
+	t.Setenv(completionAppEnv, "1")
+	help, err := helpFor(os.Args[0], path)
+	require.NoError(t, err)
+	return help
+}
+
+func TestParseHelp(t *testing.T) {
+	tests := []struct {
+		path []string
+		want compNode
+	}{
+		{
+			nil,
+			compNode{
+				commands: []string{"account", "a", "transfer"},
+				flags:    []string{"-v", "--verbose"},
+				values:   []string{"--profile"},
+			},
+		},
+		{
+			[]string{"account", "lock"},
+			compNode{
+				flags:     nil,
+				values:    []string{"-e", "--eai-address", "--keys"},
+				addresses: []string{"-e", "--eai-address"},
+				names:     true,
+				args:      2,
+			},
+		},
+		{
+			// a value option given in the spec without a placeholder
+			[]string{"transfer"},
+			compNode{
+				values: []string{"--file"},
+				names:  true,
+				args:   1,
+			},
+		},
+		{
+			[]string{"account", "list"},
+			compNode{
+				flags: []string{"--addresses"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(append([]string{"ndau"}, tt.path...), " "), func(t *testing.T) {
+			got := parseHelp(testHelp(t, tt.path...))
+			require.Equal(t, tt.want.commands, got.commands)
+			require.Equal(t, tt.want.flags, got.flags)
+			require.ElementsMatch(t, tt.want.values, got.values)
+			require.Equal(t, tt.want.addresses, got.addresses)
+			require.Equal(t, tt.want.names, got.names)
+			require.Equal(t, tt.want.args, got.args)
+		})
+	}
+}
+
+func TestCompletionScripts(t *testing.T) {
+	t.Setenv(completionAppEnv, "1")
+	root, err := buildCompTree(os.Args[0], nil, []string{""})
+	require.NoError(t, err)
+
+	for _, shell := range []struct {
+		name  string
+		write func(io.Writer, *compNode)
+	}{
+		{"bash", writeBash},
+		{"zsh", writeZsh},
+		{"fish", writeFish},
+	} {
+		t.Run(shell.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			shell.write(&buf, root)
+
+			golden := filepath.Join("testdata", "completion."+shell.name)
+			if *update {
+				require.NoError(t, ioutil.WriteFile(golden, buf.Bytes(), 0644))
+			}
+			want, err := ioutil.ReadFile(golden)
+			require.NoError(t, err)
+			require.Equal(t, string(want), buf.String())
+
+			// the script must at least parse, where the shell is installed
+			if sh, err := exec.LookPath(shell.name); err == nil {
+				out, err := exec.Command(sh, "-n", golden).CombinedOutput()
+				require.NoError(t, err, string(out))
+			}
+		})
+	}
+}
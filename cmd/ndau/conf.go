@@ -12,6 +12,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	sv "github.com/ndau/system_vars/pkg/system_vars"
@@ -28,18 +30,23 @@ func getConf(verbose *bool) func(*cli.Cmd) {
 		var addr = cmd.StringArg("ADDR", config.DefaultAddress, "Address of node to connect to")
 
 		cmd.Action = func() {
-			conf, err := config.Load(config.GetConfigPath())
+			conf, err := loadConfig()
 			if err != nil && os.IsNotExist(err) {
 				conf = config.NewConfig(*addr)
 			} else {
 				orQuit(errors.Wrap(err, "loading config"))
 				conf.Node = *addr
 			}
-			err = conf.Save()
+			err = saveConfig(conf)
 			orQuit(errors.Wrap(err, "Failed to save configuration"))
 		}
 
 		cmd.Command("update-from", "update the config from an associated-data file", confUpdateFrom)
+		cmd.Command("encrypt", "encrypt the config into a passphrase-protected keystore", confEncrypt)
+		cmd.Command("decrypt", "decrypt the keystore back into a plaintext config", confDecrypt)
+		cmd.Command("unlock", "run an agent which keeps the keystore unlocked for a while", confUnlock)
+		cmd.Command("lock", "stop a running unlock agent", confLock)
+		cmd.Command("profile", "manage network profiles", confProfile)
 	}
 }
 
@@ -61,7 +68,7 @@ func confUpdateFrom(cmd *cli.Cmd) {
 			orQuit(errors.New("path to associated data must be set"))
 		}
 
-		conf, err := config.Load(config.GetConfigPath())
+		conf, err := loadConfig()
 		orQuit(errors.Wrap(err, "loading existing config"))
 
 		err = conf.UpdateFrom(*asscpath)
@@ -83,7 +90,169 @@ func confUpdateFrom(cmd *cli.Cmd) {
 			},
 		})
 
-		err = conf.Save()
+		err = saveConfig(conf)
 		orQuit(errors.Wrap(err, "failed to save configuration"))
 	}
 }
+
+func confEncrypt(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		if keystoreExists() {
+			orQuit(errors.New("config is already encrypted: " + keystorePath()))
+		}
+		conf, err := config.Load(config.GetConfigPath())
+		orQuit(errors.Wrap(err, "loading existing config"))
+
+		p, err := newPassphrase()
+		orQuit(err)
+		data, err := marshalConfig(conf)
+		orQuit(errors.Wrap(err, "serializing config"))
+		ks, err := encrypt(data, p)
+		orQuit(errors.Wrap(err, "encrypting config"))
+		err = ks.save(keystorePath())
+		orQuit(errors.Wrap(err, "writing keystore"))
+
+		// only remove the plaintext once we're sure the keystore can be read back
+		ks, err = loadKeystore(keystorePath())
+		orQuit(errors.Wrap(err, "verifying keystore"))
+		_, err = ks.decrypt(p)
+		orQuit(errors.Wrap(err, "verifying keystore"))
+		err = os.Remove(config.GetConfigPath())
+		orQuit(errors.Wrap(err, "removing plaintext config"))
+
+		fmt.Println("config encrypted into", keystorePath())
+	}
+}
+
+func confDecrypt(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		if !keystoreExists() {
+			orQuit(errors.New("config is not encrypted"))
+		}
+		conf, err := unlockKeystore()
+		orQuit(errors.Wrap(err, "unlocking keystore"))
+		err = conf.Save()
+		orQuit(errors.Wrap(err, "writing plaintext config"))
+		err = os.Remove(keystorePath())
+		orQuit(errors.Wrap(err, "removing keystore"))
+
+		fmt.Println("config decrypted into", config.GetConfigPath())
+	}
+}
+
+func confUnlock(cmd *cli.Cmd) {
+	var (
+		timeout = cmd.StringOpt("t timeout", "15m", "lock the keystore again after this long")
+	)
+
+	cmd.Spec = "[-t]"
+
+	cmd.Action = func() {
+		if !keystoreExists() {
+			orQuit(errors.New("config is not encrypted"))
+		}
+		d, err := time.ParseDuration(*timeout)
+		orQuit(errors.Wrap(err, "parsing timeout"))
+
+		ks, err := loadKeystore(keystorePath())
+		orQuit(errors.Wrap(err, "loading keystore"))
+		p := []byte(os.Getenv(passphraseEnv))
+		if len(p) == 0 {
+			p, err = readPassphrase("keystore passphrase: ")
+			orQuit(err)
+		}
+		_, err = ks.decrypt(p)
+		orQuit(err)
+
+		fmt.Fprintf(os.Stderr, "keystore unlocked for %s at %s\n", d, agentPath())
+		err = runAgent(p, d)
+		orQuit(errors.Wrap(err, "running unlock agent"))
+		fmt.Fprintln(os.Stderr, "keystore locked")
+	}
+}
+
+func confLock(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		_, err := agentRequest("lock")
+		orQuit(errors.Wrap(err, "contacting unlock agent"))
+	}
+}
+
+func confProfile(cmd *cli.Cmd) {
+	cmd.Command("add", "add or replace a network profile", confProfileAdd)
+	cmd.Command("list ls", "list network profiles", confProfileList)
+	cmd.Command("use", "make a profile current", confProfileUse)
+}
+
+func confProfileAdd(cmd *cli.Cmd) {
+	var (
+		name     = cmd.StringArg("NAME", "", "Name of profile")
+		node     = cmd.StringArg("NODE", "", "RPC address of a node on this network")
+		api      = cmd.StringOpt("a api", "", "address of an ndauapi instance on this network")
+		accounts = cmd.StringsOpt("account", nil, "restrict this profile to these accounts (repeatable)")
+		mainnet  = cmd.BoolOpt("mainnet", false, "require confirmation before sending txs")
+		use      = cmd.BoolOpt("u use", false, "make this profile current")
+	)
+
+	cmd.Spec = "NAME NODE [-a=<api>] [--account=<name>...] [--mainnet] [-u]"
+
+	cmd.Action = func() {
+		ps, err := loadProfiles()
+		orQuit(err)
+		ps.Profiles[*name] = &profile{
+			Node:     *node,
+			API:      *api,
+			Accounts: *accounts,
+			Mainnet:  *mainnet,
+		}
+		if *use || len(ps.Profiles) == 1 {
+			ps.Current = *name
+		}
+		err = ps.save()
+		orQuit(errors.Wrap(err, "saving profiles"))
+	}
+}
+
+func confProfileList(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		ps, err := loadProfiles()
+		orQuit(err)
+		current, _, err := selectProfile(ps)
+		orQuit(err)
+		for _, name := range ps.names() {
+			p := ps.Profiles[name]
+			marker := " "
+			if name == current {
+				marker = "*"
+			}
+			fmt.Printf("%s %s: %s", marker, name, p.Node)
+			if p.API != "" {
+				fmt.Printf(" (api %s)", p.API)
+			}
+			if p.Mainnet {
+				fmt.Print(" [MAINNET]")
+			}
+			if len(p.Accounts) > 0 {
+				fmt.Printf(" accounts: %s", strings.Join(p.Accounts, ", "))
+			}
+			fmt.Println()
+		}
+	}
+}
+
+func confProfileUse(cmd *cli.Cmd) {
+	var name = cmd.StringArg("NAME", "", "Name of profile; empty to use no profile")
+
+	cmd.Spec = "[NAME]"
+
+	cmd.Action = func() {
+		ps, err := loadProfiles()
+		orQuit(err)
+		if _, ok := ps.Profiles[*name]; !ok && *name != "" {
+			orQuit(fmt.Errorf("unknown profile: %s", *name))
+		}
+		ps.Current = *name
+		err = ps.save()
+		orQuit(errors.Wrap(err, "saving profiles"))
+	}
+}
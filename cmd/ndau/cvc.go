@@ -14,8 +14,6 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
-	config "github.com/ndau/ndau/pkg/tool.config"
 	"github.com/pkg/errors"
 )
 
@@ -47,15 +45,15 @@ func getCVC(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd) {
 				orQuit(errors.New("CVC data not set in tool config"))
 			}
 
-			fkeys := config.FilterK(conf.CVC.Keys, *keys)
-
+			signer := sysSigner("cvc", conf.CVC, *keys)
 			cvc := ndau.NewCommandValidatorChange(
 				acct.Address, int64(*power),
 				sequence(conf, conf.CVC.Address),
-				fkeys...,
+				signer.Keys()...,
 			)
+			signer.Sign(cvc)
 
-			result, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), cvc)
+			result, err := sendTx(tmnode(conf.Node, emitJSON, compact), cvc)
 			finish(*verbose, result, err, "cvc")
 		}
 	}
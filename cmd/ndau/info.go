@@ -16,7 +16,6 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/tool"
-	"github.com/tendermint/tendermint/rpc/client"
 )
 
 func getInfo(verbose *bool) func(*cli.Cmd) {
@@ -29,7 +28,7 @@ func getInfo(verbose *bool) func(*cli.Cmd) {
 
 		cmd.Action = func() {
 			config := getConfig()
-			info, err := tool.Info(tmnode(config.Node, nil, nil).(*client.HTTP))
+			info, err := tool.Info(httpnode(config.Node))
 
 			if *key {
 				b := info.ValidatorInfo.PubKey.Bytes()
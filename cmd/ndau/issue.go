@@ -14,8 +14,6 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
-	config "github.com/ndau/ndau/pkg/tool.config"
 	"github.com/pkg/errors"
 )
 
@@ -37,15 +35,15 @@ func getIssue(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd)
 				orQuit(errors.New("RFE data (for issuance) not set in tool config"))
 			}
 
-			keys := config.FilterK(conf.RFE.Keys, *keys)
-
+			signer := sysSigner("rfe", conf.RFE, *keys)
 			issue := ndau.NewIssue(
 				ndauQty,
 				sequence(conf, conf.RFE.Address),
-				keys...,
+				signer.Keys()...,
 			)
+			signer.Sign(issue)
 
-			result, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), issue)
+			result, err := sendTx(tmnode(conf.Node, emitJSON, compact), issue)
 			finish(*verbose, result, err, "issue")
 		}
 	}
@@ -0,0 +1,440 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	config "github.com/ndau/ndau/pkg/tool.config"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+// passphraseEnv names the environment variable which, if set, is used as
+// the keystore passphrase instead of prompting for it
+const passphraseEnv = "NDAU_PASSPHRASE"
+
+// keystoreVersion is the current version of the keystore file format
+const keystoreVersion = 1
+
+// scrypt parameters for newly encrypted keystores
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// A keystore is an encrypted ndau tool configuration
+//
+// The entire TOML configuration, including every private key, is encrypted
+// with AES-256-GCM under a key derived from a passphrase with scrypt.
+type keystore struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// passphrase is retained once the keystore has been unlocked, so that
+// modified configuration can be re-encrypted on save
+var passphrase []byte
+
+// keystorePath returns the location of the encrypted configuration
+func keystorePath() string {
+	return strings.TrimSuffix(config.GetConfigPath(), ".toml") + ".keystore"
+}
+
+// agentPath returns the location of the unlock agent's socket
+func agentPath() string {
+	return filepath.Join(filepath.Dir(config.GetConfigPath()), "agent.sock")
+}
+
+// keystoreExists is true when the configuration is encrypted
+func keystoreExists() bool {
+	_, err := os.Stat(keystorePath())
+	return err == nil
+}
+
+func (ks *keystore) key(passphrase []byte) ([]byte, error) {
+	if ks.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported keystore kdf: %s", ks.KDF)
+	}
+	return scrypt.Key(passphrase, ks.Salt, ks.N, ks.R, ks.P, scryptKeyLen)
+}
+
+func (ks *keystore) aead(passphrase []byte) (cipher.AEAD, error) {
+	key, err := ks.key(passphrase)
+	if err != nil {
+		return nil, errors.Wrap(err, "deriving key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing cipher")
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt plaintext into a new keystore
+func encrypt(plaintext, passphrase []byte) (*keystore, error) {
+	ks := &keystore{
+		Version: keystoreVersion,
+		KDF:     "scrypt",
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    make([]byte, 32),
+	}
+	_, err := rand.Read(ks.Salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating salt")
+	}
+	aead, err := ks.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	ks.Nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(ks.Nonce)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating nonce")
+	}
+	ks.Ciphertext = aead.Seal(nil, ks.Nonce, plaintext, nil)
+	return ks, nil
+}
+
+// decrypt the keystore's contents
+func (ks *keystore) decrypt(passphrase []byte) ([]byte, error) {
+	if ks.Version != keystoreVersion {
+		return nil, fmt.Errorf("unsupported keystore version: %d", ks.Version)
+	}
+	aead, err := ks.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, ks.Nonce, ks.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("incorrect passphrase or corrupt keystore")
+	}
+	return plaintext, nil
+}
+
+func loadKeystore(path string) (*keystore, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ks := new(keystore)
+	err = json.Unmarshal(data, ks)
+	return ks, errors.Wrap(err, "parsing keystore")
+}
+
+func (ks *keystore) save(path string) error {
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	// write then rename, so that a failure can't destroy the only copy of the keys
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600) // u=rw;go-
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// tomlConfig mirrors the on-disk layout of config.Config, which is not
+// exported by the config package
+type tomlConfig struct {
+	Node        string             `toml:"node"`
+	Accounts    []config.Account   `toml:"accounts"`
+	RFE         *config.SysAccount `toml:"rfe"`
+	NNR         *config.SysAccount `toml:"nnr"`
+	CVC         *config.SysAccount `toml:"cvc"`
+	RecordPrice *config.SysAccount `toml:"record_price"`
+	SetSysvar   *config.SysAccount `toml:"set_sysvar"`
+}
+
+// marshalConfig serializes a config exactly as config.Save would
+func marshalConfig(conf *config.Config) ([]byte, error) {
+	tc := tomlConfig{
+		Node:        conf.Node,
+		RFE:         conf.RFE,
+		NNR:         conf.NNR,
+		CVC:         conf.CVC,
+		RecordPrice: conf.RecordPrice,
+		SetSysvar:   conf.SetSysvar,
+	}
+	for _, acct := range conf.GetAccounts() {
+		tc.Accounts = append(tc.Accounts, *acct)
+	}
+	sort.Slice(tc.Accounts, func(i, j int) bool { return tc.Accounts[i].Name < tc.Accounts[j].Name })
+
+	buf := new(bytes.Buffer)
+	err := toml.NewEncoder(buf).Encode(tc)
+	return buf.Bytes(), err
+}
+
+// unmarshalConfig deserializes a config exactly as config.Load would
+func unmarshalConfig(data []byte) (*config.Config, error) {
+	tc := tomlConfig{}
+	err := toml.Unmarshal(data, &tc)
+	if err != nil {
+		return nil, err
+	}
+	conf := config.NewConfig(tc.Node)
+	conf.RFE = tc.RFE
+	conf.NNR = tc.NNR
+	conf.CVC = tc.CVC
+	conf.RecordPrice = tc.RecordPrice
+	conf.SetSysvar = tc.SetSysvar
+	for _, act := range tc.Accounts {
+		acct := act
+		conf.Accounts[acct.Address.String()] = &acct
+		if acct.Name != "" {
+			conf.Accounts[acct.Name] = &acct
+		}
+	}
+	return conf, nil
+}
+
+// readPassphrase prompts for a passphrase on the terminal without echoing it
+func readPassphrase(prompt string) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("no terminal available; set %s", passphraseEnv))
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	stty := func(args ...string) error {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = tty
+		return cmd.Run()
+	}
+	if stty("-echo") == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(tty)
+		}()
+	}
+
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return nil, errors.Wrap(err, "reading passphrase")
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// newPassphrase gets a passphrase with which to encrypt the keystore
+//
+// When prompting, the passphrase must be entered twice.
+func newPassphrase() ([]byte, error) {
+	if p, ok := os.LookupEnv(passphraseEnv); ok {
+		if p == "" {
+			return nil, errors.New(passphraseEnv + " must not be empty")
+		}
+		return []byte(p), nil
+	}
+	p, err := readPassphrase("new keystore passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	confirm, err := readPassphrase("confirm passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(p, confirm) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return p, nil
+}
+
+// unlockPassphrase gets the passphrase for an existing keystore
+//
+// In order, it tries the environment, a running unlock agent, and finally
+// prompts on the terminal.
+func unlockPassphrase() ([]byte, error) {
+	if p, ok := os.LookupEnv(passphraseEnv); ok {
+		return []byte(p), nil
+	}
+	if p, err := agentRequest("get"); err == nil {
+		return []byte(p), nil
+	}
+	return readPassphrase("keystore passphrase: ")
+}
+
+// unlockKeystore decrypts the keystore, returning the plaintext config
+func unlockKeystore() (*config.Config, error) {
+	ks, err := loadKeystore(keystorePath())
+	if err != nil {
+		return nil, errors.Wrap(err, "loading keystore")
+	}
+	p, err := unlockPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ks.decrypt(p)
+	if err != nil {
+		return nil, err
+	}
+	passphrase = p
+	conf, err := unmarshalConfig(data)
+	return conf, errors.Wrap(err, "parsing decrypted config")
+}
+
+// saveConfig saves the configuration, encrypting it if a keystore is in use
+func saveConfig(conf *config.Config) error {
+	conf, err := unapplyProfile(conf)
+	if err != nil {
+		return err
+	}
+	if !keystoreExists() {
+		return conf.Save()
+	}
+	if passphrase == nil {
+		passphrase, err = unlockPassphrase()
+		if err != nil {
+			return err
+		}
+	}
+	data, err := marshalConfig(conf)
+	if err != nil {
+		return errors.Wrap(err, "serializing config")
+	}
+	ks, err := encrypt(data, passphrase)
+	if err != nil {
+		return errors.Wrap(err, "encrypting config")
+	}
+	return errors.Wrap(ks.save(keystorePath()), "writing keystore")
+}
+
+// agentRequest sends a single command to the unlock agent and returns its reply
+func agentRequest(command string) (string, error) {
+	conn, err := net.DialTimeout("unix", agentPath(), time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = fmt.Fprintln(conn, command)
+	if err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	reply = strings.TrimRight(reply, "\n")
+	if strings.HasPrefix(reply, "error: ") {
+		return "", errors.New(strings.TrimPrefix(reply, "error: "))
+	}
+	return reply, nil
+}
+
+// runAgent serves the keystore passphrase over a unix socket until the
+// timeout expires or it is told to lock
+//
+// The socket is only accessible by the current user. Supported commands:
+//
+//	get:  reply with the passphrase
+//	lock: forget the passphrase and exit
+func runAgent(passphrase []byte, timeout time.Duration) error {
+	path := agentPath()
+	if _, err := agentRequest("ping"); err == nil {
+		return errors.New("an unlock agent is already running")
+	}
+	// remove any stale socket from an agent which didn't exit cleanly
+	os.Remove(path)
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	ln, err := listenUnix(path)
+	if err != nil {
+		return errors.Wrap(err, "listening")
+	}
+	defer os.Remove(path)
+	defer ln.Close()
+
+	done := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(done) })
+	defer timer.Stop()
+	go func() {
+		<-done
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-done:
+				return nil
+			default:
+				return errors.Wrap(err, "accepting connection")
+			}
+		}
+		lock := serveAgent(conn, passphrase)
+		if lock {
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+// serveAgent handles a single agent connection, returning true if the
+// agent should lock
+func serveAgent(conn net.Conn, passphrase []byte) bool {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	command, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	switch strings.TrimSpace(command) {
+	case "get":
+		fmt.Fprintf(conn, "%s\n", passphrase)
+	case "ping":
+		fmt.Fprintln(conn, "pong")
+	case "lock":
+		fmt.Fprintln(conn, "locked")
+		return true
+	default:
+		fmt.Fprintln(conn, "error: unknown command")
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	plaintext := []byte("[node]\naddress = \"http://localhost:26670\"\n")
+	ks, err := encrypt(plaintext, []byte("correct horse"))
+	require.NoError(t, err)
+	require.NotContains(t, string(ks.Ciphertext), "localhost")
+
+	// through the file, as the tool uses it
+	path := filepath.Join(t.TempDir(), "ndautool.keystore")
+	require.NoError(t, ks.save(path))
+	loaded, err := loadKeystore(path)
+	require.NoError(t, err)
+
+	got, err := loaded.decrypt([]byte("correct horse"))
+	require.NoError(t, err)
+	require.Equal(t, plaintext, got)
+}
+
+func TestKeystoreWrongPassphrase(t *testing.T) {
+	ks, err := encrypt([]byte("secret"), []byte("correct horse"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		modify func(*keystore)
+		pass   string
+	}{
+		{"wrong passphrase", func(*keystore) {}, "battery staple"},
+		{"empty passphrase", func(*keystore) {}, ""},
+		{"tampered ciphertext", func(ks *keystore) { ks.Ciphertext[0] ^= 1 }, "correct horse"},
+		{"wrong version", func(ks *keystore) { ks.Version++ }, "correct horse"},
+		{"unknown kdf", func(ks *keystore) { ks.KDF = "pbkdf2" }, "correct horse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := *ks
+			c.Ciphertext = append([]byte(nil), ks.Ciphertext...)
+			tt.modify(&c)
+			_, err := c.decrypt([]byte(tt.pass))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestNewPassphraseFromEnv(t *testing.T) {
+	t.Setenv(passphraseEnv, "correct horse")
+	p, err := newPassphrase()
+	require.NoError(t, err)
+	require.Equal(t, "correct horse", string(p))
+
+	t.Setenv(passphraseEnv, "")
+	_, err = newPassphrase()
+	require.Error(t, err)
+}
@@ -18,7 +18,7 @@ import (
 func main() {
 	app := cli.App("ndau", "interact with the ndau chain")
 
-	app.Spec = "[-v][-k][-j [-c]]"
+	app.Spec = "[-v][-k][-j [-c]][-p=<profile>][-y][--async | --confirmations=<N>]"
 
 	var (
 		verbose  = app.BoolOpt("v verbose", false, "emit detailed results from the ndau chain if set")
@@ -26,6 +26,14 @@ func main() {
 		emitJSON = app.BoolOpt("j json", false, "emit tx as JSON instead of sending to node")
 		compact  = app.BoolOpt("c compact", false, "emit compact JSON (default: pretty)")
 	)
+	profileName = app.String(cli.StringOpt{
+		Name:   "p profile",
+		Desc:   "use this network profile",
+		EnvVar: profileEnv,
+	})
+	skipConfirm = app.BoolOpt("y yes", false, "send to mainnet profiles without confirmation")
+	sendAsync = app.BoolOpt("async", false, "print the tx hash once the tx is accepted, instead of waiting for it to be committed")
+	confirmations = app.IntOpt("confirmations", 0, "after a tx is committed, wait until this many more blocks have been committed")
 
 	app.Command("conf", "perform initial configuration", getConf(verbose))
 	app.Command("conf-path", "show location of config file", confPath)
@@ -47,6 +55,9 @@ func main() {
 	app.Command("version", "emit version information and quit", getVersion(verbose))
 	app.Command("signable-bytes", "emit the signable bytes of the input tx", getSignableBytes(verbose))
 	app.Command("send", "send a pre-prepared transaction", getSendJSON(verbose))
+	app.Command("tx", "render, send, and follow txs", getTx(verbose, keys, emitJSON, compact))
+	app.Command("completion", "generate shell completion scripts", getCompletion(verbose))
+	app.Command("serve", "serve the CLI's operations over local JSON-RPC", getServe(verbose))
 
 	app.Run(os.Args)
 }
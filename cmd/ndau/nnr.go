@@ -17,8 +17,6 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
-	config "github.com/ndau/ndau/pkg/tool.config"
 	"github.com/pkg/errors"
 )
 
@@ -53,15 +51,15 @@ func getNNR(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd) {
 				orQuit(errors.New("NNR data not set in tool config"))
 			}
 
-			keys := config.FilterK(conf.NNR.Keys, *keys)
-
+			signer := sysSigner("nnr", conf.NNR, *keys)
 			nnr := ndau.NewNominateNodeReward(
 				random,
 				sequence(conf, conf.NNR.Address),
-				keys...,
+				signer.Keys()...,
 			)
+			signer.Sign(nnr)
 
-			result, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), nnr)
+			result, err := sendTx(tmnode(conf.Node, emitJSON, compact), nnr)
 			finish(*verbose, result, err, "nnr")
 		}
 	}
@@ -0,0 +1,288 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	metatx "github.com/ndau/metanode/pkg/meta/transaction"
+	"github.com/ndau/ndau/pkg/ndau"
+	config "github.com/ndau/ndau/pkg/tool.config"
+	"github.com/pkg/errors"
+	"github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	ttypes "github.com/tendermint/tendermint/types"
+)
+
+// profileEnv names the environment variable which selects a profile
+const profileEnv = "NDAU_PROFILE"
+
+// A profile describes a network and the accounts used with it
+type profile struct {
+	// Node is the RPC address of a node on this network
+	Node string `toml:"node"`
+	// API is the address of an ndauapi instance for this network
+	API string `toml:"api,omitempty"`
+	// Accounts restricts the visible accounts to these names or addresses.
+	// If empty, all accounts are visible.
+	Accounts []string `toml:"accounts,omitempty"`
+	// Mainnet profiles require confirmation before sending any tx
+	Mainnet bool `toml:"mainnet,omitempty"`
+}
+
+// profiles is the layout of profiles.toml
+type profiles struct {
+	Current  string              `toml:"current,omitempty"`
+	Profiles map[string]*profile `toml:"profiles"`
+}
+
+var (
+	// profileName is the profile requested on the command line or environment
+	profileName *string
+	// skipConfirm suppresses the confirmation prompt for mainnet sends
+	skipConfirm *bool
+
+	// activeName and active are the profile in use, if any
+	activeName string
+	active     *profile
+
+	// profileView tracks the config view created by a profile, so that
+	// changes to it can be merged back into the full config on save
+	profileView *viewState
+)
+
+type viewState struct {
+	full    *config.Config
+	view    *config.Config
+	initial []*config.Account
+}
+
+// profilesPath returns the location of the profiles file
+func profilesPath() string {
+	return filepath.Join(filepath.Dir(config.GetConfigPath()), "profiles.toml")
+}
+
+func loadProfiles() (*profiles, error) {
+	ps := &profiles{Profiles: make(map[string]*profile)}
+	_, err := toml.DecodeFile(profilesPath(), ps)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "loading profiles")
+	}
+	if ps.Profiles == nil {
+		ps.Profiles = make(map[string]*profile)
+	}
+	return ps, nil
+}
+
+func (ps *profiles) save() error {
+	buf := new(bytes.Buffer)
+	err := toml.NewEncoder(buf).Encode(ps)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(profilesPath()), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(profilesPath(), buf.Bytes(), 0600)
+}
+
+// names returns the profile names, sorted
+func (ps *profiles) names() []string {
+	names := make([]string, 0, len(ps.Profiles))
+	for name := range ps.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectProfile determines which profile is in use, if any
+//
+// Precedence: --profile, then NDAU_PROFILE, then the current profile
+// recorded in profiles.toml.
+func selectProfile(ps *profiles) (string, *profile, error) {
+	name := ""
+	if profileName != nil {
+		name = *profileName
+	}
+	if name == "" {
+		name = ps.Current
+	}
+	if name == "" {
+		return "", nil, nil
+	}
+	p, ok := ps.Profiles[name]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown profile: %s", name)
+	}
+	return name, p, nil
+}
+
+// applyProfile returns a view of the config as seen through the selected
+// profile, if any
+func applyProfile(full *config.Config) (*config.Config, error) {
+	ps, err := loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+	activeName, active, err = selectProfile(ps)
+	if err != nil || active == nil {
+		return full, err
+	}
+
+	view := *full
+	if active.Node != "" {
+		view.Node = active.Node
+	}
+	if len(active.Accounts) > 0 {
+		view.Accounts = make(map[string]*config.Account)
+		for _, name := range active.Accounts {
+			if acct, ok := full.Accounts[name]; ok {
+				view.Accounts[acct.Address.String()] = acct
+				if acct.Name != "" {
+					view.Accounts[acct.Name] = acct
+				}
+			}
+		}
+	}
+	profileView = &viewState{
+		full:    full,
+		view:    &view,
+		initial: view.GetAccounts(),
+	}
+	return &view, nil
+}
+
+// unapplyProfile merges changes made to a profile's view of the config back
+// into the full config
+//
+// Accounts created while a restricted profile is active are added to it.
+func unapplyProfile(conf *config.Config) (*config.Config, error) {
+	if profileView == nil || conf != profileView.view {
+		return conf, nil
+	}
+	full := profileView.full
+	for _, acct := range profileView.initial {
+		if _, ok := conf.Accounts[acct.Address.String()]; !ok {
+			delete(full.Accounts, acct.Name)
+			delete(full.Accounts, acct.Address.String())
+		}
+	}
+
+	added := false
+	for _, acct := range conf.GetAccounts() {
+		full.SetAccount(*acct)
+		if len(active.Accounts) > 0 && !active.has(acct) {
+			// unnamed accounts are listed by address
+			name := acct.Name
+			if name == "" {
+				name = acct.Address.String()
+			}
+			active.Accounts = append(active.Accounts, name)
+			added = true
+		}
+	}
+	if added {
+		ps, err := loadProfiles()
+		if err != nil {
+			return nil, err
+		}
+		ps.Profiles[activeName] = active
+		err = ps.save()
+		if err != nil {
+			return nil, errors.Wrap(err, "saving profiles")
+		}
+	}
+	return full, nil
+}
+
+func (p *profile) has(acct *config.Account) bool {
+	for _, name := range p.Accounts {
+		if name == acct.Name || name == acct.Address.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// apiAddress returns the ndauapi address of the active profile
+func apiAddress() (string, error) {
+	if active == nil || active.API == "" {
+		return "", errors.New("no API address: select a profile which has one")
+	}
+	return active.API, nil
+}
+
+// mainnetGuard is a client which asks for confirmation before broadcasting
+// any tx
+type mainnetGuard struct {
+	client.ABCIClient
+}
+
+func (mainnetGuard) confirm(txbytes ttypes.Tx) error {
+	if skipConfirm != nil && *skipConfirm {
+		return nil
+	}
+	if serving {
+		return errors.New("mainnet profile: restart the server with -y to allow sends")
+	}
+	summary := "unknown tx"
+	if tx, err := metatx.Unmarshal(txbytes, ndau.TxIDs); err == nil {
+		summary = summarize(tx)
+	}
+	// stdin may be carrying the tx itself, so ask on the terminal
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return errors.New("mainnet profile: no terminal to confirm on; use -y to allow sends")
+	}
+	defer tty.Close()
+	fmt.Fprintf(tty, "profile %s is MAINNET. About to send:\n  %s\n", activeName, summary)
+	fmt.Fprint(tty, "Proceed? [y/N] ")
+	scanner := bufio.NewScanner(tty)
+	scanner.Scan()
+	switch strings.ToLower(strings.TrimSpace(scanner.Text())) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("not confirmed; tx not sent")
+}
+
+// BroadcastTxCommit implements ABCIClient
+func (g mainnetGuard) BroadcastTxCommit(tx ttypes.Tx) (*ctypes.ResultBroadcastTxCommit, error) {
+	if err := g.confirm(tx); err != nil {
+		return nil, err
+	}
+	return g.ABCIClient.BroadcastTxCommit(tx)
+}
+
+// BroadcastTxSync implements ABCIClient
+func (g mainnetGuard) BroadcastTxSync(tx ttypes.Tx) (*ctypes.ResultBroadcastTx, error) {
+	if err := g.confirm(tx); err != nil {
+		return nil, err
+	}
+	return g.ABCIClient.BroadcastTxSync(tx)
+}
+
+// BroadcastTxAsync implements ABCIClient
+func (g mainnetGuard) BroadcastTxAsync(tx ttypes.Tx) (*ctypes.ResultBroadcastTx, error) {
+	if err := g.confirm(tx); err != nil {
+		return nil, err
+	}
+	return g.ABCIClient.BroadcastTxAsync(tx)
+}
@@ -14,7 +14,6 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
 	"github.com/pkg/errors"
 )
 
@@ -43,13 +42,15 @@ func getRecordEndowmentNAV(verbose *bool, keys *int, emitJSON, compact *bool) fu
 			}
 
 			// construct the RecordEndowmentNAV
+			signer := sysSigner("rfe", conf.RFE, -1)
 			RecordEndowmentNAV := ndau.NewRecordEndowmentNAV(
 				nanocentQty,
 				sequence(conf, conf.RFE.Address),
-				conf.RFE.Keys...,
+				signer.Keys()...,
 			)
+			signer.Sign(RecordEndowmentNAV)
 
-			tresp, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), RecordEndowmentNAV)
+			tresp, err := sendTx(tmnode(conf.Node, emitJSON, compact), RecordEndowmentNAV)
 			finish(*verbose, tresp, err, "record-price")
 		}
 	}
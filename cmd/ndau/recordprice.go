@@ -14,7 +14,6 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
 	"github.com/pkg/errors"
 )
 
@@ -43,13 +42,15 @@ func getRecordPrice(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli
 			}
 
 			// construct the recordPrice
+			signer := sysSigner("record_price", conf.RecordPrice, -1)
 			recordPrice := ndau.NewRecordPrice(
 				nanocentQty,
 				sequence(conf, conf.RecordPrice.Address),
-				conf.RecordPrice.Keys...,
+				signer.Keys()...,
 			)
+			signer.Sign(recordPrice)
 
-			tresp, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), recordPrice)
+			tresp, err := sendTx(tmnode(conf.Node, emitJSON, compact), recordPrice)
 			finish(*verbose, tresp, err, "record-price")
 		}
 	}
@@ -14,8 +14,6 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
-	config "github.com/ndau/ndau/pkg/tool.config"
 	"github.com/pkg/errors"
 )
 
@@ -43,16 +41,16 @@ func getRfe(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd) {
 				orQuit(errors.New("RFE data not set in tool config"))
 			}
 
-			keys := config.FilterK(conf.RFE.Keys, *keys)
-
+			signer := sysSigner("rfe", conf.RFE, *keys)
 			rfe := ndau.NewReleaseFromEndowment(
 				address,
 				ndauQty,
 				sequence(conf, conf.RFE.Address),
-				keys...,
+				signer.Keys()...,
 			)
+			signer.Sign(rfe)
 
-			result, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), rfe)
+			result, err := sendTx(tmnode(conf.Node, emitJSON, compact), rfe)
 			finish(*verbose, result, err, "rfe")
 		}
 	}
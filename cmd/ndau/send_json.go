@@ -13,7 +13,6 @@ import (
 	"fmt"
 
 	cli "github.com/jawher/mow.cli"
-	"github.com/ndau/ndau/pkg/tool"
 )
 
 func getSendJSON(verbose *bool) func(*cli.Cmd) {
@@ -28,7 +27,7 @@ func getSendJSON(verbose *bool) func(*cli.Cmd) {
 		cmd.Action = func() {
 			tx := getJSONTX()
 			conf := getConfig()
-			resp, err := tool.SendCommit(tmnode(conf.Node, nil, nil), tx)
+			resp, err := sendTx(tmnode(conf.Node, nil, nil), tx)
 			finish(*verbose, resp, err, "send-json")
 		}
 	}
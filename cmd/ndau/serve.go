@@ -0,0 +1,468 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"syscall"
+
+	cli "github.com/jawher/mow.cli"
+	"github.com/ndau/commands/cmd/sysvar/sysvar"
+	metatx "github.com/ndau/metanode/pkg/meta/transaction"
+	"github.com/ndau/ndau/pkg/ndau"
+	"github.com/ndau/ndau/pkg/ndau/backing"
+	"github.com/ndau/ndau/pkg/tool"
+	config "github.com/ndau/ndau/pkg/tool.config"
+	"github.com/ndau/ndaumath/pkg/address"
+	math "github.com/ndau/ndaumath/pkg/types"
+	"github.com/pkg/errors"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+)
+
+// serving is set while in server mode, so that failures are returned to the
+// client instead of exiting
+var serving bool
+
+// serveError carries a failure from orQuit back to the request which caused it
+type serveError struct {
+	err error
+}
+
+// server is the JSON-RPC service, registered as "Ndau"
+//
+// Requests are handled one at a time: the CLI's helpers share global state.
+type server struct {
+	lock sync.Mutex
+	conf *config.Config
+}
+
+// call runs f, converting any failure within it to an error
+func (s *server) call(f func()) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(serveError)
+			if !ok {
+				// a bug shouldn't take the server down with it
+				fmt.Fprintf(os.Stderr, "panic serving request: %v\n%s", r, debug.Stack())
+				err = fmt.Errorf("internal error: %v", r)
+				return
+			}
+			err = se.err
+		}
+	}()
+	f()
+	return nil
+}
+
+// account looks up an account in the config by name or address
+func (s *server) account(name string) *config.Account {
+	acct, ok := s.conf.Accounts[name]
+	if !ok || acct == nil {
+		orQuit(fmt.Errorf("no such account: %s", name))
+	}
+	if !hasValidation(acct) {
+		orQuit(fmt.Errorf("validation key for %s not set", name))
+	}
+	return acct
+}
+
+// address resolves a config account name or an address
+func (s *server) address(name string) address.Address {
+	if acct, ok := s.conf.Accounts[name]; ok && acct != nil {
+		return acct.Address
+	}
+	addr, err := address.Validate(name)
+	orQuit(errors.Wrap(err, "unknown account"))
+	return addr
+}
+
+func keysOrAll(keys *int) int {
+	if keys == nil {
+		return -1
+	}
+	return *keys
+}
+
+// TxResult is the result of sending a tx
+type TxResult struct {
+	Hash   string `json:"hash"`
+	Height int64  `json:"height"`
+	Code   uint32 `json:"code"`
+	Log    string `json:"log,omitempty"`
+}
+
+func (s *server) send(tx metatx.Transactable, reply *TxResult) {
+	resp, err := tool.SendCommit(tmnode(s.conf.Node, nil, nil), tx)
+	*reply = TxResult{Hash: metatx.Hash(tx), Log: tool.ResultLog(resp)}
+	if r, ok := resp.(*ctypes.ResultBroadcastTxCommit); ok && r != nil {
+		reply.Height = r.Height
+		reply.Code = r.DeliverTx.Code
+		if r.CheckTx.Code != 0 {
+			reply.Code = r.CheckTx.Code
+		}
+	}
+	orQuit(err)
+}
+
+// AccountInfo describes an account in the config
+type AccountInfo struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// Accounts lists the accounts in the config
+func (s *server) Accounts(_ struct{}, reply *[]AccountInfo) error {
+	return s.call(func() {
+		*reply = []AccountInfo{}
+		for _, acct := range s.conf.GetAccounts() {
+			*reply = append(*reply, AccountInfo{Name: acct.Name, Address: acct.Address.String()})
+		}
+	})
+}
+
+// AccountArgs names an account by config name or address
+type AccountArgs struct {
+	Account string `json:"account"`
+}
+
+// Account queries the chain about an account
+func (s *server) Account(args AccountArgs, reply *backing.AccountData) error {
+	return s.call(func() {
+		ad, _, err := tool.GetAccount(tmnode(s.conf.Node, nil, nil), s.address(args.Account))
+		orQuit(err)
+		*reply = *ad
+	})
+}
+
+// TransferArgs describes a transfer
+type TransferArgs struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Qty    string `json:"qty"`
+	Period string `json:"period,omitempty"`
+	Keys   *int   `json:"keys,omitempty"`
+}
+
+// Transfer sends ndau from a config account
+//
+// If a period is given, the destination is locked with it.
+func (s *server) Transfer(args TransferArgs, reply *TxResult) error {
+	return s.call(func() {
+		from := s.account(args.From)
+		to := s.address(args.To)
+		qty, err := math.ParseNdau(args.Qty)
+		orQuit(errors.Wrap(err, "parsing qty"))
+
+		signer := accountSigner(from, keysOrAll(args.Keys))
+		var tx metatx.Transactable
+		if args.Period == "" {
+			tx = ndau.NewTransfer(from.Address, to, qty, sequence(s.conf, from.Address), signer.Keys()...)
+		} else {
+			period, err := math.ParseDuration(args.Period)
+			orQuit(errors.Wrap(err, "parsing period"))
+			tx = ndau.NewTransferAndLock(from.Address, to, qty, period, sequence(s.conf, from.Address), signer.Keys()...)
+		}
+		signer.Sign(tx)
+		s.send(tx, reply)
+	})
+}
+
+// LockArgs describes a lock
+type LockArgs struct {
+	Account string `json:"account"`
+	Period  string `json:"period"`
+	Keys    *int   `json:"keys,omitempty"`
+}
+
+// Lock locks a config account
+func (s *server) Lock(args LockArgs, reply *TxResult) error {
+	return s.call(func() {
+		acct := s.account(args.Account)
+		period, err := math.ParseDuration(args.Period)
+		orQuit(errors.Wrap(err, "parsing period"))
+		signer := accountSigner(acct, keysOrAll(args.Keys))
+		tx := ndau.NewLock(acct.Address, period, sequence(s.conf, acct.Address), signer.Keys()...)
+		signer.Sign(tx)
+		s.send(tx, reply)
+	})
+}
+
+// NotifyArgs describes a notify
+type NotifyArgs struct {
+	Account string `json:"account"`
+	Keys    *int   `json:"keys,omitempty"`
+}
+
+// Notify starts the countdown to unlock a config account
+func (s *server) Notify(args NotifyArgs, reply *TxResult) error {
+	return s.call(func() {
+		acct := s.account(args.Account)
+		signer := accountSigner(acct, keysOrAll(args.Keys))
+		tx := ndau.NewNotify(acct.Address, sequence(s.conf, acct.Address), signer.Keys()...)
+		signer.Sign(tx)
+		s.send(tx, reply)
+	})
+}
+
+// SysvarsArgs names system variables
+type SysvarsArgs struct {
+	Names []string `json:"names"`
+}
+
+// Sysvars gets system variables as JSON
+//
+// If no names are given, all are returned.
+func (s *server) Sysvars(args SysvarsArgs, reply *map[string]json.RawMessage) error {
+	return s.call(func() {
+		svs, _, err := tool.Sysvars(tmnode(s.conf.Node, nil, nil), args.Names...)
+		orQuit(err)
+		*reply = make(map[string]json.RawMessage, len(svs))
+		for name, data := range svs {
+			js, err := sysvar.MsgpJSON(data)
+			orQuit(errors.Wrap(err, "converting "+name))
+			(*reply)[name] = json.RawMessage(js)
+		}
+	})
+}
+
+// SendArgs is a pre-prepared tx
+type SendArgs struct {
+	TxType string          `json:"txtype"`
+	Tx     json.RawMessage `json:"tx"`
+}
+
+// Send sends a pre-prepared tx, as the send command does
+func (s *server) Send(args SendArgs, reply *TxResult) error {
+	return s.call(func() {
+		tx, err := ndau.TxFromName(args.TxType)
+		orQuit(err)
+		orQuit(errors.Wrap(json.Unmarshal(args.Tx, tx), "decoding tx"))
+		s.send(tx, reply)
+	})
+}
+
+// TemplateArgs describes a tx template and its params
+type TemplateArgs struct {
+	Path   string            `json:"path"`
+	Params map[string]string `json:"params"`
+	Keys   *int              `json:"keys,omitempty"`
+}
+
+// RunTemplate renders, signs, and sends a tx template, as tx run does
+func (s *server) RunTemplate(args TemplateArgs, reply *TxResult) error {
+	return s.call(func() {
+		t, err := loadTxTemplate(args.Path)
+		orQuit(err)
+		tx, acct, err := t.render(s.conf, args.Params)
+		orQuit(err)
+		if acct == nil {
+			orQuit(errors.New("cannot sign: template has no account"))
+		}
+		templateSigner(tx, acct, keysOrAll(args.Keys)).SignUnsigned(tx)
+		s.send(tx, reply)
+	})
+}
+
+// listen on a unix socket, or on a loopback tcp address
+func listen(socket, addr string) (net.Listener, error) {
+	if addr != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(host)
+		if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, fmt.Errorf("%s is not a loopback address", addr)
+		}
+		return net.Listen("tcp", addr)
+	}
+	return listenUnix(socket)
+}
+
+// listenUnix listens on a unix socket which only the current user can use
+//
+// Requests are signed with the config's keys, so a connection can spend from
+// any of its accounts. The file permissions are the only protection: the
+// socket is made private before it appears at path.
+func listenUnix(path string) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".sock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// closing must not try to remove tmp, which will be gone by then
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	err = os.Chmod(tmp, 0600)
+	if err == nil {
+		os.Remove(path)
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// tokenEnv holds the bearer token for tcp mode if no token file is given
+const tokenEnv = "NDAU_SERVE_TOKEN"
+
+// loadToken gets the bearer token which tcp clients must present
+//
+// Any local user can connect to a loopback port, so unlike the socket, tcp
+// mode can't rely on file permissions; the token takes their place, and so
+// must itself be readable only by the current user.
+func loadToken(path string) (string, error) {
+	if path == "" {
+		if token := os.Getenv(tokenEnv); token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("serving on tcp requires a token: set %s or use --token-file", tokenEnv)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("token file %s must be accessible only by its owner", path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// httpRPC serves a JSON-RPC request in the body of each POST which carries
+// the bearer token
+type httpRPC struct {
+	rs    *rpc.Server
+	token string
+}
+
+func (h httpRPC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	auth := r.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	h.rs.ServeRequest(jsonrpc.NewServerCodec(struct {
+		io.ReadCloser
+		io.Writer
+	}{r.Body, w}))
+}
+
+// serveRPC serves JSON-RPC requests on ln until it is closed
+func serveRPC(ln net.Listener, rs *rpc.Server, verbose bool) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			// closed by signal
+			return
+		}
+		if verbose {
+			fmt.Fprintln(os.Stderr, "connection from", conn.RemoteAddr())
+		}
+		go rs.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+func getServe(verbose *bool) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[-s=<PATH> | -l=<ADDR> [--token-file=<PATH>]]"
+
+		var (
+			socket    = cmd.StringOpt("s socket", filepath.Join(filepath.Dir(config.GetConfigPath()), "ndau.sock"), "serve on this unix socket")
+			addr      = cmd.StringOpt("l listen", "", "serve on this loopback address instead of a socket, e.g. localhost:3035")
+			tokenFile = cmd.StringOpt("token-file", "", "read the bearer token for -l from this file, which must be private")
+		)
+
+		cmd.LongDesc = `Serve the CLI's operations as JSON-RPC 1.0 requests.
+
+Methods: Ndau.Accounts, Ndau.Account, Ndau.Transfer, Ndau.Lock,
+Ndau.Notify, Ndau.Sysvars, Ndau.Send, Ndau.RunTemplate. For example:
+
+    {"id": 1, "method": "Ndau.Transfer", "params": [{"from": "payroll", "to": "ndaxxxx", "qty": "1.5"}]}
+
+Accounts are named as in the config, which is loaded once at startup.
+Sends to mainnet profiles are refused unless the server was started with -y.
+
+With -l, each request is instead POSTed over HTTP, and must carry the header
+"Authorization: Bearer <token>". The token is read from --token-file, which
+must be accessible only by its owner, or else from $NDAU_SERVE_TOKEN.`
+
+		cmd.Action = func() {
+			var token string
+			if *addr != "" {
+				var err error
+				token, err = loadToken(*tokenFile)
+				orQuit(errors.Wrap(err, "loading token"))
+			}
+
+			s := &server{conf: getConfig()}
+			rs := rpc.NewServer()
+			orQuit(rs.RegisterName("Ndau", s))
+
+			ln, err := listen(*socket, *addr)
+			orQuit(errors.Wrap(err, "listening"))
+			if *addr == "" {
+				defer os.Remove(*socket)
+			}
+			fmt.Fprintln(os.Stderr, "serving on", ln.Addr())
+
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+			go func() {
+				<-sigs
+				ln.Close()
+			}()
+
+			serving = true
+			if *addr != "" {
+				// returns once closed by signal
+				http.Serve(ln, httpRPC{rs: rs, token: token})
+				return
+			}
+			serveRPC(ln, rs, *verbose)
+		}
+	}
+}
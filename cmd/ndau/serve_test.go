@@ -0,0 +1,218 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	config "github.com/ndau/ndau/pkg/tool.config"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ndau.sock")
+	// a stale socket from a server which didn't exit cleanly
+	require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+
+	ln, err := listenUnix(path)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.ModeSocket, fi.Mode().Type())
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// the private directory it was created in is gone
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestServerCall(t *testing.T) {
+	serving = true
+	defer func() { serving = false }()
+	s := &server{conf: &config.Config{}}
+
+	tests := []struct {
+		name    string
+		f       func()
+		wantErr string
+	}{
+		{"success", func() {}, ""},
+		{"failure", func() { orQuit(errors.New("no such account: bob")) }, "no such account: bob"},
+		{"bug", func() { panic("boom") }, "internal error: boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.call(tt.f)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestServeRoundTrip(t *testing.T) {
+	serving = true
+	defer func() { serving = false }()
+	alice := testAddress(t, 1)
+	s := &server{conf: &config.Config{Accounts: map[string]*config.Account{
+		"alice":        {Name: "alice", Address: alice},
+		alice.String(): {Name: "alice", Address: alice},
+	}}}
+	rs := rpc.NewServer()
+	require.NoError(t, rs.RegisterName("Ndau", s))
+
+	path := filepath.Join(t.TempDir(), "ndau.sock")
+	ln, err := listenUnix(path)
+	require.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		serveRPC(ln, rs, false)
+		close(done)
+	}()
+	defer func() {
+		ln.Close()
+		<-done
+	}()
+
+	client, err := jsonrpc.Dial("unix", path)
+	require.NoError(t, err)
+	defer client.Close()
+
+	var accounts []AccountInfo
+	require.NoError(t, client.Call("Ndau.Accounts", struct{}{}, &accounts))
+	require.Equal(t, []AccountInfo{{Name: "alice", Address: alice.String()}}, accounts)
+
+	// a failed request is reported to the client, and the server carries on
+	var info struct{}
+	err = client.Call("Ndau.Account", AccountArgs{Account: "nobody"}, &info)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown account")
+
+	accounts = nil
+	require.NoError(t, client.Call("Ndau.Accounts", struct{}{}, &accounts))
+	require.Len(t, accounts, 1)
+}
+
+func TestLoadToken(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string, perm os.FileMode) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), perm))
+		require.NoError(t, os.Chmod(path, perm))
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{name: "private file", path: write("private", "s3cret\n", 0600), want: "s3cret"},
+		{name: "file wins over env", path: write("private2", "s3cret", 0400), env: "other", want: "s3cret"},
+		{name: "readable by others", path: write("public", "s3cret", 0644), wantErr: true},
+		{name: "empty file", path: write("empty", "\n", 0600), wantErr: true},
+		{name: "missing file", path: filepath.Join(dir, "missing"), wantErr: true},
+		{name: "env", env: "s3cret", want: "s3cret"},
+		{name: "none", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, had := os.LookupEnv(tokenEnv)
+			os.Setenv(tokenEnv, tt.env)
+			defer func() {
+				if had {
+					os.Setenv(tokenEnv, old)
+				} else {
+					os.Unsetenv(tokenEnv)
+				}
+			}()
+
+			got, err := loadToken(tt.path)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	serving = true
+	defer func() { serving = false }()
+	alice := testAddress(t, 1)
+	s := &server{conf: &config.Config{Accounts: map[string]*config.Account{
+		"alice":        {Name: "alice", Address: alice},
+		alice.String(): {Name: "alice", Address: alice},
+	}}}
+	rs := rpc.NewServer()
+	require.NoError(t, rs.RegisterName("Ndau", s))
+	ts := httptest.NewServer(httpRPC{rs: rs, token: "s3cret"})
+	defer ts.Close()
+
+	const body = `{"id": 1, "method": "Ndau.Accounts", "params": [{}]}`
+	tests := []struct {
+		name   string
+		method string
+		auth   string
+		want   int
+	}{
+		{"token", http.MethodPost, "Bearer s3cret", http.StatusOK},
+		{"no token", http.MethodPost, "", http.StatusUnauthorized},
+		{"wrong token", http.MethodPost, "Bearer s3cre", http.StatusUnauthorized},
+		{"not bearer", http.MethodPost, "s3cret", http.StatusUnauthorized},
+		{"get", http.MethodGet, "Bearer s3cret", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL, strings.NewReader(body))
+			require.NoError(t, err)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, tt.want, resp.StatusCode)
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var reply struct {
+				ID     int           `json:"id"`
+				Result []AccountInfo `json:"result"`
+				Error  interface{}   `json:"error"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+			require.Equal(t, 1, reply.ID)
+			require.Nil(t, reply.Error)
+			require.Equal(t, []AccountInfo{{Name: "alice", Address: alice.String()}}, reply.Result)
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/shlex"
+	metatx "github.com/ndau/metanode/pkg/meta/transaction"
+	"github.com/ndau/ndau/pkg/ndau"
+	config "github.com/ndau/ndau/pkg/tool.config"
+	"github.com/ndau/ndaumath/pkg/address"
+	"github.com/ndau/ndaumath/pkg/signature"
+	"github.com/pkg/errors"
+)
+
+// signerProtocolVersion is the version of the external signer protocol
+const signerProtocolVersion = 1
+
+// signerTimeout bounds how long we wait for an external signer
+//
+// It is generous, because a signer may be waiting for a human to approve.
+const signerTimeout = 10 * time.Minute
+
+// An externalSigner is a process or service which signs txs on behalf of an
+// account, so that its private keys never need to appear in the config
+//
+// Exactly one of Command or Socket should be set. A Command is run once per
+// tx: it receives a signRequest as JSON on stdin, and must write a
+// signResponse as JSON to stdout. A Socket is a unix socket which receives
+// the same request as a single line of JSON, and must reply likewise.
+type externalSigner struct {
+	Command string `toml:"command"`
+	Socket  string `toml:"socket"`
+}
+
+// signersFile is the layout of signers.toml
+//
+// Signers are keyed by account name or address. System accounts are keyed by
+// their config names: rfe, nnr, cvc, record_price, set_sysvar.
+type signersFile struct {
+	Signers map[string]externalSigner `toml:"signers"`
+}
+
+// signRequest is sent to an external signer
+type signRequest struct {
+	Version       int             `json:"version"`
+	Account       string          `json:"account"`
+	Name          string          `json:"name,omitempty"`
+	Role          string          `json:"role"`
+	Keys          int             `json:"keys"`
+	TxType        string          `json:"txtype"`
+	Summary       string          `json:"summary"`
+	Tx            json.RawMessage `json:"tx"`
+	SignableBytes []byte          `json:"signable_bytes"`
+}
+
+// signResponse is returned by an external signer
+type signResponse struct {
+	Signatures []signature.Signature `json:"signatures"`
+	Error      string                `json:"error,omitempty"`
+}
+
+// signersPath returns the location of the external signer configuration
+func signersPath() string {
+	return filepath.Join(filepath.Dir(config.GetConfigPath()), "signers.toml")
+}
+
+var signers *signersFile
+
+// getExternalSigner returns the external signer configured for any of the
+// given keys, or nil if there is none
+func getExternalSigner(keys ...string) *externalSigner {
+	if signers == nil {
+		signers = new(signersFile)
+		_, err := toml.DecodeFile(signersPath(), signers)
+		if err != nil && !os.IsNotExist(err) {
+			orQuit(errors.Wrap(err, "loading external signers"))
+		}
+	}
+	for _, k := range keys {
+		if s, ok := signers.Signers[k]; ok && k != "" {
+			return &s
+		}
+	}
+	return nil
+}
+
+// a txSigner signs txs on behalf of a single account, either in-process with
+// keys from the config or with an external signer
+type txSigner struct {
+	name     string
+	addr     address.Address
+	role     string
+	keys     int
+	local    []signature.PrivateKey
+	external *externalSigner
+}
+
+// accountSigner signs with an account's validation keys
+func accountSigner(acct *config.Account, keys int) *txSigner {
+	return &txSigner{
+		name:     acct.Name,
+		addr:     acct.Address,
+		role:     "validation",
+		keys:     keys,
+		local:    acct.ValidationPrivateK(keys),
+		external: getExternalSigner(acct.Name, acct.Address.String()),
+	}
+}
+
+// ownershipSigner signs with an account's ownership key
+func ownershipSigner(acct *config.Account) *txSigner {
+	return &txSigner{
+		name:     acct.Name,
+		addr:     acct.Address,
+		role:     "ownership",
+		keys:     -1,
+		local:    []signature.PrivateKey{acct.Ownership.Private},
+		external: getExternalSigner(acct.Name, acct.Address.String()),
+	}
+}
+
+// sysSigner signs with a system account's keys
+func sysSigner(name string, sa *config.SysAccount, keys int) *txSigner {
+	return &txSigner{
+		name:     name,
+		addr:     sa.Address,
+		role:     "validation",
+		keys:     keys,
+		local:    config.FilterK(sa.Keys, keys),
+		external: getExternalSigner(name, sa.Address.String()),
+	}
+}
+
+// hasValidation is true when txs can be signed for an account: it either has
+// validation keys in the config or an external signer
+func hasValidation(acct *config.Account) bool {
+	return len(acct.Validation) > 0 || getExternalSigner(acct.Name, acct.Address.String()) != nil
+}
+
+// Keys returns the private keys with which to construct a tx
+//
+// When an external signer is in use, this is empty: the tx is constructed
+// unsigned, and Sign must be called on it.
+func (s *txSigner) Keys() []signature.PrivateKey {
+	if s.external != nil {
+		return nil
+	}
+	return s.local
+}
+
+// Sign a tx with the external signer, if one is in use
+func (s *txSigner) Sign(tx metatx.Transactable) {
+	if s.external == nil {
+		return
+	}
+	sigs, err := s.external.sign(s.request(tx))
+	orQuit(errors.Wrap(err, "external signer"))
+	if len(sigs) == 0 {
+		orQuit(errors.New("external signer returned no signatures"))
+	}
+	addSignatures(tx, sigs)
+}
+
+// SignUnsigned signs a tx which was constructed without keys, such as one
+// decoded from JSON
+func (s *txSigner) SignUnsigned(tx metatx.Transactable) {
+	if s.external != nil {
+		s.Sign(tx)
+		return
+	}
+	if len(s.local) == 0 {
+		orQuit(fmt.Errorf("no keys with which to sign for %s", s.addr))
+	}
+	sb := tx.SignableBytes()
+	sigs := make([]signature.Signature, 0, len(s.local))
+	for _, key := range s.local {
+		sigs = append(sigs, key.Sign(sb))
+	}
+	addSignatures(tx, sigs)
+}
+
+func addSignatures(tx metatx.Transactable, sigs []signature.Signature) {
+	switch t := tx.(type) {
+	case *ndau.SetValidation:
+		t.Signature = sigs[0]
+	case ndau.Signable:
+		t.ExtendSignatures(sigs)
+	default:
+		orQuit(fmt.Errorf("%s cannot be signed", metatx.NameOf(tx)))
+	}
+}
+
+func (s *txSigner) request(tx metatx.Transactable) signRequest {
+	txjs, err := json.Marshal(tx)
+	orQuit(errors.Wrap(err, "marshaling tx for external signer"))
+	return signRequest{
+		Version:       signerProtocolVersion,
+		Account:       s.addr.String(),
+		Name:          s.name,
+		Role:          s.role,
+		Keys:          s.keys,
+		TxType:        metatx.NameOf(tx),
+		Summary:       summarize(tx),
+		Tx:            txjs,
+		SignableBytes: tx.SignableBytes(),
+	}
+}
+
+// summarize a tx in a single human-readable line
+func summarize(tx metatx.Transactable) string {
+	js, err := json.Marshal(tx)
+	if err != nil {
+		return metatx.NameOf(tx)
+	}
+	fields := make(map[string]interface{})
+	if json.Unmarshal(js, &fields) != nil {
+		return metatx.NameOf(tx)
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		switch strings.ToLower(name) {
+		case "signature", "signatures":
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := []string{metatx.NameOf(tx) + ":"}
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%v", name, fields[name]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// sign sends a request to the external signer and returns its signatures
+func (e *externalSigner) sign(req signRequest) ([]signature.Signature, error) {
+	reqjs, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshaling request")
+	}
+
+	var respjs []byte
+	switch {
+	case e.Command != "" && e.Socket != "":
+		return nil, errors.New("signer must set only one of command or socket")
+	case e.Command != "":
+		respjs, err = e.runCommand(reqjs)
+	case e.Socket != "":
+		respjs, err = e.callSocket(reqjs)
+	default:
+		return nil, errors.New("signer must set command or socket")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp := signResponse{}
+	err = json.Unmarshal(respjs, &resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing response")
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Signatures, nil
+}
+
+func (e *externalSigner) runCommand(reqjs []byte) ([]byte, error) {
+	argv, err := shlex.Split(e.Command)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing command")
+	}
+	if len(argv) == 0 {
+		return nil, errors.New("empty command")
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = bytes.NewReader(reqjs)
+	// the signer may need to interact with the user
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	return out, errors.Wrap(err, fmt.Sprintf("running %s", argv[0]))
+}
+
+func (e *externalSigner) callSocket(reqjs []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", e.Socket, 5*time.Second)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to signer")
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(signerTimeout))
+	_, err = conn.Write(append(reqjs, '\n'))
+	if err != nil {
+		return nil, errors.Wrap(err, "writing request")
+	}
+	out, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(out) == 0 {
+		return nil, errors.Wrap(err, "reading response")
+	}
+	return out, nil
+}
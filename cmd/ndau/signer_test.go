@@ -0,0 +1,151 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metatx "github.com/ndau/metanode/pkg/meta/transaction"
+	"github.com/ndau/ndau/pkg/ndau"
+	"github.com/ndau/ndaumath/pkg/signature"
+	"github.com/stretchr/testify/require"
+)
+
+// buildFilesigner builds the reference external signer, and returns its path
+func buildFilesigner(t *testing.T) string {
+	t.Helper()
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is needed to build filesigner")
+	}
+	bin := filepath.Join(t.TempDir(), "filesigner")
+	out, err := exec.Command(gobin, "build", "-o", bin, "github.com/ndau/commands/cmd/filesigner").CombinedOutput()
+	require.NoError(t, err, string(out))
+	return bin
+}
+
+// serveFilesigner runs filesigner on a socket until the test ends, and
+// returns the socket's path
+func serveFilesigner(t *testing.T, bin, keys string) string {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "signer.sock")
+	cmd := exec.Command(bin, "-s", sock, keys)
+	cmd.Stderr = os.Stderr
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		cmd.Process.Signal(os.Interrupt)
+		cmd.Wait()
+	})
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(sock); err == nil {
+			return sock
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("filesigner never created its socket")
+	return ""
+}
+
+func TestExternalSigner(t *testing.T) {
+	bin := buildFilesigner(t)
+
+	var pubs []signature.PublicKey
+	var pvts []string
+	for i := 0; i < 2; i++ {
+		pub, pvt, err := signature.Generate(signature.Ed25519, nil)
+		require.NoError(t, err)
+		text, err := pvt.MarshalText()
+		require.NoError(t, err)
+		pubs = append(pubs, pub)
+		pvts = append(pvts, fmt.Sprintf("%q", text))
+	}
+	owner, _, err := signature.Generate(signature.Ed25519, nil)
+	require.NoError(t, err)
+	alice := testAddress(t, 1)
+	bob := testAddress(t, 2)
+	keys := filepath.Join(t.TempDir(), "keys.toml")
+	require.NoError(t, ioutil.WriteFile(keys, []byte(fmt.Sprintf(
+		"[accounts.%s]\nkeys = [%s]\n[accounts.bob]\nkeys = [%s]\n",
+		alice, strings.Join(pvts, ", "), pvts[0],
+	)), 0600))
+
+	signers := map[string]*externalSigner{
+		"command": {Command: bin + " " + keys},
+		"socket":  {Socket: serveFilesigner(t, bin, keys)},
+	}
+	tests := []struct {
+		name string
+		s    txSigner
+		tx   func() metatx.Transactable
+		want []signature.PublicKey
+	}{
+		{
+			"all keys",
+			txSigner{addr: alice, role: "validation", keys: -1},
+			func() metatx.Transactable { return ndau.NewTransfer(alice, bob, 1, 1) },
+			pubs,
+		},
+		{
+			"second key",
+			txSigner{addr: alice, role: "validation", keys: 2},
+			func() metatx.Transactable { return ndau.NewTransfer(alice, bob, 1, 1) },
+			pubs[1:],
+		},
+		{
+			// keys may be found by account name when not by address
+			"ownership by name",
+			txSigner{name: "bob", addr: bob, role: "ownership", keys: -1},
+			func() metatx.Transactable {
+				return ndau.NewSetValidation(bob, owner, []signature.PublicKey{pubs[1]}, nil, 1)
+			},
+			pubs[:1],
+		},
+	}
+	for mode, ext := range signers {
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				s := tt.s
+				s.external = ext
+				require.Empty(t, s.Keys())
+				tx := tt.tx()
+				s.Sign(tx)
+
+				var sigs []signature.Signature
+				switch tx := tx.(type) {
+				case *ndau.Transfer:
+					sigs = tx.Signatures
+				case *ndau.SetValidation:
+					sigs = []signature.Signature{tx.Signature}
+				}
+				require.Len(t, sigs, len(tt.want))
+				for i, sig := range sigs {
+					require.True(t, sig.Verify(tx.SignableBytes(), tt.want[i]), "signature %d", i)
+				}
+			})
+		}
+	}
+
+	t.Run("unknown account", func(t *testing.T) {
+		req := (&txSigner{addr: bob, role: "validation", keys: -1}).request(ndau.NewTransfer(bob, alice, 1, 1))
+		req.Name = ""
+		for mode, ext := range signers {
+			_, err := ext.sign(req)
+			require.Error(t, err, mode)
+			require.Contains(t, err.Error(), "no keys for", mode)
+		}
+	})
+}
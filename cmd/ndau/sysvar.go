@@ -17,7 +17,6 @@ import (
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/ndau"
 	"github.com/ndau/ndau/pkg/tool"
-	config "github.com/ndau/ndau/pkg/tool.config"
 	"github.com/pkg/errors"
 	"github.com/tinylib/msgp/msgp"
 )
@@ -36,6 +35,18 @@ func getSysvar(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd)
 			getSysvarSet(verbose, keys, emitJSON, compact),
 		)
 
+		cmd.Command(
+			"diff",
+			"compare system variables with a desired state; exits 2 if they differ",
+			getSysvarDiff(verbose),
+		)
+
+		cmd.Command(
+			"apply",
+			"set system variables to match a desired state",
+			getSysvarApply(verbose, keys, emitJSON, compact),
+		)
+
 		cmd.Command(
 			"history",
 			"get history of system variables",
@@ -112,14 +123,16 @@ func getSysvarSet(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.C
 				orQuit(errors.New("SetSysvar not configured"))
 			}
 
+			signer := sysSigner("set_sysvar", conf.SetSysvar, *keys)
 			ssv := ndau.NewSetSysvar(
 				*name,
 				getValue(),
 				sequence(conf, conf.SetSysvar.Address),
-				config.FilterK(conf.SetSysvar.Keys, *keys)...,
+				signer.Keys()...,
 			)
+			signer.Sign(ssv)
 
-			result, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), ssv)
+			result, err := sendTx(tmnode(conf.Node, emitJSON, compact), ssv)
 			finish(*verbose, result, err, "sysvar set")
 		}
 	}
@@ -0,0 +1,242 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	cli "github.com/jawher/mow.cli"
+	"github.com/ndau/commands/cmd/sysvar/sysvar"
+	"github.com/ndau/ndau/pkg/ndau"
+	"github.com/ndau/ndau/pkg/tool"
+	"github.com/pkg/errors"
+)
+
+// typesKey is a reserved top-level key in a sysvar state file. It maps
+// sysvar names to explicit encodings, for values whose encoding can't be
+// inferred from the chain.
+const typesKey = "_types"
+
+// A sysvarChange is a difference between desired and on-chain sysvar state
+type sysvarChange struct {
+	Name    string
+	Current string // JSON of the on-chain value; empty if unset
+	Desired string // JSON of the desired value, as it will appear on chain
+	Value   []byte // msgp encoding of the desired value
+}
+
+// loadSysvarState reads a desired-state file: a map of sysvar names to
+// values, in the same shape as the output of `sysvar get`
+//
+// The file may be JSON or TOML, chosen by its extension.
+func loadSysvarState(path string) (map[string]interface{}, map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		_, err = toml.Decode(string(data), &raw)
+		if err == nil {
+			// round-trip through JSON so values have the same types as in a JSON file
+			data, err = json.Marshal(raw)
+		}
+		if err == nil {
+			raw = make(map[string]interface{})
+			err = decodeJSON(data, &raw)
+		}
+	default:
+		err = decodeJSON(data, &raw)
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parsing "+path)
+	}
+
+	types := make(map[string]string)
+	if t, ok := raw[typesKey]; ok {
+		tm, ok := t.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("%s must be a table of sysvar names to types", typesKey)
+		}
+		for name, typ := range tm {
+			types[name] = fmt.Sprint(typ)
+		}
+		delete(raw, typesKey)
+	}
+	return raw, types, nil
+}
+
+func decodeJSON(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// encodeSysvar encodes a desired sysvar value
+func encodeSysvar(name string, v interface{}, current []byte, typ string) ([]byte, error) {
+	if typ != "" {
+		return sysvar.Encode(typ, v)
+	}
+	enc, err := sysvar.EncodeLike(current, v, nil)
+	if err != nil {
+		return nil, err
+	}
+	// make sure that the value will look as intended when read back
+	want, err := sysvar.CanonicalJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	got, err := sysvar.MsgpJSON(enc)
+	if err != nil {
+		return nil, err
+	}
+	if want != got {
+		return nil, fmt.Errorf("encoding changes value to %s; specify its type in %s", got, typesKey)
+	}
+	return enc, nil
+}
+
+// sysvarChanges computes the minimal set of changes needed to bring the chain
+// into the state described by the desired-state file
+func sysvarChanges(node, path string) ([]sysvarChange, error) {
+	desired, types, err := loadSysvarState(path)
+	if err != nil {
+		return nil, err
+	}
+	current, _, err := tool.Sysvars(tmnode(node, nil, nil))
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving sysvars from blockchain")
+	}
+
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var changes []sysvarChange
+	for _, name := range names {
+		change := sysvarChange{Name: name}
+		cur, exists := current[name]
+		if exists {
+			change.Current, err = sysvar.MsgpJSON(cur)
+			if err != nil {
+				return nil, errors.Wrap(err, "decoding on-chain "+name)
+			}
+		}
+		change.Value, err = encodeSysvar(name, desired[name], cur, types[name])
+		if err != nil {
+			return nil, errors.Wrap(err, "encoding "+name)
+		}
+		if exists && bytes.Equal(cur, change.Value) {
+			continue
+		}
+		change.Desired, err = sysvar.MsgpJSON(change.Value)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding "+name)
+		}
+		if exists && change.Current == change.Desired {
+			// same value, different encoding: not worth a tx
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func printSysvarChanges(changes []sysvarChange) {
+	if len(changes) == 0 {
+		fmt.Println("no changes: on-chain sysvars match")
+		return
+	}
+	for _, c := range changes {
+		if c.Current == "" {
+			fmt.Printf("+ %s\n    + %s\n", c.Name, c.Desired)
+			continue
+		}
+		fmt.Printf("~ %s\n    - %s\n    + %s\n", c.Name, c.Current, c.Desired)
+	}
+}
+
+func getSysvarDiff(verbose *bool) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "FILE"
+
+		var path = cmd.StringArg("FILE", "", "desired sysvar state, as JSON or TOML")
+
+		cmd.Action = func() {
+			conf := getConfig()
+			changes, err := sysvarChanges(conf.Node, *path)
+			orQuit(err)
+			printSysvarChanges(changes)
+			if len(changes) > 0 {
+				cli.Exit(2)
+			}
+		}
+	}
+}
+
+func getSysvarApply(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "FILE [-F]"
+
+		var (
+			path  = cmd.StringArg("FILE", "", "desired sysvar state, as JSON or TOML")
+			force = cmd.BoolOpt("F force", false, "proceed without manual confirmation")
+		)
+
+		cmd.Action = func() {
+			conf := getConfig()
+			if conf.SetSysvar == nil {
+				orQuit(errors.New("SetSysvar not configured"))
+			}
+
+			changes, err := sysvarChanges(conf.Node, *path)
+			orQuit(err)
+			printSysvarChanges(changes)
+			if len(changes) == 0 {
+				return
+			}
+
+			if !*force {
+				fmt.Println()
+				fmt.Printf("Send %d SetSysvar txs? [y/N] ", len(changes))
+				scanner := bufio.NewScanner(os.Stdin)
+				scanner.Scan()
+				input := strings.ToLower(strings.TrimSpace(scanner.Text()))
+				if input != "y" && input != "yes" {
+					fmt.Println("aborting")
+					os.Exit(1)
+				}
+			}
+
+			seq := sequence(conf, conf.SetSysvar.Address)
+			signer := sysSigner("set_sysvar", conf.SetSysvar, *keys)
+			for _, c := range changes {
+				ssv := ndau.NewSetSysvar(c.Name, c.Value, seq, signer.Keys()...)
+				signer.Sign(ssv)
+				seq++
+
+				result, err := sendTx(tmnode(conf.Node, emitJSON, compact), ssv)
+				finish(*verbose, result, err, "sysvar apply "+c.Name)
+			}
+		}
+	}
+}
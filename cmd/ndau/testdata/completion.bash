@@ -0,0 +1,42 @@
+# bash completion for ndau; generated by ndau completion bash
+_ndau_spec() {
+	cmds= flags= vals= addrs= args=
+	case "$1" in
+	"") cmds="account a transfer" flags="-v --verbose" vals="--profile" addrs="" args="" ;;
+	"account"|"a") cmds="list lock" flags="" vals="" addrs="" args="" ;;
+	"account list"|"a list") cmds="" flags="--addresses" vals="" addrs="" args="" ;;
+	"account lock"|"a lock") cmds="" flags="" vals="-e --eai-address --keys" addrs="-e --eai-address" args="name" ;;
+	"transfer") cmds="" flags="" vals="--file" addrs="" args="name" ;;
+	esac
+}
+
+_ndau() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	local cmdpath="" cmds flags vals addrs args i w
+	_ndau_spec ""
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		w=${COMP_WORDS[i]}
+		if [[ " $vals " == *" $w "* ]]; then
+			((i++))
+			continue
+		fi
+		[[ $w == -* ]] && continue
+		if [[ " $cmds " == *" $w "* ]]; then
+			cmdpath="${cmdpath:+$cmdpath }$w"
+			_ndau_spec "$cmdpath"
+		fi
+	done
+
+	if [[ " $addrs " == *" $prev "* ]]; then
+		COMPREPLY=($(compgen -W "$(ndau completion accounts --addresses 2>/dev/null)" -- "$cur"))
+	elif [[ " $vals " == *" $prev "* ]]; then
+		COMPREPLY=($(compgen -f -- "$cur"))
+	elif [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "$flags $vals" -- "$cur"))
+	elif [[ -n $args ]]; then
+		COMPREPLY=($(compgen -W "$cmds $(ndau completion accounts 2>/dev/null)" -- "$cur"))
+	else
+		COMPREPLY=($(compgen -W "$cmds" -- "$cur"))
+	fi
+}
+complete -F _ndau ndau
@@ -0,0 +1,68 @@
+# fish completion for ndau; generated by ndau completion fish
+function __ndau_spec
+	set -g __ndau_cmds
+	set -g __ndau_vals
+	switch "$argv"
+	case ''
+		set -g __ndau_cmds account a transfer
+		set -g __ndau_vals --profile
+	case 'account' 'a'
+		set -g __ndau_cmds list lock
+	case 'account list' 'a list'
+	case 'account lock' 'a lock'
+		set -g __ndau_vals -e --eai-address --keys
+	case 'transfer'
+		set -g __ndau_vals --file
+	end
+end
+
+function __ndau_cmdpath
+	set -l tokens (commandline -opc)
+	set -l cmdpath ''
+	set -l skip 0
+	__ndau_spec ''
+	for w in $tokens[2..-1]
+		if test $skip = 1
+			set skip 0
+			continue
+		end
+		if contains -- $w $__ndau_vals
+			set skip 1
+			continue
+		end
+		string match -q -- '-*' $w; and continue
+		if contains -- $w $__ndau_cmds
+			set cmdpath (string trim -- "$cmdpath $w")
+			__ndau_spec $cmdpath
+		end
+	end
+	echo $cmdpath
+end
+
+function __ndau_at
+	test (__ndau_cmdpath) = "$argv[1]"
+end
+
+complete -c ndau -f
+complete -c ndau -n '__ndau_at \'\'' -a account -d 'manage accounts'
+complete -c ndau -n '__ndau_at \'\'' -a a -d 'manage accounts'
+complete -c ndau -n '__ndau_at \'\'' -a transfer -d 'transfer ndau'
+complete -c ndau -n '__ndau_at \'\'' -s v -d 'emit additional output'
+complete -c ndau -n '__ndau_at \'\'' -l verbose -d 'emit additional output'
+complete -c ndau -n '__ndau_at \'\'' -l profile -r -F -d 'use this profile'
+complete -c ndau -n '__ndau_at \'account\'' -a list -d 'list known accounts'
+complete -c ndau -n '__ndau_at \'account\'' -a lock -d 'lock an account'
+complete -c ndau -n '__ndau_at \'a\'' -a list -d 'list known accounts'
+complete -c ndau -n '__ndau_at \'a\'' -a lock -d 'lock an account'
+complete -c ndau -n '__ndau_at \'account list\'' -l addresses -d 'list addresses instead of names'
+complete -c ndau -n '__ndau_at \'a list\'' -l addresses -d 'list addresses instead of names'
+complete -c ndau -n '__ndau_at \'account lock\'' -a '(ndau completion accounts 2>/dev/null)'
+complete -c ndau -n '__ndau_at \'account lock\'' -s e -x -a '(ndau completion accounts --addresses 2>/dev/null)' -d 'send EAI to this address'
+complete -c ndau -n '__ndau_at \'account lock\'' -l eai-address -x -a '(ndau completion accounts --addresses 2>/dev/null)' -d 'send EAI to this address'
+complete -c ndau -n '__ndau_at \'account lock\'' -l keys -r -F -d 'number of keys to sign with (default 1)'
+complete -c ndau -n '__ndau_at \'a lock\'' -a '(ndau completion accounts 2>/dev/null)'
+complete -c ndau -n '__ndau_at \'a lock\'' -s e -x -a '(ndau completion accounts --addresses 2>/dev/null)' -d 'send EAI to this address'
+complete -c ndau -n '__ndau_at \'a lock\'' -l eai-address -x -a '(ndau completion accounts --addresses 2>/dev/null)' -d 'send EAI to this address'
+complete -c ndau -n '__ndau_at \'a lock\'' -l keys -r -F -d 'number of keys to sign with (default 1)'
+complete -c ndau -n '__ndau_at \'transfer\'' -a '(ndau completion accounts 2>/dev/null)'
+complete -c ndau -n '__ndau_at \'transfer\'' -l file -r -F -d 'write the tx here (default "tx.json")'
@@ -14,7 +14,6 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
 )
 
 func getTransfer(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd) {
@@ -49,19 +48,21 @@ func getTransfer(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cm
 			if !hasAcct || fromAcct == nil {
 				orQuit(fmt.Errorf("Account for address '%s' not found in config", from))
 			}
-			if fromAcct.Validation == nil {
+			if !hasValidation(fromAcct) {
 				orQuit(fmt.Errorf("From acct validation key not set"))
 			}
 
 			// construct the transfer
+			signer := accountSigner(fromAcct, *keys)
 			transfer := ndau.NewTransfer(
 				from, to,
 				ndauQty,
 				sequence(conf, from),
-				fromAcct.ValidationPrivateK(*keys)...,
+				signer.Keys()...,
 			)
+			signer.Sign(transfer)
 
-			tresp, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), transfer)
+			tresp, err := sendTx(tmnode(conf.Node, emitJSON, compact), transfer)
 			finish(*verbose, tresp, err, "transfer")
 		}
 	}
@@ -14,7 +14,6 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/ndau/ndau/pkg/ndau"
-	"github.com/ndau/ndau/pkg/tool"
 )
 
 func getTransferAndLock(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd) {
@@ -52,20 +51,22 @@ func getTransferAndLock(verbose *bool, keys *int, emitJSON, compact *bool) func(
 			if !hasAcct {
 				orQuit(fmt.Errorf("From account '%s' not found", fromAcct.Name))
 			}
-			if fromAcct.Validation == nil {
+			if !hasValidation(fromAcct) {
 				orQuit(fmt.Errorf("From acct validation key not set"))
 			}
 
 			// construct the transfer
+			signer := accountSigner(fromAcct, *keys)
 			transfer := ndau.NewTransferAndLock(
 				from, to,
 				ndauQty,
 				duration,
 				sequence(conf, from),
-				fromAcct.ValidationPrivateK(*keys)...,
+				signer.Keys()...,
 			)
+			signer.Sign(transfer)
 
-			tresp, err := tool.SendCommit(tmnode(conf.Node, emitJSON, compact), transfer)
+			tresp, err := sendTx(tmnode(conf.Node, emitJSON, compact), transfer)
 			finish(*verbose, tresp, err, "transferandlock")
 		}
 	}
@@ -0,0 +1,233 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"fmt"
+	"time"
+
+	cli "github.com/jawher/mow.cli"
+	"github.com/ndau/metanode/pkg/meta/app/code"
+	metatx "github.com/ndau/metanode/pkg/meta/transaction"
+	"github.com/ndau/ndau/pkg/ndau"
+	"github.com/ndau/ndau/pkg/ndau/search"
+	"github.com/ndau/ndau/pkg/tool"
+	"github.com/pkg/errors"
+	"github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+)
+
+// blockPoll is how often to check for new blocks while waiting for confirmations
+const blockPoll = time.Second
+
+var (
+	// sendAsync returns as soon as a tx is accepted into the mempool
+	sendAsync *bool
+	// confirmations is the number of blocks to wait for after a tx is committed
+	confirmations *int
+)
+
+// sendTx sends a tx as requested by the --async and --confirmations options
+//
+// With --async, the tx hash is printed once the tx passes CheckTx; use
+// tx status to follow it from there.
+func sendTx(node client.ABCIClient, tx metatx.Transactable) (interface{}, error) {
+	if sendAsync != nil && *sendAsync {
+		resp, err := tool.SendSync(node, tx)
+		// the JSON client returns an empty result
+		if r, ok := resp.(*ctypes.ResultBroadcastTx); ok && err == nil && r != nil && len(r.Hash) > 0 {
+			fmt.Println(metatx.Hash(tx))
+		}
+		return resp, err
+	}
+
+	resp, err := tool.SendCommit(node, tx)
+	if err != nil || confirmations == nil || *confirmations <= 0 {
+		return resp, err
+	}
+	if r, ok := resp.(*ctypes.ResultBroadcastTxCommit); ok && r != nil && r.Height > 0 {
+		err = waitForHeight(r.Height + int64(*confirmations))
+	}
+	return resp, err
+}
+
+// sendCommitTx sends a tx whose outcome must be known before the command
+// continues, such as one which changes keys that are only saved to the config
+// once the tx has been committed
+//
+// --async is refused: the command can't know whether to save the keys.
+func sendCommitTx(node client.ABCIClient, tx metatx.Transactable) (interface{}, error) {
+	if sendAsync != nil && *sendAsync {
+		return nil, errors.New("--async can't be used with this command: its changes are saved only once the tx is committed")
+	}
+	return sendTx(node, tx)
+}
+
+// committedOK is true when resp reports that a tx was committed successfully
+func committedOK(resp interface{}) bool {
+	r, ok := resp.(*ctypes.ResultBroadcastTxCommit)
+	return ok && r != nil &&
+		code.ReturnCode(r.CheckTx.Code) == code.OK &&
+		code.ReturnCode(r.DeliverTx.Code) == code.OK
+}
+
+// waitForHeight blocks until the node has committed the given height
+func waitForHeight(height int64) error {
+	if nodeHTTP == nil {
+		return errors.New("can't wait for confirmations without a connection to a node")
+	}
+	for {
+		status, err := nodeHTTP.Status()
+		if err != nil {
+			return errors.Wrap(err, "waiting for confirmations")
+		}
+		if status.SyncInfo.LatestBlockHeight >= height {
+			return nil
+		}
+		time.Sleep(blockPoll)
+	}
+}
+
+// txStatus describes the fate of a tx
+//
+// Offset is the tx's position within its block.
+type txStatus struct {
+	Hash          string `json:"hash"`
+	Status        string `json:"status"`
+	Height        int64  `json:"height,omitempty"`
+	Offset        int    `json:"offset,omitempty"`
+	Code          uint32 `json:"code"`
+	Log           string `json:"log,omitempty"`
+	Confirmations int64  `json:"confirmations,omitempty"`
+}
+
+// Statuses of a tx
+//
+// The node indexes only the txs which it applied, so a tx which failed is
+// unknown once it has left the mempool.
+const (
+	txPending   = "pending"
+	txCommitted = "committed"
+	txUnknown   = "unknown"
+)
+
+// txStatusNode is the part of a node's RPC interface which tx status uses
+type txStatusNode interface {
+	client.ABCIClient
+	Block(height *int64) (*ctypes.ResultBlock, error)
+	BlockResults(height *int64) (*ctypes.ResultBlockResults, error)
+	Status() (*ctypes.ResultStatus, error)
+	UnconfirmedTxs(limit int) (*ctypes.ResultUnconfirmedTxs, error)
+}
+
+// getTxStatus looks for a tx in the chain's tx index, and then in the mempool
+func getTxStatus(node txStatusNode, hash string) (*txStatus, error) {
+	st := &txStatus{Hash: hash, Status: txUnknown}
+
+	value, err := tool.GetSearchResults(node, search.QueryParams{
+		Command: search.HeightByTxHashCommand,
+		Hash:    hash,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "searching for tx")
+	}
+	vd := search.TxValueData{}
+	if value != "" {
+		err = vd.Unmarshal(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding search result")
+		}
+	}
+
+	if vd.BlockHeight > 0 {
+		st.Height = int64(vd.BlockHeight)
+		// the index's offset counts only the txs which were applied, so find
+		// the tx's position among all of the block's txs
+		block, err := node.Block(&st.Height)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting block")
+		}
+		st.Offset = -1
+		for i, txb := range block.Block.Data.Txs {
+			tx, err := metatx.Unmarshal(txb, ndau.TxIDs)
+			if err == nil && metatx.Hash(tx) == hash {
+				st.Offset = i
+				break
+			}
+		}
+		if st.Offset < 0 {
+			return nil, fmt.Errorf("tx index gives height %d, but the tx is not in that block", st.Height)
+		}
+		results, err := node.BlockResults(&st.Height)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting block results")
+		}
+		if st.Offset >= len(results.TxsResults) {
+			return nil, fmt.Errorf("block %d has %d tx results, but its tx %d was applied", st.Height, len(results.TxsResults), st.Offset)
+		}
+		result := results.TxsResults[st.Offset]
+		st.Code = result.Code
+		st.Log = result.Log
+		st.Status = txCommitted
+		status, err := node.Status()
+		if err != nil {
+			return nil, errors.Wrap(err, "getting node status")
+		}
+		st.Confirmations = status.SyncInfo.LatestBlockHeight - st.Height
+		return st, nil
+	}
+
+	pending, err := node.UnconfirmedTxs(-1)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting mempool")
+	}
+	for _, txb := range pending.Txs {
+		tx, err := metatx.Unmarshal(txb, ndau.TxIDs)
+		if err == nil && metatx.Hash(tx) == hash {
+			st.Status = txPending
+			break
+		}
+	}
+	return st, nil
+}
+
+func getTxStatusCmd(verbose *bool) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "HASH"
+		cmd.LongDesc = `Report whether a tx is pending, committed, or unknown.
+
+Unknown txs were never received, were rejected or evicted from the mempool, or
+failed when their block was committed: the node doesn't index failed txs.
+With --confirmations, wait until a committed tx has that many confirmations.
+Exits with 1 if the tx is unknown.`
+
+		hash := cmd.StringArg("HASH", "", "tx hash, as printed by --async")
+
+		cmd.Action = func() {
+			conf := getConfig()
+			node := httpnode(conf.Node)
+			st, err := getTxStatus(node, *hash)
+			orQuit(err)
+
+			if st.Status == txCommitted && confirmations != nil && st.Confirmations < int64(*confirmations) {
+				orQuit(waitForHeight(st.Height + int64(*confirmations)))
+				st, err = getTxStatus(node, *hash)
+				orQuit(err)
+			}
+
+			js, err := jsonify(st)
+			orQuit(err)
+			fmt.Println(js)
+			if st.Status == txUnknown {
+				cli.Exit(1)
+			}
+		}
+	}
+}
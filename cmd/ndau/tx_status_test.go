@@ -0,0 +1,171 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"encoding/json"
+	"testing"
+
+	metatx "github.com/ndau/metanode/pkg/meta/transaction"
+	"github.com/ndau/ndau/pkg/ndau"
+	"github.com/ndau/ndau/pkg/ndau/search"
+	"github.com/stretchr/testify/require"
+	abci "github.com/tendermint/tendermint/abci/types"
+	cmn "github.com/tendermint/tendermint/libs/bytes"
+	"github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	ttypes "github.com/tendermint/tendermint/types"
+)
+
+// statusNode is a node with a tx index, blocks and their results, and a
+// mempool
+//
+// As on a real node, the index holds only the txs which were applied, and
+// their offsets count only those txs.
+type statusNode struct {
+	client.ABCIClient
+	index   map[string]search.TxValueData
+	blocks  map[int64][]ttypes.Tx
+	results map[int64][]*abci.ResponseDeliverTx
+	latest  int64
+	mempool []ttypes.Tx
+}
+
+// ABCIQuery implements ABCIClient, answering tx index searches
+func (n *statusNode) ABCIQuery(path string, data cmn.HexBytes) (*ctypes.ResultABCIQuery, error) {
+	var params search.QueryParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	res := new(ctypes.ResultABCIQuery)
+	if vd, ok := n.index[params.Hash]; ok {
+		res.Response.Value = []byte(vd.Marshal())
+	}
+	return res, nil
+}
+
+func (n *statusNode) Block(height *int64) (*ctypes.ResultBlock, error) {
+	block := new(ttypes.Block)
+	block.Data.Txs = n.blocks[*height]
+	return &ctypes.ResultBlock{Block: block}, nil
+}
+
+func (n *statusNode) BlockResults(height *int64) (*ctypes.ResultBlockResults, error) {
+	return &ctypes.ResultBlockResults{Height: *height, TxsResults: n.results[*height]}, nil
+}
+
+func (n *statusNode) Status() (*ctypes.ResultStatus, error) {
+	st := new(ctypes.ResultStatus)
+	st.SyncInfo.LatestBlockHeight = n.latest
+	return st, nil
+}
+
+func (n *statusNode) UnconfirmedTxs(limit int) (*ctypes.ResultUnconfirmedTxs, error) {
+	return &ctypes.ResultUnconfirmedTxs{Count: len(n.mempool), Txs: n.mempool}, nil
+}
+
+func TestGetTxStatus(t *testing.T) {
+	transfer := func(seq uint64) (*ndau.Transfer, ttypes.Tx) {
+		tx := &ndau.Transfer{Source: testAddress(t, 1), Destination: testAddress(t, 2), Qty: 1, Sequence: seq}
+		txb, err := metatx.Marshal(tx, ndau.TxIDs)
+		require.NoError(t, err)
+		return tx, txb
+	}
+	failed, failedBytes := transfer(1)
+	first, firstBytes := transfer(2)
+	second, secondBytes := transfer(3)
+	pending, pendingBytes := transfer(4)
+	missing, _ := transfer(5)
+
+	// block 5 holds a failed tx ahead of two which were applied
+	node := &statusNode{
+		index: map[string]search.TxValueData{
+			metatx.Hash(first):   {BlockHeight: 5, TxOffset: 0},
+			metatx.Hash(second):  {BlockHeight: 5, TxOffset: 1},
+			metatx.Hash(missing): {BlockHeight: 5, TxOffset: 2},
+		},
+		blocks: map[int64][]ttypes.Tx{
+			5: {failedBytes, firstBytes, secondBytes},
+		},
+		results: map[int64][]*abci.ResponseDeliverTx{
+			5: {{Code: 3, Log: "invalid sequence"}, {Log: "first"}, {Log: "second"}},
+		},
+		latest:  8,
+		mempool: []ttypes.Tx{ttypes.Tx("not a tx"), pendingBytes},
+	}
+
+	tests := []struct {
+		name    string
+		hash    string
+		want    txStatus
+		wantErr bool
+	}{
+		{"committed", metatx.Hash(first), txStatus{Status: txCommitted, Height: 5, Offset: 1, Log: "first", Confirmations: 3}, false},
+		{"committed later in the block", metatx.Hash(second), txStatus{Status: txCommitted, Height: 5, Offset: 2, Log: "second", Confirmations: 3}, false},
+		{"failed", metatx.Hash(failed), txStatus{Status: txUnknown}, false},
+		{"indexed but not in the block", metatx.Hash(missing), txStatus{}, true},
+		{"pending", metatx.Hash(pending), txStatus{Status: txPending}, false},
+		{"unknown", "nope", txStatus{Status: txUnknown}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getTxStatus(node, tt.hash)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			tt.want.Hash = tt.hash
+			require.Equal(t, tt.want, *got)
+		})
+	}
+}
+
+func TestCommittedOK(t *testing.T) {
+	commit := func(check, deliver uint32) *ctypes.ResultBroadcastTxCommit {
+		r := &ctypes.ResultBroadcastTxCommit{Height: 1}
+		r.CheckTx.Code = check
+		r.DeliverTx.Code = deliver
+		return r
+	}
+	tests := []struct {
+		name string
+		resp interface{}
+		want bool
+	}{
+		{"committed", commit(0, 0), true},
+		{"failed delivery", commit(0, 1), false},
+		{"failed check", commit(1, 0), false},
+		{"async", &ctypes.ResultBroadcastTx{Hash: []byte{1}}, false},
+		{"nil commit", (*ctypes.ResultBroadcastTxCommit)(nil), false},
+		{"nothing", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, committedOK(tt.resp))
+		})
+	}
+}
+
+func TestSendCommitTxRefusesAsync(t *testing.T) {
+	async := true
+	sendAsync = &async
+	defer func() { sendAsync = nil }()
+
+	_, err := sendCommitTx(nil, &ndau.Transfer{})
+	require.Error(t, err)
+}
+
+func TestWaitForHeightWithoutNode(t *testing.T) {
+	prev := nodeHTTP
+	nodeHTTP = nil
+	defer func() { nodeHTTP = prev }()
+	require.Error(t, waitForHeight(1))
+}
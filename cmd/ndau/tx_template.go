@@ -0,0 +1,543 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	cli "github.com/jawher/mow.cli"
+	metatx "github.com/ndau/metanode/pkg/meta/transaction"
+	"github.com/ndau/ndau/pkg/ndau"
+	config "github.com/ndau/ndau/pkg/tool.config"
+	"github.com/ndau/ndaumath/pkg/address"
+	"github.com/ndau/ndaumath/pkg/signature"
+	math "github.com/ndau/ndaumath/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// A txTemplate describes a tx with placeholders
+//
+// Placeholders have the form {{name}}. A string which consists only of a
+// placeholder is replaced by the param's typed value; a placeholder embedded
+// in a longer string is replaced by the param's text.
+//
+//	tx = "Transfer"
+//	account = "{{from}}"
+//
+//	[params.from]
+//	description = "paying account"
+//	type = "address"
+//
+//	[params.qty]
+//	type = "ndau"
+//	default = "100"
+//
+//	[fields]
+//	source = "{{from}}"
+//	destination = "ndaxxxx"
+//	qty = "{{qty}}"
+//	sequence = "{{sequence}}"
+//
+// If the sequence param is not set, it is fetched from the chain for the
+// template's account.
+type txTemplate struct {
+	Tx          string                 `toml:"tx"`
+	Description string                 `toml:"description,omitempty"`
+	Account     string                 `toml:"account,omitempty"`
+	Params      map[string]*txParam    `toml:"params"`
+	Fields      map[string]interface{} `toml:"fields"`
+}
+
+// A txParam describes a template placeholder
+//
+// Types: string (the default), int, bool, ndau, duration, timestamp,
+// address, json, and msgp. A msgp param is given as JSON and converted with
+// json2msgp, using the param's json2msgp type hints.
+type txParam struct {
+	Description string              `toml:"description,omitempty"`
+	Type        string              `toml:"type,omitempty"`
+	Default     *string             `toml:"default,omitempty"`
+	Types       map[string][]string `toml:"types,omitempty"`
+}
+
+const sequenceParam = "sequence"
+
+var placeholderRE = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+func loadTxTemplate(path string) (*txTemplate, error) {
+	t := new(txTemplate)
+	_, err := toml.DecodeFile(path, t)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading template")
+	}
+	if t.Tx == "" {
+		return nil, errors.New("template does not specify a tx")
+	}
+	if t.Params == nil {
+		t.Params = make(map[string]*txParam)
+	}
+	for _, name := range t.placeholders() {
+		if _, ok := t.Params[name]; !ok {
+			t.Params[name] = &txParam{}
+		}
+	}
+	return t, nil
+}
+
+// placeholders returns the names of all placeholders used in the template
+func (t *txTemplate) placeholders() []string {
+	seen := make(map[string]struct{})
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch vv := v.(type) {
+		case string:
+			for _, m := range placeholderRE.FindAllStringSubmatch(vv, -1) {
+				seen[m[1]] = struct{}{}
+			}
+		case map[string]interface{}:
+			for _, item := range vv {
+				walk(item)
+			}
+		case []interface{}:
+			for _, item := range vv {
+				walk(item)
+			}
+		case []map[string]interface{}:
+			for _, item := range vv {
+				walk(item)
+			}
+		}
+	}
+	walk(t.Account)
+	walk(t.Fields)
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseSets parses k=v pairs
+func parseSets(sets []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, set := range sets {
+		kv := strings.SplitN(set, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("%s: expected KEY=VALUE", set)
+		}
+		values[kv[0]] = kv[1]
+	}
+	return values, nil
+}
+
+// value converts a param's text to its typed value
+func (p *txParam) value(text string) (interface{}, error) {
+	switch p.Type {
+	case "", "string":
+		return text, nil
+	case "int":
+		return strconv.ParseInt(text, 10, 64)
+	case "bool":
+		return strconv.ParseBool(text)
+	case "ndau":
+		return math.ParseNdau(text)
+	case "duration":
+		return math.ParseDuration(text)
+	case "timestamp":
+		return math.ParseTimestamp(text)
+	case "address":
+		return address.Validate(text)
+	case "json":
+		return json.RawMessage(text), checkJSON(text)
+	case "msgp":
+		// []byte is rendered as base64 in JSON, which is how txs expect it
+		return jsonToMsgp([]byte(text), p.Types)
+	}
+	return nil, fmt.Errorf("unknown param type %s", p.Type)
+}
+
+func checkJSON(text string) error {
+	var v interface{}
+	return json.Unmarshal([]byte(text), &v)
+}
+
+// resolve determines the text of each param
+//
+// Missing params are reported together.
+func (t *txTemplate) resolve(values map[string]string) (map[string]string, error) {
+	for name := range values {
+		if _, ok := t.Params[name]; !ok {
+			return nil, fmt.Errorf("template has no param %s", name)
+		}
+	}
+	resolved := make(map[string]string)
+	var missing []string
+	for name, p := range t.Params {
+		switch text, ok := values[name]; {
+		case ok:
+			resolved[name] = text
+		case p.Default != nil:
+			resolved[name] = *p.Default
+		case name == sequenceParam:
+			// filled in from the chain once the account is known
+		default:
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("missing params: %s", strings.Join(missing, ", "))
+	}
+	return resolved, nil
+}
+
+// substitute replaces placeholders in v
+func (t *txTemplate) substitute(v interface{}, texts map[string]string) (interface{}, error) {
+	switch vv := v.(type) {
+	case string:
+		if m := placeholderRE.FindStringSubmatch(vv); m != nil && m[0] == vv {
+			out, err := t.Params[m[1]].value(texts[m[1]])
+			return out, errors.Wrap(err, m[1])
+		}
+		return placeholderRE.ReplaceAllStringFunc(vv, func(s string) string {
+			return texts[placeholderRE.FindStringSubmatch(s)[1]]
+		}), nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(vv))
+		for k, item := range vv {
+			sub, err := t.substitute(item, texts)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = sub
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(vv))
+		for i, item := range vv {
+			sub, err := t.substitute(item, texts)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = sub
+		}
+		return out, nil
+	case []map[string]interface{}:
+		out := make([]interface{}, len(vv))
+		for i, item := range vv {
+			sub, err := t.substitute(item, texts)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = sub
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// render the template into a tx
+//
+// If the template's account is in the config, it is returned so that the tx
+// can be signed.
+func (t *txTemplate) render(conf *config.Config, values map[string]string) (metatx.Transactable, *config.Account, error) {
+	texts, err := t.resolve(values)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var acct *config.Account
+	if t.Account != "" {
+		name := placeholderRE.ReplaceAllStringFunc(t.Account, func(s string) string {
+			return texts[placeholderRE.FindStringSubmatch(s)[1]]
+		})
+		acct = conf.Accounts[name]
+		if acct == nil {
+			return nil, nil, fmt.Errorf("account %s not found in config", name)
+		}
+	}
+
+	tx, err := ndau.TxFromName(t.Tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	t.inferTypes(tx)
+
+	if _, ok := t.Params[sequenceParam]; ok {
+		if _, ok := texts[sequenceParam]; !ok {
+			if acct == nil {
+				return nil, nil, errors.New("sequence not set and template has no account")
+			}
+			texts[sequenceParam] = strconv.FormatUint(sequence(conf, acct.Address), 10)
+			if t.Params[sequenceParam].Type == "" {
+				t.Params[sequenceParam].Type = "int"
+			}
+		}
+	}
+
+	fields, err := t.substitute(t.Fields, texts)
+	if err != nil {
+		return nil, nil, err
+	}
+	js, err := json.Marshal(fields)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "encoding fields")
+	}
+	err = json.Unmarshal(js, tx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "decoding "+t.Tx)
+	}
+	return tx, acct, nil
+}
+
+var (
+	ndauType      = reflect.TypeOf(math.Ndau(0))
+	durationType  = reflect.TypeOf(math.Duration(0))
+	timestampType = reflect.TypeOf(math.Timestamp(0))
+	addressType   = reflect.TypeOf(address.Address{})
+	bytesType     = reflect.TypeOf([]byte{})
+	signatureType = reflect.TypeOf(signature.Signature{})
+	signaturesTy  = reflect.TypeOf([]signature.Signature{})
+)
+
+// paramType chooses the param type for a tx field
+func paramType(t reflect.Type) string {
+	switch t {
+	case ndauType:
+		return "ndau"
+	case durationType:
+		return "duration"
+	case timestampType:
+		return "timestamp"
+	case addressType:
+		return "address"
+	case bytesType:
+		return "msgp"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	}
+	return "json"
+}
+
+// txFields returns the types of a tx's fields, by their JSON names
+func txFields(tx metatx.Transactable) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	st := reflect.TypeOf(tx).Elem()
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// inferTypes gives untyped params the type of the tx field they fill
+//
+// Only a param which is the whole of a top-level field is inferred, and
+// only when its text can be parsed as the field's type; other params remain
+// strings.
+func (t *txTemplate) inferTypes(tx metatx.Transactable) {
+	types := txFields(tx)
+	for name, v := range t.Fields {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		m := placeholderRE.FindStringSubmatch(s)
+		if m == nil || m[0] != s {
+			continue
+		}
+		p := t.Params[m[1]]
+		ft, ok := types[name]
+		if p == nil || p.Type != "" || !ok {
+			continue
+		}
+		switch typ := paramType(ft); typ {
+		case "int", "bool", "ndau", "duration", "timestamp", "address":
+			p.Type = typ
+		}
+	}
+}
+
+// skeleton creates a template with a placeholder for each field of a tx
+func skeleton(txname string) (*txTemplate, error) {
+	tx, err := ndau.TxFromName(txname)
+	if err != nil {
+		return nil, err
+	}
+	t := &txTemplate{
+		Tx:     metatx.NameOf(tx),
+		Params: make(map[string]*txParam),
+		Fields: make(map[string]interface{}),
+	}
+	for name, ft := range txFields(tx) {
+		if ft == signatureType || ft == signaturesTy {
+			continue
+		}
+		t.Fields[name] = fmt.Sprintf("{{%s}}", name)
+		t.Params[name] = &txParam{Type: paramType(ft)}
+	}
+	return t, nil
+}
+
+// signsWithOwnership is true for txs which are signed with the account's
+// ownership key rather than its validation keys
+func signsWithOwnership(tx metatx.Transactable) bool {
+	_, ok := tx.(*ndau.SetValidation)
+	return ok
+}
+
+// templateSigner chooses the signer for a rendered tx
+func templateSigner(tx metatx.Transactable, acct *config.Account, keys int) *txSigner {
+	if signsWithOwnership(tx) {
+		return ownershipSigner(acct)
+	}
+	return accountSigner(acct, keys)
+}
+
+func getTx(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Command(
+			"template",
+			"emit a template with a placeholder for each field of a tx",
+			getTxTemplate(verbose),
+		)
+
+		cmd.Command(
+			"render",
+			"render a template into JSON suitable for the send command",
+			getTxRender(verbose, keys),
+		)
+
+		cmd.Command(
+			"run",
+			"render a template, sign it, and send it",
+			getTxRun(verbose, keys, emitJSON, compact),
+		)
+
+		cmd.Command(
+			"status",
+			"report the status of a sent tx",
+			getTxStatusCmd(verbose),
+		)
+	}
+}
+
+func getTxTemplate(verbose *bool) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "TXNAME"
+
+		txname := cmd.StringArg("TXNAME", "", "transaction name")
+
+		cmd.Action = func() {
+			t, err := skeleton(*txname)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				for _, tx := range ndau.KnownTxNames() {
+					fmt.Fprintln(os.Stderr, "  ", tx)
+				}
+				os.Exit(1)
+			}
+			orQuit(toml.NewEncoder(os.Stdout).Encode(t))
+		}
+	}
+}
+
+// getTemplateClosure handles the args common to rendering a template
+func getTemplateClosure(cmd *cli.Cmd) func() (metatx.Transactable, *config.Account, *config.Config) {
+	var (
+		path = cmd.StringArg("TEMPLATE", "", "path to the template")
+		sets = cmd.StringsOpt("s set", nil, "set a param: KEY=VALUE")
+	)
+
+	return func() (metatx.Transactable, *config.Account, *config.Config) {
+		t, err := loadTxTemplate(*path)
+		orQuit(err)
+		values, err := parseSets(*sets)
+		orQuit(err)
+		conf := getConfig()
+		tx, acct, err := t.render(conf, values)
+		orQuit(err)
+		return tx, acct, conf
+	}
+}
+
+func getTxRender(verbose *bool, keys *int) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "TEMPLATE [-s=<KEY=VALUE>...] [--sign]"
+
+		getTemplate := getTemplateClosure(cmd)
+		sign := cmd.BoolOpt("sign", false, "sign the tx with the template's account")
+
+		cmd.Action = func() {
+			tx, acct, _ := getTemplate()
+			if *sign {
+				if acct == nil {
+					orQuit(errors.New("cannot sign: template has no account"))
+				}
+				templateSigner(tx, acct, *keys).SignUnsigned(tx)
+			}
+			if *verbose {
+				fmt.Fprintln(os.Stderr, summarize(tx))
+			}
+			js, err := jsonify(tx)
+			orQuit(err)
+			fmt.Println(js)
+		}
+	}
+}
+
+func getTxRun(verbose *bool, keys *int, emitJSON, compact *bool) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "TEMPLATE [-s=<KEY=VALUE>...]"
+
+		getTemplate := getTemplateClosure(cmd)
+
+		cmd.Action = func() {
+			tx, acct, conf := getTemplate()
+			if acct == nil {
+				orQuit(errors.New("cannot sign: template has no account"))
+			}
+			if !signsWithOwnership(tx) && !hasValidation(acct) {
+				orQuit(fmt.Errorf("%s validation key not set", acct.Name))
+			}
+			templateSigner(tx, acct, *keys).SignUnsigned(tx)
+			if *verbose {
+				fmt.Println(summarize(tx))
+			}
+
+			resp, err := sendTx(tmnode(conf.Node, emitJSON, compact), tx)
+			finish(*verbose, resp, err, "tx run")
+		}
+	}
+}
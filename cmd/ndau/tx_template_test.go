@@ -0,0 +1,236 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/ndau/ndau/pkg/ndau"
+	config "github.com/ndau/ndau/pkg/tool.config"
+	"github.com/ndau/ndaumath/pkg/address"
+	math "github.com/ndau/ndaumath/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func testAddress(t *testing.T, b byte) address.Address {
+	addr, err := address.Generate(address.KindUser, bytes.Repeat([]byte{b}, 32))
+	require.NoError(t, err)
+	return addr
+}
+
+func transferTemplate() *txTemplate {
+	return &txTemplate{
+		Tx: "Transfer",
+		Params: map[string]*txParam{
+			"from": {Type: "address"},
+			"to":   {},
+			"qty":  {},
+		},
+		Fields: map[string]interface{}{
+			"source":      "{{from}}",
+			"destination": "{{to}}",
+			"qty":         "{{qty}}",
+			"sequence":    "{{sequence}}",
+		},
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	tmpl := &txTemplate{
+		Account: "{{ acct }}",
+		Fields: map[string]interface{}{
+			"a": "{{x}} and {{y}}",
+			"b": []interface{}{"{{z}}", 1},
+			"c": map[string]interface{}{"d": "{{x}}"},
+			"e": []map[string]interface{}{{"f": "{{w}}"}},
+			"g": "no placeholder",
+		},
+	}
+	require.Equal(t, []string{"acct", "w", "x", "y", "z"}, tmpl.placeholders())
+}
+
+func TestParseSets(t *testing.T) {
+	tests := []struct {
+		name    string
+		sets    []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"none", nil, map[string]string{}, false},
+		{"simple", []string{"a=1", "b=2"}, map[string]string{"a": "1", "b": "2"}, false},
+		{"value with equals", []string{"a=b=c"}, map[string]string{"a": "b=c"}, false},
+		{"empty value", []string{"a="}, map[string]string{"a": ""}, false},
+		{"later wins", []string{"a=1", "a=2"}, map[string]string{"a": "2"}, false},
+		{"no equals", []string{"a"}, nil, true},
+		{"no key", []string{"=1"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSets(tt.sets)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParamValue(t *testing.T) {
+	addr := testAddress(t, 1)
+	tests := []struct {
+		typ     string
+		text    string
+		want    interface{}
+		wantErr bool
+	}{
+		{"", "hi", "hi", false},
+		{"string", "1", "1", false},
+		{"int", "-5", int64(-5), false},
+		{"int", "five", nil, true},
+		{"bool", "true", true, false},
+		{"bool", "maybe", nil, true},
+		{"ndau", "1.5", math.Ndau(150000000), false},
+		{"ndau", "lots", nil, true},
+		{"duration", "1d", math.Duration(math.Day), false},
+		{"address", addr.String(), addr, false},
+		{"address", "ndanotanaddress", nil, true},
+		{"json", `{"a": 1}`, json.RawMessage(`{"a": 1}`), false},
+		{"json", `{"a":`, nil, true},
+		{"float", "1.5", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ+" "+tt.text, func(t *testing.T) {
+			got, err := (&txParam{Type: tt.typ}).value(tt.text)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	tmpl, err := skeleton("transfer")
+	require.NoError(t, err)
+	require.Equal(t, "Transfer", tmpl.Tx)
+	require.Equal(t, map[string]interface{}{
+		"source":      "{{source}}",
+		"destination": "{{destination}}",
+		"qty":         "{{qty}}",
+		"sequence":    "{{sequence}}",
+	}, tmpl.Fields)
+	types := make(map[string]string)
+	for name, p := range tmpl.Params {
+		types[name] = p.Type
+	}
+	require.Equal(t, map[string]string{
+		"source":      "address",
+		"destination": "address",
+		"qty":         "ndau",
+		"sequence":    "int",
+	}, types)
+
+	_, err = skeleton("NoSuchTx")
+	require.Error(t, err)
+}
+
+func TestRender(t *testing.T) {
+	from := testAddress(t, 1)
+	to := testAddress(t, 2)
+	conf := &config.Config{Accounts: map[string]*config.Account{}}
+
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    *ndau.Transfer
+		wantErr bool
+	}{
+		{
+			// untyped params take the type of the field they fill
+			"inferred types",
+			map[string]string{"from": from.String(), "to": to.String(), "qty": "1.5", "sequence": "7"},
+			&ndau.Transfer{Source: from, Destination: to, Qty: 150000000, Sequence: 7},
+			false,
+		},
+		{
+			"missing params",
+			map[string]string{"from": from.String(), "sequence": "7"},
+			nil,
+			true,
+		},
+		{
+			"unknown param",
+			map[string]string{"from": from.String(), "to": to.String(), "qty": "1", "sequence": "7", "fee": "1"},
+			nil,
+			true,
+		},
+		{
+			"bad value",
+			map[string]string{"from": from.String(), "to": to.String(), "qty": "lots", "sequence": "7"},
+			nil,
+			true,
+		},
+		{
+			// the sequence would be fetched for the template's account
+			"sequence without account",
+			map[string]string{"from": from.String(), "to": to.String(), "qty": "1"},
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl := transferTemplate()
+			tmpl.Params[sequenceParam] = &txParam{}
+			tx, acct, err := tmpl.render(conf, tt.values)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Nil(t, acct)
+			require.Equal(t, tt.want, tx)
+		})
+	}
+}
+
+func TestRenderAccount(t *testing.T) {
+	from := testAddress(t, 1)
+	conf := &config.Config{Accounts: map[string]*config.Account{
+		"alice": {Name: "alice", Address: from},
+	}}
+	tmpl := transferTemplate()
+	tmpl.Account = "{{name}}"
+	tmpl.Params["name"] = &txParam{}
+	values := map[string]string{
+		"name": "alice", "from": from.String(), "to": testAddress(t, 2).String(),
+		"qty": "1", "sequence": "1",
+	}
+	tmpl.Params[sequenceParam] = &txParam{}
+
+	_, acct, err := tmpl.render(conf, values)
+	require.NoError(t, err)
+	require.Equal(t, conf.Accounts["alice"], acct)
+
+	values["name"] = "bob"
+	_, _, err = tmpl.render(conf, values)
+	require.Error(t, err)
+}
+
+func TestSignsWithOwnership(t *testing.T) {
+	require.True(t, signsWithOwnership(&ndau.SetValidation{}))
+	require.False(t, signsWithOwnership(&ndau.Transfer{}))
+}
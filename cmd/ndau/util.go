@@ -19,21 +19,43 @@ import (
 	"github.com/ndau/ndau/pkg/tool"
 	config "github.com/ndau/ndau/pkg/tool.config"
 	"github.com/ndau/ndaumath/pkg/address"
+	"github.com/ndau/ndaumath/pkg/signature"
 	"github.com/pkg/errors"
 	"github.com/tendermint/tendermint/rpc/client"
 )
 
 func orQuit(err error) {
 	if err != nil {
+		if serving {
+			panic(serveError{err})
+		}
 		fmt.Fprintln(os.Stderr, fmt.Sprintf("%v", err))
 		cli.Exit(1)
 	}
 }
 
+// loadConfig loads the configuration, unlocking the keystore if it is encrypted
+func loadConfig() (*config.Config, error) {
+	if keystoreExists() {
+		return unlockKeystore()
+	}
+	return config.Load(config.GetConfigPath())
+}
+
+// getConfig loads the configuration as seen through the selected profile
 func getConfig() *config.Config {
-	config, err := config.LoadDefault(config.GetConfigPath())
-	orQuit(errors.Wrap(err, "Failed to load configuration"))
-	return config
+	var conf *config.Config
+	var err error
+	if keystoreExists() {
+		conf, err = unlockKeystore()
+		orQuit(errors.Wrap(err, "Failed to unlock keystore"))
+	} else {
+		conf, err = config.LoadDefault(config.GetConfigPath())
+		orQuit(errors.Wrap(err, "Failed to load configuration"))
+	}
+	conf, err = applyProfile(conf)
+	orQuit(errors.Wrap(err, "Failed to apply profile"))
+	return conf
 }
 
 // validateBytes ensures that the submitted bytes are valid utf-8,
@@ -44,7 +66,7 @@ func validateBytes(bytes []byte) {
 	}
 }
 
-// JSG create global client conn, will get reused for server mode
+// JSG create global client conn, reused for server mode
 var nodeHTTP *client.HTTP
 
 // tmnode sets up a client connection to a Tendermint node
@@ -53,6 +75,14 @@ func tmnode(node string, json, compact *bool) client.ABCIClient {
 		return tool.NewJSONClient(!*compact)
 	}
 
+	if active != nil && active.Mainnet {
+		return mainnetGuard{httpnode(node)}
+	}
+	return httpnode(node)
+}
+
+// httpnode returns the shared http connection to a Tendermint node
+func httpnode(node string) *client.HTTP {
 	if nodeHTTP == nil {
 		nodeHTTP = client.NewHTTP(node, "/websocket")
 	}
@@ -88,3 +118,16 @@ func sequence(conf *config.Config, addr address.Address) uint64 {
 	))
 	return ad.Sequence + 1
 }
+
+// query the account to get the validation keys it has on the blockchain
+//
+// These are the account's real keys, even when it is externally signed and
+// the config has none of them.
+func validationKeys(conf *config.Config, addr address.Address) []signature.PublicKey {
+	ad, _, err := tool.GetAccount(tmnode(conf.Node, nil, nil), addr)
+	orQuit(errors.Wrap(
+		err,
+		fmt.Sprintf("Failed to get current validation keys for %s", addr),
+	))
+	return ad.ValidationKeys
+}
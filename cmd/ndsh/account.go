@@ -126,6 +126,9 @@ func (acct *Account) Update(sh *Shell, print func(format string, args ...interfa
 	if sh.Verbose && print != nil {
 		print("updating %s", acct.Address)
 	}
+	if sh.Offline != nil {
+		return acct.updateOffline(sh, print)
+	}
 	ad, resp, err := tool.GetAccount(sh.Node, acct.Address)
 	if err != nil {
 		if sh.Verbose && print != nil {
@@ -154,6 +157,21 @@ func (acct *Account) Update(sh *Shell, print func(format string, args ...interfa
 	return nil
 }
 
+// updateOffline updates this account with data from the offline snapshot
+func (acct *Account) updateOffline(sh *Shell, print func(format string, args ...interface{})) error {
+	ad, exists := sh.Offline.Snapshot.Accounts[acct.Address.String()]
+	if sh.Verbose && print != nil {
+		print("    exists in snapshot: %t", exists)
+	}
+	if !exists || ad == nil {
+		acct.Data = &backing.AccountData{}
+		return AccountDoesNotExist{acct.Address}
+	}
+	// share the pointer: Offline.Emit advances the sequence in the snapshot
+	acct.Data = ad
+	return nil
+}
+
 func (acct *Account) display(sh *Shell, nicknames []string) {
 	sh.Write("%s (%s): %s", acct.Address, acct.Path, strings.Join(nicknames, " "))
 }
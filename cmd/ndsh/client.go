@@ -16,18 +16,32 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
+	cmn "github.com/tendermint/tendermint/libs/bytes"
 	"github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	ttypes "github.com/tendermint/tendermint/types"
 )
 
 const servicesURL = "https://s3.us-east-2.amazonaws.com/ndau-json/services.json"
 
+// servicesTimeout bounds how long we wait for the remote services.json
+const servicesTimeout = 10 * time.Second
+
 var (
-	servicesJSON     map[string]interface{}
-	haveServicesJSON chan error
+	servicesJSON map[string]interface{}
+	servicesPath string
+	servicesLock sync.Mutex
+
+	// per-network overrides, keyed by canonical network name
+	nodeOverrides     = make(map[string]*url.URL)
+	recoveryOverrides = make(map[string]*url.URL)
 
 	// ClientURL stores client URL currently in use
 	ClientURL *url.URL
@@ -36,32 +50,67 @@ var (
 	RecoveryURL *url.URL
 )
 
-// on init, async get the services list
-// just try to read from haveServicesJson before trying to use it
-func init() {
-	haveServicesJSON = make(chan error)
-	go func() {
-		defer close(haveServicesJSON)
-		resp, err := http.Get(servicesURL)
+// setServicesPath causes services data to be read from a local file instead
+// of being fetched from servicesURL.
+//
+// The file must have the same schema as the remote services.json. An empty
+// path restores the default remote behavior.
+func setServicesPath(path string) error {
+	if path != "" {
+		var err error
+		path, err = homedir.Expand(os.ExpandEnv(path))
 		if err != nil {
-			haveServicesJSON <- errors.Wrap(err, "getting services map")
-			return
+			return errors.Wrap(err, "expanding homedir")
 		}
+	}
 
-		defer resp.Body.Close()
-		data, err := ioutil.ReadAll(resp.Body)
+	servicesLock.Lock()
+	defer servicesLock.Unlock()
+	servicesPath = path
+	servicesJSON = nil
+	return nil
+}
+
+// getServices returns the services map, loading it if necessary
+//
+// Nothing is fetched until the first time a symbolic network name needs to
+// be resolved, so ndsh can start with no connectivity. Failures are not
+// cached; a subsequent call will try again.
+func getServices() (map[string]interface{}, error) {
+	servicesLock.Lock()
+	defer servicesLock.Unlock()
+	if servicesJSON != nil {
+		return servicesJSON, nil
+	}
+
+	var data []byte
+	var err error
+	if servicesPath != "" {
+		data, err = ioutil.ReadFile(servicesPath)
 		if err != nil {
-			haveServicesJSON <- errors.Wrap(err, "reading services data")
-			return
+			return nil, errors.Wrap(err, "reading services file")
 		}
-
-		err = json.Unmarshal(data, &servicesJSON)
+	} else {
+		hc := http.Client{Timeout: servicesTimeout}
+		var resp *http.Response
+		resp, err = hc.Get(servicesURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting services map")
+		}
+		defer resp.Body.Close()
+		data, err = ioutil.ReadAll(resp.Body)
 		if err != nil {
-			haveServicesJSON <- errors.Wrap(err, "unmarshaling services data")
-			fmt.Fprintln(os.Stderr, "unmarshaling services data:", err)
-			return
+			return nil, errors.Wrap(err, "reading services data")
 		}
-	}()
+	}
+
+	var sj map[string]interface{}
+	err = json.Unmarshal(data, &sj)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshaling services data")
+	}
+	servicesJSON = sj
+	return servicesJSON, nil
 }
 
 func getNested(dict map[string]interface{}, path ...string) (interface{}, error) {
@@ -102,8 +151,8 @@ func getNestedInner(breadcrumbs []string, dict map[string]interface{}, path []st
 	return getNestedInner(append(breadcrumbs, head), imap, rest)
 }
 
-func getService(name, nodename string, path ...string) (*url.URL, error) {
-	svci, err := getNested(servicesJSON, path...)
+func getService(services map[string]interface{}, name, nodename string, path ...string) (*url.URL, error) {
+	svci, err := getNested(services, path...)
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("retrieving %s for %s", name, nodename))
 	}
@@ -113,56 +162,105 @@ func getService(name, nodename string, path ...string) (*url.URL, error) {
 		return nil, fmt.Errorf("unexpected %s type %T in %s", name, svci, nodename)
 	}
 
+	return parseServiceURL(svc)
+}
+
+func parseServiceURL(svc string) (*url.URL, error) {
 	if !strings.HasPrefix(svc, "http") {
 		svc = "https://" + svc
 	}
 	return url.Parse(svc)
 }
 
-func getClient(network string, node int) (client.ABCIClient, error) {
-	if node < 0 {
-		return nil, fmt.Errorf("invalid node: %d", node)
-	}
-
-	ClientURL = nil
-	RecoveryURL = nil
-
-	var netname string
-	var err error
-
-	switch strings.ToLower(network) {
+// canonicalNet returns the canonical name of a symbolic network
+//
+// If the input is a URL instead of a network name, it returns "". Network
+// names never contain a colon, so both full URLs and bare host:port
+// addresses are URLs.
+func canonicalNet(network string) string {
+	network = strings.ToLower(network)
+	switch network {
 	case "main", "mainnet":
-		netname = "mainnet"
+		return "mainnet"
 	case "test", "testnet":
-		netname = "testnet"
+		return "testnet"
 	case "dev", "devnet":
-		netname = "devnet"
+		return "devnet"
 	case "local", "localnet":
-		ClientURL, err = url.Parse(fmt.Sprintf("http://localhost:%d", 26670+node))
-		if err != nil {
-			return nil, errors.New("bad code in net.go: couldn't parse localnet url")
-		}
-	default:
-		ClientURL, err = url.Parse(network)
+		return "localnet"
+	}
+	if strings.Contains(network, ":") {
+		return ""
+	}
+	return network
+}
+
+// addOverride parses a NET=URL spec and records it in the given overrides
+func addOverride(overrides map[string]*url.URL, spec string) error {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("override must have the form NET=URL; got %q", spec)
+	}
+	netname := canonicalNet(parts[0])
+	if netname == "" {
+		return fmt.Errorf("invalid network name in override: %s", parts[0])
+	}
+	u, err := parseServiceURL(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid URL in override: %s", parts[1])
+	}
+	overrides[netname] = u
+	return nil
+}
+
+// AddNodeOverride sets the node RPC URL for a network from a NET=URL spec
+//
+// Overrides take precedence over services.json for every node number on
+// that network.
+func AddNodeOverride(spec string) error {
+	return addOverride(nodeOverrides, spec)
+}
+
+// AddRecoveryOverride sets the recovery service URL for a network from a
+// NET=URL spec
+func AddRecoveryOverride(spec string) error {
+	return addOverride(recoveryOverrides, spec)
+}
+
+// resolveNet determines the node and recovery URLs for a symbolic network
+//
+// The recovery URL may be nil even when there is no error: not every
+// network has a recovery service.
+func resolveNet(netname string, node int) (nodeURL, recoveryURL *url.URL, err error) {
+	// copy overrides so that callers can't modify them through the results
+	if u := nodeOverrides[netname]; u != nil {
+		nu := *u
+		nodeURL = &nu
+	}
+	if u := recoveryOverrides[netname]; u != nil {
+		ru := *u
+		recoveryURL = &ru
+	}
+
+	if nodeURL == nil && netname == "localnet" {
+		nodeURL, err = url.Parse(fmt.Sprintf("http://localhost:%d", 26670+node))
 		if err != nil {
-			// suppress the actual error, but use our own
-			return nil, fmt.Errorf("invalid URL: %s", network)
+			return nil, nil, errors.New("bad code in client.go: couldn't parse localnet url")
 		}
 	}
 
-	if ClientURL == nil {
-		// user specified a symbolic name, which means we have to wait for
-		// the AWS query to resolve
-		select {
-		case <-time.After(10 * time.Second):
-			err = errors.New("timeout after 10 s")
-		case err = <-haveServicesJSON:
-			// probably success, but we check it anyway
+	if nodeURL != nil && (recoveryURL != nil || netname == "localnet") {
+		// we have everything we need; don't touch services.json at all
+		return
+	}
+
+	services, serr := getServices()
+	if nodeURL == nil {
+		if serr != nil {
+			return nil, nil, errors.Wrap(serr, "fetching services.json")
 		}
-		check(err, "fetching services.json")
 		nodename := fmt.Sprintf("%s-%d", netname, node)
-
-		ClientURL, err = getService("rpc addr", nodename,
+		nodeURL, err = getService(services, "rpc addr", nodename,
 			"networks",
 			netname,
 			"nodes",
@@ -170,11 +268,14 @@ func getClient(network string, node int) (client.ABCIClient, error) {
 			"rpc",
 		)
 		if err != nil {
-			return nil, errors.Wrap(err, "invalid services.json for "+nodename)
+			return nil, nil, errors.Wrap(err, "invalid services.json for "+nodename)
 		}
+	}
 
+	if recoveryURL == nil && serr == nil {
+		// not all networks have a recovery service; its absence is not an error
 		node0name := fmt.Sprintf("%s-0", netname)
-		RecoveryURL, err = getService("recovery addr", node0name,
+		recoveryURL, _ = getService(services, "recovery addr", node0name,
 			"recovery",
 			netname,
 			"nodes",
@@ -182,9 +283,186 @@ func getClient(network string, node int) (client.ABCIClient, error) {
 			"api",
 		)
 	}
+	return
+}
+
+// knownNets lists every network name about which we have any information
+//
+// If services.json is unavailable, the error is returned along with the
+// networks we know about from other sources.
+func knownNets() ([]string, error) {
+	names := map[string]struct{}{"localnet": {}}
+	for n := range nodeOverrides {
+		names[n] = struct{}{}
+	}
+	for n := range recoveryOverrides {
+		names[n] = struct{}{}
+	}
+	services, err := getServices()
+	if err == nil {
+		if nets, ok := services["networks"].(map[string]interface{}); ok {
+			for n := range nets {
+				names[n] = struct{}{}
+			}
+		}
+	}
 
+	out := make([]string, 0, len(names))
+	for n := range names {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out, err
+}
+
+// netNodes returns the node names and rpc addresses listed for a network
+// in services.json, sorted by node name
+func netNodes(netname string) ([]string, map[string]*url.URL) {
+	services, err := getServices()
+	if err != nil {
+		return nil, nil
+	}
+	nodesi, err := getNested(services, "networks", netname, "nodes")
+	if err != nil {
+		return nil, nil
+	}
+	nodes, ok := nodesi.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	names := make([]string, 0, len(nodes))
+	rpcs := make(map[string]*url.URL, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+		if rpc, err := getService(nodes, "rpc addr", name, name, "rpc"); err == nil {
+			rpcs[name] = rpc
+		}
+	}
+	sort.Strings(names)
+	return names, rpcs
+}
+
+func newHTTPClient(u *url.URL) client.ABCIClient {
 	// we have a URL object
 	// ignore any path; we'll supply our own, externally
-	ClientURL.Path = ""
-	return client.NewHTTP(ClientURL.String(), "/websocket"), nil
+	u.Path = ""
+	return client.NewHTTP(u.String(), "/websocket")
+}
+
+// getClient returns a client for the named network
+//
+// If network is a URL, the client is returned immediately. Otherwise, the
+// returned client defers resolving the network name until it is first used,
+// so that ndsh can start with no connectivity.
+func getClient(network string, node int) (client.ABCIClient, error) {
+	if node < 0 {
+		return nil, fmt.Errorf("invalid node: %d", node)
+	}
+
+	ClientURL = nil
+	RecoveryURL = nil
+
+	netname := canonicalNet(network)
+	if netname == "" {
+		// a bare host:port is a node's RPC address
+		if !strings.Contains(network, "://") {
+			network = "http://" + network
+		}
+		var err error
+		ClientURL, err = url.Parse(network)
+		if err != nil {
+			// suppress the actual error, but use our own
+			return nil, fmt.Errorf("invalid URL: %s", network)
+		}
+		return newHTTPClient(ClientURL), nil
+	}
+
+	return &lazyClient{netname: netname, node: node}, nil
+}
+
+// lazyClient is a client.ABCIClient which resolves a symbolic network name
+// only when it is first used.
+//
+// Resolution sets ClientURL and RecoveryURL as a side-effect. If resolution
+// fails, it is retried on the next use.
+type lazyClient struct {
+	netname string
+	node    int
+
+	lock  sync.Mutex
+	inner client.ABCIClient
+}
+
+var _ client.ABCIClient = (*lazyClient)(nil)
+
+// Resolve the network name, returning the underlying client
+func (l *lazyClient) Resolve() (client.ABCIClient, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.inner != nil {
+		return l.inner, nil
+	}
+
+	nodeURL, recoveryURL, err := resolveNet(l.netname, l.node)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("resolving %s-%d", l.netname, l.node))
+	}
+	ClientURL = nodeURL
+	RecoveryURL = recoveryURL
+	l.inner = newHTTPClient(nodeURL)
+	return l.inner, nil
+}
+
+// ABCIInfo implements ABCIClient
+func (l *lazyClient) ABCIInfo() (*ctypes.ResultABCIInfo, error) {
+	c, err := l.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return c.ABCIInfo()
+}
+
+// ABCIQuery implements ABCIClient
+func (l *lazyClient) ABCIQuery(path string, data cmn.HexBytes) (*ctypes.ResultABCIQuery, error) {
+	c, err := l.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return c.ABCIQuery(path, data)
+}
+
+// ABCIQueryWithOptions implements ABCIClient
+func (l *lazyClient) ABCIQueryWithOptions(path string, data cmn.HexBytes, opts client.ABCIQueryOptions) (*ctypes.ResultABCIQuery, error) {
+	c, err := l.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return c.ABCIQueryWithOptions(path, data, opts)
+}
+
+// BroadcastTxCommit implements ABCIClient
+func (l *lazyClient) BroadcastTxCommit(tx ttypes.Tx) (*ctypes.ResultBroadcastTxCommit, error) {
+	c, err := l.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return c.BroadcastTxCommit(tx)
+}
+
+// BroadcastTxSync implements ABCIClient
+func (l *lazyClient) BroadcastTxSync(tx ttypes.Tx) (*ctypes.ResultBroadcastTx, error) {
+	c, err := l.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return c.BroadcastTxSync(tx)
+}
+
+// BroadcastTxAsync implements ABCIClient
+func (l *lazyClient) BroadcastTxAsync(tx ttypes.Tx) (*ctypes.ResultBroadcastTx, error) {
+	c, err := l.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return c.BroadcastTxAsync(tx)
 }
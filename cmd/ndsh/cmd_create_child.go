@@ -198,7 +198,7 @@ func (CreateChild) Run(argvs []string, sh *Shell) (err error) {
 	}
 
 	err = child.Update(sh, sh.Write)
-	if args.Stage && IsAccountDoesNotExist(err) {
+	if (args.Stage || sh.Offline != nil) && IsAccountDoesNotExist(err) {
 		err = nil
 	}
 	if err != nil {
@@ -0,0 +1,397 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	metatx "github.com/ndau/metanode/pkg/meta/transaction"
+	"github.com/ndau/ndau/pkg/ndau"
+	"github.com/ndau/ndau/pkg/ndau/search"
+	"github.com/ndau/ndau/pkg/tool"
+	"github.com/ndau/ndaumath/pkg/address"
+	math "github.com/ndau/ndaumath/pkg/types"
+	"github.com/pkg/errors"
+	"github.com/savaki/jq"
+	"github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+)
+
+// historyPageSize is the number of history entries requested from the node at once
+const historyPageSize = 100
+
+// History shows an account's transaction history
+type History struct{}
+
+var _ Command = (*History)(nil)
+
+// Name implements Command
+func (History) Name() string { return "history" }
+
+type historyargs struct {
+	Account string   `arg:"positional" help:"show history of this account"`
+	Since   string   `help:"only show txs at or after this time (RFC3339 or YYYY-MM-DD)"`
+	After   uint64   `help:"only show txs after this block height"`
+	Types   []string `arg:"-t,--type,separate" help:"only show txs of this type"`
+	Limit   int      `arg:"-l" help:"show at most this many txs (0: no limit)"`
+	JSON    bool     `arg:"-j" help:"emit json instead of a table"`
+	JQ      string   `help:"filter output json by this jq expression"`
+}
+
+func (historyargs) Description() string {
+	return strings.TrimSpace(`
+Show the transactions which have affected an account.
+
+Counterparties are shown by nickname when they are known accounts. Amount is
+the tx's quantity, if any; the resulting balance reflects fees and SIB.
+
+Example: show all transfers into or out of an account since the start of 2020:
+
+	history myacct --since 2020-01-01 -t transfer -t transferandlock
+	`)
+}
+
+// HistoryItem is a single entry in an account's history
+type HistoryItem struct {
+	Time           time.Time           `json:"time"`
+	Height         uint64              `json:"height"`
+	Offset         int                 `json:"offset"`
+	TxHash         string              `json:"txhash"`
+	TxType         string              `json:"txtype"`
+	Counterparties []string            `json:"counterparties,omitempty"`
+	Amount         math.Ndau           `json:"amount"`
+	Fee            math.Ndau           `json:"fee"`
+	SIB            math.Ndau           `json:"sib"`
+	Balance        math.Ndau           `json:"balance"`
+	Tx             metatx.Transactable `json:"tx"`
+}
+
+// blockClient returns the node as a client which can retrieve blocks
+func blockClient(node client.ABCIClient) (client.SignClient, error) {
+	if lc, ok := node.(*lazyClient); ok {
+		var err error
+		node, err = lc.Resolve()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := node.(offlineClient); ok {
+		return nil, ErrOffline
+	}
+	sc, ok := node.(client.SignClient)
+	if !ok {
+		return nil, fmt.Errorf("%T cannot retrieve blocks", node)
+	}
+	return sc, nil
+}
+
+// txAddresses returns every address which appears as a top-level field of a tx
+func txAddresses(tx metatx.Transactable) []address.Address {
+	v := reflect.ValueOf(tx)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	addrtype := reflect.TypeOf(address.Address{})
+	var out []address.Address
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch {
+		case f.Type() == addrtype:
+			out = append(out, f.Interface().(address.Address))
+		case f.Kind() == reflect.Ptr && f.Type().Elem() == addrtype && !f.IsNil():
+			out = append(out, f.Elem().Interface().(address.Address))
+		}
+	}
+	return out
+}
+
+// txQty returns the quantity of a tx, if it has one
+func txQty(tx metatx.Transactable) math.Ndau {
+	v := reflect.ValueOf(tx)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return 0
+	}
+	if f := v.FieldByName("Qty"); f.IsValid() {
+		if qty, ok := f.Interface().(math.Ndau); ok {
+			return qty
+		}
+	}
+	return 0
+}
+
+// nameOf returns the first nickname of an address, or the address itself
+func nameOf(addr address.Address, names map[string]string) string {
+	if n, ok := names[addr.String()]; ok {
+		return n
+	}
+	return addr.String()
+}
+
+// nicknames maps each known address to its first nickname
+func (sh *Shell) nicknames() map[string]string {
+	names := make(map[string]string)
+	for acct, nicks := range sh.Accts.Reverse() {
+		if len(nicks) > 0 {
+			names[acct.Address.String()] = nicks[0]
+		}
+	}
+	return names
+}
+
+// historyFetcher requests a page of an account's history after a block height
+type historyFetcher func(after uint64) (*search.AccountHistoryResponse, error)
+
+// pageHistory calls emit for each entry of an account's history after the
+// given height, until emit returns false or there are no more entries.
+//
+// The node pages by block height, so a page can end partway through the
+// txs of a block. The next page is therefore requested from the block
+// before that one, and the entries which were already emitted are skipped.
+func pageHistory(
+	fetch historyFetcher,
+	after uint64,
+	emit func(search.AccountTxValueData) (bool, error),
+) error {
+	var lastHeight uint64
+	// offsets of the entries emitted at lastHeight
+	seen := make(map[int]bool)
+	for {
+		from := after
+		if lastHeight > 0 {
+			from = lastHeight - 1
+		}
+		ahr, err := fetch(from)
+		if err != nil {
+			return err
+		}
+		progress := false
+		for _, vd := range ahr.Txs {
+			if vd.BlockHeight < lastHeight || (vd.BlockHeight == lastHeight && seen[vd.TxOffset]) {
+				continue
+			}
+			more, err := emit(vd)
+			if err != nil || !more {
+				return err
+			}
+			if vd.BlockHeight != lastHeight {
+				lastHeight = vd.BlockHeight
+				seen = make(map[int]bool)
+			}
+			seen[vd.TxOffset] = true
+			progress = true
+		}
+		if !ahr.More || len(ahr.Txs) == 0 {
+			return nil
+		}
+		if !progress {
+			return fmt.Errorf("block %d has more txs for this account than fit in a page of history", lastHeight)
+		}
+	}
+}
+
+// getHistory retrieves the history of an account
+//
+// It continues paging through the account's history until keep returns
+// false or there are no more entries.
+func (sh *Shell) getHistory(
+	addr address.Address,
+	after uint64,
+	keep func(HistoryItem) bool,
+) error {
+	bc, err := blockClient(sh.Node)
+	if err != nil {
+		return err
+	}
+	names := sh.nicknames()
+
+	fetch := func(after uint64) (*search.AccountHistoryResponse, error) {
+		ahr, _, err := tool.GetAccountHistory(sh.Node, search.AccountHistoryParams{
+			Address:     addr.String(),
+			AfterHeight: after,
+			Limit:       historyPageSize,
+		})
+		return ahr, errors.Wrap(err, "getting account history")
+	}
+
+	blocks := make(map[int64]*ctypes.ResultBlock)
+	return pageHistory(fetch, after, func(vd search.AccountTxValueData) (bool, error) {
+		select {
+		case <-sh.Stop:
+			return false, errors.New("shell is stopping")
+		default:
+		}
+
+		height := int64(vd.BlockHeight)
+		block, ok := blocks[height]
+		if !ok {
+			block, err = bc.Block(&height)
+			if err != nil {
+				return false, errors.Wrap(err, fmt.Sprintf("getting block %d", height))
+			}
+			blocks[height] = block
+		}
+		if vd.TxOffset >= len(block.Block.Data.Txs) {
+			return false, fmt.Errorf("tx offset out of range: %d >= %d", vd.TxOffset, len(block.Block.Data.Txs))
+		}
+		tx, err := metatx.Unmarshal(block.Block.Data.Txs[vd.TxOffset], ndau.TxIDs)
+		if err != nil {
+			return false, errors.Wrap(err, "decoding tx")
+		}
+
+		item := HistoryItem{
+			Time:    block.Block.Header.Time,
+			Height:  vd.BlockHeight,
+			Offset:  vd.TxOffset,
+			TxHash:  metatx.Hash(tx),
+			TxType:  metatx.NameOf(tx),
+			Amount:  txQty(tx),
+			Balance: vd.Balance,
+			Tx:      tx,
+		}
+		for _, a := range txAddresses(tx) {
+			if a != addr {
+				item.Counterparties = append(item.Counterparties, nameOf(a, names))
+			}
+		}
+
+		sv, err := tool.GetSearchResults(sh.Node, search.QueryParams{
+			Command: search.HeightByTxHashCommand,
+			Hash:    item.TxHash,
+		})
+		if err == nil {
+			tvd := search.TxValueData{}
+			if tvd.Unmarshal(sv) == nil {
+				item.Fee = math.Ndau(tvd.Fee)
+				item.SIB = math.Ndau(tvd.SIB)
+			}
+		}
+
+		return keep(item), nil
+	})
+}
+
+func parseSince(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not parse time: %s", s)
+}
+
+// Run implements Command
+func (History) Run(argvs []string, sh *Shell) (err error) {
+	args := historyargs{}
+
+	err = ParseInto(argvs, &args)
+	if err != nil {
+		if err == arg.ErrHelp || err == arg.ErrVersion {
+			err = nil
+		}
+		return
+	}
+
+	var addr *address.Address
+	if args.Account == "" {
+		var acct *Account
+		acct, err = sh.Accts.Get("")
+		if err != nil {
+			return
+		}
+		addr = &acct.Address
+	} else {
+		addr, _, err = sh.AddressOf(args.Account)
+		if err != nil {
+			return
+		}
+	}
+
+	var since time.Time
+	if args.Since != "" {
+		since, err = parseSince(args.Since)
+		if err != nil {
+			return
+		}
+	}
+
+	types := make(map[string]struct{})
+	for _, t := range args.Types {
+		types[strings.ToLower(strings.Replace(t, "-", "", -1))] = struct{}{}
+	}
+
+	items := make([]HistoryItem, 0)
+	err = sh.getHistory(*addr, args.After, func(item HistoryItem) bool {
+		if !since.IsZero() && item.Time.Before(since) {
+			return true
+		}
+		if len(types) > 0 {
+			if _, ok := types[strings.ToLower(item.TxType)]; !ok {
+				return true
+			}
+		}
+		items = append(items, item)
+		return args.Limit <= 0 || len(items) < args.Limit
+	})
+	if err != nil {
+		return
+	}
+
+	if args.JSON || args.JQ != "" {
+		var data []byte
+		data, err = json.MarshalIndent(items, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshaling history")
+		}
+		if args.JQ != "" {
+			op, err := jq.Parse(args.JQ)
+			if err != nil {
+				return errors.Wrap(err, "parsing JQ selector")
+			}
+			data, err = op.Apply(data)
+			if err != nil {
+				return errors.Wrap(err, "applying JQ selector")
+			}
+		}
+		sh.Write(string(data))
+		return
+	}
+
+	sh.WriteBatch(func(print func(format string, context ...interface{})) {
+		print("%-20s %8s %-22s %-16s %16s %12s %12s %18s",
+			"time", "height", "type", "counterparty", "amount", "fee", "sib", "balance",
+		)
+		for _, item := range items {
+			cp := strings.Join(item.Counterparties, ",")
+			print("%-20s %8d %-22s %-16s %16s %12s %12s %18s",
+				item.Time.UTC().Format("2006-01-02T15:04:05Z"),
+				item.Height,
+				item.TxType,
+				cp,
+				item.Amount,
+				item.Fee,
+				item.SIB,
+				item.Balance,
+			)
+		}
+		print("%d txs", len(items))
+	})
+	return
+}
@@ -0,0 +1,116 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ndau/ndau/pkg/ndau"
+	"github.com/ndau/ndau/pkg/ndau/search"
+	"github.com/ndau/ndaumath/pkg/address"
+	math "github.com/ndau/ndaumath/pkg/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryTxFields(t *testing.T) {
+	from, err := NewAccount(nil, "", 0)
+	require.NoError(t, err)
+	to, err := NewAccount(nil, "", 0)
+	require.NoError(t, err)
+
+	tx := ndau.NewTransfer(from.Address, to.Address, math.Ndau(12345), 1)
+	require.Equal(t, []address.Address{from.Address, to.Address}, txAddresses(tx))
+	require.Equal(t, math.Ndau(12345), txQty(tx))
+
+	lock := ndau.NewLock(from.Address, math.Duration(1), 1)
+	require.Equal(t, math.Ndau(0), txQty(lock))
+
+	sh := NewShell(false, offlineClient{})
+	sh.Accts.Add(&to, "dest")
+	names := sh.nicknames()
+	require.Equal(t, "dest", nameOf(to.Address, names))
+	require.Equal(t, from.Address.String(), nameOf(from.Address, names))
+}
+
+func TestHistoryOffline(t *testing.T) {
+	acct, err := NewAccount(nil, "", 0)
+	require.NoError(t, err)
+	sh := NewShell(false, offlineClient{}, History{})
+	err = sh.Exec("history " + acct.Address.String())
+	require.Equal(t, ErrOffline, errors.Cause(err))
+}
+
+// fakeHistory serves an account's history the way the node does: a page
+// holds the entries above a height, up to a limit, so it can end partway
+// through a block
+func fakeHistory(entries []search.AccountTxValueData, limit int, requests *[]uint64) historyFetcher {
+	return func(after uint64) (*search.AccountHistoryResponse, error) {
+		*requests = append(*requests, after)
+		ahr := &search.AccountHistoryResponse{}
+		for _, e := range entries {
+			if e.BlockHeight <= after {
+				continue
+			}
+			if len(ahr.Txs) == limit {
+				ahr.More = true
+				break
+			}
+			ahr.Txs = append(ahr.Txs, e)
+		}
+		return ahr, nil
+	}
+}
+
+func TestPageHistory(t *testing.T) {
+	// history isn't ordered by offset within a block
+	entries := []search.AccountTxValueData{
+		{BlockHeight: 3, TxOffset: 0},
+		{BlockHeight: 5, TxOffset: 2},
+		{BlockHeight: 5, TxOffset: 0},
+		{BlockHeight: 5, TxOffset: 1},
+		{BlockHeight: 6, TxOffset: 1},
+		{BlockHeight: 6, TxOffset: 0},
+		{BlockHeight: 8, TxOffset: 0},
+	}
+	for _, limit := range []int{4, 5, 10} {
+		t.Run(fmt.Sprintf("limit %d", limit), func(t *testing.T) {
+			var requests []uint64
+			var got []search.AccountTxValueData
+			err := pageHistory(fakeHistory(entries, limit, &requests), 0, func(vd search.AccountTxValueData) (bool, error) {
+				got = append(got, vd)
+				return true, nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, entries, got)
+		})
+	}
+
+	t.Run("after", func(t *testing.T) {
+		var requests []uint64
+		var got []search.AccountTxValueData
+		err := pageHistory(fakeHistory(entries, 4, &requests), 4, func(vd search.AccountTxValueData) (bool, error) {
+			got = append(got, vd)
+			return len(got) < 5, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, entries[1:6], got)
+		require.Equal(t, []uint64{4, 5}, requests)
+	})
+
+	t.Run("block larger than a page", func(t *testing.T) {
+		var requests []uint64
+		err := pageHistory(fakeHistory(entries, 2, &requests), 0, func(vd search.AccountTxValueData) (bool, error) {
+			return true, nil
+		})
+		require.Error(t, err)
+	})
+}
@@ -10,7 +10,11 @@ package main
 // - -- --- ---- -----
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/alexflint/go-arg"
+	"github.com/pkg/errors"
 	tmclient "github.com/tendermint/tendermint/rpc/client"
 )
 
@@ -22,12 +26,36 @@ var _ Command = (*Net)(nil)
 // Name implements Command
 func (Net) Name() string { return "net" }
 
+type netargs struct {
+	Action   string   `arg:"positional" help:"'list' to list known networks"`
+	Set      string   `help:"switch networks to this network. WARNING: this can cause inconsistent state, only do this if you know what you're doing."`
+	Num      int      `help:"node number to use when switching networks"`
+	Node     []string `arg:"--node-url,separate" help:"override the node RPC URL for a network: NET=URL"`
+	Recovery []string `arg:"--recovery-url,separate" help:"override the recovery service URL for a network: NET=URL"`
+}
+
+func (netargs) Description() string {
+	return strings.TrimSpace(`
+Show or change the network to which ndsh is connected.
+
+Symbolic network names are resolved from services.json, which is read from
+the file given by --services or $NDSH_SERVICES if set, and otherwise fetched
+from the network the first time it is needed. Per-network overrides take
+precedence over services.json.
+
+Example: list known networks and their nodes:
+
+	net list
+
+Example: use a local node for testnet:
+
+	net --node-url testnet=http://localhost:26670 --set testnet
+	`)
+}
+
 // Run implements Command
 func (Net) Run(argvs []string, sh *Shell) (err error) {
-	args := struct {
-		Set string `help:"switch networks to this network. WARNING: this can cause inconsistent state, only do this if you know what you're doing."`
-		Num int    `help:"node number to use when switching networks"`
-	}{}
+	args := netargs{}
 
 	err = ParseInto(argvs, &args)
 	if err != nil {
@@ -37,16 +65,84 @@ func (Net) Run(argvs []string, sh *Shell) (err error) {
 		return
 	}
 
+	for _, spec := range args.Node {
+		err = AddNodeOverride(spec)
+		if err != nil {
+			return
+		}
+	}
+	for _, spec := range args.Recovery {
+		err = AddRecoveryOverride(spec)
+		if err != nil {
+			return
+		}
+	}
+
+	switch args.Action {
+	case "":
+	case "list", "ls":
+		listNets(sh)
+		return
+	default:
+		return fmt.Errorf("unknown net action: %s", args.Action)
+	}
+
 	if args.Set != "" {
+		if sh.Offline != nil {
+			return errors.New("cannot switch networks while offline")
+		}
 		var client tmclient.ABCIClient
 		client, err = getClient(args.Set, args.Num)
 		if err != nil {
 			return
 		}
+		// the user asked for this network explicitly, so resolve it now
+		if lc, ok := client.(*lazyClient); ok {
+			_, err = lc.Resolve()
+			if err != nil {
+				return
+			}
+		}
 		sh.Node = client
+	} else if lc, ok := sh.Node.(*lazyClient); ok {
+		_, err = lc.Resolve()
+		if err != nil {
+			return
+		}
 	}
 	// ClientURL gets updated as a side-effect of getClient
 	// so does RecoveryURL
 	sh.Write("    node: %s\nrecovery: %s", ClientURL, RecoveryURL)
 	return
 }
+
+func listNets(sh *Shell) {
+	nets, serr := knownNets()
+	sh.WriteBatch(func(print func(format string, context ...interface{})) {
+		if serr != nil {
+			print("WARN: services.json unavailable: %s", serr)
+		}
+		for _, netname := range nets {
+			print("%s", netname)
+			if u := nodeOverrides[netname]; u != nil {
+				print("    node override: %s", u)
+			}
+			if u := recoveryOverrides[netname]; u != nil {
+				print("    recovery override: %s", u)
+			}
+			names, rpcs := netNodes(netname)
+			for _, name := range names {
+				rpc := rpcs[name]
+				if rpc == nil {
+					print("    %s: (no rpc address)", name)
+					continue
+				}
+				current := ""
+				if ClientURL != nil && rpc.Host == ClientURL.Host {
+					current = " (current)"
+				}
+				print("    %s: %s%s", name, rpc, current)
+			}
+		}
+	})
+}
@@ -10,6 +10,9 @@ package main
 // - -- --- ---- -----
 
 import (
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -23,3 +26,99 @@ func TestNetSet(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "http://fake.org:1234", ClientURL.String())
 }
+
+func TestGetClientURL(t *testing.T) {
+	tests := []struct {
+		network string
+		want    string
+	}{
+		{"http://example.org:4321", "http://example.org:4321"},
+		{"example.org:4321", "http://example.org:4321"},
+		{"127.0.0.1:26657", "http://127.0.0.1:26657"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			client, err := getClient(tt.network, 0)
+			require.NoError(t, err)
+			require.NotNil(t, ClientURL)
+			require.Equal(t, tt.want, ClientURL.String())
+			_, lazy := client.(*lazyClient)
+			require.False(t, lazy)
+		})
+	}
+}
+
+const testServices = `{
+	"networks": {
+		"testnet": {
+			"nodes": {
+				"testnet-0": {"rpc": "testnet-0.example.org:26657"},
+				"testnet-1": {"rpc": "http://testnet-1.example.org:26657"}
+			}
+		}
+	},
+	"recovery": {
+		"testnet": {
+			"nodes": {
+				"testnet-0": {"api": "recovery.example.org"}
+			}
+		}
+	}
+}`
+
+func withServicesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testServices), 0600))
+	require.NoError(t, setServicesPath(path))
+	t.Cleanup(func() {
+		setServicesPath("")
+		nodeOverrides = make(map[string]*url.URL)
+		recoveryOverrides = make(map[string]*url.URL)
+	})
+}
+
+func TestLocalServicesFile(t *testing.T) {
+	withServicesFile(t)
+	client, err := getClient("test", 1)
+	require.NoError(t, err)
+	// resolution is deferred until first use
+	require.Nil(t, ClientURL)
+
+	_, err = client.(*lazyClient).Resolve()
+	require.NoError(t, err)
+	require.Equal(t, "http://testnet-1.example.org:26657", ClientURL.String())
+	require.Equal(t, "https://recovery.example.org", RecoveryURL.String())
+}
+
+func TestUnknownNodeInServicesFile(t *testing.T) {
+	withServicesFile(t)
+	client, err := getClient("testnet", 5)
+	require.NoError(t, err)
+	_, err = client.(*lazyClient).Resolve()
+	require.Error(t, err)
+}
+
+func TestNetOverrides(t *testing.T) {
+	withServicesFile(t)
+	// point the services file somewhere unreadable: overrides must not need it
+	require.NoError(t, setServicesPath(filepath.Join(t.TempDir(), "missing.json")))
+
+	client, err := getClient("http://example.org:4321", 0)
+	require.NoError(t, err)
+	sh := NewShell(true, client, Net{})
+	err = sh.Exec("net --node-url main=http://node.local:26657 --recovery-url mainnet=http://recovery.local --set main")
+	require.NoError(t, err)
+	require.Equal(t, "http://node.local:26657", ClientURL.String())
+	require.Equal(t, "http://recovery.local", RecoveryURL.String())
+}
+
+func TestNetList(t *testing.T) {
+	withServicesFile(t)
+	nets, err := knownNets()
+	require.NoError(t, err)
+	require.Equal(t, []string{"localnet", "testnet"}, nets)
+
+	names, rpcs := netNodes("testnet")
+	require.Equal(t, []string{"testnet-0", "testnet-1"}, names)
+	require.Equal(t, "https://testnet-0.example.org:26657", rpcs["testnet-0"].String())
+}
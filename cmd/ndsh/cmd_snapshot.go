@@ -0,0 +1,104 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/pkg/errors"
+)
+
+// SnapshotCmd exports account state for use in offline mode
+type SnapshotCmd struct{}
+
+var _ Command = (*SnapshotCmd)(nil)
+
+// Name implements Command
+func (SnapshotCmd) Name() string { return "snapshot" }
+
+type snapshotargs struct {
+	Path     string   `arg:"positional,required" help:"write the snapshot to this file"`
+	Accounts []string `arg:"positional" help:"snapshot only these accounts (default: all known accounts)"`
+}
+
+func (snapshotargs) Description() string {
+	return strings.TrimSpace(`
+Export current account state to a snapshot file for use in offline mode.
+
+Each account is updated from the blockchain before being written. Accounts
+which do not yet exist are omitted.
+
+Example: on an online machine, recover accounts and snapshot them:
+
+	recover eye eye eye eye eye eye eye eye eye eye eye eye
+	snapshot accts.json
+
+Then, on an offline machine, start ndsh with that snapshot:
+
+	ndsh --offline accts.json --tx-dir signed/
+	`)
+}
+
+// Run implements Command
+func (SnapshotCmd) Run(argvs []string, sh *Shell) (err error) {
+	args := snapshotargs{}
+
+	err = ParseInto(argvs, &args)
+	if err != nil {
+		if err == arg.ErrHelp || err == arg.ErrVersion {
+			err = nil
+		}
+		return
+	}
+
+	if sh.Offline != nil {
+		return errors.New("cannot take a snapshot while offline")
+	}
+
+	var accts []*Account
+	if len(args.Accounts) > 0 {
+		for _, name := range args.Accounts {
+			var acct *Account
+			acct, err = sh.Accts.Get(name)
+			if err != nil {
+				return
+			}
+			accts = append(accts, acct)
+		}
+	} else {
+		for acct := range sh.Accts.Reverse() {
+			accts = append(accts, acct)
+		}
+	}
+
+	snap := NewSnapshot()
+	if ClientURL != nil {
+		snap.Network = ClientURL.String()
+	}
+	for _, acct := range accts {
+		err = acct.Update(sh, sh.Write)
+		if IsAccountDoesNotExist(err) {
+			sh.VWrite("skipping %s: does not exist", acct.Address)
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "updating "+acct.Address.String())
+		}
+		snap.Accounts[acct.Address.String()] = acct.Data
+	}
+
+	err = snap.Save(args.Path)
+	if err != nil {
+		return
+	}
+	sh.Write("wrote %d accounts to %s", len(snap.Accounts), args.Path)
+	return
+}
@@ -87,7 +87,8 @@ func (TransferAndLock) Run(argvs []string, sh *Shell) (err error) {
 		return
 	}
 
-	if to != nil {
+	// offline, the destination's state can't have changed
+	if to != nil && sh.Offline == nil {
 		err = to.Update(sh, sh.Write)
 	}
 	return
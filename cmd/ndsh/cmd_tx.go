@@ -13,6 +13,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"strings"
 
 	"github.com/sirupsen/logrus"
@@ -37,6 +38,8 @@ func (Tx) Name() string { return "tx" }
 type txargs struct {
 	Name          string                 `arg:"-n" help:"with -j, name of tx to stage"`
 	FromJSON      string                 `arg:"-j,--json" help:"with -n, stage a tx based on this JSON data"`
+	Import        string                 `help:"stage a tx from this JSON file. -n is inferred from the file name if not set"`
+	Export        string                 `help:"write the staged tx as JSON to this file"`
 	Account       string                 `arg:"-a" help:"associate the staged tx with this account"`
 	Sequence      uint                   `arg:"-s" help:"set tx sequence to this value and re-sign with account keys"`
 	OverrideKey   string                 `arg:"-k,--key" help:"with -v, override this key to that value"`
@@ -47,6 +50,7 @@ type txargs struct {
 	SignableBytes bool                   `arg:"-b,--signable-bytes" help:"print the base64 signable bytes of this tx and return"`
 	Clear         bool                   `arg:"-C" help:"clear the staged tx"`
 	Prevalidate   bool                   `arg:"-p" help:"prevalidate the tx"`
+	Simulate      bool                   `help:"prevalidate the tx and report its fee, SIB, and effects on balances and locks"`
 	Send          bool                   `help:"send this tx to the blockchain"`
 	JQ            string                 `help:"filter output json by this jq expression"`
 }
@@ -57,6 +61,16 @@ Manipulate a staged Tx.
 
 -n, -j, and -a are intended to work together to construct a tx from scratch.
 -a is optional, but -n and -j must be specified together if at all.
+
+--import stages a tx from a file such as those written in offline mode, and
+--export writes the staged tx to a file. Either file can be submitted with
+` + "`ndau send TXNAME PATH`" + `.
+
+--simulate shows the exact fee and SIB the tx would be charged, and the
+resulting balances of its source and destination, without sending it.
+
+In offline mode, --send writes the signed tx to the tx dir instead of
+sending it to the blockchain.
 	`)
 }
 
@@ -72,6 +86,21 @@ func (Tx) Run(argvs []string, sh *Shell) (err error) {
 		return
 	}
 
+	if args.Import != "" {
+		if args.FromJSON != "" {
+			return errors.New("--import and -j are mutually exclusive")
+		}
+		var data []byte
+		data, err = ioutil.ReadFile(args.Import)
+		if err != nil {
+			return errors.Wrap(err, "reading tx file")
+		}
+		args.FromJSON = string(data)
+		if args.Name == "" {
+			args.Name = txNameFromPath(args.Import)
+		}
+	}
+
 	if args.Name != "" && args.FromJSON != "" {
 		if sh.Staged != nil && sh.Staged.Tx != nil {
 			return errors.New("can't overwrite existing staged tx; try --clear")
@@ -163,6 +192,20 @@ func (Tx) Run(argvs []string, sh *Shell) (err error) {
 		return
 	}
 
+	if args.Export != "" {
+		var data []byte
+		data, err = json.MarshalIndent(sh.Staged.Tx, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshaling staged tx")
+		}
+		err = ioutil.WriteFile(args.Export, data, 0600)
+		if err != nil {
+			return errors.Wrap(err, "writing staged tx")
+		}
+		sh.Write("wrote %s to %s", metatx.NameOf(sh.Staged.Tx), args.Export)
+		return
+	}
+
 	if args.Prevalidate {
 		logger := logrus.New()
 		fee, sib, _, err := tool.Prevalidate(sh.Node, sh.Staged.Tx, logger)
@@ -172,10 +215,37 @@ func (Tx) Run(argvs []string, sh *Shell) (err error) {
 		sh.Write("prevalidation estimates:\nfee: %s ndau\nsib: %s ndau", fee, sib)
 	}
 
+	if args.Simulate {
+		var sim *Simulation
+		sim, err = sh.Simulate(sh.Staged.Tx)
+		if err != nil {
+			return
+		}
+		if args.JQ == "" {
+			sim.display(sh)
+			return
+		}
+		var data []byte
+		data, err = json.MarshalIndent(sim, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshaling simulation")
+		}
+		op, err := jq.Parse(args.JQ)
+		if err != nil {
+			return errors.Wrap(err, "parsing JQ selector")
+		}
+		data, err = op.Apply(data)
+		if err != nil {
+			return errors.Wrap(err, "applying JQ selector")
+		}
+		sh.Write(string(data))
+		return nil
+	}
+
 	if args.Send {
-		_, err = tool.SendCommit(sh.Node, sh.Staged.Tx)
+		err = sh.send(sh.Staged.Tx, sh.Staged.Account)
 		if err != nil {
-			return errors.Wrap(err, "sending to blockchain")
+			return err
 		}
 		sh.Staged = nil
 		return
@@ -11,9 +11,10 @@ package main
 
 import (
 	"strings"
+	"time"
 
 	"github.com/alexflint/go-arg"
-	"github.com/ndau/ndaumath/pkg/address"
+	"github.com/pkg/errors"
 )
 
 // Watch adds foreign accounts, sometimes with nicknames
@@ -25,9 +26,14 @@ var _ Command = (*Watch)(nil)
 func (Watch) Name() string { return "watch" }
 
 type watchargs struct {
-	Address   address.Address `arg:"positional,required" help:"watch this account"`
-	Nicknames []string        `arg:"-n,separate" help:"short nicknames which can refer to this account."`
-	NewOK     bool            `arg:"-N,--new-ok" help:"don't complain if account does not yet exist on blockchain"`
+	Account   string        `arg:"positional" help:"watch this account"`
+	Nicknames []string      `arg:"-n,separate" help:"short nicknames which can refer to this account."`
+	NewOK     bool          `arg:"-N,--new-ok" help:"don't complain if account does not yet exist on blockchain"`
+	Live      bool          `arg:"-l" help:"start notifying about changes to watched accounts in the background"`
+	Interval  time.Duration `arg:"-i" help:"with -l, poll at this interval"`
+	Stop      bool          `help:"stop notifying about changes to watched accounts"`
+	Unwatch   bool          `arg:"-u" help:"stop watching this account"`
+	List      bool          `help:"list watched accounts"`
 }
 
 func (watchargs) Description() string {
@@ -35,12 +41,26 @@ func (watchargs) Description() string {
 Watch accounts for which you do not possess the private keys.
 
 This is most useful to declare short nicknames for destination accounts.
+
+Known accounts can also be watched: watching an account which is already
+known adds it to the set of watched accounts.
+
+With -l, changes to the balance, lock state, validation keys, or sequence of
+any watched account are announced in the shell as they happen. ndsh
+subscribes to tx events from the node, and falls back to polling if the node
+does not support subscriptions.
+
+Example: watch an account and start live notifications:
+
+	watch -l ndaekyty73hd56gynsswuj5q9em68tp6ed5v7tpft872hvuc -n exchange
 	`)
 }
 
 // Run implements Command
 func (Watch) Run(argvs []string, sh *Shell) (err error) {
-	args := watchargs{}
+	args := watchargs{
+		Interval: 30 * time.Second,
+	}
 
 	err = ParseInto(argvs, &args)
 	if err != nil {
@@ -50,18 +70,78 @@ func (Watch) Run(argvs []string, sh *Shell) (err error) {
 		return
 	}
 
-	a := &Account{
-		Address: args.Address,
+	if args.Stop {
+		sh.Watcher.Stop()
+		return
+	}
+
+	if args.Account != "" {
+		err = watchAccount(sh, args)
+		if err != nil {
+			return
+		}
+	}
+
+	if args.Live {
+		if args.Interval <= 0 {
+			return errors.New("interval must be positive")
+		}
+		err = sh.Watcher.Start(sh, args.Interval)
+		if err != nil {
+			return
+		}
+	}
+
+	if args.List {
+		names := sh.nicknames()
+		sh.WriteBatch(func(print func(format string, context ...interface{})) {
+			if mode := sh.Watcher.Running(); mode != "" {
+				print("live notifications: %s", mode)
+			} else {
+				print("live notifications: stopped")
+			}
+			for _, addr := range sh.Watcher.Addresses() {
+				if name := nameOf(addr, names); name != addr.String() {
+					print("  %s (%s)", addr, name)
+				} else {
+					print("  %s", addr)
+				}
+			}
+		})
+	}
+
+	if args.Account == "" && !args.Live && !args.List {
+		return errors.New("must specify an account, -l, --stop, or --list")
+	}
+	return
+}
+
+func watchAccount(sh *Shell, args watchargs) error {
+	addr, known, err := sh.AddressOf(args.Account)
+	if err != nil {
+		return err
+	}
+
+	if args.Unwatch {
+		sh.Watcher.Remove(*addr)
+		return nil
+	}
+
+	a := known
+	if a == nil {
+		a = &Account{
+			Address: *addr,
+		}
 	}
 	err = a.Update(sh, sh.Write)
 	if args.NewOK && IsAccountDoesNotExist(err) {
 		err = nil
 	}
 	if err != nil {
-		return
+		return err
 	}
 
 	sh.Accts.Add(a, args.Nicknames...)
-
-	return
+	sh.Watcher.Add(*addr, a.Data)
+	return nil
 }
@@ -16,6 +16,7 @@ import (
 
 	"github.com/alexflint/go-arg"
 	"github.com/ndau/ndau/pkg/version"
+	tmclient "github.com/tendermint/tendermint/rpc/client"
 )
 
 func bail(err string, context ...interface{}) {
@@ -40,12 +41,17 @@ const (
 )
 
 type mainargs struct {
-	Net      string `arg:"-N" help:"net to configure: ('main', 'test', 'dev', 'local', or a URL)"`
-	Node     int    `arg:"-n" help:"node number to which to connect"`
-	Verbose  bool   `arg:"-v" help:"emit additional debug data"`
-	Command  string `arg:"-c" help:"run this command"`
-	CMode    int    `arg:"-C" help:"when to exit after running a command. 0 (default): always; 1: if no err; 2: if err; 3: never"`
-	SysAccts string `arg:"--system-accts" help:"load system_accts.toml from this path"`
+	Net      string   `arg:"-N" help:"net to configure: ('main', 'test', 'dev', 'local', another network named in services.json, or a URL or host:port)"`
+	Node     int      `arg:"-n" help:"node number to which to connect"`
+	Verbose  bool     `arg:"-v" help:"emit additional debug data"`
+	Command  string   `arg:"-c" help:"run this command"`
+	CMode    int      `arg:"-C" help:"when to exit after running a command. 0 (default): always; 1: if no err; 2: if err; 3: never"`
+	SysAccts string   `arg:"--system-accts" help:"load system_accts.toml from this path"`
+	Services string   `arg:"--services,env:NDSH_SERVICES" help:"read services.json from this path instead of fetching it"`
+	NodeURL  []string `arg:"--node-url,separate" help:"override the node RPC URL for a network: NET=URL"`
+	Recovery []string `arg:"--recovery-url,separate" help:"override the recovery service URL for a network: NET=URL"`
+	Offline  string   `arg:"--offline" help:"work without a node, reading account state from this snapshot file"`
+	TxDir    string   `arg:"--tx-dir" help:"in offline mode, write signed txs into this directory"`
 }
 
 func (mainargs) Version() string {
@@ -63,8 +69,24 @@ func main() {
 
 	arg.MustParse(&args)
 
-	client, err := getClient(args.Net, args.Node)
-	check(err, "setting up connection to node")
+	err := setServicesPath(args.Services)
+	check(err, "setting services path")
+	for _, spec := range args.NodeURL {
+		check(AddNodeOverride(spec), "overriding node url")
+	}
+	for _, spec := range args.Recovery {
+		check(AddRecoveryOverride(spec), "overriding recovery url")
+	}
+
+	var client tmclient.ABCIClient = offlineClient{}
+	var offline *Offline
+	if args.Offline != "" {
+		offline, err = NewOffline(args.Offline, args.TxDir)
+		check(err, "setting up offline mode")
+	} else {
+		client, err = getClient(args.Net, args.Node)
+		check(err, "setting up connection to node")
+	}
 
 	shell := NewShell(
 		args.Verbose,
@@ -78,6 +100,7 @@ func main() {
 		Add{},
 		Watch{},
 		View{},
+		History{},
 		New{},
 		RecoverKeys{},
 		SetValidation{},
@@ -106,7 +129,9 @@ func main() {
 		ClaimNodeReward{},
 		CreditEAI{},
 		Closeout{},
+		SnapshotCmd{},
 	)
+	shell.Offline = offline
 
 	shell.VWrite("initialized shell...")
 
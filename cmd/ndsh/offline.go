@@ -0,0 +1,203 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+	metatx "github.com/ndau/metanode/pkg/meta/transaction"
+	"github.com/ndau/ndau/pkg/ndau"
+	"github.com/ndau/ndau/pkg/ndau/backing"
+	"github.com/pkg/errors"
+	cmn "github.com/tendermint/tendermint/libs/bytes"
+	"github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	ttypes "github.com/tendermint/tendermint/types"
+)
+
+// ErrOffline is returned by any operation which requires a node while the
+// shell is in offline mode
+var ErrOffline = errors.New("ndsh is offline: no node available")
+
+// A Snapshot records the state of a set of accounts at a point in time
+//
+// It is written by the `snapshot` command on an online machine, and read
+// in offline mode so that txs can be constructed without a node.
+type Snapshot struct {
+	Network  string                          `json:"network,omitempty"`
+	Taken    time.Time                       `json:"taken"`
+	Accounts map[string]*backing.AccountData `json:"accounts"`
+}
+
+// NewSnapshot creates an empty Snapshot
+func NewSnapshot() *Snapshot {
+	return &Snapshot{
+		Taken:    time.Now().UTC(),
+		Accounts: make(map[string]*backing.AccountData),
+	}
+}
+
+// LoadSnapshot reads a Snapshot from a JSON file
+func LoadSnapshot(path string) (*Snapshot, error) {
+	path, err := homedir.Expand(os.ExpandEnv(path))
+	if err != nil {
+		return nil, errors.Wrap(err, "expanding homedir")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading snapshot")
+	}
+	snap := NewSnapshot()
+	err = json.Unmarshal(data, snap)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshaling snapshot")
+	}
+	if snap.Accounts == nil {
+		snap.Accounts = make(map[string]*backing.AccountData)
+	}
+	return snap, nil
+}
+
+// Save writes the Snapshot to a JSON file
+func (s *Snapshot) Save(path string) error {
+	path, err := homedir.Expand(os.ExpandEnv(path))
+	if err != nil {
+		return errors.Wrap(err, "expanding homedir")
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshaling snapshot")
+	}
+	return errors.Wrap(ioutil.WriteFile(path, data, 0600), "writing snapshot")
+}
+
+// Offline manages the shell's state while it has no node connection
+type Offline struct {
+	Snapshot *Snapshot
+	TxDir    string
+}
+
+// NewOffline loads a snapshot in preparation for offline operation
+//
+// Signed txs will be written into txdir.
+func NewOffline(snapshotPath, txdir string) (*Offline, error) {
+	snap, err := LoadSnapshot(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	if txdir == "" {
+		txdir = "."
+	}
+	txdir, err = homedir.Expand(os.ExpandEnv(txdir))
+	if err != nil {
+		return nil, errors.Wrap(err, "expanding homedir")
+	}
+	err = os.MkdirAll(txdir, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating tx dir")
+	}
+	return &Offline{
+		Snapshot: snap,
+		TxDir:    txdir,
+	}, nil
+}
+
+// Emit writes a signed tx to a new file in the tx dir, returning its path
+//
+// The file contains the tx's JSON representation, so it can be submitted
+// from an online machine with `ndau send TXNAME PATH` or `tx --import PATH`.
+//
+// If signer is not nil and the tx has a sequence number, the signer's
+// sequence in the snapshot is advanced so that subsequent txs are valid.
+func (o *Offline) Emit(tx metatx.Transactable, signer *Account) (string, error) {
+	data, err := json.MarshalIndent(tx, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "marshaling tx")
+	}
+	name := metatx.NameOf(tx)
+
+	var path string
+	var f *os.File
+	for idx := 1; ; idx++ {
+		path = filepath.Join(o.TxDir, fmt.Sprintf("%03d-%s.json", idx, name))
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "creating tx file")
+		}
+		break
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	if err != nil {
+		return "", errors.Wrap(err, "writing tx file")
+	}
+
+	if s, ok := tx.(ndau.Sequencer); ok && signer != nil {
+		ad := o.Snapshot.Accounts[signer.Address.String()]
+		if ad != nil && s.GetSequence() > ad.Sequence {
+			ad.Sequence = s.GetSequence()
+		}
+	}
+	return path, nil
+}
+
+// txNameFromPath infers a tx name from a file name produced by Emit
+func txNameFromPath(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if idx := strings.Index(name, "-"); idx >= 0 {
+		name = name[idx+1:]
+	}
+	return name
+}
+
+// offlineClient is a client.ABCIClient which fails every request
+type offlineClient struct{}
+
+var _ client.ABCIClient = (*offlineClient)(nil)
+
+// ABCIInfo implements ABCIClient
+func (offlineClient) ABCIInfo() (*ctypes.ResultABCIInfo, error) {
+	return nil, ErrOffline
+}
+
+// ABCIQuery implements ABCIClient
+func (offlineClient) ABCIQuery(path string, data cmn.HexBytes) (*ctypes.ResultABCIQuery, error) {
+	return nil, ErrOffline
+}
+
+// ABCIQueryWithOptions implements ABCIClient
+func (offlineClient) ABCIQueryWithOptions(path string, data cmn.HexBytes, opts client.ABCIQueryOptions) (*ctypes.ResultABCIQuery, error) {
+	return nil, ErrOffline
+}
+
+// BroadcastTxCommit implements ABCIClient
+func (offlineClient) BroadcastTxCommit(tx ttypes.Tx) (*ctypes.ResultBroadcastTxCommit, error) {
+	return nil, ErrOffline
+}
+
+// BroadcastTxSync implements ABCIClient
+func (offlineClient) BroadcastTxSync(tx ttypes.Tx) (*ctypes.ResultBroadcastTx, error) {
+	return nil, ErrOffline
+}
+
+// BroadcastTxAsync implements ABCIClient
+func (offlineClient) BroadcastTxAsync(tx ttypes.Tx) (*ctypes.ResultBroadcastTx, error) {
+	return nil, ErrOffline
+}
@@ -0,0 +1,74 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ndau/ndau/pkg/ndau"
+	"github.com/ndau/ndau/pkg/ndau/backing"
+	"github.com/ndau/ndaumath/pkg/signature"
+	math "github.com/ndau/ndaumath/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfflineTransfer(t *testing.T) {
+	dir := t.TempDir()
+
+	from, err := NewAccount(nil, "", 0)
+	require.NoError(t, err)
+	_, pvt, err := signature.Generate(signature.Ed25519, nil)
+	require.NoError(t, err)
+	from.PrivateValidationKeys = []signature.PrivateKey{pvt}
+	to, err := NewAccount(nil, "", 0)
+	require.NoError(t, err)
+
+	snap := NewSnapshot()
+	snap.Accounts[from.Address.String()] = &backing.AccountData{
+		Balance:  10 * math.Ndau(100000000),
+		Sequence: 5,
+	}
+	snappath := filepath.Join(dir, "snapshot.json")
+	require.NoError(t, snap.Save(snappath))
+
+	offline, err := NewOffline(snappath, filepath.Join(dir, "signed"))
+	require.NoError(t, err)
+	sh := NewShell(false, offlineClient{}, Transfer{})
+	sh.Offline = offline
+	sh.Accts.Add(&from, "from")
+	sh.Accts.Add(&to, "to")
+
+	// unknown accounts don't exist offline
+	require.True(t, IsAccountDoesNotExist(to.Update(sh, nil)))
+
+	require.NoError(t, from.Update(sh, nil))
+	require.Equal(t, uint64(5), from.Data.Sequence)
+
+	require.NoError(t, sh.Exec("transfer 1 from "+to.Address.String()))
+	require.NoError(t, sh.Exec("transfer 2 from to"))
+
+	// the sequence advances in the snapshot so successive txs are valid
+	require.Equal(t, uint64(7), from.Data.Sequence)
+
+	files, err := filepath.Glob(filepath.Join(dir, "signed", "*.json"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	require.Equal(t, "Transfer", txNameFromPath(files[1]))
+
+	data, err := ioutil.ReadFile(files[1])
+	require.NoError(t, err)
+	tx := new(ndau.Transfer)
+	require.NoError(t, json.Unmarshal(data, tx))
+	require.Equal(t, uint64(7), tx.Sequence)
+	require.Len(t, tx.Signatures, 1)
+}
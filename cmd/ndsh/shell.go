@@ -39,6 +39,8 @@ type Shell struct {
 	Verbose  bool
 	Staged   *Stage
 	Accts    *Accounts
+	Offline  *Offline
+	Watcher  *Watcher
 
 	ireader     *bufio.Reader
 	writelock   sync.Mutex
@@ -56,6 +58,7 @@ func NewShell(verbose bool, node client.ABCIClient, commands ...Command) *Shell
 		Verbose:  verbose,
 		ireader:  bufio.NewReader(os.Stdin),
 		Accts:    NewAccounts(),
+		Watcher:  NewWatcher(),
 		writer:   bufio.NewWriter(os.Stdout),
 	}
 	for _, command := range commands {
@@ -299,11 +302,9 @@ func (sh *Shell) Dispatch(stage bool, tx metatx.Transactable, update, magic *Acc
 		}
 	}
 
-	sh.VWrite("sending tx with hash %s", metatx.Hash(tx))
-
-	_, err := tool.SendCommit(sh.Node, tx)
+	err := sh.send(tx, sh.Staged.Account)
 	if err != nil {
-		return errors.Wrap(err, "sending transaction")
+		return err
 	}
 
 	// clear staging after successful send
@@ -315,6 +316,25 @@ func (sh *Shell) Dispatch(stage bool, tx metatx.Transactable, update, magic *Acc
 	return err
 }
 
+// send a tx to the blockchain, or to a file when offline
+//
+// signer is the account whose keys signed the tx; it may be nil.
+func (sh *Shell) send(tx metatx.Transactable, signer *Account) error {
+	if sh.Offline != nil {
+		path, err := sh.Offline.Emit(tx, signer)
+		if err != nil {
+			return errors.Wrap(err, "writing signed tx")
+		}
+		sh.Write("offline: wrote %s to %s", metatx.NameOf(tx), path)
+		return nil
+	}
+
+	sh.VWrite("sending tx with hash %s", metatx.Hash(tx))
+
+	_, err := tool.SendCommit(sh.Node, tx)
+	return errors.Wrap(err, "sending transaction")
+}
+
 // AddressOf returns the address from an input string
 //
 // This extends sh.Accts.Get: it has all the same behavior,
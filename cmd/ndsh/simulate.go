@@ -0,0 +1,199 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	metatx "github.com/ndau/metanode/pkg/meta/transaction"
+	"github.com/ndau/ndau/pkg/ndau"
+	"github.com/ndau/ndau/pkg/tool"
+	"github.com/ndau/ndaumath/pkg/address"
+	math "github.com/ndau/ndaumath/pkg/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// BalanceChange describes the effect of a tx on one account's balance
+type BalanceChange struct {
+	Address string    `json:"address"`
+	Name    string    `json:"name,omitempty"`
+	Before  math.Ndau `json:"before"`
+	After   math.Ndau `json:"after"`
+}
+
+// Simulation describes the expected effects of a tx
+type Simulation struct {
+	TxType      string         `json:"txtype"`
+	TxHash      string         `json:"txhash"`
+	Fee         math.Ndau      `json:"fee"`
+	SIB         math.Ndau      `json:"sib"`
+	Source      *BalanceChange `json:"source,omitempty"`
+	Destination *BalanceChange `json:"destination,omitempty"`
+	Effects     []string       `json:"effects,omitempty"`
+}
+
+// txAddressField returns the address in the named field of a tx, if present
+func txAddressField(tx metatx.Transactable, name string) *address.Address {
+	v := reflect.ValueOf(tx)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	f := v.FieldByName(name)
+	if !f.IsValid() {
+		return nil
+	}
+	switch a := f.Interface().(type) {
+	case address.Address:
+		return &a
+	case *address.Address:
+		return a
+	}
+	return nil
+}
+
+// simAccount gets current account data for an address, without modifying
+// any known account
+func (sh *Shell) simAccount(addr address.Address) (*Account, error) {
+	acct := &Account{Address: addr}
+	err := acct.Update(sh, nil)
+	if IsAccountDoesNotExist(err) {
+		err = nil
+	}
+	return acct, err
+}
+
+// Simulate prevalidates a tx and computes its expected effects
+//
+// The computation is local and approximate in one respect: it does not
+// model EAI which may be credited between now and the tx's execution.
+func (sh *Shell) Simulate(tx metatx.Transactable) (*Simulation, error) {
+	fee, sib, _, err := tool.Prevalidate(sh.Node, tx, logrus.New())
+	if err != nil {
+		return nil, errors.Wrap(err, "prevalidating")
+	}
+
+	sim := &Simulation{
+		TxType: metatx.NameOf(tx),
+		TxHash: metatx.Hash(tx),
+		Fee:    fee,
+		SIB:    sib,
+	}
+	names := sh.nicknames()
+
+	qty := txQty(tx)
+	var withdrawal math.Ndau
+	if w, ok := tx.(ndau.Withdrawer); ok {
+		withdrawal = w.Withdrawal()
+	}
+
+	// the account paying the fee is the source if the tx has one, or
+	// otherwise the target
+	src := txAddressField(tx, "Source")
+	if src == nil {
+		src = txAddressField(tx, "Target")
+	}
+	if src != nil {
+		acct, err := sh.simAccount(*src)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting source account")
+		}
+		sim.Source = &BalanceChange{
+			Address: src.String(),
+			Name:    names[src.String()],
+			Before:  acct.Data.Balance,
+			After:   acct.Data.Balance - withdrawal - fee - sib,
+		}
+		if sim.Source.After < 0 {
+			sim.Effects = append(sim.Effects, "WARN: source balance would be negative")
+		}
+	}
+
+	if dst := txAddressField(tx, "Destination"); dst != nil {
+		acct, err := sh.simAccount(*dst)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting destination account")
+		}
+		sim.Destination = &BalanceChange{
+			Address: dst.String(),
+			Name:    names[dst.String()],
+			Before:  acct.Data.Balance,
+			After:   acct.Data.Balance + qty,
+		}
+		if acct.Data.Lock != nil {
+			sim.Effects = append(sim.Effects, fmt.Sprintf(
+				"destination is locked (notice period %s); received ndau will be locked too",
+				acct.Data.Lock.NoticePeriod,
+			))
+		}
+	}
+
+	now, err := math.TimestampFrom(time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "getting current timestamp")
+	}
+	switch t := tx.(type) {
+	case *ndau.Lock:
+		sim.Effects = append(sim.Effects, fmt.Sprintf(
+			"%s will be locked with notice period %s", nameOf(t.Target, names), t.Period,
+		))
+	case *ndau.Notify:
+		acct, err := sh.simAccount(t.Target)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting target account")
+		}
+		if acct.Data.Lock == nil {
+			sim.Effects = append(sim.Effects, "WARN: target is not locked")
+		} else {
+			sim.Effects = append(sim.Effects, fmt.Sprintf(
+				"%s will unlock on approximately %s",
+				nameOf(t.Target, names), now.Add(acct.Data.Lock.NoticePeriod),
+			))
+		}
+	case *ndau.TransferAndLock:
+		sim.Effects = append(sim.Effects, fmt.Sprintf(
+			"%s will be locked with notice period %s", nameOf(t.Destination, names), t.Period,
+		))
+	}
+
+	return sim, nil
+}
+
+func (sim *Simulation) display(sh *Shell) {
+	sh.WriteBatch(func(print func(format string, context ...interface{})) {
+		print("simulated %s (%s)", sim.TxType, sim.TxHash)
+		print("fee: %s ndau", sim.Fee)
+		print("sib: %s ndau", sim.SIB)
+		for _, bc := range []struct {
+			label string
+			bc    *BalanceChange
+		}{
+			{"source", sim.Source},
+			{"destination", sim.Destination},
+		} {
+			if bc.bc == nil {
+				continue
+			}
+			name := bc.bc.Address
+			if bc.bc.Name != "" {
+				name = fmt.Sprintf("%s (%s)", bc.bc.Name, bc.bc.Address)
+			}
+			print("%s %s: %s -> %s ndau", bc.label, name, bc.bc.Before, bc.bc.After)
+		}
+		for _, e := range sim.Effects {
+			print("%s", e)
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"fmt"
+	"testing"
+
+	metatx "github.com/ndau/metanode/pkg/meta/transaction"
+	"github.com/ndau/ndau/pkg/ndau"
+	"github.com/ndau/ndau/pkg/ndau/backing"
+	"github.com/ndau/ndau/pkg/query"
+	math "github.com/ndau/ndaumath/pkg/types"
+	"github.com/stretchr/testify/require"
+	abci "github.com/tendermint/tendermint/abci/types"
+	cmn "github.com/tendermint/tendermint/libs/bytes"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+)
+
+// stubNode answers the account and prevalidate queries which a simulation
+// makes; every other request fails as if offline
+type stubNode struct {
+	offlineClient
+	accounts map[string]*backing.AccountData
+	fee, sib math.Ndau
+	// if set, prevalidation fails with this log
+	invalid string
+}
+
+// ABCIQuery implements ABCIClient
+func (n stubNode) ABCIQuery(path string, data cmn.HexBytes) (*ctypes.ResultABCIQuery, error) {
+	resp := abci.ResponseQuery{}
+	switch path {
+	case query.AccountEndpoint:
+		ad, exists := n.accounts[string(data)]
+		if !exists {
+			ad = &backing.AccountData{}
+		}
+		value, err := ad.MarshalMsg(nil)
+		if err != nil {
+			return nil, err
+		}
+		resp.Value = value
+		resp.Info = fmt.Sprintf(query.AccountInfoFmt, exists)
+	case query.PrevalidateEndpoint:
+		resp.Info = fmt.Sprintf(query.PrevalidateInfoFmt, n.fee, n.sib)
+		if n.invalid != "" {
+			resp.Code = 1
+			resp.Log = n.invalid
+		}
+	default:
+		return nil, ErrOffline
+	}
+	return &ctypes.ResultABCIQuery{Response: resp}, nil
+}
+
+func TestSimulate(t *testing.T) {
+	src, err := NewAccount(nil, "", 0)
+	require.NoError(t, err)
+	dst, err := NewAccount(nil, "", 0)
+	require.NoError(t, err)
+	const ndau1 = math.Ndau(100000000)
+
+	tests := []struct {
+		name     string
+		tx       func() metatx.Transactable
+		accounts map[string]*backing.AccountData
+		invalid  string
+		want     Simulation
+		wantErr  bool
+	}{
+		{
+			name: "transfer",
+			tx:   func() metatx.Transactable { return ndau.NewTransfer(src.Address, dst.Address, 2*ndau1, 1) },
+			accounts: map[string]*backing.AccountData{
+				src.Address.String(): {Balance: 10 * ndau1},
+				dst.Address.String(): {Balance: 1 * ndau1, Lock: &backing.Lock{NoticePeriod: math.Duration(math.Day)}},
+			},
+			want: Simulation{
+				TxType:      "Transfer",
+				Fee:         1000,
+				SIB:         500,
+				Source:      &BalanceChange{Address: src.Address.String(), Name: "src", Before: 10 * ndau1, After: 8*ndau1 - 1500},
+				Destination: &BalanceChange{Address: dst.Address.String(), Before: 1 * ndau1, After: 3 * ndau1},
+				Effects: []string{fmt.Sprintf(
+					"destination is locked (notice period %s); received ndau will be locked too", math.Duration(math.Day),
+				)},
+			},
+		},
+		{
+			name: "transfer overdrawing the source to a new account",
+			tx:   func() metatx.Transactable { return ndau.NewTransfer(src.Address, dst.Address, 2*ndau1, 1) },
+			accounts: map[string]*backing.AccountData{
+				src.Address.String(): {Balance: 2 * ndau1},
+			},
+			want: Simulation{
+				TxType:      "Transfer",
+				Fee:         1000,
+				SIB:         500,
+				Source:      &BalanceChange{Address: src.Address.String(), Name: "src", Before: 2 * ndau1, After: -1500},
+				Destination: &BalanceChange{Address: dst.Address.String(), Before: 0, After: 2 * ndau1},
+				Effects:     []string{"WARN: source balance would be negative"},
+			},
+		},
+		{
+			name: "notify an unlocked account",
+			tx:   func() metatx.Transactable { return ndau.NewNotify(src.Address, 1) },
+			accounts: map[string]*backing.AccountData{
+				src.Address.String(): {Balance: 1 * ndau1},
+			},
+			want: Simulation{
+				TxType:  "Notify",
+				Fee:     1000,
+				SIB:     500,
+				Source:  &BalanceChange{Address: src.Address.String(), Name: "src", Before: 1 * ndau1, After: 1*ndau1 - 1500},
+				Effects: []string{"WARN: target is not locked"},
+			},
+		},
+		{
+			name:    "invalid",
+			tx:      func() metatx.Transactable { return ndau.NewTransfer(src.Address, dst.Address, 2*ndau1, 1) },
+			invalid: "insufficient balance",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := stubNode{accounts: tt.accounts, fee: 1000, sib: 500, invalid: tt.invalid}
+			sh := NewShell(false, node)
+			sh.Accts.Add(&src, "src")
+			sim, err := sh.Simulate(tt.tx())
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			tt.want.TxHash = sim.TxHash
+			require.Equal(t, tt.want, *sim)
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ndau/ndau/pkg/ndau/backing"
+	"github.com/ndau/ndaumath/pkg/address"
+	"github.com/pkg/errors"
+	"github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	ttypes "github.com/tendermint/tendermint/types"
+)
+
+// watchSubscriber identifies ndsh's event subscriptions to the node
+const watchSubscriber = "ndsh-watch"
+
+// Watcher notices changes to watched accounts and notifies the shell
+type Watcher struct {
+	lock     sync.Mutex
+	accounts map[string]address.Address
+	last     map[string]*backing.AccountData
+	stop     chan struct{}
+	mode     string
+
+	// subscribe subscribes to tx events from a node
+	subscribe func(client.ABCIClient) (<-chan ctypes.ResultEvent, func(), error)
+}
+
+// NewWatcher creates a new Watcher which is not yet running
+func NewWatcher() *Watcher {
+	return &Watcher{
+		accounts:  make(map[string]address.Address),
+		last:      make(map[string]*backing.AccountData),
+		subscribe: subscribeTxs,
+	}
+}
+
+// Add an address to the set of watched accounts
+func (w *Watcher) Add(addr address.Address, data *backing.AccountData) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.accounts[addr.String()] = addr
+	if data != nil {
+		// copy: the caller may continue to modify its own data
+		d := *data
+		w.last[addr.String()] = &d
+	}
+}
+
+// Remove an address from the set of watched accounts
+func (w *Watcher) Remove(addr address.Address) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.accounts, addr.String())
+	delete(w.last, addr.String())
+}
+
+// Addresses returns the watched addresses, sorted
+func (w *Watcher) Addresses() []address.Address {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	out := make([]address.Address, 0, len(w.accounts))
+	for _, a := range w.accounts {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].String() < out[j].String() })
+	return out
+}
+
+// Running returns how the watcher is running: "starting", "events",
+// "polling", or "" if it is stopped
+func (w *Watcher) Running() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.mode
+}
+
+// Start watching in the background
+//
+// If the connected node supports it, account state is checked whenever a
+// tx is committed; otherwise, it is polled at the given interval.
+func (w *Watcher) Start(sh *Shell, interval time.Duration) error {
+	w.lock.Lock()
+	if w.stop != nil {
+		w.lock.Unlock()
+		return errors.New("already watching")
+	}
+	stop := make(chan struct{})
+	w.stop = stop
+	w.mode = "starting"
+	w.lock.Unlock()
+
+	// subscribing can take a while, so don't hold the lock meanwhile
+	events, cleanup, err := w.subscribe(sh.Node)
+	mode := "events"
+	if err != nil {
+		sh.VWrite("watch: subscribing to events: %s; falling back to polling", err)
+		mode = "polling"
+	}
+	w.setMode(stop, mode)
+
+	sh.Running.Add(1)
+	go func() {
+		defer sh.Running.Done()
+		if cleanup != nil {
+			defer cleanup()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sh.Stop:
+				return
+			case <-stop:
+				return
+			case _, ok := <-events:
+				if !ok {
+					// the subscription ended; a nil channel is never ready
+					sh.VWrite("watch: event subscription closed; falling back to polling")
+					events = nil
+					w.setMode(stop, "polling")
+					continue
+				}
+				w.check(sh)
+			case <-ticker.C:
+				// even with event subscriptions, poll occasionally in case
+				// the websocket silently dropped
+				w.check(sh)
+			}
+		}
+	}()
+	return nil
+}
+
+// setMode records how the watcher is running, unless it has been stopped
+// or restarted since stop was its stop channel
+func (w *Watcher) setMode(stop chan struct{}, mode string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stop == stop {
+		w.mode = mode
+	}
+}
+
+// Stop watching in the background
+func (w *Watcher) Stop() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+		w.mode = ""
+	}
+}
+
+// subscribeTxs subscribes to tx events from the node
+//
+// The returned channel is nil on error. The cleanup function, when not
+// nil, must be called when the subscription is no longer needed. The node
+// client is shared with every other command, and can't be started again
+// once stopped, so it is left running either way.
+func subscribeTxs(node client.ABCIClient) (<-chan ctypes.ResultEvent, func(), error) {
+	if lc, ok := node.(*lazyClient); ok {
+		var err error
+		node, err = lc.Resolve()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	c, ok := node.(client.Client)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support event subscriptions", node)
+	}
+	if !c.IsRunning() {
+		err := c.Start()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "starting websocket client")
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events, err := c.Subscribe(ctx, watchSubscriber, ttypes.EventQueryTx.String())
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "subscribing")
+	}
+	return events, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c.UnsubscribeAll(ctx, watchSubscriber)
+	}, nil
+}
+
+// check every watched account for changes, notifying the shell of any
+func (w *Watcher) check(sh *Shell) {
+	names := sh.nicknames()
+	for _, addr := range w.Addresses() {
+		acct := &Account{Address: addr}
+		err := acct.Update(sh, nil)
+		if err != nil && !IsAccountDoesNotExist(err) {
+			sh.VWrite("watch: updating %s: %s", addr, err)
+			continue
+		}
+
+		w.lock.Lock()
+		prev, known := w.last[addr.String()]
+		if _, watched := w.accounts[addr.String()]; watched {
+			w.last[addr.String()] = acct.Data
+		}
+		w.lock.Unlock()
+
+		if !known {
+			continue
+		}
+		changes := accountChanges(prev, acct.Data)
+		if len(changes) == 0 {
+			continue
+		}
+		name := nameOf(addr, names)
+		sh.WriteBatch(func(print func(format string, context ...interface{})) {
+			print("")
+			print("[watch %s] %s changed:", time.Now().Format("15:04:05"), name)
+			for _, c := range changes {
+				print("    %s", c)
+			}
+		})
+	}
+}
+
+// accountChanges describes the notable differences between two account states
+func accountChanges(prev, cur *backing.AccountData) []string {
+	var changes []string
+	if prev.Balance != cur.Balance {
+		changes = append(changes, fmt.Sprintf("balance: %s -> %s", prev.Balance, cur.Balance))
+	}
+	if prev.Sequence != cur.Sequence {
+		changes = append(changes, fmt.Sprintf("sequence: %d -> %d", prev.Sequence, cur.Sequence))
+	}
+	if lockString(prev.Lock) != lockString(cur.Lock) {
+		changes = append(changes, fmt.Sprintf("lock: %s -> %s", lockString(prev.Lock), lockString(cur.Lock)))
+	}
+	if keysString(prev) != keysString(cur) {
+		changes = append(changes, fmt.Sprintf(
+			"validation keys: %d -> %d keys", len(prev.ValidationKeys), len(cur.ValidationKeys),
+		))
+	}
+	return changes
+}
+
+func lockString(l *backing.Lock) string {
+	switch {
+	case l == nil:
+		return "unlocked"
+	case l.UnlocksOn == nil:
+		return fmt.Sprintf("locked (notice %s)", l.NoticePeriod)
+	default:
+		return fmt.Sprintf("notified (unlocks %s)", l.UnlocksOn)
+	}
+}
+
+func keysString(ad *backing.AccountData) string {
+	s := ""
+	for _, k := range ad.ValidationKeys {
+		s += k.FullString() + ","
+	}
+	return s
+}
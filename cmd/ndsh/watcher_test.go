@@ -0,0 +1,166 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ndau/ndau/pkg/ndau/backing"
+	math "github.com/ndau/ndaumath/pkg/types"
+	"github.com/stretchr/testify/require"
+	cmn "github.com/tendermint/tendermint/libs/bytes"
+	"github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+)
+
+func TestAccountChanges(t *testing.T) {
+	prev := &backing.AccountData{Balance: 100, Sequence: 1}
+	cur := *prev
+	require.Empty(t, accountChanges(prev, &cur))
+
+	cur.Balance = 50
+	cur.Sequence = 2
+	cur.Lock = &backing.Lock{NoticePeriod: math.Duration(math.Day)}
+	changes := accountChanges(prev, &cur)
+	require.Len(t, changes, 3)
+	require.Contains(t, changes[2], "unlocked -> locked")
+}
+
+func TestWatcherLifecycle(t *testing.T) {
+	sh := NewShell(false, offlineClient{})
+	acct, err := NewAccount(nil, "", 0)
+	require.NoError(t, err)
+	sh.Watcher.Add(acct.Address, nil)
+	require.Len(t, sh.Watcher.Addresses(), 1)
+
+	// offline nodes can't subscribe, so this falls back to polling
+	require.NoError(t, sh.Watcher.Start(sh, time.Millisecond))
+	require.Equal(t, "polling", sh.Watcher.Running())
+	require.Error(t, sh.Watcher.Start(sh, time.Millisecond))
+
+	time.Sleep(5 * time.Millisecond)
+	sh.Watcher.Stop()
+	require.Equal(t, "", sh.Watcher.Running())
+	timeout(func() { sh.Running.Wait() }, time.Second, "watcher didn't stop")
+
+	sh.Watcher.Remove(acct.Address)
+	require.Empty(t, sh.Watcher.Addresses())
+}
+
+// countingNode counts the queries made of it, all of which fail
+type countingNode struct {
+	offlineClient
+	queries *int64
+}
+
+// ABCIQuery implements ABCIClient
+func (n countingNode) ABCIQuery(path string, data cmn.HexBytes) (*ctypes.ResultABCIQuery, error) {
+	atomic.AddInt64(n.queries, 1)
+	return nil, ErrOffline
+}
+
+func TestWatcherSubscribeWithoutLock(t *testing.T) {
+	sh := NewShell(false, offlineClient{})
+	release := make(chan struct{})
+	sh.Watcher.subscribe = func(client.ABCIClient) (<-chan ctypes.ResultEvent, func(), error) {
+		<-release
+		return nil, nil, ErrOffline
+	}
+	started := make(chan error)
+	go func() { started <- sh.Watcher.Start(sh, time.Hour) }()
+
+	// while subscribing, the watcher can still be inspected and stopped
+	timeout(func() {
+		for sh.Watcher.Running() != "starting" {
+			time.Sleep(time.Millisecond)
+		}
+	}, time.Second, "watcher didn't start subscribing")
+	timeout(sh.Watcher.Stop, time.Second, "Stop blocked on subscribing")
+	require.Equal(t, "", sh.Watcher.Running())
+
+	close(release)
+	require.NoError(t, <-started)
+	require.Equal(t, "", sh.Watcher.Running())
+	timeout(func() { sh.Running.Wait() }, time.Second, "watcher didn't stop")
+}
+
+func TestWatcherEventsClosed(t *testing.T) {
+	var queries int64
+	sh := NewShell(false, countingNode{queries: &queries})
+	acct, err := NewAccount(nil, "", 0)
+	require.NoError(t, err)
+	sh.Watcher.Add(acct.Address, nil)
+
+	events := make(chan ctypes.ResultEvent)
+	close(events)
+	sh.Watcher.subscribe = func(client.ABCIClient) (<-chan ctypes.ResultEvent, func(), error) {
+		return events, nil, nil
+	}
+	require.NoError(t, sh.Watcher.Start(sh, time.Hour))
+	timeout(func() {
+		for sh.Watcher.Running() != "polling" {
+			time.Sleep(time.Millisecond)
+		}
+	}, time.Second, "watcher didn't fall back to polling")
+
+	// a closed channel must not make the watcher check continuously
+	time.Sleep(20 * time.Millisecond)
+	require.Zero(t, atomic.LoadInt64(&queries))
+
+	sh.Watcher.Stop()
+	timeout(func() { sh.Running.Wait() }, time.Second, "watcher didn't stop")
+}
+
+// subscribingClient is a node client which records what subscribeTxs does
+// with it
+type subscribingClient struct {
+	client.Client
+	running      bool
+	stopped      bool
+	unsubscribed string
+}
+
+func (c *subscribingClient) IsRunning() bool { return c.running }
+
+func (c *subscribingClient) Start() error {
+	c.running = true
+	return nil
+}
+
+func (c *subscribingClient) Stop() error {
+	c.running = false
+	c.stopped = true
+	return nil
+}
+
+func (c *subscribingClient) Subscribe(ctx context.Context, subscriber, query string, outCapacity ...int) (<-chan ctypes.ResultEvent, error) {
+	return make(chan ctypes.ResultEvent), nil
+}
+
+func (c *subscribingClient) UnsubscribeAll(ctx context.Context, subscriber string) error {
+	c.unsubscribed = subscriber
+	return nil
+}
+
+func TestSubscribeTxsLeavesClientRunning(t *testing.T) {
+	c := &subscribingClient{}
+	events, cleanup, err := subscribeTxs(c)
+	require.NoError(t, err)
+	require.NotNil(t, events)
+	require.True(t, c.running)
+
+	cleanup()
+	require.Equal(t, watchSubscriber, c.unsubscribed)
+	require.True(t, c.running, "the shared client must stay running")
+	require.False(t, c.stopped)
+}
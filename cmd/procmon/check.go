@@ -0,0 +1,609 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+// "procmon check CONFIG" validates a config file without running anything,
+// and prints its task tree:
+//
+//     procmon check docker-procmon.toml             # problems, then the tree as text
+//     procmon check --format dot docker-procmon.toml | dot -Tpng > tasks.png
+//
+// Environment variables are resolved as procmon would resolve them, so run
+// the check with the environment the config will be run in. Errors are
+// problems that stop procmon from starting; warnings are settings that are
+// probably mistakes. The exit status is 1 if there are any errors.
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	arg "github.com/alexflint/go-arg"
+	"github.com/sirupsen/logrus"
+)
+
+// known keys of the tables in a task's config, for finding typos
+var (
+	knownSpecials = keySet("onetime", "periodic", "terminate", "shutdown", "signal",
+		"cron", "timezone", "jitter", "overlap")
+	knownRestart = keySet("policy", "delay", "maxdelay", "factor", "jitter",
+		"maxrestarts", "window", "onloop", "alert")
+	knownOutput = keySet("maxsize", "maxage", "keep", "keepfor", "compress", "prefix", "buffer")
+	knownLimits = keySet("nofile", "as", "core", "nice", "ionice")
+)
+
+func keySet(keys ...string) map[string]bool {
+	m := make(map[string]bool)
+	for _, k := range keys {
+		m[k] = true
+	}
+	return m
+}
+
+// maxLinesShown limits the lines listed for an unresolved variable
+const maxLinesShown = 5
+
+// hasUnresolved tells if any value in a map has an unresolved variable,
+// which is reported already and would make further checks misleading
+func hasUnresolved(m map[string]string) bool {
+	for _, v := range m {
+		if unresolvedVar.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// unresolvedVar matches an environment substitution that was not resolved
+var unresolvedVar = regexp.MustCompile(`\$\{?[A-Za-z0-9_]+\}?`)
+
+// problem is something wrong with a config; where is empty for errors from
+// building the tasks, which say where they are themselves
+type problem struct {
+	warning bool
+	where   string
+	msg     string
+}
+
+func (p problem) String() string {
+	level := "error"
+	if p.warning {
+		level = "warning"
+	}
+	if p.where == "" {
+		return level + ": " + p.msg
+	}
+	return level + ": " + p.where + ": " + p.msg
+}
+
+// checker collects the problems in a config
+type checker struct {
+	lines    []string // of the config file, to locate unresolved variables
+	problems []problem
+}
+
+func (c *checker) errorf(where, format string, args ...interface{}) {
+	c.problems = append(c.problems, problem{where: where, msg: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) warnf(where, format string, args ...interface{}) {
+	c.problems = append(c.problems, problem{warning: true, where: where, msg: fmt.Sprintf(format, args...)})
+}
+
+// unresolved reports the environment substitutions left in a value.
+// In task arguments they may be meant for a shell, so they are only
+// warnings there.
+func (c *checker) unresolved(where, value string, inArgs bool) {
+	seen := make(map[string]bool)
+	for _, v := range unresolvedVar.FindAllString(value, -1) {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		msg := "unresolved " + v
+		var at []string
+		for i, line := range c.lines {
+			if strings.Contains(line, v) {
+				at = append(at, fmt.Sprint(i+1))
+			}
+		}
+		if len(at) > maxLinesShown {
+			at = append(at[:maxLinesShown], "...")
+		}
+		if len(at) > 0 {
+			msg += " (line " + strings.Join(at, ", ") + ")"
+		}
+		if inArgs {
+			c.warnf(where, "%s; fine if it is meant for a shell", msg)
+		} else {
+			c.errorf(where, "%s", msg)
+		}
+	}
+}
+
+func (c *checker) unresolvedMap(where string, m map[string]string) {
+	for _, k := range sortedKeys(m) {
+		c.unresolved(where+"."+k, m[k], false)
+	}
+}
+
+func (c *checker) unknownKeys(where string, m map[string]string, known map[string]bool) {
+	for _, k := range sortedKeys(m) {
+		if !known[k] {
+			c.warnf(where, "unknown key %s", k)
+		}
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// specialsStrings converts a task's specials to strings, for checking
+func specialsStrings(specials map[string]interface{}) map[string]string {
+	m := make(map[string]string)
+	for k, v := range specials {
+		m[k] = fmt.Sprint(v)
+	}
+	return m
+}
+
+// configKind classifies a task definition as BuildTasks does
+func configKind(ct ConfigTask) string {
+	switch {
+	case parseSignal(ct.Specials["signal"]) != nil:
+		return kindSignal
+	case ct.Parent != "":
+		return kindChild
+	case ct.Specials["periodic"] != nil || ct.Specials["cron"] != nil:
+		return kindPeriodic
+	case !parseBool(ct.Specials["onetime"], false):
+		return kindMain
+	}
+	return kindOnetime
+}
+
+// checkConfig finds the problems in a loaded config. The text of the config
+// file is used to say where unresolved variables are.
+//
+// The tasks are validated by building them as procmon would, which stops at
+// the first error; the rest of the checks are for what building the tasks
+// lets through: unresolved variables, typos, and likely mistakes.
+func checkConfig(cfg Config, text string) []problem {
+	c := &checker{lines: strings.Split(text, "\n")}
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	for _, k := range sortedKeys(cfg.Env) {
+		c.unresolved("[env] "+k, cfg.Env[k], false)
+	}
+
+	c.unresolvedMap("[logger]", cfg.Logger)
+	switch cfg.Logger["output"] {
+	case "", LoggerOutputStdout, LoggerOutputStderr, LoggerOutputSuppress:
+	default:
+		c.warnf("[logger] output", "%s is not STDOUT, STDERR, or SUPPRESS; procmon's log will be discarded", cfg.Logger["output"])
+	}
+	switch cfg.Logger["format"] {
+	case "", "json", "text", "plain":
+	default:
+		c.warnf("[logger] format", "unknown format %s; json will be used", cfg.Logger["format"])
+	}
+	switch cfg.Logger["level"] {
+	case "", "info", "debug", "warn", "warning", "err", "error":
+	default:
+		c.warnf("[logger] level", "unknown level %s; info will be used", cfg.Logger["level"])
+	}
+
+	c.unresolvedMap("[control]", cfg.Control)
+	if s := cfg.Control["reloadsignal"]; s != "" && parseSignal(s) == nil {
+		c.errorf("[control] reloadsignal", "unknown signal %s", s)
+	}
+	c.unresolvedMap("[metrics]", cfg.Metrics)
+	c.unresolvedMap("[output]", cfg.Output)
+
+	for i, p := range cfg.Prologue {
+		where := fmt.Sprintf("[[prologue]] %d", i+1)
+		if p["name"] != "" {
+			where += " (" + p["name"] + ")"
+		}
+		c.unresolvedMap(where, p)
+		if hasUnresolved(p) {
+			continue
+		}
+		if _, err := BuildMonitor(p, logger); err != nil {
+			c.errorf(where, "%s", err)
+		}
+	}
+
+	// where each task is defined, to find duplicates
+	defined := make(map[string]int)
+	for i, ct := range cfg.Task {
+		if ct.Name == "" {
+			c.errorf(fmt.Sprintf("[[task]] %d", i+1), "task has no name")
+			continue
+		}
+		if _, ok := defined[ct.Name]; ok {
+			c.errorf("task "+ct.Name, "defined more than once")
+			continue
+		}
+		defined[ct.Name] = i
+	}
+
+	signals := make(map[string]string)
+	for i, ct := range cfg.Task {
+		c.checkTask(i, ct, signals)
+	}
+	c.checkCycles(cfg)
+	if _, err := cfg.buildTasks(logger, nil, true); err != nil {
+		c.errorf("", "%s", err)
+	}
+	return c.problems
+}
+
+// checkTask checks one task definition
+func (c *checker) checkTask(i int, ct ConfigTask, signals map[string]string) {
+	where := "task " + ct.Name
+	if ct.Name == "" {
+		where = fmt.Sprintf("[[task]] %d", i+1)
+	}
+
+	c.unresolved(where+" name", ct.Name, false)
+	c.unresolved(where+" path", ct.Path, false)
+	for j, a := range ct.Args {
+		c.unresolved(fmt.Sprintf("%s args[%d]", where, j), a, true)
+	}
+	c.unresolved(where+" stdout", ct.Stdout, false)
+	c.unresolved(where+" stderr", ct.Stderr, false)
+	c.unresolved(where+" parent", ct.Parent, false)
+	c.unresolved(where+" maxstartup", ct.MaxStartup, false)
+	c.unresolved(where+" maxshutdown", ct.MaxShutdown, false)
+	c.unresolved(where+" stopsignal", ct.StopSignal, false)
+	for j, h := range ct.PreStop {
+		c.unresolved(fmt.Sprintf("%s prestop[%d]", where, j), h, true)
+	}
+	specials := specialsStrings(ct.Specials)
+	c.unresolvedMap(where+" specials", specials)
+	c.unresolvedMap(where+" restart", ct.Restart)
+	c.unresolvedMap(where+" output", ct.Output)
+	c.unresolved(where+" workdir", ct.Workdir, false)
+	c.unresolved(where+" user", ct.User, false)
+	c.unresolved(where+" group", ct.Group, false)
+	c.unresolvedMap(where+" limits", ct.Limits)
+
+	if ct.Path != "" && !strings.Contains(ct.Path, "$") {
+		if _, err := exec.LookPath(ct.Path); err != nil {
+			c.warnf(where+" path", "%s is not an executable here", ct.Path)
+		}
+	}
+	for _, out := range []struct{ name, path string }{{"stdout", ct.Stdout}, {"stderr", ct.Stderr}} {
+		switch out.path {
+		case "", LoggerOutputStdout, LoggerOutputStderr, LoggerOutputSuppress:
+			continue
+		}
+		if unresolvedVar.MatchString(out.path) {
+			continue
+		}
+		if dir := filepath.Dir(out.path); !fileExists(dir) {
+			c.warnf(where+" "+out.name, "directory %s does not exist here", dir)
+		}
+	}
+
+	if len(ct.PreStop) > 0 && (ct.Path == "" || parseBool(ct.Specials["onetime"], false)) {
+		c.warnf(where+" prestop", "only a long-running task is ever stopped")
+	}
+
+	// specials
+	c.unknownKeys(where+" specials", specials, knownSpecials)
+	kind := configKind(ct)
+	onetime := parseBool(ct.Specials["onetime"], false)
+	if s, ok := ct.Specials["signal"]; ok {
+		sig := parseSignal(s)
+		switch {
+		case sig == nil:
+			c.errorf(where+" specials.signal", "unknown signal %v", s)
+		case signals[sig.String()] != "":
+			c.errorf(where+" specials.signal", "%v is already handled by task %s", s, signals[sig.String()])
+		default:
+			signals[sig.String()] = ct.Name
+		}
+	}
+	switch kind {
+	case kindSignal:
+		if ct.Parent != "" {
+			c.warnf(where, "parent is ignored for a signal task")
+		}
+		fallthrough
+	case kindPeriodic:
+		if !onetime {
+			c.warnf(where+" specials", "%s tasks should be onetime", kind)
+		}
+	case kindChild:
+		if ct.Specials["periodic"] != nil || ct.Specials["cron"] != nil {
+			c.warnf(where+" specials", "a task with a parent is never run periodically")
+		}
+	}
+
+	c.unknownKeys(where+" restart", ct.Restart, knownRestart)
+	c.unknownKeys(where+" output", ct.Output, knownOutput)
+	c.unknownKeys(where+" limits", ct.Limits, knownLimits)
+
+	if ct.Workdir != "" && !unresolvedVar.MatchString(ct.Workdir) && !fileExists(ct.Workdir) {
+		c.warnf(where+" workdir", "directory %s does not exist here", ct.Workdir)
+	}
+	// users and groups are not looked up by building the tasks
+	if !unresolvedVar.MatchString(ct.User + ct.Group) {
+		if _, err := parseCredential(ct.User, ct.Group); err != nil {
+			c.warnf(where, "%s here", err)
+		}
+	}
+
+	// monitors
+	names := make(map[string]bool)
+	for j, mon := range ct.Monitors {
+		mwhere := fmt.Sprintf("%s monitors[%d]", where, j)
+		if mon["name"] != "" {
+			mwhere += " (" + mon["name"] + ")"
+		}
+		c.unresolvedMap(mwhere, mon)
+		if mon["name"] == "" {
+			c.warnf(mwhere, "monitor has no name")
+		} else if names[mon["name"]] {
+			c.errorf(mwhere, "monitor name is used more than once")
+		}
+		names[mon["name"]] = true
+	}
+}
+
+// checkCycles finds cycles among the parent, prerun, and alert references
+// of the tasks
+func (c *checker) checkCycles(cfg Config) {
+	deps := make(map[string][]string)
+	for _, ct := range cfg.Task {
+		if ct.Parent != "" {
+			deps[ct.Name] = append(deps[ct.Name], ct.Parent)
+		}
+		deps[ct.Name] = append(deps[ct.Name], ct.Prerun...)
+		if ct.Restart["alert"] != "" {
+			deps[ct.Name] = append(deps[ct.Name], ct.Restart["alert"])
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string)
+	visit = func(name string) {
+		switch state[name] {
+		case visited:
+			return
+		case visiting:
+			for i, p := range path {
+				if p == name {
+					cycle := append(append([]string{}, path[i:]...), name)
+					c.errorf("task "+name, "dependency cycle: %s", strings.Join(cycle, " -> "))
+				}
+			}
+			return
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, d := range deps[name] {
+			visit(d)
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+	}
+	for _, ct := range cfg.Task {
+		visit(ct.Name)
+	}
+}
+
+// describe summarizes how a task is run, for the task tree
+func describe(ct ConfigTask) string {
+	var notes []string
+	switch configKind(ct) {
+	case kindSignal:
+		notes = append(notes, fmt.Sprint("on ", ct.Specials["signal"]))
+	case kindPeriodic:
+		if ct.Specials["cron"] != nil {
+			notes = append(notes, fmt.Sprintf("cron %q", ct.Specials["cron"]))
+		} else {
+			notes = append(notes, fmt.Sprintf("every %v", ct.Specials["periodic"]))
+		}
+	case kindOnetime:
+		notes = append(notes, "onetime")
+	}
+	if parseBool(ct.Specials["shutdown"], false) {
+		notes = append(notes, "shutdown")
+	}
+	if parseBool(ct.Specials["terminate"], false) {
+		notes = append(notes, "terminate")
+	}
+	if len(ct.Prerun) > 0 {
+		notes = append(notes, "prerun "+strings.Join(ct.Prerun, ", "))
+	}
+	if ct.Restart["alert"] != "" {
+		notes = append(notes, "alert "+ct.Restart["alert"])
+	}
+	if len(notes) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(notes, "; ") + ")"
+}
+
+// printTree writes the task tree as indented text
+func printTree(cfg Config, w io.Writer) {
+	defined := make(map[string]bool)
+	for _, ct := range cfg.Task {
+		defined[ct.Name] = true
+	}
+	children := make(map[string][]ConfigTask)
+	var special, orphans []ConfigTask
+	for _, ct := range cfg.Task {
+		switch configKind(ct) {
+		case kindMain:
+			children[""] = append(children[""], ct)
+		case kindChild:
+			if defined[ct.Parent] {
+				children[ct.Parent] = append(children[ct.Parent], ct)
+			} else {
+				orphans = append(orphans, ct)
+			}
+		default:
+			special = append(special, ct)
+		}
+	}
+
+	fmt.Fprintln(w, rootTaskName)
+	var walk func(parent, indent string, seen map[string]bool)
+	walk = func(parent, indent string, seen map[string]bool) {
+		kids := children[parent]
+		for i, ct := range kids {
+			branch, next := "├── ", "│   "
+			if i == len(kids)-1 {
+				branch, next = "└── ", "    "
+			}
+			fmt.Fprintln(w, indent+branch+ct.Name+describe(ct))
+			if seen[ct.Name] {
+				continue
+			}
+			seen[ct.Name] = true
+			walk(ct.Name, indent+next, seen)
+		}
+	}
+	seen := make(map[string]bool)
+	walk("", "", seen)
+	for _, ct := range cfg.Task {
+		if kind := configKind(ct); kind == kindChild && defined[ct.Parent] && !seen[ct.Name] {
+			orphans = append(orphans, ct)
+		}
+	}
+	for _, ct := range special {
+		fmt.Fprintln(w, "  "+ct.Name+describe(ct))
+	}
+	for _, ct := range orphans {
+		if defined[ct.Parent] {
+			fmt.Fprintln(w, "  "+ct.Name+" (parent "+ct.Parent+" is not reachable)")
+		} else {
+			fmt.Fprintln(w, "  "+ct.Name+" (unknown parent "+ct.Parent+")")
+		}
+	}
+}
+
+// printDot writes the task tree in the Graphviz DOT language
+func printDot(cfg Config, w io.Writer) {
+	q := func(s string) string { return fmt.Sprintf("%q", s) }
+	fmt.Fprintln(w, "digraph procmon {")
+	fmt.Fprintln(w, "    rankdir=LR;")
+	fmt.Fprintf(w, "    %s [shape=doubleoctagon];\n", q(rootTaskName))
+	for _, ct := range cfg.Task {
+		attrs := "shape=box"
+		switch configKind(ct) {
+		case kindMain:
+			fmt.Fprintf(w, "    %s -> %s;\n", q(rootTaskName), q(ct.Name))
+		case kindChild:
+			fmt.Fprintf(w, "    %s -> %s;\n", q(ct.Parent), q(ct.Name))
+		default:
+			attrs = "shape=box, style=dashed"
+		}
+		label := ct.Name + describe(ct)
+		fmt.Fprintf(w, "    %s [%s, label=%s];\n", q(ct.Name), attrs, q(label))
+		for _, pre := range ct.Prerun {
+			fmt.Fprintf(w, "    %s -> %s [style=dotted, label=\"prerun\"];\n", q(pre), q(ct.Name))
+		}
+		if alert := ct.Restart["alert"]; alert != "" {
+			fmt.Fprintf(w, "    %s -> %s [style=dashed, label=\"alert\"];\n", q(ct.Name), q(alert))
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// checkCommand implements "procmon check" and returns the exit status
+func checkCommand(argv []string) int {
+	var args struct {
+		Configfile string `arg:"positional,required" help:"the name of the .toml config file to check"`
+		Format     string `help:"how to print the task tree: text, dot, or none" default:"text"`
+	}
+	p, err := arg.NewParser(arg.Config{Program: "procmon check"}, &args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	switch err := p.Parse(argv); {
+	case err == arg.ErrHelp:
+		p.WriteHelp(os.Stdout)
+		return 0
+	case err != nil:
+		p.WriteUsage(os.Stderr)
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 2
+	}
+	switch args.Format {
+	case "text", "dot", "none":
+	default:
+		fmt.Fprintln(os.Stderr, "error: --format must be text, dot, or none")
+		return 2
+	}
+
+	text, err := ioutil.ReadFile(args.Configfile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	// unresolved variables are reported by checkConfig, with their locations
+	cfg, err := Load(args.Configfile, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	problems := checkConfig(cfg, string(text))
+	var md toml.MetaData
+	if md, err = toml.Decode(string(text), &Config{}); err == nil {
+		for _, k := range md.Undecoded() {
+			problems = append(problems, problem{warning: true, where: k.String(), msg: "unknown key"})
+		}
+	}
+
+	errs := 0
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p)
+		if !p.warning {
+			errs++
+		}
+	}
+	switch args.Format {
+	case "text":
+		printTree(cfg, os.Stdout)
+	case "dot":
+		printDot(cfg, os.Stdout)
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d errors, %d warnings\n", args.Configfile, errs, len(problems)-errs)
+	if errs > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_checkConfig(t *testing.T) {
+	text := "[env]\nURL = \"http://$HOST/\"\n"
+	tests := []struct {
+		name  string
+		tasks []ConfigTask
+		want  string
+	}{
+		{"ok", []ConfigTask{
+			{Name: "A", Path: "/bin/sh"},
+			{Name: "B", Path: "/bin/sh", Parent: "A"},
+		}, ""},
+		{"output is not opened", []ConfigTask{
+			{Name: "A", Path: "/bin/sh", Stdout: "/nonexistent/a.log"},
+		}, "warning: task A stdout: directory /nonexistent does not exist here"},
+		{"bad restart", []ConfigTask{
+			{Name: "A", Path: "/bin/sh", Restart: map[string]string{"policy": "sometimes"}},
+		}, "error: A: "},
+		{"alert not onetime", []ConfigTask{
+			{Name: "A", Path: "/bin/sh", Restart: map[string]string{"onloop": "alert", "alert": "B"}},
+			{Name: "B", Path: "/bin/sh"},
+		}, "error: A: alert task B must be a onetime task"},
+		{"unknown parent", []ConfigTask{
+			{Name: "A", Path: "/bin/sh", Parent: "X"},
+		}, "error: A: did not find parent task X defined before it"},
+		{"parent defined later", []ConfigTask{
+			{Name: "A", Path: "/bin/sh", Parent: "B"},
+			{Name: "B", Path: "/bin/sh"},
+		}, "error: A: did not find parent task B defined before it"},
+		{"cycle", []ConfigTask{
+			{Name: "A", Path: "/bin/sh", Parent: "B"},
+			{Name: "B", Path: "/bin/sh", Prerun: []string{"A"}},
+		}, "error: task A: dependency cycle: A -> B -> A"},
+		{"unresolved", []ConfigTask{
+			{Name: "A", Path: "/bin/sh", Monitors: []map[string]string{
+				{"name": "up", "type": "http", "url": "http://$HOST/"},
+			}},
+		}, "error: task A monitors[0] (up).url: unresolved $HOST (line 2)"},
+		{"unresolved arg", []ConfigTask{
+			{Name: "A", Path: "/bin/sh", Args: []string{"-c", "echo $x"}},
+		}, "warning: task A args[1]: unresolved $x; fine if it is meant for a shell"},
+		{"bad stop signal", []ConfigTask{
+			{Name: "A", Path: "/bin/sh", StopSignal: "SIGSTOP"},
+		}, "error: A: unknown stop signal SIGSTOP"},
+		{"bad monitor", []ConfigTask{
+			{Name: "A", Path: "/bin/sh", Monitors: []map[string]string{
+				{"name": "up", "type": "carrierpigeon"},
+			}},
+		}, "error: A: monitor up: unknown monitor type carrierpigeon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, p := range checkConfig(Config{Task: tt.tasks}, text) {
+				got = append(got, p.String())
+			}
+			if tt.want == "" {
+				if len(got) != 0 {
+					t.Errorf("checkConfig() = %v, want no problems", got)
+				}
+				return
+			}
+			if !strings.Contains(strings.Join(got, "\n"), tt.want) {
+				t.Errorf("checkConfig() = %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
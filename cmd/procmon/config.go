@@ -13,10 +13,12 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -38,8 +40,20 @@ const (
 type Config struct {
 	Env      map[string]string
 	Logger   map[string]string
+	Control  map[string]string
+	Metrics  map[string]string
+	Output   map[string]string
 	Prologue []map[string]string
 	Task     []ConfigTask
+
+	// time spent running each element of the prologue
+	prologueTimes []prologueTime
+}
+
+// prologueTime is the time spent running one element of the prologue
+type prologueTime struct {
+	name string
+	d    time.Duration
 }
 
 // The ConfigTask section is a map ("table") of tasks
@@ -53,8 +67,16 @@ type ConfigTask struct {
 	Parent      string
 	MaxStartup  string
 	MaxShutdown string
+	StopSignal  string
+	PreStop     []string
 	Monitors    []map[string]string
 	Prerun      []string
+	Restart     map[string]string
+	Output      map[string]string
+	Workdir     string
+	User        string
+	Group       string
+	Limits      map[string]string
 }
 
 // Tasks is the container for all the task types that get manipulated.
@@ -65,6 +87,10 @@ type Tasks struct {
 	All      map[string]*Task
 }
 
+// tasksLock guards the maps and slices of the running Tasks, which a
+// reload can change
+var tasksLock sync.RWMutex
+
 // NewTasks creates the Tasks object
 func NewTasks() Tasks {
 	return Tasks{
@@ -97,6 +123,33 @@ func parseDuration(idur interface{}, def time.Duration) (time.Duration, error) {
 	}
 }
 
+// parseSize parses a number of bytes with an optional K, M, G, or T suffix
+// (powers of 1024), or a percentage with a % suffix.
+func parseSize(size string) (bytes uint64, percent float64, err error) {
+	s := strings.TrimSpace(size)
+	if s == "" {
+		return 0, 0, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		percent, err = strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return 0, 0, errors.New("bad percentage " + size)
+		}
+		return 0, percent, nil
+	}
+	mult := uint64(1)
+	suffixes := "KMGT"
+	if i := strings.IndexByte(suffixes, strings.ToUpper(s)[len(s)-1]); i >= 0 {
+		mult = 1 << (10 * uint(i+1))
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, 0, errors.New("bad size " + size)
+	}
+	return uint64(n * float64(mult)), 0, nil
+}
+
 // parseBool turns an interface value into a bool
 func parseBool(v interface{}, def bool) bool {
 	switch b := v.(type) {
@@ -146,11 +199,19 @@ func (ct *ConfigTask) interpolate(env map[string]string) {
 	ct.Stderr = interpolate(ct.Stderr, env)
 	ct.Parent = interpolate(ct.Parent, env)
 	ct.MaxShutdown = interpolate(ct.MaxShutdown, env)
+	ct.StopSignal = interpolate(ct.StopSignal, env)
+	ct.PreStop = interpolateAll(ct.PreStop, env).([]string)
 	ct.Args = interpolateAll(ct.Args, env).([]string)
 	ct.Specials = interpolateAll(ct.Specials, env).(map[string]interface{})
 	for i := range ct.Monitors {
 		ct.Monitors[i] = interpolateAll(ct.Monitors[i], env).(map[string]string)
 	}
+	ct.Restart = interpolateAll(ct.Restart, env).(map[string]string)
+	ct.Output = interpolateAll(ct.Output, env).(map[string]string)
+	ct.Workdir = interpolate(ct.Workdir, env)
+	ct.User = interpolate(ct.User, env)
+	ct.Group = interpolate(ct.Group, env)
+	ct.Limits = interpolateAll(ct.Limits, env).(map[string]string)
 }
 
 // Load does the toml load into a config object
@@ -189,6 +250,9 @@ func Load(filename string, nocheck bool) (Config, error) {
 	// Now we can use that to interpolate the rest
 	// of the loaded configuration
 	cfg.Logger = interpolateAll(cfg.Logger, cfg.Env).(map[string]string)
+	cfg.Control = interpolateAll(cfg.Control, cfg.Env).(map[string]string)
+	cfg.Metrics = interpolateAll(cfg.Metrics, cfg.Env).(map[string]string)
+	cfg.Output = interpolateAll(cfg.Output, cfg.Env).(map[string]string)
 
 	for i := range cfg.Prologue {
 		cfg.Prologue[i] = interpolateAll(cfg.Prologue[i], cfg.Env).(map[string]string)
@@ -196,6 +260,15 @@ func Load(filename string, nocheck bool) (Config, error) {
 
 	for i := range cfg.Task {
 		cfg.Task[i].interpolate(cfg.Env)
+		// the [output] section supplies defaults for each task's output table
+		for k, v := range cfg.Output {
+			if _, ok := cfg.Task[i].Output[k]; !ok {
+				if cfg.Task[i].Output == nil {
+					cfg.Task[i].Output = make(map[string]string)
+				}
+				cfg.Task[i].Output[k] = v
+			}
+		}
 	}
 
 	return cfg, err
@@ -209,7 +282,10 @@ func (c *Config) RunPrologue(logger logrus.FieldLogger) error {
 		if err != nil {
 			return err
 		}
-		if status := pinger(); status != OK {
+		begin := time.Now()
+		status := pinger()
+		c.prologueTimes = append(c.prologueTimes, prologueTime{p["name"], time.Since(begin)})
+		if status != OK {
 			l := logger.WithField("status", status)
 			for k, v := range p {
 				l = l.WithField(k, v)
@@ -262,22 +338,108 @@ func BuildMonitor(mon map[string]string, logger logrus.FieldLogger) (func() Even
 		m := RedisPinger(mon["addr"], logger)
 		return m, nil
 	case "http":
+		if _, err := url.Parse(mon["url"]); err != nil {
+			return nil, err
+		}
 		timeout, err := parseDuration(mon["timeout"], time.Second)
 		if err != nil {
 			return nil, err
 		}
 		m := HTTPPinger(mon["url"], timeout, logger)
 		return m, nil
+	case "tendermint":
+		return buildTendermintMonitor(mon, nil, logger)
+	case "noms":
+		if mon["url"] == "" {
+			return nil, errors.New("noms requires a url parm")
+		}
+		timeout, err := parseDuration(mon["timeout"], time.Second)
+		if err != nil {
+			return nil, err
+		}
+		m := NomsPinger(mon["url"], timeout, logger)
+		return m, nil
+	case "tcp":
+		if mon["addr"] == "" {
+			return nil, errors.New("tcp requires an addr parm")
+		}
+		timeout, err := parseDuration(mon["timeout"], time.Second)
+		if err != nil {
+			return nil, err
+		}
+		m := TCPPinger(mon["addr"], mon["send"], mon["expect"], timeout, logger)
+		return m, nil
+	case "exec":
+		if mon["command"] == "" {
+			return nil, errors.New("exec requires a command parm")
+		}
+		timeout, err := parseDuration(mon["timeout"], 10*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		m := ExecPinger(mon["command"], timeout, logger)
+		return m, nil
+	case "diskfree":
+		if mon["path"] == "" {
+			return nil, errors.New("diskfree requires a path parm")
+		}
+		minBytes, minPercent, err := parseSize(mon["min"])
+		if err != nil {
+			return nil, err
+		}
+		m := DiskFree(mon["path"], minBytes, minPercent, logger)
+		return m, nil
 	default:
 		return nil, errors.New("unknown monitor type " + mon["type"])
 	}
 }
 
+// buildTendermintMonitor constructs a tendermint monitor, whose stall timer
+// restarts whenever started reports a new time, if it is given
+func buildTendermintMonitor(mon map[string]string, started func() time.Time, logger logrus.FieldLogger) (func() Eventer, error) {
+	if mon["url"] == "" {
+		mon["url"] = "http://localhost:26657"
+	}
+	timeout, err := parseDuration(mon["timeout"], time.Second)
+	if err != nil {
+		return nil, err
+	}
+	stall, err := parseDuration(mon["stall"], 0)
+	if err != nil {
+		return nil, err
+	}
+	m := TendermintPinger(mon["url"], timeout, stall, parseBool(mon["failcatchingup"], false), started, logger)
+	return m, nil
+}
+
+// buildTaskMonitor constructs a monitor of a task's own process, or
+// any of the monitors that BuildMonitor knows.
+func buildTaskMonitor(mon map[string]string, t *Task, logger logrus.FieldLogger) (func() Eventer, error) {
+	switch mon["type"] {
+	case "tendermint":
+		// each run of the task gets a full stall period
+		return buildTendermintMonitor(mon, t.Started, logger)
+	case "rss":
+		max, percent, err := parseSize(mon["max"])
+		if err != nil {
+			return nil, err
+		}
+		if max == 0 || percent != 0 {
+			return nil, errors.New("rss requires a max parm in bytes")
+		}
+		return RSSMonitor(t.monitoredPID, max, logger), nil
+	default:
+		return BuildMonitor(mon, logger)
+	}
+}
+
 // Pass in one of the LoggerOutput* contants.
 // If blank, the given default is used.
 // Otherwise, the logger output is assumed to be a file name.
 // If the HONEYCOMB_* env vars are set, then all logging goes to honeycomb.
-func fileparse(taskName, loggerOutput string, def io.Writer) (io.Writer, error) {
+// Files are opened through files, so that they are shared, and rotated
+// according to opts.
+func fileparse(taskName, loggerOutput string, def io.Writer, files outputFiles, opts OutputOptions) (io.Writer, error) {
 	if useHoneycomb {
 		// Route all output from a given task to its own honeycomb filter.
 		return newFilter(taskName), nil
@@ -293,19 +455,40 @@ func fileparse(taskName, loggerOutput string, def io.Writer) (io.Writer, error)
 	case LoggerOutputSuppress:
 		return ioutil.Discard, nil
 	default:
-		f, err := os.OpenFile(loggerOutput, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			return nil, err
-		}
-		return f, nil
+		return files.open(loggerOutput, opts)
+	}
+}
+
+// defaultRestartDelay is the initial restart delay for tasks that don't set
+// their own. DEFAULT_RESTART_DELAY sets it, in procmon's own environment or
+// else in the config's env section; like any config variable, the process
+// environment takes precedence.
+func (c *Config) defaultRestartDelay() (time.Duration, error) {
+	s := os.Getenv("DEFAULT_RESTART_DELAY")
+	if s == "" {
+		s = c.Env["DEFAULT_RESTART_DELAY"]
 	}
+	return parseDuration(s, 10*time.Second)
 }
 
 // BuildTasks constructs all the tasks from a loaded config
 // It returns an array of the tasks that need to be individually
 // started. All child tasks will be descendants of these.
-func (c *Config) BuildTasks(logger logrus.FieldLogger) (Tasks, error) {
+// Output files are opened through files, which is kept across reloads so
+// that a file is only ever open once.
+func (c *Config) BuildTasks(logger logrus.FieldLogger, files outputFiles) (Tasks, error) {
+	return c.buildTasks(logger, files, false)
+}
+
+// buildTasks implements BuildTasks. When checking, it only validates the
+// config: no output files are opened and no users or groups are looked up,
+// as the config may be meant for another machine.
+func (c *Config) buildTasks(logger logrus.FieldLogger, files outputFiles, checking bool) (Tasks, error) {
 	tasks := NewTasks()
+	defaultRestartDelay, err := c.defaultRestartDelay()
+	if err != nil {
+		return tasks, errors.Wrap(err, "DEFAULT_RESTART_DELAY")
+	}
 	// taskm := make(map[string]*Task)
 	// tasks := make([]*Task, 0)
 	for _, ct := range c.Task {
@@ -313,9 +496,9 @@ func (c *Config) BuildTasks(logger logrus.FieldLogger) (Tasks, error) {
 		t.Env = c.Getenv()
 		// set up any monitors we need
 		for _, mon := range ct.Monitors {
-			m, err := BuildMonitor(mon, logger)
+			m, err := buildTaskMonitor(mon, t, logger)
 			if err != nil {
-				return tasks, err
+				return tasks, errors.Wrap(err, t.Name+": monitor "+mon["name"])
 			}
 			switch mon["name"] {
 			case "ready":
@@ -323,44 +506,82 @@ func (c *Config) BuildTasks(logger logrus.FieldLogger) (Tasks, error) {
 			default:
 				period, err := parseDuration(mon["period"], 15*time.Second)
 				if err != nil {
-					return tasks, err
+					return tasks, errors.Wrap(err, t.Name+": monitor "+mon["name"]+": period")
+				}
+				retries, err := parseRetries(mon["retries"])
+				if err != nil {
+					return tasks, errors.Wrap(err, t.Name+": monitor "+mon["name"])
 				}
 				nm := NewFailMonitor(NewMonitor(t.Status, period, m))
+				nm.Name = mon["name"]
+				nm.Type = mon["type"]
+				nm.Retries = retries
 				t.Monitors = append(t.Monitors, nm)
 			}
 		}
 		// check for stdout/err assignments
-		stdout, err := fileparse(t.Name, ct.Stdout, os.Stdout)
+		t.Output, err = parseOutputOptions(ct.Output)
 		if err != nil {
-			return tasks, err
+			return tasks, errors.Wrap(err, t.Name)
+		}
+		if !checking {
+			stdout, err := fileparse(t.Name, ct.Stdout, os.Stdout, files, t.Output)
+			if err != nil {
+				return tasks, errors.Wrap(err, t.Name+": stdout")
+			}
+			t.Stdout = stdout
+			stderr, err := fileparse(t.Name, ct.Stderr, os.Stderr, files, t.Output)
+			if err != nil {
+				return tasks, errors.Wrap(err, t.Name+": stderr")
+			}
+			t.Stderr = stderr
 		}
-		t.Stdout = stdout
-		stderr, err := fileparse(t.Name, ct.Stderr, os.Stderr)
+
+		// resource limits and credentials
+		t.Workdir = ct.Workdir
+		t.Limits, err = parseLimits(ct.Limits)
 		if err != nil {
-			return tasks, err
+			return tasks, errors.Wrap(err, t.Name+": limits")
+		}
+		if !checking {
+			t.Credential, err = parseCredential(ct.User, ct.Group)
+			if err != nil {
+				return tasks, errors.Wrap(err, t.Name)
+			}
 		}
-		t.Stderr = stderr
 
 		// MaxStartup
 		maxstartup, err := parseDuration(ct.MaxStartup, t.MaxStartup)
 		if err != nil {
-			return tasks, err
+			return tasks, errors.Wrap(err, t.Name+": maxstartup")
 		}
 		t.MaxStartup = maxstartup
 
 		// MaxShutdown
 		maxshutdown, err := parseDuration(ct.MaxShutdown, t.MaxShutdown)
 		if err != nil {
-			return tasks, err
+			return tasks, errors.Wrap(err, t.Name+": maxshutdown")
 		}
 		t.MaxShutdown = maxshutdown
 
+		// how it is stopped
+		t.StopSignal, err = parseStopSignal(ct.StopSignal)
+		if err != nil {
+			return tasks, errors.Wrap(err, t.Name)
+		}
+		t.PreStop = ct.PreStop
+
 		// set up the logger
 		t.Logger = logger
 
+		t.Restart, err = parseRestartPolicy(ct.Restart, defaultRestartDelay)
+		if err != nil {
+			return tasks, errors.Wrap(err, t.Name)
+		}
+
 		for _, prerun := range ct.Prerun {
 			if _, ok := tasks.All[prerun]; !ok {
-				return tasks, errors.New("did not find prerun task " + prerun)
+				return tasks, errors.New(t.Name + ": did not find prerun task " + prerun + " defined before it")
 			}
 			t.Prerun = append(t.Prerun, tasks.All[prerun])
 		}
@@ -370,7 +591,22 @@ func (c *Config) BuildTasks(logger logrus.FieldLogger) (Tasks, error) {
 		t.Terminate = parseBool(ct.Specials["terminate"], false)
 		t.Shutdown = parseBool(ct.Specials["shutdown"], false)
 		if err != nil {
-			return tasks, err
+			return tasks, errors.Wrap(err, t.Name+": periodic")
+		}
+		t.Schedule, err = parseSchedule(ct.Specials)
+		if err != nil {
+			return tasks, errors.Wrap(err, t.Name+": cron")
+		}
+		if t.Schedule != nil && t.Periodic != 0 {
+			return tasks, errors.New(t.Name + ": periodic and cron cannot both be set")
+		}
+		t.Jitter, err = parseDuration(ct.Specials["jitter"], 0)
+		if err != nil {
+			return tasks, errors.Wrap(err, t.Name+": jitter")
+		}
+		t.Overlap, err = parseOverlap(ct.Specials["overlap"])
+		if err != nil {
+			return tasks, errors.Wrap(err, t.Name)
 		}
 		sig := parseSignal(ct.Specials["signal"])
 		switch {
@@ -378,10 +614,10 @@ func (c *Config) BuildTasks(logger logrus.FieldLogger) (Tasks, error) {
 			tasks.Signals[sig] = t
 		case ct.Parent != "":
 			if _, ok := tasks.All[ct.Parent]; !ok {
-				return tasks, errors.New("did not find parent task " + ct.Parent)
+				return tasks, errors.New(t.Name + ": did not find parent task " + ct.Parent + " defined before it")
 			}
 			tasks.All[ct.Parent].AddDependent(t)
-		case t.Periodic != 0:
+		case t.Periodic != 0 || t.Schedule != nil:
 			tasks.Periodic = append(tasks.Periodic, t)
 		case ct.Parent == "" && t.Onetime == false:
 			// if no parent and not a onetime task, then it's in the root set of tasks that have to
@@ -394,6 +630,22 @@ func (c *Config) BuildTasks(logger logrus.FieldLogger) (Tasks, error) {
 		tasks.All[t.Name] = t
 	}
 
+	// alert tasks may be defined after the tasks that use them
+	for _, ct := range c.Task {
+		t := tasks.All[ct.Name]
+		if t.Restart.OnLoop != CrashLoopAlert {
+			continue
+		}
+		alert, ok := tasks.All[ct.Restart["alert"]]
+		if !ok {
+			return tasks, errors.New(t.Name + ": did not find alert task " + ct.Restart["alert"])
+		}
+		if !alert.Onetime {
+			return tasks, errors.New(t.Name + ": alert task " + alert.Name + " must be a onetime task")
+		}
+		t.Restart.Alert = alert
+	}
+
 	return tasks, nil
 }
 
@@ -463,3 +715,16 @@ func (c *Config) Getenv() []string {
 	}
 	return env
 }
+
+// parseRetries parses the number of consecutive failures a monitor
+// tolerates; the default is none
+func parseRetries(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, errors.New("monitor retries must be a non-negative integer")
+	}
+	return n, nil
+}
@@ -9,7 +9,10 @@ package main
 // https://www.apache.org/licenses/LICENSE-2.0.txt
 // - -- --- ---- -----
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func Test_parseBool(t *testing.T) {
 	type args struct {
@@ -37,3 +40,63 @@ func Test_parseBool(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		bytes   uint64
+		percent float64
+		wantErr bool
+	}{
+		{"", 0, 0, false},
+		{"512", 512, 0, false},
+		{"2K", 2048, 0, false},
+		{"1.5g", 3 << 29, 0, false},
+		{"10%", 0, 10, false},
+		{"110%", 0, 0, true},
+		{"lots", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			bytes, percent, err := parseSize(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if bytes != tt.bytes || percent != tt.percent {
+				t.Errorf("parseSize() = %v, %v, want %v, %v", bytes, percent, tt.bytes, tt.percent)
+			}
+		})
+	}
+}
+
+func TestConfig_defaultRestartDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     string
+		env     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"default", "", "", 10 * time.Second, false},
+		{"process env", "", "3s", 3 * time.Second, false},
+		{"config env", "2s", "", 2 * time.Second, false},
+		{"process env wins", "2s", "3s", 3 * time.Second, false},
+		{"bad", "", "soon", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEFAULT_RESTART_DELAY", tt.env)
+			c := &Config{Env: map[string]string{}}
+			if tt.cfg != "" {
+				c.Env["DEFAULT_RESTART_DELAY"] = tt.cfg
+			}
+			got, err := c.defaultRestartDelay()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("defaultRestartDelay() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("defaultRestartDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
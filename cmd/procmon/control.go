@@ -0,0 +1,384 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+// The control API lets operators inspect and manipulate a running procmon.
+//
+// It is configured by the [control] section of the config; if that section
+// sets neither listen nor socket, there is no control API.
+//
+//     GET  /tasks               list all tasks
+//     GET  /tasks/NAME          describe one task
+//     POST /tasks/NAME/stop     stop a task and its dependents; it stays stopped
+//     POST /tasks/NAME/start    start a stopped task and its dependents
+//     POST /tasks/NAME/restart  stop a task and start it again at once
+//     POST /tasks/NAME/run      run a signal, periodic, or onetime task now
+//     POST /reload              reload the config and apply the changes
+//     POST /reload?dryrun=true  reload the config and only report the changes
+//
+// A restart through the API is not a failure: it is not subject to the
+// task's restart policy, and does not count toward its failcount or toward
+// crash-looping.
+//
+// The API is not authenticated; listen only on loopback addresses or on a
+// socket, which is created with mode 0600.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Task status strings reported by the control API
+const (
+	statusRunning = "running"
+	statusStopped = "stopped"
+	statusHeld    = "held"
+	statusLooping = "crashlooping"
+	statusSpecial = "special"
+)
+
+// MonitorStatus describes the health of a behavior monitor
+type MonitorStatus struct {
+	Name    string     `json:"name"`
+	Status  string     `json:"status"`
+	Error   string     `json:"error,omitempty"`
+	Checked *time.Time `json:"checked,omitempty"`
+}
+
+// TaskStatus describes a task for the control API
+type TaskStatus struct {
+	Name       string          `json:"name"`
+	Parent     string          `json:"parent,omitempty"`
+	PID        int             `json:"pid,omitempty"`
+	Status     string          `json:"status"`
+	FailCount  int             `json:"failcount"`
+	Uptime     string          `json:"uptime,omitempty"`
+	NextRun    *time.Time      `json:"nextrun,omitempty"`
+	Monitors   []MonitorStatus `json:"monitors,omitempty"`
+	Dependents []string        `json:"dependents,omitempty"`
+}
+
+// controller serves the control API for a task tree
+type controller struct {
+	root   *Task
+	tasks  *Tasks
+	reload func(dryrun bool) (*reloadPlan, error)
+	logger logrus.FieldLogger
+}
+
+// special tells if a task is run on demand rather than kept running
+func (c *controller) special(t *Task) bool {
+	if t.Onetime || t.Periodic != 0 || t.Schedule != nil {
+		return true
+	}
+	for _, st := range c.tasks.Signals {
+		if st == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *controller) status(t *Task) TaskStatus {
+	st := t.state()
+	ts := TaskStatus{
+		Name:      t.Name,
+		FailCount: st.failCount,
+	}
+	if t.parent != nil && t.parent != c.root {
+		ts.Parent = t.parent.Name
+	}
+	for _, ch := range t.Dependents {
+		ts.Dependents = append(ts.Dependents, ch.Name)
+	}
+
+	switch {
+	case c.special(t):
+		ts.Status = statusSpecial
+		if next := st.nextRun; !next.IsZero() {
+			ts.NextRun = &next
+		}
+	case st.crashLooping:
+		ts.Status = statusLooping
+	case st.held:
+		ts.Status = statusHeld
+	case st.running:
+		ts.Status = statusRunning
+		ts.PID = st.pid
+		if !st.started.IsZero() {
+			ts.Uptime = time.Since(st.started).Round(time.Second).String()
+		}
+	default:
+		ts.Status = statusStopped
+	}
+
+	for _, m := range t.Monitors {
+		ms := MonitorStatus{Name: m.Name, Status: "unknown"}
+		e, at := m.Health()
+		if e != nil {
+			ms.Status = e.Code().String()
+			if ee, ok := e.(ErrorEvent); ok && ee.Err != nil {
+				ms.Error = ee.Err.Error()
+			}
+			ms.Checked = &at
+		}
+		ts.Monitors = append(ts.Monitors, ms)
+	}
+	return ts
+}
+
+// stop stops a task and holds it, so that its parent does not restart it
+func (c *controller) stop(t *Task) error {
+	if !t.Running() {
+		return fmt.Errorf("%s is not running", t.Name)
+	}
+	t.setHeld(true)
+	requestStop(t)
+	return nil
+}
+
+// restart stops a task without holding it; its parent starts it again
+// at once, without consulting its restart policy
+func (c *controller) restart(t *Task) error {
+	if !t.Running() {
+		return fmt.Errorf("%s is not running", t.Name)
+	}
+	t.setRestartNow()
+	requestStop(t)
+	return nil
+}
+
+// requestStop sends Stop to a task unless a Stop is already pending
+func requestStop(t *Task) {
+	select {
+	case t.statusChan() <- Stop:
+	default:
+	}
+}
+
+// start starts a stopped task under its parent, and keeps it running
+func (c *controller) start(t *Task) error {
+	return startTask(t)
+}
+
+// startTask starts a stopped task under its parent, and starts a
+// childMonitor to keep it running
+func startTask(t *Task) error {
+	if t.Running() {
+		return fmt.Errorf("%s is already running", t.Name)
+	}
+	if t.parent == nil {
+		return fmt.Errorf("%s has no parent to run under", t.Name)
+	}
+	if !t.parent.Running() {
+		return fmt.Errorf("parent %s of %s is not running", t.parent.Name, t.Name)
+	}
+	t.setHeld(false)
+	t.resetRestarts()
+	parent := t.parent
+	parentstop := parent.stoppedChan()
+	go func() {
+		// a task which was just stopped may still be shutting down
+		t.waitShutdown()
+		t.Start(parentstop)
+		parent.childMonitor(t)
+	}()
+	return nil
+}
+
+// run triggers a special task as if by its signal or timer. A periodic
+// task is subject to its overlap policy, as when its timer triggers it.
+func (c *controller) run(t *Task) error {
+	if !c.special(t) {
+		return fmt.Errorf("%s is not a signal, periodic, or onetime task", t.Name)
+	}
+	if r := t.periodicRunner(); r != nil {
+		r.trigger()
+		return nil
+	}
+	go runfunc(t, c.root, c.tasks)()
+	return nil
+}
+
+func (c *controller) sortedTasks() []*Task {
+	tasks := make([]*Task, 0, len(c.tasks.All))
+	for _, t := range c.tasks.All {
+		tasks = append(tasks, t)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Name < tasks[j].Name })
+	return tasks
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// serveReload handles /reload, which must not hold tasksLock
+func (c *controller) serveReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+		return
+	}
+	dryrun := parseBool(r.URL.Query().Get("dryrun"), false)
+	c.logger.WithField("dryrun", dryrun).Warn("control request to reload")
+	plan, err := c.reload(dryrun)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// ServeHTTP implements http.Handler
+func (c *controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 1 && parts[0] == "reload" && c.reload != nil {
+		c.serveReload(w, r)
+		return
+	}
+
+	tasksLock.RLock()
+	defer tasksLock.RUnlock()
+	if parts[0] != "tasks" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("use GET"))
+			return
+		}
+		statuses := []TaskStatus{}
+		for _, t := range c.sortedTasks() {
+			statuses = append(statuses, c.status(t))
+		}
+		writeJSON(w, http.StatusOK, statuses)
+		return
+	}
+
+	t, ok := c.tasks.All[parts[1]]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such task: %s", parts[1]))
+		return
+	}
+
+	if len(parts) == 2 {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("use GET"))
+			return
+		}
+		writeJSON(w, http.StatusOK, c.status(t))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+		return
+	}
+	var action func(*Task) error
+	switch parts[2] {
+	case "stop":
+		action = c.stop
+	case "start":
+		action = c.start
+	case "restart":
+		action = c.restart
+	case "run":
+		action = c.run
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action: %s", parts[2]))
+		return
+	}
+	c.logger.WithField("task", t.Name).WithField("action", parts[2]).Warn("control request")
+	if err := action(t); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, c.status(t))
+}
+
+// serveControl starts the control API if the config asks for it
+func serveControl(
+	cfg map[string]string,
+	root *Task,
+	tasks *Tasks,
+	reload func(bool) (*reloadPlan, error),
+	logger logrus.FieldLogger,
+) error {
+	var ln net.Listener
+	var err error
+	switch {
+	case cfg["socket"] != "":
+		ln, err = listenUnix(cfg["socket"])
+	case cfg["listen"] != "":
+		ln, err = net.Listen("tcp", cfg["listen"])
+	default:
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "control api")
+	}
+
+	c := &controller{root: root, tasks: tasks, reload: reload, logger: logger}
+	logger.WithField("addr", ln.Addr().String()).Info("serving control api")
+	go func() {
+		err := http.Serve(ln, c)
+		logger.WithError(err).Error("control api terminated")
+	}()
+	return nil
+}
+
+// listenUnix listens on a unix socket for the control API.
+//
+// The API can stop and restart any task, so only procmon's own user may
+// connect: the socket is bound inside a fresh private directory and renamed
+// onto path once it has mode 0600.
+func listenUnix(path string) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".sock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// the socket won't be at tmp by the time it's closed
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	err = os.Chmod(tmp, 0600)
+	if err == nil {
+		os.Remove(path)
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,239 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func Test_controller(t *testing.T) {
+	root := NewTask(rootTaskName, "")
+	a := NewTask("a", "/bin/true")
+	b := NewTask("b", "/bin/true")
+	h := NewTask("h", "/bin/true")
+	h.Onetime = true
+	root.AddDependent(a)
+	a.AddDependent(b)
+	tasks := NewTasks()
+	tasks.Main = append(tasks.Main, a)
+	tasks.All["a"] = a
+	tasks.All["b"] = b
+	tasks.All["h"] = h
+	c := &controller{root: root, tasks: &tasks, logger: logrus.New()}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		code   int
+	}{
+		{"list", "GET", "/tasks", http.StatusOK},
+		{"one", "GET", "/tasks/b", http.StatusOK},
+		{"unknown task", "GET", "/tasks/zz", http.StatusNotFound},
+		{"unknown path", "GET", "/pids", http.StatusNotFound},
+		{"unknown action", "POST", "/tasks/a/pause", http.StatusNotFound},
+		{"stop needs post", "GET", "/tasks/a/stop", http.StatusMethodNotAllowed},
+		{"stop not running", "POST", "/tasks/a/stop", http.StatusConflict},
+		{"run not special", "POST", "/tasks/a/run", http.StatusConflict},
+		{"start without parent running", "POST", "/tasks/b/start", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.code {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, w.Code, tt.code, w.Body)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/tasks", nil))
+	var statuses []TaskStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &statuses); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": statusStopped, "b": statusStopped, "h": statusSpecial}
+	if len(statuses) != len(want) {
+		t.Fatalf("got %d tasks, want %d", len(statuses), len(want))
+	}
+	for _, ts := range statuses {
+		if ts.Status != want[ts.Name] {
+			t.Errorf("%s status = %s, want %s", ts.Name, ts.Status, want[ts.Name])
+		}
+	}
+	if statuses[1].Parent != "a" {
+		t.Errorf("b parent = %q, want a", statuses[1].Parent)
+	}
+}
+
+// waitStatus polls a task's status until check accepts it
+func waitStatus(t *testing.T, c *controller, name string, check func(TaskStatus) bool) TaskStatus {
+	t.Helper()
+	var ts TaskStatus
+	for i := 0; i < 200; i++ {
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, httptest.NewRequest("GET", "/tasks/"+name, nil))
+		if err := json.Unmarshal(w.Body.Bytes(), &ts); err != nil {
+			t.Fatal(err)
+		}
+		if check(ts) {
+			return ts
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	t.Fatalf("%s never reached the expected state; last %+v", name, ts)
+	return ts
+}
+
+func Test_controllerActions(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	root := NewTask(rootTaskName, "")
+	root.Logger = logger
+	root.Stopped = make(chan struct{})
+	a := NewTask("a", "/bin/sleep", "60")
+	a.Logger = logger
+	// an operator restart must not be refused by the policy
+	a.Restart.Policy = RestartNever
+	root.AddDependent(a)
+	tasks := NewTasks()
+	tasks.Main = append(tasks.Main, a)
+	tasks.All["a"] = a
+	c := &controller{root: root, tasks: &tasks, logger: logger}
+	defer func() {
+		close(root.Stopped)
+		waitStatus(t, c, "a", func(ts TaskStatus) bool { return ts.Status == statusStopped })
+	}()
+
+	post := func(action string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/a/"+action, nil))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("%s = %d: %s", action, w.Code, w.Body)
+		}
+	}
+	running := func(ts TaskStatus) bool { return ts.Status == statusRunning && ts.PID != 0 }
+
+	post("start")
+	first := waitStatus(t, c, "a", running)
+
+	post("restart")
+	second := waitStatus(t, c, "a", func(ts TaskStatus) bool { return running(ts) && ts.PID != first.PID })
+	if second.FailCount != 0 {
+		t.Errorf("failcount after restart = %d, want 0", second.FailCount)
+	}
+
+	post("stop")
+	held := waitStatus(t, c, "a", func(ts TaskStatus) bool { return ts.Status == statusHeld })
+	if held.PID != 0 {
+		t.Errorf("held task has pid %d", held.PID)
+	}
+	// a held task is not restarted by its parent
+	time.Sleep(100 * time.Millisecond)
+	waitStatus(t, c, "a", func(ts TaskStatus) bool { return ts.Status == statusHeld })
+
+	post("start")
+	third := waitStatus(t, c, "a", running)
+	if third.PID == second.PID {
+		t.Errorf("start reused pid %d", third.PID)
+	}
+}
+
+func Test_listenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	// a stale socket is replaced
+	if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %v, want 0600 socket", fi.Mode())
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func Test_controllerRunOverlap(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	tests := []struct {
+		overlap string
+		runs    int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{OverlapSkip, 1, 500 * time.Millisecond, 900 * time.Millisecond},
+		{OverlapQueue, 2, 900 * time.Millisecond, 1400 * time.Millisecond},
+		// the first run is killed as soon as the second is asked for
+		{OverlapKill, 2, 500 * time.Millisecond, 900 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.overlap, func(t *testing.T) {
+			root := NewTask(rootTaskName, "")
+			root.Logger = logger
+			p := NewTask("p", "/bin/sleep", "0.5")
+			p.Logger = logger
+			p.Onetime = true
+			p.Periodic = time.Hour
+			p.Overlap = tt.overlap
+			tasks := NewTasks()
+			tasks.Periodic = append(tasks.Periodic, p)
+			tasks.All["p"] = p
+			runner := &scheduledRunner{task: p, run: runfunc(p, root, &tasks)}
+			p.setRunner(runner)
+			c := &controller{root: root, tasks: &tasks, logger: logger}
+
+			begin := time.Now()
+			for i := 0; i < 2; i++ {
+				w := httptest.NewRecorder()
+				c.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/p/run", nil))
+				if w.Code != http.StatusAccepted {
+					t.Fatalf("run = %d: %s", w.Code, w.Body)
+				}
+				// let the first run start its process
+				for j := 0; j < 100 && p.PID() == 0; j++ {
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			runner.wait()
+			elapsed := time.Since(begin)
+
+			if runs := p.Runs().count; runs != tt.runs {
+				t.Errorf("runs = %d, want %d", runs, tt.runs)
+			}
+			if elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("runs took %v, want %v to %v", elapsed, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -25,6 +25,22 @@ const (
 	Failed  Event = iota
 )
 
+// String implements fmt.Stringer for Event
+func (e Event) String() string {
+	switch e {
+	case OK:
+		return "ok"
+	case Stop:
+		return "stop"
+	case Failing:
+		return "failing"
+	case Failed:
+		return "failed"
+	default:
+		return fmt.Sprintf("Event(%d)", int(e))
+	}
+}
+
 // Eventer is an interface for an object that is carrying an event
 type Eventer interface {
 	Code() Event
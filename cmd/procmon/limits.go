@@ -0,0 +1,341 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+// Each task can be confined so that it cannot starve the others:
+//
+//     [[task]]
+//         name = "ndauapi"
+//         path = "/bin/ndauapi"
+//         workdir = "/data/ndauapi"  # the directory it runs in
+//         user = "ndau"              # a user name or uid to run as
+//         group = "ndau"             # a group name or gid; the user's group by default
+//         [task.limits]
+//             nofile = "4096"        # open files; soft:hard, like "1024:4096", or unlimited
+//             as = "4G"              # address space, in bytes
+//             core = "0"             # core file size, in bytes
+//             nice = "10"            # scheduling priority, from -20 to 19
+//             ionice = "best-effort:7"  # idle, best-effort, or realtime, with a level from 0 to 7
+//
+// Running as another user requires procmon to run as root, as does raising
+// a hard limit or lowering the nice value. The limits and priorities are
+// applied before the task's program runs; if they cannot be applied, the
+// task fails to start. The rss monitor and ionice are supported only on
+// Linux.
+//
+// To restart a task that uses too much memory, give it an rss monitor:
+//
+//     [[task.monitors]]
+//         name = "memory"
+//         type = "rss"
+//         max = "2G"
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// rlimitInfinity is the value of an unlimited resource limit
+const rlimitInfinity = ^uint64(0)
+
+// I/O scheduling classes, as used by ionice
+const (
+	ioClassRealtime   = 1
+	ioClassBestEffort = 2
+	ioClassIdle       = 3
+)
+
+// Rlimit is a soft and hard resource limit
+type Rlimit struct {
+	Soft uint64
+	Hard uint64
+}
+
+// Limits confine the process of a task
+type Limits struct {
+	NoFile *Rlimit
+	AS     *Rlimit
+	Core   *Rlimit
+	Nice   *int
+	// IOClass is zero if the I/O priority is not set
+	IOClass int
+	IOLevel int
+}
+
+// empty tells if there are no limits to apply to the process
+func (l Limits) empty() bool {
+	return l.NoFile == nil && l.AS == nil && l.Core == nil && l.Nice == nil && l.IOClass == 0
+}
+
+// parseRlimit parses a limit of the form "soft" or "soft:hard", where each
+// is a size or "unlimited"; a single value sets both
+func parseRlimit(s string) (*Rlimit, error) {
+	if s == "" {
+		return nil, nil
+	}
+	value := func(v string) (uint64, error) {
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "unlimited", "infinity":
+			return rlimitInfinity, nil
+		}
+		n, percent, err := parseSize(v)
+		if err != nil || percent != 0 {
+			return 0, errors.New("bad limit " + v)
+		}
+		return n, nil
+	}
+	parts := strings.SplitN(s, ":", 2)
+	soft, err := value(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	hard := soft
+	if len(parts) == 2 {
+		if hard, err = value(parts[1]); err != nil {
+			return nil, err
+		}
+		if soft > hard {
+			return nil, errors.New("soft limit is above hard limit in " + s)
+		}
+	}
+	return &Rlimit{Soft: soft, Hard: hard}, nil
+}
+
+// parseIONice parses an I/O priority like "best-effort:4" or "idle"
+func parseIONice(s string) (class, level int, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	parts := strings.SplitN(s, ":", 2)
+	switch parts[0] {
+	case "realtime", "rt":
+		class = ioClassRealtime
+	case "best-effort", "be":
+		class = ioClassBestEffort
+	case "idle":
+		class = ioClassIdle
+	default:
+		return 0, 0, errors.New("ionice class must be idle, best-effort, or realtime")
+	}
+	if class != ioClassIdle {
+		level = 4
+	}
+	if len(parts) == 2 {
+		if class == ioClassIdle {
+			return 0, 0, errors.New("ionice class idle takes no level")
+		}
+		if level, err = strconv.Atoi(parts[1]); err != nil || level < 0 || level > 7 {
+			return 0, 0, errors.New("ionice level must be from 0 to 7")
+		}
+	}
+	return class, level, nil
+}
+
+// parseLimits parses the limits table of a task's config
+func parseLimits(m map[string]string) (Limits, error) {
+	var l Limits
+	var err error
+	if l.NoFile, err = parseRlimit(m["nofile"]); err != nil {
+		return l, errors.Wrap(err, "nofile")
+	}
+	if l.AS, err = parseRlimit(m["as"]); err != nil {
+		return l, errors.Wrap(err, "as")
+	}
+	if l.Core, err = parseRlimit(m["core"]); err != nil {
+		return l, errors.Wrap(err, "core")
+	}
+	if m["nice"] != "" {
+		n, err := strconv.Atoi(m["nice"])
+		if err != nil || n < -20 || n > 19 {
+			return l, errors.New("nice must be from -20 to 19")
+		}
+		l.Nice = &n
+	}
+	if l.IOClass, l.IOLevel, err = parseIONice(m["ionice"]); err != nil {
+		return l, err
+	}
+	return l, nil
+}
+
+// parseCredential finds the uid and gid to run a task as; it returns nil
+// if neither a user nor a group is set
+func parseCredential(username, groupname string) (*syscall.Credential, error) {
+	if username == "" && groupname == "" {
+		return nil, nil
+	}
+	cred := &syscall.Credential{
+		Uid: uint32(syscall.Getuid()),
+		Gid: uint32(syscall.Getgid()),
+	}
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			if u, err = user.LookupId(username); err != nil {
+				return nil, errors.New("unknown user " + username)
+			}
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, errors.Wrap(err, "uid of "+username)
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return nil, errors.Wrap(err, "gid of "+username)
+		}
+		cred.Uid, cred.Gid = uint32(uid), uint32(gid)
+	}
+	if groupname != "" {
+		g, err := user.LookupGroup(groupname)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupname); err != nil {
+				return nil, errors.New("unknown group " + groupname)
+			}
+		}
+		gid, err := strconv.ParseUint(g.Gid, 10, 32)
+		if err != nil {
+			return nil, errors.Wrap(err, "gid of "+groupname)
+		}
+		cred.Gid = uint32(gid)
+	}
+	return cred, nil
+}
+
+// limitsCommand is the hidden command procmon runs itself as to apply a
+// task's limits to its own process before replacing itself with the task
+const limitsCommand = "_limits"
+
+// limitedRun is what the limits helper is told to do: apply the limits,
+// then switch to the credential, if any
+type limitedRun struct {
+	Limits     Limits
+	Credential *syscall.Credential `json:",omitempty"`
+}
+
+// prepareCommand sets the working directory and credentials of the task's
+// command before it is started. If the task has limits, procmon starts
+// itself as the task, so that the limits are in place before the task
+// runs. The helper applies them while it still has procmon's privileges,
+// which raising a hard limit or lowering the nice value need, and only then
+// switches to the task's user and group.
+func (t *Task) prepareCommand() error {
+	t.cmd.Dir = t.Workdir
+	if t.Limits.empty() {
+		if t.Credential != nil {
+			cred := *t.Credential
+			t.cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &cred}
+		}
+		return nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "finding procmon executable")
+	}
+	enc, err := json.Marshal(limitedRun{Limits: t.Limits, Credential: t.Credential})
+	if err != nil {
+		return errors.Wrap(err, "encoding limits")
+	}
+	t.cmd.Args = append([]string{self, limitsCommand, string(enc), t.cmd.Path}, t.cmd.Args...)
+	t.cmd.Path = self
+	return nil
+}
+
+// limitedExec applies limits to the current process, switches to the
+// task's credential, and then executes the task in its place. Its arguments
+// are the encoded limitedRun, the path of the task, and the task's argv. It
+// only returns if something goes wrong.
+func limitedExec(args []string) int {
+	if len(args) < 3 {
+		fmt.Fprintln(os.Stderr, "usage: procmon "+limitsCommand+" LIMITS PATH ARGV...")
+		return 127
+	}
+	var run limitedRun
+	if err := json.Unmarshal([]byte(args[0]), &run); err != nil {
+		fmt.Fprintln(os.Stderr, "procmon: decoding limits:", err)
+		return 127
+	}
+	// nice and ionice apply to a thread, so stay on the thread that execs
+	runtime.LockOSThread()
+	if err := setLimits(run.Limits); err != nil {
+		fmt.Fprintln(os.Stderr, "procmon: applying limits:", err)
+		return 127
+	}
+	if run.Credential != nil {
+		if err := setCredential(*run.Credential); err != nil {
+			fmt.Fprintln(os.Stderr, "procmon: switching user:", err)
+			return 127
+		}
+	}
+	err := syscall.Exec(args[1], args[2:], os.Environ())
+	fmt.Fprintln(os.Stderr, "procmon: running "+args[1]+":", err)
+	return 127
+}
+
+// setCredential switches the current process to a user and group, as
+// exec.Cmd does for a SysProcAttr.Credential: the supplementary groups go
+// first, then the group, and the user last, as it gives up the privilege
+// to change the others
+func setCredential(cred syscall.Credential) error {
+	if !cred.NoSetGroups {
+		groups := make([]int, len(cred.Groups))
+		for i, g := range cred.Groups {
+			groups[i] = int(g)
+		}
+		if err := syscall.Setgroups(groups); err != nil {
+			return errors.Wrap(err, "setgroups")
+		}
+	}
+	if err := syscall.Setgid(int(cred.Gid)); err != nil {
+		return errors.Wrap(err, "setgid")
+	}
+	if err := syscall.Setuid(int(cred.Uid)); err != nil {
+		return errors.Wrap(err, "setuid")
+	}
+	return nil
+}
+
+// setLimits applies limits to the current process
+func setLimits(l Limits) error {
+	for _, r := range []struct {
+		name     string
+		resource int
+		limit    *Rlimit
+	}{
+		{"nofile", syscall.RLIMIT_NOFILE, l.NoFile},
+		{"as", syscall.RLIMIT_AS, l.AS},
+		{"core", syscall.RLIMIT_CORE, l.Core},
+	} {
+		if r.limit == nil {
+			continue
+		}
+		lim := syscall.Rlimit{Cur: r.limit.Soft, Max: r.limit.Hard}
+		if err := syscall.Setrlimit(r.resource, &lim); err != nil {
+			return errors.Wrap(err, r.name)
+		}
+	}
+	if l.Nice != nil {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, *l.Nice); err != nil {
+			return errors.Wrap(err, "nice")
+		}
+	}
+	if l.IOClass != 0 {
+		if err := setIOPriority(l.IOClass, l.IOLevel); err != nil {
+			return errors.Wrap(err, "ionice")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ioprioWhoProcess selects a single process (or thread) for ioprio_set
+const ioprioWhoProcess = 1
+
+// setIOPriority sets the I/O scheduling class and level of the calling thread
+func setIOPriority(class, level int) error {
+	prio := class<<13 | level
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, uintptr(prio))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// readRSS returns the resident set size of a process, in bytes
+func readRSS(pid int) (uint64, error) {
+	b, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/statm")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) < 2 {
+		return 0, errors.New("unexpected statm format")
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "statm")
+	}
+	return pages * uint64(os.Getpagesize()), nil
+}
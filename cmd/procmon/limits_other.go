@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import "github.com/pkg/errors"
+
+// setIOPriority sets the I/O scheduling class and level of the calling thread
+func setIOPriority(class, level int) error {
+	return errors.New("ionice is only supported on linux")
+}
+
+// readRSS returns the resident set size of a process, in bytes
+func readRSS(pid int) (uint64, error) {
+	return 0, errors.New("rss is only supported on linux")
+}
@@ -0,0 +1,113 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestMain lets the test binary stand in for procmon as the limits helper
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == limitsCommand {
+		os.Exit(limitedExec(os.Args[2:]))
+	}
+	os.Exit(m.Run())
+}
+
+func Test_parseLimits(t *testing.T) {
+	nice := 5
+	tests := []struct {
+		name    string
+		limits  map[string]string
+		want    Limits
+		wantErr bool
+	}{
+		{"empty", nil, Limits{}, false},
+		{"soft only", map[string]string{"nofile": "1024"},
+			Limits{NoFile: &Rlimit{1024, 1024}}, false},
+		{"soft and hard", map[string]string{"nofile": "1024:4096", "as": "1K:unlimited"},
+			Limits{NoFile: &Rlimit{1024, 4096}, AS: &Rlimit{1024, rlimitInfinity}}, false},
+		{"soft above hard", map[string]string{"core": "2G:1G"}, Limits{}, true},
+		{"percent", map[string]string{"as": "50%"}, Limits{}, true},
+		{"nice", map[string]string{"nice": "5"}, Limits{Nice: &nice}, false},
+		{"nice out of range", map[string]string{"nice": "20"}, Limits{}, true},
+		{"ionice", map[string]string{"ionice": "best-effort:7"},
+			Limits{IOClass: ioClassBestEffort, IOLevel: 7}, false},
+		{"ionice default level", map[string]string{"ionice": "realtime"},
+			Limits{IOClass: ioClassRealtime, IOLevel: 4}, false},
+		{"ionice idle", map[string]string{"ionice": "idle"},
+			Limits{IOClass: ioClassIdle}, false},
+		{"ionice idle level", map[string]string{"ionice": "idle:3"}, Limits{}, true},
+		{"ionice bad class", map[string]string{"ionice": "fast"}, Limits{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLimits(tt.limits)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseLimits() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLimits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTask_prepareCommand(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("switching users needs root")
+	}
+	// a negative nice value can only be set with privilege, so it must be
+	// set before switching users
+	nice := -5
+	nobody := &syscall.Credential{Uid: 65534, Gid: 65534}
+	tests := []struct {
+		name   string
+		limits Limits
+		cred   *syscall.Credential
+		want   []string
+	}{
+		{"credential only", Limits{}, nobody, []string{"65534", "65534"}},
+		{
+			"limits then credential",
+			Limits{NoFile: &Rlimit{Soft: 1024, Hard: 2048}, Nice: &nice},
+			nobody,
+			[]string{"65534", "65534", "2048", "-5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := NewTask("limited", "/bin/sh", "-c", "id -u; id -g; ulimit -Hn; nice")
+			task.Limits = tt.limits
+			task.Credential = tt.cred
+			task.cmd = exec.Command(task.Path, task.Args...)
+			if err := task.prepareCommand(); err != nil {
+				t.Fatal(err)
+			}
+			out, err := task.cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("%v: %s", err, out)
+			}
+			got := strings.Fields(string(out))
+			if len(got) > len(tt.want) {
+				got = got[:len(tt.want)]
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("output = %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
@@ -44,11 +44,13 @@ func WatchSignals(sigs map[os.Signal]func()) {
 }
 
 // loads the arguments and the configuration and returns the loaded
-// config. If the config fails to load, it does not return.
-func loadConfig() Config {
+// config, and a function that loads it again.
+// If the config fails to load, it does not return.
+func loadConfig() (Config, func() (Config, error)) {
 	var args struct {
 		Configfile string `arg:"positional" help:"the name of the .toml config file to load"`
 		NoCheck    bool   `help:"set this to disable checking that envvar substitutions are fully resolved"`
+		Schedule   int    `help:"print the next N scheduled runs of each periodic task and exit" placeholder:"N"`
 	}
 	arg.MustParse(&args)
 
@@ -60,47 +62,66 @@ func loadConfig() Config {
 			fmt.Printf("%s\n", err)
 			os.Exit(1)
 		}
-		return cfg
+		if args.Schedule > 0 {
+			if err = printSchedule(cfg, args.Schedule, os.Stdout); err != nil {
+				fmt.Printf("%s\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		return cfg, func() (Config, error) {
+			return Load(args.Configfile, args.NoCheck)
+		}
 	}
 	fmt.Println("a config file name is required!")
 	os.Exit(1)
-	return cfg
+	return cfg, nil
+}
+
+// mainTasks returns the current root tasks
+func mainTasks(tasks *Tasks) []*Task {
+	tasksLock.RLock()
+	defer tasksLock.RUnlock()
+	return append([]*Task{}, tasks.Main...)
 }
 
-// killall returns a function that kills everything by telling
-// the root tasks to kill themselves.
-func killall(mainTasks []*Task) func() {
+// killall returns a function that kills everything by killing the root
+// task, which stops the tasks in order from the leaves of the tree up.
+func killall(root *Task) func() {
 	return func() {
-		for _, t := range mainTasks {
-			t.Logger.Println("shutting down tasks by killing them")
-			t.Kill()
-		}
+		root.Logger.Println("shutting down tasks by killing them")
+		root.Kill()
 		os.Exit(0)
 	}
 }
 
 // shutdown returns a function that can shut things down more politely, kinda
-func shutdown(root *Task, mainTasks []*Task) func() {
+func shutdown(root *Task, tasks *Tasks) func() {
 	return func() {
-		root.Logger.Print("shutting down by closing Stopped channel on the root")
-		close(root.Stopped)
-		exitcode := waitForTasksToDie(root, mainTasks)
-		os.Exit(exitcode)
+		os.Exit(stopAll(root, tasks))
 	}
 }
 
+// stopAll stops all the tasks by closing the root's Stopped channel, and
+// returns the exit code once they have died
+func stopAll(root *Task, tasks *Tasks) int {
+	root.Logger.Print("shutting down by closing Stopped channel on the root")
+	close(root.stoppedChan())
+	return waitForTasksToDie(root, mainTasks(tasks))
+}
+
 // runfunc creates a function that allows us to run a task with a Signal or
 // from a timer.
 // If task.Shutdown is defined, we shut everything else down first.
 // Then we run the task, and when it is finished, we check task.Terminate.
 // If task.Terminate is defined, we call killall. Otherwise, if necessary
 // (task.Shutdown was true) we run the root task again.
-func runfunc(task, root *Task, mainTasks, periodicTasks []*Task) func() {
+func runfunc(task, root *Task, tasks *Tasks) func() {
 	return func() {
 		if task.Shutdown {
 			root.Logger.Warn("running shutdown task, temporarily stopping all tasks")
 			close(root.Stopped)
-			exitcode := waitForTasksToDie(root, mainTasks)
+			exitcode := waitForTasksToDie(root, mainTasks(tasks))
 			root.Logger.WithField("exitcode", exitcode).Debug("all tasks terminated")
 		}
 		tempstop := make(chan struct{})
@@ -108,21 +129,26 @@ func runfunc(task, root *Task, mainTasks, periodicTasks []*Task) func() {
 		close(tempstop)
 		root.Logger.Debug("finished running shutdown task")
 		if task.Terminate {
-			killall(mainTasks)()
+			killall(root)()
 		}
 		if task.Shutdown {
 			root.Logger.Debug("restarting main tasks")
-			startChildren(root, mainTasks, periodicTasks)
+			startChildren(root, tasks)
 			root.Logger.Warn("shutdown processing complete")
 		}
 	}
 }
 
 // Helper function to set up root.Stopped and start its child tasks.
-func startChildren(root *Task, mainTasks, periodicTasks []*Task) {
+func startChildren(root *Task, tasks *Tasks) {
+	root.mu.Lock()
 	root.Stopped = make(chan struct{})
+	root.mu.Unlock()
 	root.StartChildren()
-	setupPeriodic(root, mainTasks, periodicTasks)
+	tasksLock.RLock()
+	periodic := append([]*Task{}, tasks.Periodic...)
+	tasksLock.RUnlock()
+	setupPeriodic(root, tasks, periodic)
 }
 
 func waitForTasksToDie(root *Task, mainTasks []*Task) int {
@@ -162,35 +188,97 @@ func waitForTasksToDie(root *Task, mainTasks []*Task) int {
 	}
 }
 
-func setupSighandlers(root *Task, tasks Tasks) {
+// setupSighandlers returns the set of signals it handles
+func setupSighandlers(root *Task, tasks *Tasks, reloadSig os.Signal, reload func()) map[os.Signal]bool {
 	// define some default sighandlers; they can be overridden in the
 	// config file and additional ones can be defined
-	sighandlers := map[os.Signal]func(){
-		syscall.SIGTERM: killall(tasks.Main),
-		syscall.SIGINT:  shutdown(root, tasks.Main),
+	defaults := map[os.Signal]func(){
+		syscall.SIGTERM: killall(root),
+		syscall.SIGINT:  shutdown(root, tasks),
 	}
-	for sig, task := range tasks.Signals {
-		sighandlers[sig] = runfunc(task, root, tasks.Main, tasks.Periodic)
+	sighandlers := make(map[os.Signal]func())
+	handle := func(sig os.Signal) func() {
+		// the signal's task is looked up when the signal arrives,
+		// since a reload can change it
+		return func() {
+			tasksLock.RLock()
+			task := tasks.Signals[sig]
+			tasksLock.RUnlock()
+			switch {
+			case task != nil:
+				runfunc(task, root, tasks)()
+			case defaults[sig] != nil:
+				defaults[sig]()
+			default:
+				root.Logger.WithField("signal", sig).Warn("no task for signal")
+			}
+		}
+	}
+	for sig := range defaults {
+		sighandlers[sig] = handle(sig)
+	}
+	for sig := range tasks.Signals {
+		sighandlers[sig] = handle(sig)
+	}
+	if reloadSig != nil {
+		if task, ok := tasks.Signals[reloadSig]; ok {
+			root.Logger.WithField("signal", reloadSig).WithField("task", task.Name).
+				Warn("reload signal overrides task")
+		}
+		sighandlers[reloadSig] = reload
 	}
 	WatchSignals(sighandlers)
+
+	handled := make(map[os.Signal]bool)
+	for sig := range sighandlers {
+		handled[sig] = true
+	}
+	return handled
 }
 
-func setupPeriodic(root *Task, mainTasks, periodicTasks []*Task) {
-	// set up the execution of any periodic tasks
+// setupPeriodic sets up the execution of periodic tasks.
+// Each one runs until the root is stopped or until a reload makes it no
+// longer periodic; a reload can also change its period.
+func setupPeriodic(root *Task, tasks *Tasks, periodicTasks []*Task) {
+	stillPeriodic := func(t *Task) bool {
+		tasksLock.RLock()
+		defer tasksLock.RUnlock()
+		for _, pt := range tasks.Periodic {
+			if pt == t {
+				return true
+			}
+		}
+		return false
+	}
+
 	for _, t := range periodicTasks {
-		f := runfunc(t, root, mainTasks, periodicTasks)
-		dur := t.Periodic
+		t := t
+		f := runfunc(t, root, tasks)
+		runner := &scheduledRunner{task: t, run: f}
+		t.setRunner(runner)
+		// a shutdown task replaces root.Stopped, so watch the one we started with
+		stopped := root.stoppedChan()
 		logger := t.Logger
-		logger.WithField("task", root.Name).WithField("period", dur).Info("setting up periodic task")
+		logger.WithField("task", root.Name).WithField("period", t.Periodic).
+			WithField("cron", scheduleSpec(t)).Info("setting up periodic task")
 		go func() {
-			ticker := time.NewTicker(dur)
-			defer ticker.Stop()
+			timer := time.NewTimer(t.nextWait(time.Now()))
+			defer timer.Stop()
 			for {
 				select {
-				case <-ticker.C:
+				case <-timer.C:
+					if !stillPeriodic(t) {
+						logger.WithField("task", t.Name).Info("no longer a periodic task")
+						return
+					}
 					logger.WithField("task", root.Name).Info("periodic task running")
-					f()
-				case <-root.Stopped:
+					runner.trigger()
+					if t.Schedule == nil {
+						// fixed intervals count from the end of the run
+						runner.wait()
+					}
+					timer.Reset(t.nextWait(time.Now()))
+				case <-stopped:
 					return
 				}
 			}
@@ -198,8 +286,22 @@ func setupPeriodic(root *Task, mainTasks, periodicTasks []*Task) {
 	}
 }
 
+// scheduleSpec returns the cron schedule of a task, if it has one
+func scheduleSpec(t *Task) string {
+	if t.Schedule == nil {
+		return ""
+	}
+	return t.Schedule.Spec
+}
+
 func main() {
-	cfg := loadConfig()
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(checkCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == limitsCommand {
+		os.Exit(limitedExec(os.Args[2:]))
+	}
+	cfg, reload := loadConfig()
 
 	// Init honeycomb filters if applicable; no-op otherwise.
 	// Do this before building the root logger and before building tasks,
@@ -219,7 +321,8 @@ func main() {
 		logger.WithError(err).Fatal("problems running prologue")
 	}
 
-	tasks, err := cfg.BuildTasks(logger)
+	files := make(outputFiles)
+	tasks, err := cfg.BuildTasks(logger, files)
 	// if we can't read the tasks we shouldn't even continue
 	if err != nil {
 		logger.WithError(err).Fatal("aborting because task file was invalid")
@@ -228,11 +331,30 @@ func main() {
 	// now build a special task to act as the parent of the root tasks
 	root := NewTask(rootTaskName, "")
 	root.Logger = logger
+	// a crash loop can ask for everything to be stopped
+	root.Status = make(chan Eventer, 1)
 	for i := range tasks.Main {
 		root.AddDependent(tasks.Main[i])
 	}
-	startChildren(root, tasks.Main, tasks.Periodic)
-	setupSighandlers(root, tasks)
+
+	r := &reloader{load: reload, cfg: &cfg, root: root, tasks: &tasks, files: files, logger: logger}
+	reloadSig := parseSignal(cfg.Control["reloadsignal"])
+	if cfg.Control["reloadsignal"] != "" && reloadSig == nil {
+		logger.WithField("reloadsignal", cfg.Control["reloadsignal"]).Fatal("unknown reload signal")
+	}
+
+	// start the control api and metrics first, so that a bad address fails before any
+	// task is running
+	if err := serveControl(cfg.Control, root, &tasks, r.reload, logger); err != nil {
+		logger.WithError(err).Fatal("could not start control api")
+	}
+	if err := serveMetrics(&cfg, &tasks, logger); err != nil {
+		logger.WithError(err).Fatal("could not start metrics endpoint")
+	}
+	startChildren(root, &tasks)
+	r.signals = setupSighandlers(root, &tasks, reloadSig, func() {
+		r.reload(false)
+	})
 
 	// and run almost forever
 	logstatus := time.NewTicker(15 * time.Second)
@@ -243,6 +365,9 @@ func main() {
 			pids = root.CollectPIDs(pids)
 			sort.Sort(sort.IntSlice(pids))
 			logger.WithField("pids", pids).WithField("npids", len(pids)).Print("pidinfo")
+		case <-root.Status:
+			stopAll(root, &tasks)
+			os.Exit(1)
 		}
 	}
 }
@@ -0,0 +1,195 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+// Metrics are served in the Prometheus text format, as configured by the
+// [metrics] section of the config:
+//
+//     [metrics]
+//     listen = ":9100"       # required; without it there is no metrics endpoint
+//     path = "/metrics"      # the default
+//     namespace = "procmon"  # prefix for all metric names; the default
+//
+// All values are read from the task tree when the endpoint is scraped.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// sample is a single value of a metric
+type sample struct {
+	suffix string
+	labels []string // alternating names and values
+	value  float64
+}
+
+// metric is a named family of samples
+type metric struct {
+	name    string
+	help    string
+	typ     string
+	samples []sample
+}
+
+func (m *metric) add(value float64, labels ...string) {
+	m.samples = append(m.samples, sample{labels: labels, value: value})
+}
+
+func (m *metric) addSuffixed(suffix string, value float64, labels ...string) {
+	m.samples = append(m.samples, sample{suffix: suffix, labels: labels, value: value})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// write writes the metric in the Prometheus text exposition format
+func (m *metric) write(w io.Writer, namespace string) {
+	name := namespace + "_" + m.name
+	fmt.Fprintf(w, "# HELP %s %s\n", name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, m.typ)
+	for _, s := range m.samples {
+		io.WriteString(w, name+s.suffix)
+		if len(s.labels) > 0 {
+			pairs := make([]string, 0, len(s.labels)/2)
+			for i := 0; i+1 < len(s.labels); i += 2 {
+				pairs = append(pairs, s.labels[i]+`="`+labelEscaper.Replace(s.labels[i+1])+`"`)
+			}
+			io.WriteString(w, "{"+strings.Join(pairs, ",")+"}")
+		}
+		io.WriteString(w, " "+formatValue(s.value)+"\n")
+	}
+}
+
+// exporter collects metrics from a task tree
+type exporter struct {
+	namespace string
+	cfg       *Config
+	tasks     *Tasks
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// collect reads all metrics from the task tree
+func (e *exporter) collect() []*metric {
+	up := &metric{name: "task_up", typ: "gauge",
+		help: "Whether the task is running (1) or not (0)."}
+	restarts := &metric{name: "task_restarts_total", typ: "counter",
+		help: "Number of times the task has been restarted after stopping."}
+	uptime := &metric{name: "task_uptime_seconds", typ: "gauge",
+		help: "Time since the task was last started and became ready."}
+	looping := &metric{name: "task_crashlooping", typ: "gauge",
+		help: "Whether the task was stopped because it restarted too often (1) or not (0)."}
+	monfail := &metric{name: "monitor_failures_total", typ: "counter",
+		help: "Number of failures reported by a task's behavior monitor."}
+	runs := &metric{name: "task_run_duration_seconds", typ: "summary",
+		help: "Run time of periodic, signal, and onetime tasks."}
+	lastrun := &metric{name: "task_last_run_duration_seconds", typ: "gauge",
+		help: "Run time of the most recent run of a periodic, signal, or onetime task."}
+	exitcode := &metric{name: "task_last_exit_code", typ: "gauge",
+		help: "Exit code of the most recent run of a periodic, signal, or onetime task; -1 if it could not be run."}
+	prologue := &metric{name: "prologue_seconds", typ: "gauge",
+		help: "Time spent running each element of the prologue."}
+
+	tasksLock.RLock()
+	defer tasksLock.RUnlock()
+
+	names := make([]string, 0, len(e.tasks.All))
+	for name := range e.tasks.All {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t := e.tasks.All[name]
+		if t.Onetime {
+			rs := t.Runs()
+			runs.addSuffixed("_sum", rs.total.Seconds(), "task", name)
+			runs.addSuffixed("_count", float64(rs.count), "task", name)
+			if rs.count > 0 {
+				lastrun.add(rs.last.Seconds(), "task", name)
+				exitcode.add(float64(rs.exitCode), "task", name)
+			}
+			continue
+		}
+		st := t.state()
+		up.add(boolValue(st.running), "task", name)
+		restarts.add(float64(st.failCount), "task", name)
+		looping.add(boolValue(st.crashLooping), "task", name)
+		if st.running && !st.started.IsZero() {
+			uptime.add(time.Since(st.started).Seconds(), "task", name)
+		}
+		for _, m := range t.Monitors {
+			monfail.add(float64(m.Failures()), "task", name, "monitor", m.Name, "type", m.Type)
+		}
+	}
+
+	for _, p := range e.cfg.prologueTimes {
+		prologue.add(p.d.Seconds(), "name", p.name)
+	}
+
+	return []*metric{up, restarts, looping, uptime, monfail, runs, lastrun, exitcode, prologue}
+}
+
+// ServeHTTP implements http.Handler
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	bw := bufio.NewWriter(w)
+	for _, m := range e.collect() {
+		m.write(bw, e.namespace)
+	}
+	bw.Flush()
+}
+
+// serveMetrics starts the metrics endpoint if the config asks for it
+func serveMetrics(cfg *Config, tasks *Tasks, logger logrus.FieldLogger) error {
+	if cfg.Metrics["listen"] == "" {
+		return nil
+	}
+	path := cfg.Metrics["path"]
+	if path == "" {
+		path = "/metrics"
+	}
+	namespace := cfg.Metrics["namespace"]
+	if namespace == "" {
+		namespace = rootTaskName
+	}
+
+	ln, err := net.Listen("tcp", cfg.Metrics["listen"])
+	if err != nil {
+		return errors.Wrap(err, "metrics")
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, &exporter{namespace: namespace, cfg: cfg, tasks: tasks})
+	logger.WithField("addr", ln.Addr().String()).WithField("path", path).Info("serving metrics")
+	go func() {
+		err := http.Serve(ln, mux)
+		logger.WithError(err).Error("metrics endpoint terminated")
+	}()
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_metricWrite(t *testing.T) {
+	m := &metric{name: "task_up", typ: "gauge", help: "Whether the task is running."}
+	m.add(1, "task", "a")
+	m.add(0, "task", `b"\`+"\n")
+	m.addSuffixed("_count", 3, "task", "c", "type", "http")
+
+	sb := strings.Builder{}
+	m.write(&sb, "procmon")
+	want := `# HELP procmon_task_up Whether the task is running.
+# TYPE procmon_task_up gauge
+procmon_task_up{task="a"} 1
+procmon_task_up{task="b\"\\\n"} 0
+procmon_task_up_count{task="c",type="http"} 3
+`
+	if sb.String() != want {
+		t.Errorf("write() =\n%s\nwant\n%s", sb.String(), want)
+	}
+}
+
+func Test_exporterCollect(t *testing.T) {
+	running := make(chan struct{})
+	stopped := make(chan struct{})
+	close(stopped)
+
+	api := NewTask("api", "api")
+	api.Stopped = running
+	api.FailCount = 2
+	api.started = time.Now().Add(-time.Minute)
+	api.Monitors = []*FailMonitor{
+		{Name: "health", Type: "http", failures: 3},
+		{Name: "disk", Type: "diskfree"},
+	}
+
+	redis := NewTask("redis", "redis")
+	redis.Stopped = stopped
+	redis.FailCount = 5
+	redis.crashLooping = true
+
+	backup := NewTask("backup", "backup")
+	backup.Onetime = true
+	backup.runs = runStats{count: 3, total: 6 * time.Second, last: 1500 * time.Millisecond, exitCode: 2}
+
+	cleanup := NewTask("cleanup", "cleanup")
+	cleanup.Onetime = true
+
+	// its one run could not be started
+	rotate := NewTask("rotate", "rotate")
+	rotate.Onetime = true
+	rotate.Periodic = time.Hour
+	rotate.runs = runStats{count: 1, exitCode: -1}
+
+	e := &exporter{
+		namespace: "procmon",
+		cfg: &Config{prologueTimes: []prologueTime{
+			{"setup", 2 * time.Second},
+			{"migrate", 500 * time.Millisecond},
+		}},
+		tasks: &Tasks{All: map[string]*Task{
+			"api": api, "redis": redis, "backup": backup, "cleanup": cleanup, "rotate": rotate,
+		}},
+	}
+
+	// index the samples by name and labels, as they would be written
+	got := make(map[string]float64)
+	for _, m := range e.collect() {
+		for _, s := range m.samples {
+			key := m.name + s.suffix
+			for i := 0; i+1 < len(s.labels); i += 2 {
+				key += " " + s.labels[i] + "=" + s.labels[i+1]
+			}
+			if _, ok := got[key]; ok {
+				t.Errorf("collect() has %s more than once", key)
+			}
+			got[key] = s.value
+		}
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		want  float64
+		wantN bool // the sample should be missing
+	}{
+		{"running task is up", "task_up task=api", 1, false},
+		{"stopped task is down", "task_up task=redis", 0, false},
+		{"restarts from FailCount", "task_restarts_total task=api", 2, false},
+		{"restarts of a stopped task", "task_restarts_total task=redis", 5, false},
+		{"not crash looping", "task_crashlooping task=api", 0, false},
+		{"crash looping", "task_crashlooping task=redis", 1, false},
+		{"no uptime when stopped", "task_uptime_seconds task=redis", 0, true},
+		{"monitor failures", "monitor_failures_total task=api monitor=health type=http", 3, false},
+		{"monitor without failures", "monitor_failures_total task=api monitor=disk type=diskfree", 0, false},
+		{"onetime run count", "task_run_duration_seconds_count task=backup", 3, false},
+		{"onetime run sum", "task_run_duration_seconds_sum task=backup", 6, false},
+		{"onetime last run", "task_last_run_duration_seconds task=backup", 1.5, false},
+		{"onetime exit code", "task_last_exit_code task=backup", 2, false},
+		{"never run count", "task_run_duration_seconds_count task=cleanup", 0, false},
+		{"never run sum", "task_run_duration_seconds_sum task=cleanup", 0, false},
+		{"never run has no last run", "task_last_run_duration_seconds task=cleanup", 0, true},
+		{"never run has no exit code", "task_last_exit_code task=cleanup", 0, true},
+		{"onetime task has no up", "task_up task=backup", 0, true},
+		{"periodic run count", "task_run_duration_seconds_count task=rotate", 1, false},
+		{"periodic run sum", "task_run_duration_seconds_sum task=rotate", 0, false},
+		{"periodic which could not run", "task_last_exit_code task=rotate", -1, false},
+		{"periodic task has no restarts", "task_restarts_total task=rotate", 0, true},
+		{"prologue", "prologue_seconds name=setup", 2, false},
+		{"second prologue", "prologue_seconds name=migrate", 0.5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := got[tt.key]
+			if tt.wantN {
+				if ok {
+					t.Errorf("collect() has %s = %v; want none", tt.key, v)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("collect() has no %s", tt.key)
+			}
+			if v != tt.want {
+				t.Errorf("%s = %v; want %v", tt.key, v, tt.want)
+			}
+		})
+	}
+
+	uptime, ok := got["task_uptime_seconds task=api"]
+	if !ok || uptime < 60 || uptime > 120 {
+		t.Errorf("task_uptime_seconds task=api = %v (present %t); want about 60", uptime, ok)
+	}
+}
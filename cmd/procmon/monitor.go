@@ -10,6 +10,7 @@ package main
 // - -- --- ---- -----
 
 import (
+	"sync"
 	"time"
 )
 
@@ -49,16 +50,31 @@ func (m *Monitor) Listen(done chan struct{}) {
 		case <-done:
 			return
 		case <-time.After(m.D):
-			m.Status <- m.Test()
+			select {
+			case m.Status <- m.Test():
+			case <-done:
+				return
+			}
 		}
 	}
 }
 
 // FailMonitor wraps a monitor and only sends Failure events; it sends one
-// when it receives one from its wrapped monitor.
+// when its wrapped monitor has failed more than Retries times in a row.
 type FailMonitor struct {
-	Child  *Monitor
-	Status chan Eventer
+	Name    string
+	Type    string
+	Retries int
+	Child   *Monitor
+	Status  chan Eventer
+
+	// the most recent event from the child and the number of failures,
+	// for status reporting
+	mu          sync.Mutex
+	last        Eventer
+	lastAt      time.Time
+	failures    int
+	consecutive int
 }
 
 // asserts that FailMonitor is in fact a Listener
@@ -75,8 +91,10 @@ func NewFailMonitor(child *Monitor) *FailMonitor {
 }
 
 // Listen implements listener, and should be called as a goroutine.
-// It returns Stop when its child monitor returns Failed or Stop,
-// otherwise it swallows the event.
+// It returns Stop when its child monitor has returned Failed or Stop more
+// than Retries times in a row, otherwise it swallows the event.
+// Events are compared by code, so a failure reported as an ErrorEvent
+// counts too.
 func (m *FailMonitor) Listen(done chan struct{}) {
 	go m.Child.Listen(done)
 	for {
@@ -84,13 +102,45 @@ func (m *FailMonitor) Listen(done chan struct{}) {
 		case <-done:
 			return
 		case stat := <-m.Child.Status:
-			if stat == Failed || stat == Stop {
-				m.Status <- Stop
+			m.mu.Lock()
+			m.last, m.lastAt = stat, time.Now()
+			failed := stat.Code() == Failed || stat.Code() == Stop
+			trip := false
+			if failed {
+				m.failures++
+				m.consecutive++
+				trip = m.consecutive > m.Retries
+			}
+			if !failed || trip {
+				m.consecutive = 0
+			}
+			m.mu.Unlock()
+			if trip {
+				select {
+				case m.Status <- Stop:
+				case <-done:
+					return
+				}
 			}
 		}
 	}
 }
 
+// Health returns the most recent event from the child monitor and when it was
+// received. The event is nil if the monitor has not yet run.
+func (m *FailMonitor) Health() (Eventer, time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last, m.lastAt
+}
+
+// Failures returns the number of failures the child monitor has reported.
+func (m *FailMonitor) Failures() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.failures
+}
+
 // RetryMonitor wraps a monitor and will only fail after receiving a number of
 // successive failures that exceeds the Retries value.
 // Note that this won't reliably detect an intermittent problem. For that,
@@ -120,7 +170,7 @@ func NewRetryMonitor(m *Monitor, retries int) *RetryMonitor {
 	// the system worked
 	m.Test = func() Eventer {
 		e := rm.test()
-		if e == OK {
+		if e.Code() == OK {
 			rm.failCount = 0
 		}
 		return e
@@ -138,7 +188,7 @@ func (m *RetryMonitor) Listen(done chan struct{}) {
 		case <-done:
 			return
 		case e := <-m.childStatus:
-			if e == Failed || e == Stop {
+			if e.Code() == Failed || e.Code() == Stop {
 				m.failCount++
 				if m.failCount > m.Retries {
 					m.status <- Stop
@@ -0,0 +1,64 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailMonitor(t *testing.T) {
+	fail := NewErrorEvent(Failed, errors.New("no response"))
+	tests := []struct {
+		name     string
+		retries  int
+		events   []Eventer
+		stops    int
+		failures int
+	}{
+		{"ok", 0, []Eventer{OK, OK}, 0, 0},
+		{"failed", 0, []Eventer{Failed}, 1, 1},
+		{"stop", 0, []Eventer{Stop}, 1, 1},
+		{"error event", 0, []Eventer{fail}, 1, 1},
+		{"failing is advisory", 0, []Eventer{Failing, NewErrorEvent(Failing, errors.New("slow"))}, 0, 0},
+		{"within retries", 2, []Eventer{fail, fail, OK, fail, fail}, 0, 4},
+		{"beyond retries", 2, []Eventer{fail, fail, fail}, 1, 3},
+		{"count restarts after a stop", 1, []Eventer{fail, fail, fail, fail, fail}, 2, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := make(chan Eventer, len(tt.events))
+			fm := NewFailMonitor(NewMonitor(status, time.Hour, nil))
+			fm.Retries = tt.retries
+			done := make(chan struct{})
+			defer close(done)
+			go fm.Listen(done)
+
+			// the child status channel is unbuffered, so once the final OK
+			// is received every earlier event has been handled
+			for _, e := range append(tt.events, OK) {
+				fm.Child.Status <- e
+			}
+			stops := len(status)
+			if stops != tt.stops {
+				t.Errorf("stops = %d, want %d", stops, tt.stops)
+			}
+			for i := 0; i < stops; i++ {
+				if e := <-status; e != Stop {
+					t.Errorf("sent %v, want Stop", e)
+				}
+			}
+			if fm.Failures() != tt.failures {
+				t.Errorf("failures = %d, want %d", fm.Failures(), tt.failures)
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+// Task output is captured line by line. How it is written is configured by
+// an [output] section, which sets defaults for all tasks, and by the
+// [task.output] table of each task, which overrides them:
+//
+//     [output]
+//     maxsize = "100M"     # rotate a log file when it would grow past this
+//     maxage = "24h"       # rotate a log file once it has been open this long
+//     keep = "5"           # keep this many rotated files; 0 keeps them all
+//     keepfor = "168h"     # delete rotated files older than this
+//     compress = "true"    # gzip rotated files
+//     prefix = "true"      # start each line with the task name and the time
+//     buffer = "20"        # recent lines kept for the crash report; the default
+//
+// Rotation applies only to output written to files. Rotated files are named
+// after the log file, with the time of rotation appended.
+//
+// When a task exits with an error, the lines in its buffer are written to
+// procmon's log, so that the cause is visible without reading the task's
+// own log files.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultOutputBuffer is the number of recent lines kept for each task
+const defaultOutputBuffer = 20
+
+// rotatedTimeFormat is appended to the name of a rotated log file
+const rotatedTimeFormat = "20060102-150405"
+
+// OutputOptions control how a task's output is written
+type OutputOptions struct {
+	MaxSize  int64
+	MaxAge   time.Duration
+	Keep     int
+	KeepFor  time.Duration
+	Compress bool
+	Prefix   bool
+	Buffer   int
+}
+
+// parseOutputOptions builds the output options of a task from the merged
+// output tables of its config
+func parseOutputOptions(m map[string]string) (OutputOptions, error) {
+	o := OutputOptions{Buffer: defaultOutputBuffer}
+	size, percent, err := parseSize(m["maxsize"])
+	if err != nil || percent != 0 {
+		return o, errors.New("output maxsize must be a size, like 100M")
+	}
+	o.MaxSize = int64(size)
+	if o.MaxAge, err = parseDuration(m["maxage"], 0); err != nil {
+		return o, errors.Wrap(err, "output maxage")
+	}
+	if o.KeepFor, err = parseDuration(m["keepfor"], 0); err != nil {
+		return o, errors.Wrap(err, "output keepfor")
+	}
+	for _, n := range []struct {
+		key string
+		p   *int
+	}{{"keep", &o.Keep}, {"buffer", &o.Buffer}} {
+		if m[n.key] == "" {
+			continue
+		}
+		if *n.p, err = strconv.Atoi(m[n.key]); err != nil || *n.p < 0 {
+			return o, errors.New("output " + n.key + " must be a non-negative integer")
+		}
+	}
+	o.Compress = parseBool(m["compress"], false)
+	o.Prefix = parseBool(m["prefix"], false)
+	return o, nil
+}
+
+// outputFiles opens each log file once, so that tasks and streams that
+// write to the same file share it. A file which every user has closed is
+// opened again by the next user.
+type outputFiles map[string]*rotatingFile
+
+func (files outputFiles) open(path string, opts OutputOptions) (io.Writer, error) {
+	if rf, ok := files[path]; ok {
+		rf.mu.Lock()
+		defer rf.mu.Unlock()
+		if rf.f == nil {
+			rf.opts = opts
+			if err := rf.openFile(); err != nil {
+				return nil, err
+			}
+		}
+		rf.refs++
+		return rf, nil
+	}
+	rf := &rotatingFile{path: path, opts: opts, refs: 1}
+	if err := rf.openFile(); err != nil {
+		return nil, err
+	}
+	files[path] = rf
+	return rf, nil
+}
+
+// rotatingFile is a log file that is rotated according to its options.
+// It is closed when every user has closed it.
+type rotatingFile struct {
+	path string
+	opts OutputOptions
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+	refs   int
+
+	// tidying serializes the compression and pruning of rotated files,
+	// and tidied tracks it
+	tidying sync.Mutex
+	tidied  sync.WaitGroup
+}
+
+func (rf *rotatingFile) openFile() error {
+	f, err := os.OpenFile(rf.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	rf.f = f
+	rf.size = 0
+	if fi, err := f.Stat(); err == nil {
+		rf.size = fi.Size()
+	}
+	rf.opened = time.Now()
+	return nil
+}
+
+// Write implements io.Writer
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.size > 0 && rf.due(len(p)) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// due tells if the file should be rotated before writing n more bytes
+func (rf *rotatingFile) due(n int) bool {
+	o := rf.opts
+	return (o.MaxSize > 0 && rf.size+int64(n) > o.MaxSize) ||
+		(o.MaxAge > 0 && time.Since(rf.opened) > o.MaxAge)
+}
+
+// rotate moves the current file aside and opens a new one; compression and
+// cleanup of the old files happen in the background, one rotation at a time
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	rotated := rf.path + "." + time.Now().Format(rotatedTimeFormat)
+	for i := 1; fileExists(rotated) || fileExists(rotated+".gz"); i++ {
+		rotated = rf.path + "." + time.Now().Format(rotatedTimeFormat) + "-" + strconv.Itoa(i)
+	}
+	if err := os.Rename(rf.path, rotated); err != nil {
+		rf.f = nil
+		return errors.Wrap(err, "rotating "+rf.path)
+	}
+	if err := rf.openFile(); err != nil {
+		rf.f = nil
+		return errors.Wrap(err, "rotating "+rf.path)
+	}
+	opts := rf.opts
+	rf.tidied.Add(1)
+	go func() {
+		defer rf.tidied.Done()
+		rf.tidying.Lock()
+		defer rf.tidying.Unlock()
+		if opts.Compress {
+			compressFile(rotated)
+		}
+		pruneRotated(rf.path, opts.Keep, opts.KeepFor)
+	}()
+	return nil
+}
+
+// Close implements io.Closer
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.refs--
+	if rf.refs > 0 || rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// compressFile replaces a file with a gzipped copy
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// pruneRotated deletes the rotated copies of a log file beyond the newest
+// keep of them, and those older than keepFor
+func pruneRotated(path string, keep int, keepFor time.Duration) {
+	type rotatedFile struct {
+		name  string
+		stamp string
+		n     int
+	}
+	matches, _ := filepath.Glob(path + ".*")
+	var rotated []rotatedFile
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, path+"."), ".gz")
+		if len(suffix) < len(rotatedTimeFormat) {
+			continue
+		}
+		rf := rotatedFile{name: m, stamp: suffix[:len(rotatedTimeFormat)]}
+		if _, err := time.Parse(rotatedTimeFormat, rf.stamp); err != nil {
+			continue
+		}
+		// files rotated within the same second are numbered
+		if rest := suffix[len(rotatedTimeFormat):]; rest != "" {
+			n, err := strconv.Atoi(strings.TrimPrefix(rest, "-"))
+			if err != nil || rest[0] != '-' {
+				continue
+			}
+			rf.n = n
+		}
+		rotated = append(rotated, rf)
+	}
+	// newest first
+	sort.Slice(rotated, func(i, j int) bool {
+		if rotated[i].stamp != rotated[j].stamp {
+			return rotated[i].stamp > rotated[j].stamp
+		}
+		return rotated[i].n > rotated[j].n
+	})
+	for i, rf := range rotated {
+		old := false
+		if keepFor > 0 {
+			if fi, err := os.Stat(rf.name); err == nil && time.Since(fi.ModTime()) > keepFor {
+				old = true
+			}
+		}
+		if (keep > 0 && i >= keep) || old {
+			os.Remove(rf.name)
+		}
+	}
+}
+
+// ringBuffer keeps the most recent lines of a task's output
+type ringBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	if size <= 0 {
+		return nil
+	}
+	return &ringBuffer{lines: make([]string, size)}
+}
+
+func (r *ringBuffer) add(line string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	r.full = r.full || r.next == 0
+	r.mu.Unlock()
+}
+
+// Lines returns the lines in the buffer, oldest first
+func (r *ringBuffer) Lines() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]string{}, r.lines[:r.next]...)
+	}
+	return append(append([]string{}, r.lines[r.next:]...), r.lines[:r.next]...)
+}
+
+func (r *ringBuffer) reset() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.next = 0
+	r.full = false
+	r.mu.Unlock()
+}
+
+// maxLine is the longest line a lineWriter holds; longer lines are split
+const maxLine = 64 * 1024
+
+// lineWriter splits a stream of output into lines, which it prefixes if
+// asked to, writes to its destination, and records in a ring buffer
+type lineWriter struct {
+	dst    io.Writer
+	prefix string
+	stream string
+	recent *ringBuffer
+	buf    []byte
+}
+
+// Write implements io.Writer
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.buf = append(lw.buf, p...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		lw.line(lw.buf[:i+1])
+		lw.buf = lw.buf[i+1:]
+	}
+	if len(lw.buf) > maxLine {
+		lw.Flush()
+	}
+	return len(p), nil
+}
+
+// Flush writes any partial line
+func (lw *lineWriter) Flush() {
+	if len(lw.buf) > 0 {
+		lw.line(append(lw.buf, '\n'))
+		lw.buf = nil
+	}
+}
+
+func (lw *lineWriter) line(line []byte) {
+	lw.recent.add(lw.stream + ": " + strings.TrimRight(string(line), "\r\n"))
+	if lw.prefix != "" {
+		line = append([]byte(lw.prefix+" "+time.Now().UTC().Format("2006-01-02T15:04:05.000Z")+" "), line...)
+	}
+	lw.dst.Write(line)
+}
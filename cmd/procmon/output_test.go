@@ -0,0 +1,247 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_lineWriter(t *testing.T) {
+	var out bytes.Buffer
+	recent := newRingBuffer(3)
+	lw := &lineWriter{dst: &out, stream: "stdout", recent: recent}
+	for _, s := range []string{"one\ntw", "o\nthree\nfo", "ur\nfive"} {
+		lw.Write([]byte(s))
+	}
+	lw.Flush()
+
+	if got, want := out.String(), "one\ntwo\nthree\nfour\nfive\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	want := []string{"stdout: three", "stdout: four", "stdout: five"}
+	if got := recent.Lines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Lines() = %v, want %v", got, want)
+	}
+}
+
+func Test_pruneRotated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "procmon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "task.log")
+	files := []string{
+		"task.log",
+		"task.log.20190501-100000.gz",
+		"task.log.20190501-110000.gz",
+		"task.log.20190501-110000-1.gz",
+		"task.log.20190501-120000",
+		"task.log.backup",
+	}
+	for _, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruneRotated(path, 2, 0)
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range infos {
+		got = append(got, fi.Name())
+	}
+	sort.Strings(got)
+	want := []string{
+		"task.log",
+		"task.log.20190501-110000-1.gz",
+		"task.log.20190501-120000",
+		"task.log.backup",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after pruning = %v, want %v", got, want)
+	}
+}
+
+func Test_outputFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "procmon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "task.log")
+
+	files := make(outputFiles)
+	a, err := files.open(path, OutputOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := files.open(path, OutputOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b || len(files) != 1 {
+		t.Fatalf("opened %p and %p, %d files", a, b, len(files))
+	}
+	a.(io.Closer).Close()
+	if _, err := b.Write([]byte("one\n")); err != nil {
+		t.Errorf("write with one user left: %v", err)
+	}
+	b.(io.Closer).Close()
+	if _, err := b.Write([]byte("lost\n")); err == nil {
+		t.Error("write after every user closed the file succeeded")
+	}
+
+	// the next user opens it again
+	c, err := files.open(path, OutputOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.(io.Closer).Close()
+	if _, err := c.Write([]byte("two\n")); err != nil {
+		t.Errorf("write after reopening: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "one\ntwo\n" {
+		t.Errorf("file = %q", b)
+	}
+}
+
+func Test_rotatingFile(t *testing.T) {
+	// names of the files in dir, and the contents of each, gunzipped
+	contents := func(t *testing.T, dir string) map[string]string {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string)
+		for _, fi := range infos {
+			b, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := fi.Name()
+			if strings.HasSuffix(name, ".gz") {
+				zr, err := gzip.NewReader(bytes.NewReader(b))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if b, err = ioutil.ReadAll(zr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// rotated files are named by the time; keep only the number
+			// given to those rotated within the same second
+			if i := strings.Index(name, ".log."); i >= 0 {
+				suffix := name[i+len(".log.")+len(rotatedTimeFormat):]
+				name = name[:i+len(".log")] + ".TIME" + suffix
+			}
+			got[name] = string(b)
+		}
+		return got
+	}
+
+	tests := []struct {
+		name   string
+		opts   OutputOptions
+		writes []string
+		age    time.Duration
+		want   map[string]string
+	}{
+		{
+			"no limits",
+			OutputOptions{},
+			[]string{"one\n", "two\n"},
+			0,
+			map[string]string{"task.log": "one\ntwo\n"},
+		},
+		{
+			"size",
+			OutputOptions{MaxSize: 6},
+			[]string{"one\n", "two\n", "three\n"},
+			0,
+			map[string]string{
+				"task.log":        "three\n",
+				"task.log.TIME":   "one\n",
+				"task.log.TIME-1": "two\n",
+			},
+		},
+		{
+			"a write larger than the limit",
+			OutputOptions{MaxSize: 2},
+			[]string{"one\n"},
+			0,
+			map[string]string{"task.log": "one\n"},
+		},
+		{
+			"age",
+			OutputOptions{MaxAge: time.Minute},
+			[]string{"one\n", "two\n"},
+			time.Hour,
+			map[string]string{"task.log": "two\n", "task.log.TIME": "one\n"},
+		},
+		{
+			"compress",
+			OutputOptions{MaxSize: 6, Compress: true},
+			[]string{"one\n", "two\n", "three\n"},
+			0,
+			map[string]string{
+				"task.log":           "three\n",
+				"task.log.TIME.gz":   "one\n",
+				"task.log.TIME-1.gz": "two\n",
+			},
+		},
+		{
+			"keep",
+			OutputOptions{MaxSize: 4, Keep: 1, Compress: true},
+			[]string{"one\n", "two\n", "three\n", "four\n"},
+			0,
+			map[string]string{"task.log": "four\n", "task.log.TIME-2.gz": "three\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := make(outputFiles)
+			w, err := files.open(filepath.Join(dir, "task.log"), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rf := w.(*rotatingFile)
+			for _, s := range tt.writes {
+				if tt.age != 0 {
+					rf.opened = time.Now().Add(-tt.age)
+				}
+				if _, err := rf.Write([]byte(s)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rf.Close()
+			rf.tidied.Wait()
+			if got := contents(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
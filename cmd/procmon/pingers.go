@@ -10,13 +10,22 @@ package main
 // - -- --- ---- -----
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"os/exec"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/ndau/noms/go/constants"
+	"github.com/ndau/noms/go/hash"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	"github.com/go-redis/redis"
@@ -123,3 +132,273 @@ func EnsureDir(name string, permissions os.FileMode) func() Eventer {
 		return OK
 	}
 }
+
+// TendermintPinger returns a function that checks a tendermint node's
+// /status RPC endpoint.
+//
+// A node which is catching up returns Failing, unless failCatchingUp is set,
+// in which case it returns Failed.
+// If stall is nonzero, and the latest block height has not changed for
+// that long, it returns Failed. If started is given, it returns the time
+// at which the node was last started, and the stall timer restarts with
+// the node: a restarted node is often still at the height it stopped at.
+func TendermintPinger(u string, timeout, stall time.Duration, failCatchingUp bool, started func() time.Time, logger logrus.FieldLogger) func() Eventer {
+	client := http.Client{Timeout: timeout}
+	statusURL := strings.TrimRight(u, "/") + "/status"
+	var lastHeight int64
+	var lastChange, lastStart time.Time
+
+	return func() Eventer {
+		logger := logger.WithField("url", statusURL).WithField("pinger", "TendermintPinger")
+		logger.Debug("pinging")
+		resp, err := client.Get(statusURL)
+		if err != nil {
+			return NewErrorEvent(Failed, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return NewErrorEvent(Failed, fmt.Errorf("Got status code %d (%s) from %s",
+				resp.StatusCode, resp.Status, statusURL))
+		}
+
+		var status struct {
+			Result struct {
+				SyncInfo struct {
+					LatestBlockHeight json.Number `json:"latest_block_height"`
+					CatchingUp        bool        `json:"catching_up"`
+				} `json:"sync_info"`
+			} `json:"result"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			return NewErrorEvent(Failed, errors.Wrap(err, "decoding tendermint status"))
+		}
+		height, err := status.Result.SyncInfo.LatestBlockHeight.Int64()
+		if err != nil {
+			return NewErrorEvent(Failed, errors.Wrap(err, "decoding tendermint block height"))
+		}
+
+		now := time.Now()
+		if started != nil {
+			if start := started(); !start.Equal(lastStart) {
+				lastStart, lastChange = start, time.Time{}
+			}
+		}
+		if height != lastHeight || lastChange.IsZero() {
+			lastHeight, lastChange = height, now
+		}
+		if stall != 0 && now.Sub(lastChange) > stall {
+			logger.WithField("height", height).WithField("since", lastChange).Error("block height stalled")
+			since := lastChange
+			// give the node a full stall period after it is restarted
+			lastChange = time.Time{}
+			return NewErrorEvent(Failed, fmt.Errorf("block height %d has not changed since %s", height, since))
+		}
+
+		if status.Result.SyncInfo.CatchingUp {
+			logger.WithField("height", height).Debug("catching up")
+			evt := Failing
+			if failCatchingUp {
+				evt = Failed
+			}
+			return NewErrorEvent(evt, fmt.Errorf("catching up at height %d", height))
+		}
+		return OK
+	}
+}
+
+// nomsVersionHeader is datas.NomsVersionHeader; importing datas would pull
+// all of noms into procmon
+const nomsVersionHeader = "x-noms-vers"
+
+// NomsPinger returns a function that asks a noms server for its root hash.
+func NomsPinger(u string, timeout time.Duration, logger logrus.FieldLogger) func() Eventer {
+	client := http.Client{Timeout: timeout}
+	rootURL := strings.TrimRight(u, "/") + constants.RootPath
+
+	return func() Eventer {
+		logger.WithField("url", rootURL).WithField("pinger", "NomsPinger").Debug("pinging")
+		req, err := http.NewRequest("GET", rootURL, nil)
+		if err != nil {
+			return NewErrorEvent(Failed, err)
+		}
+		req.Header.Set(nomsVersionHeader, constants.NomsVersion)
+		resp, err := client.Do(req)
+		if err != nil {
+			return NewErrorEvent(Failed, err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return NewErrorEvent(Failed, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return NewErrorEvent(Failed, fmt.Errorf("Got status code %d (%s) from %s: %s",
+				resp.StatusCode, resp.Status, rootURL, strings.TrimSpace(string(body))))
+		}
+		if _, ok := hash.MaybeParse(strings.TrimSpace(string(body))); !ok {
+			return NewErrorEvent(Failed, fmt.Errorf("noms root '%s' is not a hash", body))
+		}
+		return OK
+	}
+}
+
+// TCPPinger returns a function that connects to a TCP address.
+// If send is not empty, it is sent once connected; if expect is not empty,
+// the response must contain it.
+func TCPPinger(addr, send, expect string, timeout time.Duration, logger logrus.FieldLogger) func() Eventer {
+	return func() Eventer {
+		logger.WithField("addr", addr).WithField("pinger", "TCPPinger").Debug("pinging")
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return NewErrorEvent(Failed, err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(timeout))
+
+		if send != "" {
+			if _, err := io.WriteString(conn, send); err != nil {
+				return NewErrorEvent(Failed, err)
+			}
+		}
+		if expect == "" {
+			return OK
+		}
+		// read until we see what we expect, or the connection fails
+		var got []byte
+		buf := make([]byte, 512)
+		for {
+			n, err := conn.Read(buf)
+			got = append(got, buf[:n]...)
+			if bytes.Contains(got, []byte(expect)) {
+				return OK
+			}
+			if err != nil {
+				return NewErrorEvent(Failed, fmt.Errorf("expected '%s' from %s, got '%s': %s", expect, addr, got, err))
+			}
+		}
+	}
+}
+
+// execWaitDelay bounds how long runBounded waits for the output of a
+// command after it has exited, in case it left a process holding its output
+// open, as exec.Cmd.WaitDelay does in later versions of Go.
+const execWaitDelay = time.Second
+
+// runBounded runs cmd in its own process group, which is killed if it
+// takes longer than timeout, and returns its combined output. leftOpen
+// tells if a process it started still held its output open after it exited.
+func runBounded(cmd *exec.Cmd, timeout time.Duration) (out []byte, leftOpen bool, err error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	// the command writes straight to a pipe, so that waiting for it
+	// doesn't also wait for whatever else holds the pipe open
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, false, err
+	}
+	defer r.Close()
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return nil, false, err
+	}
+
+	buf := new(bytes.Buffer)
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(buf, r)
+		close(copied)
+	}()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-exited:
+	case <-timer.C:
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-exited
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	select {
+	case <-copied:
+	case <-time.After(execWaitDelay):
+		leftOpen = true
+		r.Close()
+		<-copied
+	}
+	return buf.Bytes(), leftOpen, err
+}
+
+// ExecPinger returns a function that runs a shell command, and is OK if
+// the command exits with status 0.
+// The command runs in its own process group, which is killed if it takes
+// longer than timeout.
+func ExecPinger(command string, timeout time.Duration, logger logrus.FieldLogger) func() Eventer {
+	return func() Eventer {
+		logger.WithField("command", command).WithField("pinger", "ExecPinger").Debug("pinging")
+		out, leftOpen, err := runBounded(exec.Command("/bin/sh", "-c", command), timeout)
+		if leftOpen {
+			logger.WithField("command", command).Warn("command left a process holding its output open")
+		}
+		if err != nil {
+			return NewErrorEvent(Failed, fmt.Errorf("%s: %s: %s", command, err, bytes.TrimSpace(out)))
+		}
+		return OK
+	}
+}
+
+// DiskFree returns a function that checks the free space on the filesystem
+// containing path. It fails if there are fewer than minBytes bytes free, or
+// if less than minPercent of the filesystem is free.
+func DiskFree(path string, minBytes uint64, minPercent float64, logger logrus.FieldLogger) func() Eventer {
+	return func() Eventer {
+		logger.WithField("path", path).WithField("pinger", "DiskFree").Debug("pinging")
+		var fs syscall.Statfs_t
+		if err := syscall.Statfs(path, &fs); err != nil {
+			return NewErrorEvent(Failed, err)
+		}
+		free := uint64(fs.Bavail) * uint64(fs.Bsize)
+		total := uint64(fs.Blocks) * uint64(fs.Bsize)
+		if free < minBytes {
+			return NewErrorEvent(Failed, fmt.Errorf("%s has %d bytes free, less than %d", path, free, minBytes))
+		}
+		if total > 0 && minPercent > 0 {
+			if pct := 100 * float64(free) / float64(total); pct < minPercent {
+				return NewErrorEvent(Failed, fmt.Errorf("%s has %.1f%% free, less than %g%%", path, pct, minPercent))
+			}
+		}
+		return OK
+	}
+}
+
+// RSSMonitor returns a function that checks the resident memory of the
+// process that pid returns. It fails if the process uses more than max
+// bytes, so that the task is restarted.
+func RSSMonitor(pid func() int, max uint64, logger logrus.FieldLogger) func() Eventer {
+	return func() Eventer {
+		p := pid()
+		if p == 0 {
+			return OK
+		}
+		logger.WithField("pid", p).WithField("pinger", "RSSMonitor").Debug("pinging")
+		rss, err := readRSS(p)
+		if err != nil {
+			// the process has most likely exited, which the task notices on its own
+			logger.WithField("pid", p).WithError(err).Debug("reading rss")
+			return OK
+		}
+		if rss > max {
+			logger.WithField("pid", p).WithField("rss", rss).WithField("max", max).Warn("process is using too much memory")
+			return NewErrorEvent(Failed, fmt.Errorf("process %d is using %d bytes of memory, more than %d", p, rss, max))
+		}
+		return OK
+	}
+}
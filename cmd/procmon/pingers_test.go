@@ -0,0 +1,219 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ndau/noms/go/constants"
+	"github.com/sirupsen/logrus"
+)
+
+func TestTendermintPinger(t *testing.T) {
+	height, catchingUp := 10, false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"result":{"sync_info":{"latest_block_height":"%d","catching_up":%v}}}`,
+			height, catchingUp)
+	}))
+	defer srv.Close()
+
+	ping := TendermintPinger(srv.URL, time.Second, 50*time.Millisecond, false, nil, logrus.New())
+	if e := ping(); e != OK {
+		t.Errorf("first ping = %v, want OK", e)
+	}
+	catchingUp = true
+	if e := ping(); e.Code() != Failing {
+		t.Errorf("catching up = %v, want Failing", e)
+	}
+	catchingUp = false
+	time.Sleep(60 * time.Millisecond)
+	if e := ping(); e.Code() != Failed {
+		t.Errorf("stalled = %v, want Failed", e)
+	}
+	height++
+	if e := ping(); e != OK {
+		t.Errorf("after new block = %v, want OK", e)
+	}
+}
+
+func TestTendermintPingerRestart(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"result":{"sync_info":{"latest_block_height":"10","catching_up":false}}}`)
+	}))
+	defer srv.Close()
+
+	const stall = 50 * time.Millisecond
+	started := time.Now()
+	ping := TendermintPinger(srv.URL, time.Second, stall, false, func() time.Time { return started }, logrus.New())
+
+	tests := []struct {
+		name    string
+		restart bool
+		wait    time.Duration
+		want    Event
+	}{
+		{"first run", false, 0, OK},
+		// the node was down for longer than the stall period, and came
+		// back at the same height
+		{"restarted after a long wait", true, 2 * stall, OK},
+		{"same height within the stall period", false, stall / 2, OK},
+		{"stalled", false, 2 * stall, Failed},
+		{"restarted at once", true, 0, OK},
+	}
+	for _, tt := range tests {
+		time.Sleep(tt.wait)
+		if tt.restart {
+			started = time.Now()
+		}
+		if e := ping(); e.Code() != tt.want {
+			t.Errorf("%s: ping = %v, want %v", tt.name, e, tt.want)
+		}
+	}
+}
+
+func TestNomsPinger(t *testing.T) {
+	root := "0123456789abcdefghijklmnopqrstuv"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != constants.RootPath || r.Header.Get(nomsVersionHeader) != constants.NomsVersion {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, root)
+	}))
+	defer srv.Close()
+
+	ping := NomsPinger(srv.URL, time.Second, logrus.New())
+	if e := ping(); e != OK {
+		t.Errorf("ping = %v, want OK", e)
+	}
+	root = "not a hash"
+	if e := ping(); e.Code() != Failed {
+		t.Errorf("bad root = %v, want Failed", e)
+	}
+}
+
+func TestTCPPinger(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// answers PING with PONG, and closes the connection on anything else
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err == nil && strings.TrimSpace(line) == "PING" {
+					fmt.Fprint(conn, "+PONG\r\n")
+				}
+			}()
+		}
+	}()
+	// an address with nothing listening on it
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	addr := ln.Addr().String()
+	tests := []struct {
+		name   string
+		addr   string
+		send   string
+		expect string
+		want   Event
+	}{
+		{"connect", addr, "", "", OK},
+		{"expected reply", addr, "PING\n", "PONG", OK},
+		{"unexpected reply", addr, "HELLO\n", "PONG", Failed},
+		{"no reply", addr, "", "PONG", Failed},
+		{"refused", closed.Addr().String(), "", "", Failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ping := TCPPinger(tt.addr, tt.send, tt.expect, 200*time.Millisecond, logrus.New())
+			if e := ping(); e.Code() != tt.want {
+				t.Errorf("ping = %v, want %v", e, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecPinger(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    Event
+		output  string
+	}{
+		{"success", "true", OK, ""},
+		{"failure", "echo broken; exit 3", Failed, "broken"},
+		{"timeout", "sleep 10", Failed, "timed out"},
+		// killing the group also kills the commands the shell started
+		{"timeout with children", "sleep 10 & sleep 10; wait", Failed, "timed out"},
+		// a process left holding the output doesn't hold up the ping
+		{"background output", "sleep 2 &", OK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ping := ExecPinger(tt.command, 200*time.Millisecond, logrus.New())
+			begin := time.Now()
+			e := ping()
+			if elapsed := time.Since(begin); elapsed > 200*time.Millisecond+2*execWaitDelay {
+				t.Errorf("ping took %v", elapsed)
+			}
+			if e.Code() != tt.want {
+				t.Fatalf("ping = %v, want %v", e, tt.want)
+			}
+			if tt.output != "" && !strings.Contains(e.(ErrorEvent).Err.Error(), tt.output) {
+				t.Errorf("error %q does not contain %q", e.(ErrorEvent).Err, tt.output)
+			}
+		})
+	}
+}
+
+func TestDiskFree(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name       string
+		path       string
+		minBytes   uint64
+		minPercent float64
+		want       Event
+	}{
+		{"no minimum", dir, 0, 0, OK},
+		{"enough bytes", dir, 1, 0, OK},
+		{"too few bytes", dir, 1 << 62, 0, Failed},
+		{"too small a percent", dir, 0, 100.5, Failed},
+		{"missing path", filepath.Join(dir, "missing"), 0, 0, Failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ping := DiskFree(tt.path, tt.minBytes, tt.minPercent, logrus.New())
+			if e := ping(); e.Code() != tt.want {
+				t.Errorf("ping = %v, want %v", e, tt.want)
+			}
+		})
+	}
+}
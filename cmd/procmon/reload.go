@@ -0,0 +1,373 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+// A reload loads the config file again and applies the differences to the
+// running tasks:
+//
+// * tasks that are new are added, and started if their parent is running
+// * tasks that are gone are stopped and removed
+// * tasks whose definition changed are stopped, updated, and started again,
+//   along with their dependents
+// * tasks whose parent or kind (main, child, signal, periodic, onetime)
+//   changed are removed and added again, along with their dependents
+//
+// A change to [env] changes every task. Changes to [logger], [control],
+// [metrics], and the prologue, and to which signals are handled, take
+// effect when procmon is restarted.
+
+import (
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Kinds of task, which determine how a task is run
+const (
+	kindMain     = "main"
+	kindChild    = "child"
+	kindSignal   = "signal"
+	kindPeriodic = "periodic"
+	kindOnetime  = "onetime"
+)
+
+// taskKind classifies a task as BuildTasks does
+func taskKind(t *Task, tasks *Tasks) string {
+	for _, st := range tasks.Signals {
+		if st == t {
+			return kindSignal
+		}
+	}
+	for _, pt := range tasks.Periodic {
+		if pt == t {
+			return kindPeriodic
+		}
+	}
+	for _, mt := range tasks.Main {
+		if mt == t {
+			return kindMain
+		}
+	}
+	if t.parent != nil {
+		return kindChild
+	}
+	return kindOnetime
+}
+
+// reloadPlan describes the changes a reload makes to the running tasks
+type reloadPlan struct {
+	Add        []string `json:"add,omitempty"`
+	Remove     []string `json:"remove,omitempty"`
+	Restart    []string `json:"restart,omitempty"`
+	Update     []string `json:"update,omitempty"`
+	Dependents []string `json:"dependents,omitempty"`
+	Unchanged  []string `json:"unchanged,omitempty"`
+	Notes      []string `json:"notes,omitempty"`
+}
+
+// reloader applies changes in the config file to the running tasks
+type reloader struct {
+	mu      sync.Mutex
+	load    func() (Config, error)
+	cfg     *Config
+	root    *Task
+	tasks   *Tasks
+	files   outputFiles
+	signals map[os.Signal]bool
+	logger  logrus.FieldLogger
+}
+
+// reload loads the config and applies it; if dryrun is set, it only
+// reports what it would do.
+func (r *reloader) reload(dryrun bool) (*reloadPlan, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logger := r.logger.WithField("task", rootTaskName)
+	cfg, err := r.load()
+	if err != nil {
+		logger.WithError(err).Error("reload failed: could not load config")
+		return nil, errors.Wrap(err, "loading config")
+	}
+	// a dry run mustn't touch the files the tasks are writing to
+	files := r.files
+	if dryrun {
+		files = make(outputFiles)
+	}
+	next, err := cfg.BuildTasks(r.logger, files)
+	if err != nil {
+		logger.WithError(err).Error("reload failed: could not build tasks")
+		return nil, errors.Wrap(err, "building tasks")
+	}
+
+	// only reload changes the structure of the live tasks, so reading
+	// them needs no lock while we hold r.mu
+	live := r.tasks
+	oldDefs := make(map[string]ConfigTask)
+	for _, ct := range r.cfg.Task {
+		oldDefs[ct.Name] = ct
+	}
+	newDefs := make(map[string]bool)
+	envChanged := !reflect.DeepEqual(r.cfg.Env, cfg.Env)
+
+	added := make(map[string]bool)
+	removed := make(map[string]bool)
+	changed := make(map[string]bool)
+	for _, ct := range cfg.Task {
+		newDefs[ct.Name] = true
+		oct, ok := oldDefs[ct.Name]
+		lt := live.All[ct.Name]
+		switch {
+		case !ok || lt == nil:
+			added[ct.Name] = true
+		case ct.Parent != oct.Parent ||
+			removed[ct.Parent] ||
+			taskKind(lt, live) != taskKind(next.All[ct.Name], &next):
+			// tasks are in parent order, so this replaces
+			// the descendants of replaced tasks too
+			removed[ct.Name] = true
+			added[ct.Name] = true
+		case envChanged || !reflect.DeepEqual(oct, ct):
+			changed[ct.Name] = true
+		}
+	}
+	for _, ct := range r.cfg.Task {
+		if !newDefs[ct.Name] {
+			removed[ct.Name] = true
+		}
+	}
+	// a task whose prerun tasks changed has changed too
+	for again := true; again; {
+		again = false
+		for _, ct := range cfg.Task {
+			if added[ct.Name] || changed[ct.Name] {
+				continue
+			}
+			for _, pre := range ct.Prerun {
+				if added[pre] || changed[pre] {
+					changed[ct.Name] = true
+					again = true
+				}
+			}
+		}
+	}
+
+	// which of the changed tasks run all the time, and so must be restarted
+	restart := make(map[string]bool)
+	for name := range changed {
+		switch taskKind(live.All[name], live) {
+		case kindMain, kindChild:
+			restart[name] = true
+		}
+	}
+	hasAncestor := func(t *Task, in map[string]bool) bool {
+		for p := t.parent; p != nil; p = p.parent {
+			if in[p.Name] && p != r.root {
+				return true
+			}
+		}
+		return false
+	}
+	// restartTree is every task that is stopped and started again by the
+	// restarts
+	restartTree := make(map[string]bool)
+	var walk func(t *Task)
+	walk = func(t *Task) {
+		restartTree[t.Name] = true
+		for _, ch := range t.Dependents {
+			walk(ch)
+		}
+	}
+	var topRestart, topRemoved []*Task
+
+	plan := &reloadPlan{}
+	for _, ct := range r.cfg.Task {
+		t := live.All[ct.Name]
+		switch {
+		case removed[ct.Name]:
+			plan.Remove = append(plan.Remove, ct.Name)
+			if !hasAncestor(t, removed) {
+				topRemoved = append(topRemoved, t)
+			}
+		case restart[ct.Name]:
+			plan.Restart = append(plan.Restart, ct.Name)
+			if !hasAncestor(t, restart) {
+				topRestart = append(topRestart, t)
+				walk(t)
+			}
+		case changed[ct.Name]:
+			plan.Update = append(plan.Update, ct.Name)
+		}
+	}
+	for _, ct := range cfg.Task {
+		if added[ct.Name] {
+			plan.Add = append(plan.Add, ct.Name)
+		}
+	}
+	for _, ct := range r.cfg.Task {
+		if restartTree[ct.Name] && !restart[ct.Name] && !removed[ct.Name] {
+			plan.Dependents = append(plan.Dependents, ct.Name)
+		}
+		if newDefs[ct.Name] && !removed[ct.Name] && !changed[ct.Name] {
+			plan.Unchanged = append(plan.Unchanged, ct.Name)
+		}
+	}
+
+	for section, same := range map[string]bool{
+		"[logger]":   reflect.DeepEqual(r.cfg.Logger, cfg.Logger),
+		"[control]":  reflect.DeepEqual(r.cfg.Control, cfg.Control),
+		"[metrics]":  reflect.DeepEqual(r.cfg.Metrics, cfg.Metrics),
+		"[prologue]": reflect.DeepEqual(r.cfg.Prologue, cfg.Prologue),
+	} {
+		if !same {
+			plan.Notes = append(plan.Notes, section+" changed; restart procmon to apply it")
+		}
+	}
+	for sig, t := range next.Signals {
+		if !r.signals[sig] {
+			plan.Notes = append(plan.Notes,
+				"signal "+sig.String()+" for task "+t.Name+" is not handled until procmon restarts")
+		}
+	}
+
+	logger.WithField("add", plan.Add).
+		WithField("remove", plan.Remove).
+		WithField("restart", plan.Restart).
+		WithField("update", plan.Update).
+		WithField("dependents", plan.Dependents).
+		WithField("notes", plan.Notes).
+		WithField("dryrun", dryrun).
+		Warn("reload plan")
+
+	if dryrun {
+		for _, t := range next.All {
+			t.closeOutputs()
+		}
+		return plan, nil
+	}
+
+	// stop everything being removed or restarted, remembering what state
+	// to return the restarted tasks to
+	for _, t := range topRemoved {
+		t.stopAndWait()
+	}
+	wasHeld := make(map[*Task]bool)
+	wasRunning := make(map[*Task]bool)
+	for _, t := range topRestart {
+		wasHeld[t] = t.Held()
+		wasRunning[t] = t.Running()
+		t.stopAndWait()
+	}
+
+	tasksLock.Lock()
+	oldPeriodic := make(map[*Task]bool)
+	for _, t := range live.Periodic {
+		oldPeriodic[t] = true
+	}
+	for name := range removed {
+		t := live.All[name]
+		if t.parent != nil {
+			t.parent.removeDependent(t)
+		}
+		t.retireOutputs()
+		delete(live.All, name)
+	}
+	var newTasks []*Task
+	for _, ct := range cfg.Task {
+		nt := next.All[ct.Name]
+		switch {
+		case changed[ct.Name]:
+			// updated below, once the tasks it refers to are in place
+		case added[ct.Name]:
+			// its dependents are added as we come to them
+			nt.Dependents = nil
+			nt.parent = nil
+			if ct.Parent != "" {
+				live.All[ct.Parent].AddDependent(nt)
+			}
+			live.All[ct.Name] = nt
+			newTasks = append(newTasks, nt)
+		default:
+			nt.closeOutputs()
+		}
+	}
+	// resolve references to other tasks by name, and update the changed
+	// tasks
+	for _, ct := range cfg.Task {
+		t := live.All[ct.Name]
+		if changed[ct.Name] {
+			t = next.All[ct.Name]
+		}
+		t.Prerun = nil
+		for _, pre := range ct.Prerun {
+			t.Prerun = append(t.Prerun, live.All[pre])
+		}
+		if t.Restart.OnLoop == CrashLoopAlert {
+			t.Restart.Alert = live.All[ct.Restart["alert"]]
+		}
+		if changed[ct.Name] {
+			live.All[ct.Name].updateFrom(t)
+		}
+	}
+	live.Main = live.Main[:0]
+	for _, t := range next.Main {
+		t = live.All[t.Name]
+		t.parent = r.root
+		live.Main = append(live.Main, t)
+	}
+	r.root.Dependents = append([]*Task{}, live.Main...)
+	live.Periodic = live.Periodic[:0]
+	var newPeriodic []*Task
+	for _, t := range next.Periodic {
+		t = live.All[t.Name]
+		live.Periodic = append(live.Periodic, t)
+		if !oldPeriodic[t] {
+			newPeriodic = append(newPeriodic, t)
+		}
+	}
+	live.Signals = make(map[os.Signal]*Task)
+	for sig, t := range next.Signals {
+		live.Signals[sig] = live.All[t.Name]
+	}
+	cfg.prologueTimes = r.cfg.prologueTimes
+	*r.cfg = cfg
+	tasksLock.Unlock()
+
+	// start the restarted tasks, which starts their dependents, and the new
+	// tasks that aren't dependents of those
+	for _, t := range topRestart {
+		t.setHeld(wasHeld[t])
+		t.resetRestarts()
+		if wasRunning[t] && !wasHeld[t] {
+			if err := startTask(t); err != nil {
+				logger.WithError(err).WithField("restart", t.Name).Error("could not restart task")
+			}
+		}
+	}
+	for _, t := range newTasks {
+		kind := taskKind(t, live)
+		if kind != kindMain && kind != kindChild {
+			continue
+		}
+		if added[t.parent.Name] || restartTree[t.parent.Name] || !t.parent.Running() {
+			continue
+		}
+		if err := startTask(t); err != nil {
+			logger.WithError(err).WithField("add", t.Name).Error("could not start task")
+		}
+	}
+	setupPeriodic(r.root, live, newPeriodic)
+
+	logger.Warn("reload complete")
+	return plan, nil
+}
@@ -0,0 +1,456 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// reloadTestConfig has a signal task which writes to log, runs for a
+// moment, and then writes again
+func reloadTestConfig(log, arg string) Config {
+	return Config{
+		Env:    map[string]string{},
+		Logger: map[string]string{"output": LoggerOutputSuppress},
+		Task: []ConfigTask{{
+			Name:     "s",
+			Path:     "/bin/sh",
+			Args:     []string{"-c", "echo before; sleep 0.3; echo after", arg},
+			Specials: map[string]interface{}{"onetime": true, "signal": "SIGUSR1"},
+			Stdout:   log,
+		}},
+	}
+}
+
+func Test_reloaderOutputs(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	log := filepath.Join(t.TempDir(), "s.log")
+	cfg := reloadTestConfig(log, "1")
+	files := make(outputFiles)
+	tasks, err := cfg.BuildTasks(logger, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := NewTask(rootTaskName, "")
+	root.Logger = logger
+	r := &reloader{
+		load:   func() (Config, error) { return reloadTestConfig(log, "2"), nil },
+		cfg:    &cfg,
+		root:   root,
+		tasks:  &tasks,
+		files:  files,
+		logger: logger,
+	}
+
+	s := tasks.All["s"]
+	ran := make(chan struct{})
+	go func() {
+		tempstop := make(chan struct{})
+		s.Start(tempstop)
+		close(tempstop)
+		close(ran)
+	}()
+	// reload while the task is running
+	for i := 0; i < 100 && !strings.Contains(readFile(t, log), "before"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	plan, err := r.reload(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Update) != 1 {
+		t.Fatalf("plan = %+v, want s updated", plan)
+	}
+	<-ran
+
+	// the run kept writing to its log after the reload, and the task was
+	// updated once it ended
+	if got := readFile(t, log); got != "before\nafter\n" {
+		t.Errorf("log = %q", got)
+	}
+	if arg := s.Args[len(s.Args)-1]; arg != "2" {
+		t.Errorf("args not updated after the run: %v", s.Args)
+	}
+	// and the updated task writes to the same file, which is open once
+	if len(files) != 1 || s.Stdout != files[log] {
+		t.Errorf("files = %v, stdout = %p", files, s.Stdout)
+	}
+	if _, err := s.Stdout.Write([]byte("later\n")); err != nil {
+		t.Errorf("writing after the run: %v", err)
+	}
+
+	// a dry run leaves the files alone
+	if _, err := r.reload(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stdout.Write([]byte("still\n")); err != nil {
+		t.Errorf("writing after a dry run: %v", err)
+	}
+	if got := readFile(t, log); !strings.HasSuffix(got, "later\nstill\n") {
+		t.Errorf("log = %q", got)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// rssTestConfig has a main task with an rss monitor
+func rssTestConfig(max string) Config {
+	return Config{
+		Env:    map[string]string{},
+		Logger: map[string]string{"output": LoggerOutputSuppress},
+		Task: []ConfigTask{{
+			Name:    "m",
+			Path:    "/bin/sleep",
+			Args:    []string{"60"},
+			Restart: map[string]string{"policy": "never"},
+			Monitors: []map[string]string{
+				{"name": "memory", "type": "rss", "max": max, "period": "1h"},
+			},
+		}},
+	}
+}
+
+func Test_reloaderRSSMonitor(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	cfg := rssTestConfig("1G")
+	files := make(outputFiles)
+	tasks, err := cfg.BuildTasks(logger, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := NewTask(rootTaskName, "")
+	root.Logger = logger
+	root.Stopped = make(chan struct{})
+	m := tasks.All["m"]
+	root.AddDependent(m)
+	r := &reloader{
+		load:   func() (Config, error) { return rssTestConfig("1"), nil },
+		cfg:    &cfg,
+		root:   root,
+		tasks:  &tasks,
+		files:  files,
+		logger: logger,
+	}
+	c := &controller{root: root, tasks: &tasks, logger: logger}
+	defer func() {
+		close(root.Stopped)
+		waitStatus(t, c, "m", func(ts TaskStatus) bool { return ts.Status == statusStopped })
+	}()
+
+	if err := startTask(m); err != nil {
+		t.Fatal(err)
+	}
+	running := func(ts TaskStatus) bool { return ts.Status == statusRunning && ts.PID != 0 }
+	waitStatus(t, c, "m", running)
+	if ev := m.Monitors[0].Child.Test(); ev.Code() != OK.Code() {
+		t.Fatalf("rss under 1G = %v, want OK", ev)
+	}
+
+	// the reload lowers the limit below what any process uses, and the
+	// restarted task's monitor watches its new process
+	plan, err := r.reload(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Restart) != 1 {
+		t.Fatalf("plan = %+v, want m restarted", plan)
+	}
+	waitStatus(t, c, "m", running)
+	if ev := m.Monitors[0].Child.Test(); ev.Code() != Failed {
+		t.Errorf("rss over 1 byte after reload = %v, want a failure", ev)
+	}
+}
+
+// planTestConfig is a tree of tasks of every kind:
+//
+//	a (main) -> b (child) -> c (child)
+//	d (main), which runs p (onetime) first
+//	per (periodic)
+//	sig (signal)
+func planTestConfig() Config {
+	return Config{
+		Env:    map[string]string{"LEVEL": "1"},
+		Logger: map[string]string{"output": LoggerOutputSuppress},
+		Task: []ConfigTask{
+			{Name: "a", Path: "/bin/true"},
+			{Name: "b", Path: "/bin/true", Parent: "a"},
+			{Name: "c", Path: "/bin/true", Parent: "b"},
+			{Name: "p", Path: "/bin/true", Specials: map[string]interface{}{"onetime": true}},
+			{Name: "d", Path: "/bin/true", Prerun: []string{"p"}},
+			{Name: "per", Path: "/bin/true", Specials: map[string]interface{}{"onetime": true, "periodic": "1h"}},
+			{Name: "sig", Path: "/bin/true", Specials: map[string]interface{}{"onetime": true, "signal": "SIGUSR1"}},
+		},
+	}
+}
+
+// configTask finds a task in a config by name
+func configTask(cfg *Config, name string) *ConfigTask {
+	for i := range cfg.Task {
+		if cfg.Task[i].Name == name {
+			return &cfg.Task[i]
+		}
+	}
+	return nil
+}
+
+// treeShape describes the live task tree: each task's kind, parent,
+// dependents, prerun tasks, and args, and the task itself
+type treeShape map[string][]interface{}
+
+func shapeOf(tasks *Tasks) treeShape {
+	shape := make(treeShape)
+	for name, t := range tasks.All {
+		parent := ""
+		if t.parent != nil {
+			parent = t.parent.Name
+		}
+		var deps, prerun []string
+		for _, d := range t.Dependents {
+			deps = append(deps, d.Name)
+		}
+		for _, p := range t.Prerun {
+			prerun = append(prerun, p.Name)
+		}
+		sort.Strings(deps)
+		shape[name] = []interface{}{t, taskKind(t, tasks), parent, deps, prerun, append([]string{}, t.Args...)}
+	}
+	return shape
+}
+
+func Test_reloaderPlan(t *testing.T) {
+	all := []string{"a", "b", "c", "p", "d", "per", "sig"}
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+		want   reloadPlan
+	}{
+		{
+			name:   "nothing changed",
+			change: func(cfg *Config) {},
+			want:   reloadPlan{Unchanged: all},
+		},
+		{
+			name: "added",
+			change: func(cfg *Config) {
+				cfg.Task = append(cfg.Task,
+					ConfigTask{Name: "e", Path: "/bin/true"},
+					ConfigTask{Name: "f", Path: "/bin/true", Parent: "c"},
+				)
+			},
+			want: reloadPlan{Add: []string{"e", "f"}, Unchanged: all},
+		},
+		{
+			name: "removed",
+			change: func(cfg *Config) {
+				cfg.Task = append(cfg.Task[:2], cfg.Task[3:]...)
+			},
+			want: reloadPlan{Remove: []string{"c"}, Unchanged: []string{"a", "b", "p", "d", "per", "sig"}},
+		},
+		{
+			name: "reparented",
+			change: func(cfg *Config) {
+				configTask(cfg, "c").Parent = "a"
+			},
+			want: reloadPlan{
+				Remove:    []string{"c"},
+				Add:       []string{"c"},
+				Unchanged: []string{"a", "b", "p", "d", "per", "sig"},
+			},
+		},
+		{
+			name: "reparented with its dependents",
+			change: func(cfg *Config) {
+				configTask(cfg, "b").Parent = ""
+			},
+			want: reloadPlan{
+				Remove:    []string{"b", "c"},
+				Add:       []string{"b", "c"},
+				Unchanged: []string{"a", "p", "d", "per", "sig"},
+			},
+		},
+		{
+			name: "periodic made main",
+			change: func(cfg *Config) {
+				configTask(cfg, "per").Specials = nil
+			},
+			want: reloadPlan{
+				Remove:    []string{"per"},
+				Add:       []string{"per"},
+				Unchanged: []string{"a", "b", "c", "p", "d", "sig"},
+			},
+		},
+		{
+			name: "signal made periodic",
+			change: func(cfg *Config) {
+				configTask(cfg, "sig").Specials = map[string]interface{}{"onetime": true, "periodic": "1m"}
+			},
+			want: reloadPlan{
+				Remove:    []string{"sig"},
+				Add:       []string{"sig"},
+				Unchanged: []string{"a", "b", "c", "p", "d", "per"},
+			},
+		},
+		{
+			name: "env changes every task",
+			change: func(cfg *Config) {
+				cfg.Env["LEVEL"] = "2"
+			},
+			want: reloadPlan{
+				Restart: []string{"a", "b", "c", "d"},
+				Update:  []string{"p", "per", "sig"},
+			},
+		},
+		{
+			name: "restart takes dependents with it",
+			change: func(cfg *Config) {
+				configTask(cfg, "a").Args = []string{"-v"}
+			},
+			want: reloadPlan{
+				Restart:    []string{"a"},
+				Dependents: []string{"b", "c"},
+				Unchanged:  []string{"b", "c", "p", "d", "per", "sig"},
+			},
+		},
+		{
+			name: "changed prerun changes its task",
+			change: func(cfg *Config) {
+				configTask(cfg, "p").Args = []string{"-v"}
+			},
+			want: reloadPlan{
+				Restart:   []string{"d"},
+				Update:    []string{"p"},
+				Unchanged: []string{"a", "b", "c", "per", "sig"},
+			},
+		},
+		{
+			name: "changed prerun list",
+			change: func(cfg *Config) {
+				configTask(cfg, "d").Prerun = nil
+			},
+			want: reloadPlan{
+				Restart:   []string{"d"},
+				Unchanged: []string{"a", "b", "c", "p", "per", "sig"},
+			},
+		},
+		{
+			name: "sections applied on restart",
+			change: func(cfg *Config) {
+				cfg.Metrics = map[string]string{"listen": ":9100"}
+				configTask(cfg, "sig").Specials["signal"] = "SIGUSR2"
+			},
+			want: reloadPlan{
+				Update:    []string{"sig"},
+				Unchanged: []string{"a", "b", "c", "p", "d", "per"},
+				Notes: []string{
+					"[metrics] changed; restart procmon to apply it",
+					"signal " + syscall.SIGUSR2.String() + " for task sig is not handled until procmon restarts",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, tasks := planTestReloader(t, tt.change)
+			before := shapeOf(tasks)
+			plan, err := r.reload(true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(plan.Notes)
+			if !reflect.DeepEqual(*plan, tt.want) {
+				t.Errorf("plan = %+v\nwant %+v", *plan, tt.want)
+			}
+
+			// a dry run leaves the tree and the config as they were
+			if after := shapeOf(tasks); !reflect.DeepEqual(after, before) {
+				t.Errorf("dry run changed the tree:\n%v\nwas\n%v", after, before)
+			}
+			if !reflect.DeepEqual(*r.cfg, planTestConfig()) {
+				t.Errorf("dry run changed the config to %+v", *r.cfg)
+			}
+			if len(r.root.Dependents) != 2 {
+				t.Errorf("dry run changed the root's dependents to %v", r.root.Dependents)
+			}
+		})
+	}
+
+	// the same reload for real does change the tree
+	t.Run("applied", func(t *testing.T) {
+		r, tasks := planTestReloader(t, func(cfg *Config) {
+			configTask(cfg, "c").Parent = "a"
+		})
+		c := tasks.All["c"]
+		if _, err := r.reload(false); err != nil {
+			t.Fatal(err)
+		}
+		if nc := tasks.All["c"]; nc == c || nc.parent != tasks.All["a"] {
+			t.Errorf("c was not replaced under a: %p (was %p), parent %v", nc, c, nc.parent)
+		}
+		if deps := tasks.All["b"].Dependents; len(deps) != 0 {
+			t.Errorf("b still has dependents %v", deps)
+		}
+	})
+}
+
+// planTestReloader is a reloader of the tasks of planTestConfig, whose
+// config file has been changed by change; none of the tasks is running
+func planTestReloader(t *testing.T, change func(cfg *Config)) (*reloader, *Tasks) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	cfg := planTestConfig()
+	files := make(outputFiles)
+	tasks, err := cfg.BuildTasks(logger, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := NewTask(rootTaskName, "")
+	root.Logger = logger
+	for _, m := range tasks.Main {
+		root.AddDependent(m)
+	}
+	signals := make(map[os.Signal]bool)
+	for sig := range tasks.Signals {
+		signals[sig] = true
+	}
+	r := &reloader{
+		load: func() (Config, error) {
+			next := planTestConfig()
+			change(&next)
+			return next, nil
+		},
+		cfg:     &cfg,
+		root:    root,
+		tasks:   &tasks,
+		files:   files,
+		signals: signals,
+		logger:  logger,
+	}
+	return r, &tasks
+}
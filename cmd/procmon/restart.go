@@ -0,0 +1,239 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Restart policies
+const (
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+	RestartNever     = "never"
+)
+
+// Actions to take when a task is crash-looping
+const (
+	CrashLoopStop     = "stop"
+	CrashLoopStopTree = "stoptree"
+	CrashLoopAlert    = "alert"
+)
+
+// RestartPolicy determines whether and when a task is restarted after it stops.
+//
+// Restarts are delayed by Delay, multiplied by Factor for every consecutive
+// restart up to MaxDelay, and then varied randomly by up to Jitter times
+// the delay. A task that stays up for longer than MaxDelay is considered
+// healthy again, and its next restart is delayed by Delay.
+//
+// If MaxRestarts is nonzero, and the task is restarted more than MaxRestarts
+// times within Window, it is crash-looping: it is not restarted, and the
+// OnLoop action is taken.
+type RestartPolicy struct {
+	Policy      string
+	Delay       time.Duration
+	MaxDelay    time.Duration
+	Factor      float64
+	Jitter      float64
+	MaxRestarts int
+	Window      time.Duration
+	OnLoop      string
+	Alert       *Task
+}
+
+// DefaultRestartPolicy restarts always, backing off from ten seconds to five minutes.
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		Policy:   RestartAlways,
+		Delay:    10 * time.Second,
+		MaxDelay: 5 * time.Minute,
+		Factor:   2,
+		Jitter:   0.1,
+		Window:   10 * time.Minute,
+		OnLoop:   CrashLoopStop,
+	}
+}
+
+// backoff returns the delay before the nth consecutive restart
+func (p RestartPolicy) backoff(n int) time.Duration {
+	d := float64(p.Delay) * math.Pow(p.Factor, float64(n))
+	if d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	d += d * p.Jitter * (2*rand.Float64() - 1)
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
+
+// parseRestartPolicy builds a restart policy from the restart table of a
+// task's config. The default delay is used unless the table sets one.
+// The alert task is resolved by the caller.
+func parseRestartPolicy(m map[string]string, defaultDelay time.Duration) (RestartPolicy, error) {
+	p := DefaultRestartPolicy()
+	var err error
+
+	if m["policy"] != "" {
+		p.Policy = m["policy"]
+	}
+	switch p.Policy {
+	case RestartAlways, RestartOnFailure, RestartNever:
+	default:
+		return p, errors.New("unknown restart policy " + p.Policy)
+	}
+
+	if p.Delay, err = parseDuration(m["delay"], defaultDelay); err != nil {
+		return p, errors.Wrap(err, "restart delay")
+	}
+	if p.MaxDelay, err = parseDuration(m["maxdelay"], p.MaxDelay); err != nil {
+		return p, errors.Wrap(err, "restart maxdelay")
+	}
+	if p.MaxDelay < p.Delay {
+		p.MaxDelay = p.Delay
+	}
+	if p.Window, err = parseDuration(m["window"], p.Window); err != nil {
+		return p, errors.Wrap(err, "restart window")
+	}
+	if m["factor"] != "" {
+		if p.Factor, err = strconv.ParseFloat(m["factor"], 64); err != nil || p.Factor < 1 {
+			return p, errors.New("restart factor must be a number >= 1")
+		}
+	}
+	if m["jitter"] != "" {
+		if p.Jitter, err = strconv.ParseFloat(m["jitter"], 64); err != nil || p.Jitter < 0 || p.Jitter > 1 {
+			return p, errors.New("restart jitter must be a number from 0 to 1")
+		}
+	}
+	if m["maxrestarts"] != "" {
+		if p.MaxRestarts, err = strconv.Atoi(m["maxrestarts"]); err != nil || p.MaxRestarts < 0 {
+			return p, errors.New("restart maxrestarts must be a non-negative integer")
+		}
+	}
+
+	if m["onloop"] != "" {
+		p.OnLoop = m["onloop"]
+	}
+	switch p.OnLoop {
+	case CrashLoopStop, CrashLoopStopTree:
+	case CrashLoopAlert:
+		if m["alert"] == "" {
+			return p, errors.New("restart onloop = alert requires an alert task")
+		}
+	default:
+		return p, errors.New("unknown crash loop action " + p.OnLoop)
+	}
+	return p, nil
+}
+
+// nextRestart decides whether a stopped task should be restarted according
+// to its restart policy, and if so, how long to wait first.
+func (t *Task) nextRestart() (time.Duration, bool) {
+	p := t.Restart
+	logger := t.Logger.WithField("task", t.Name).WithField("policy", p.Policy)
+
+	t.mu.Lock()
+	cleanExit := t.cleanExit
+	started := t.started
+	t.mu.Unlock()
+
+	switch {
+	case p.Policy == RestartNever:
+		logger.Warn("not restarting task")
+		return 0, false
+	case p.Policy == RestartOnFailure && cleanExit:
+		logger.Info("task exited successfully; not restarting")
+		return 0, false
+	}
+
+	now := time.Now()
+	t.mu.Lock()
+	// a task that stayed up for a while is healthy again
+	if !started.IsZero() && now.Sub(started) > p.MaxDelay {
+		t.backoff = 0
+	}
+	delay := p.backoff(t.backoff)
+	t.backoff++
+
+	looping := false
+	if p.MaxRestarts > 0 {
+		recent := t.restarts[:0]
+		for _, r := range t.restarts {
+			if now.Sub(r) < p.Window {
+				recent = append(recent, r)
+			}
+		}
+		t.restarts = append(recent, now)
+		looping = len(t.restarts) > p.MaxRestarts
+	}
+	t.mu.Unlock()
+
+	if looping {
+		t.crashLoop()
+		return 0, false
+	}
+	return delay, true
+}
+
+// crashLoop takes the crash loop action of the task's restart policy.
+// The task itself is held, so that it is not restarted until it is started
+// through the control API.
+func (t *Task) crashLoop() {
+	p := t.Restart
+	t.Logger.WithField("task", t.Name).
+		WithField("restarts", p.MaxRestarts).
+		WithField("window", p.Window).
+		WithField("action", p.OnLoop).
+		Error("task is crash-looping")
+
+	t.mu.Lock()
+	t.crashLooping = true
+	t.held = true
+	t.mu.Unlock()
+
+	switch p.OnLoop {
+	case CrashLoopAlert:
+		tempstop := make(chan struct{})
+		p.Alert.Start(tempstop)
+		close(tempstop)
+	case CrashLoopStopTree:
+		root := t
+		for root.parent != nil {
+			root = root.parent
+		}
+		root.Logger.WithField("task", t.Name).Error("stopping all tasks because of crash loop")
+		// the root's Status channel is watched by main, which shuts
+		// everything down in order and exits
+		requestStop(root)
+	}
+}
+
+// CrashLooping tells if the task was stopped because it was crash-looping.
+func (t *Task) CrashLooping() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.crashLooping
+}
+
+// resetRestarts forgets a task's restart history, as when it is started
+// by hand.
+func (t *Task) resetRestarts() {
+	t.mu.Lock()
+	t.restarts = nil
+	t.backoff = 0
+	t.crashLooping = false
+	t.mu.Unlock()
+}
@@ -0,0 +1,164 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func Test_parseRestartPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       map[string]string
+		wantErr bool
+	}{
+		{"default", nil, false},
+		{"on-failure", map[string]string{"policy": "on-failure"}, false},
+		{"bad policy", map[string]string{"policy": "sometimes"}, true},
+		{"bad delay", map[string]string{"delay": "soon"}, true},
+		{"bad factor", map[string]string{"factor": "0.5"}, true},
+		{"bad jitter", map[string]string{"jitter": "2"}, true},
+		{"bad maxrestarts", map[string]string{"maxrestarts": "-1"}, true},
+		{"alert without task", map[string]string{"onloop": "alert"}, true},
+		{"alert", map[string]string{"onloop": "alert", "alert": "A"}, false},
+		{"bad onloop", map[string]string{"onloop": "panic"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRestartPolicy(tt.m, time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseRestartPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRestartPolicy_backoff(t *testing.T) {
+	p := DefaultRestartPolicy()
+	p.Delay = time.Second
+	p.Jitter = 0
+	p.MaxDelay = 10 * time.Second
+	want := []time.Duration{1, 2, 4, 8, 10, 10}
+	for n, w := range want {
+		if got := p.backoff(n); got != w*time.Second {
+			t.Errorf("backoff(%d) = %v, want %v", n, got, w*time.Second)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(1); got < time.Second || got > 3*time.Second {
+			t.Fatalf("backoff(1) with jitter = %v, want 1s to 3s", got)
+		}
+	}
+}
+
+func TestTask_nextRestart(t *testing.T) {
+	type restart struct {
+		delay time.Duration
+		ok    bool
+	}
+	tests := []struct {
+		name        string
+		policy      string
+		cleanExit   bool
+		maxRestarts int
+		started     time.Duration
+		want        []restart
+		looping     bool
+	}{
+		{"never", RestartNever, false, 0, 0, []restart{{0, false}}, false},
+		{"on-failure clean", RestartOnFailure, true, 0, 0, []restart{{0, false}}, false},
+		{"on-failure failed", RestartOnFailure, false, 0, 0, []restart{{time.Second, true}}, false},
+		{"always backs off", RestartAlways, true, 0, 0,
+			[]restart{{time.Second, true}, {2 * time.Second, true}, {4 * time.Second, true}}, false},
+		{"healthy again", RestartAlways, false, 0, time.Hour,
+			[]restart{{time.Second, true}, {time.Second, true}}, false},
+		{"crash loop", RestartAlways, false, 2, 0,
+			[]restart{{time.Second, true}, {2 * time.Second, true}, {0, false}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := NewTask("a", "/bin/true")
+			task.Logger = logrus.New()
+			task.Restart.Policy = tt.policy
+			task.Restart.Delay = time.Second
+			task.Restart.MaxDelay = time.Minute
+			task.Restart.Jitter = 0
+			task.Restart.MaxRestarts = tt.maxRestarts
+			task.cleanExit = tt.cleanExit
+			for i, w := range tt.want {
+				if tt.started != 0 {
+					task.started = time.Now().Add(-tt.started)
+				}
+				delay, ok := task.nextRestart()
+				if delay != w.delay || ok != w.ok {
+					t.Errorf("restart %d = %v, %v; want %v, %v", i, delay, ok, w.delay, w.ok)
+				}
+			}
+			if task.CrashLooping() != tt.looping {
+				t.Errorf("crashlooping = %v, want %v", task.CrashLooping(), tt.looping)
+			}
+			if task.Held() != tt.looping {
+				t.Errorf("held = %v, want %v", task.Held(), tt.looping)
+			}
+		})
+	}
+}
+
+func TestTask_crashLoop(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	t.Run("alert", func(t *testing.T) {
+		flag := filepath.Join(t.TempDir(), "alerted")
+		alert := NewTask("alert", "/bin/sh", "-c", "touch "+flag)
+		alert.Onetime = true
+		alert.Logger = logger
+		task := NewTask("a", "/bin/true")
+		task.Logger = logger
+		task.Restart.OnLoop = CrashLoopAlert
+		task.Restart.Alert = alert
+		task.crashLoop()
+		if _, err := os.Stat(flag); err != nil {
+			t.Errorf("alert task did not run: %v", err)
+		}
+	})
+
+	t.Run("stoptree", func(t *testing.T) {
+		root := NewTask(rootTaskName, "")
+		root.Logger = logger
+		root.Status = make(chan Eventer, 1)
+		parent := NewTask("p", "/bin/true")
+		task := NewTask("a", "/bin/true")
+		task.Logger = logger
+		task.Restart.OnLoop = CrashLoopStopTree
+		root.AddDependent(parent)
+		parent.AddDependent(task)
+		task.crashLoop()
+		// main shuts everything down when the root is asked to stop
+		select {
+		case e := <-root.Status:
+			if e != Stop {
+				t.Errorf("root got %v, want Stop", e)
+			}
+		default:
+			t.Error("root was not asked to stop")
+		}
+		if !task.Held() {
+			t.Error("crash-looping task is not held")
+		}
+	})
+}
@@ -0,0 +1,454 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+// Periodic tasks are run either at a fixed interval, counted from the end of
+// the previous run:
+//
+//     [task.specials]
+//         periodic = "17s"
+//
+// or on a cron schedule, which follows the wall clock:
+//
+//     [task.specials]
+//         cron = "0 3 * * *"     # minute hour day-of-month month day-of-week
+//         timezone = "UTC"       # the default is the local timezone
+//         jitter = "5m"          # delay each run by a random time up to this
+//         overlap = "skip"       # skip, queue, or kill; see below
+//
+// Cron fields accept *, numbers, names (jan-dec, sun-sat), ranges (1-5),
+// steps (*/15, 1-30/2), and lists of these (1,15,30). As in cron, if both
+// day-of-month and day-of-week are restricted, a day matching either one
+// matches. The descriptors @yearly, @monthly, @weekly, @daily, @midnight,
+// and @hourly are also accepted.
+//
+// If a scheduled run comes due while the previous one is still running,
+// the overlap policy decides what happens:
+//
+// * skip (the default) does not run the task
+// * queue runs the task again when the previous run finishes; at most one
+//   run is queued
+// * kill stops the previous run and then runs the task
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Overlap policies for scheduled tasks
+const (
+	OverlapSkip  = "skip"
+	OverlapQueue = "queue"
+	OverlapKill  = "kill"
+)
+
+// Schedule is a parsed cron expression
+type Schedule struct {
+	Spec     string
+	Location *time.Location
+
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// ParseSchedule parses a five-field cron expression or descriptor, to be
+// evaluated in the given location.
+func ParseSchedule(spec string, loc *time.Location) (*Schedule, error) {
+	expr := strings.TrimSpace(spec)
+	if d, ok := cronDescriptors[strings.ToLower(expr)]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("cron schedule must have 5 fields: " + spec)
+	}
+
+	s := &Schedule{Spec: spec, Location: loc}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, errors.Wrap(err, "minute")
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, errors.Wrap(err, "hour")
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, errors.Wrap(err, "day of month")
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, errors.Wrap(err, "month")
+	}
+	// 7 is also Sunday
+	if s.dow, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, errors.Wrap(err, "day of week")
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseCronField parses one field of a cron expression into a bit set
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	value := func(s string) (int, error) {
+		if n, ok := names[strings.ToLower(s)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, errors.New("bad value " + s)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, errors.New("bad step in " + part)
+			}
+			step = n
+			part = part[:i]
+		}
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err error
+			if lo, err = value(part[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = value(part[i+1:]); err != nil {
+				return 0, err
+			}
+			if hi < lo {
+				return 0, errors.New("bad range " + part)
+			}
+		default:
+			var err error
+			if lo, err = value(part); err != nil {
+				return 0, err
+			}
+			// a single value with a step runs from there to the maximum
+			if step == 1 {
+				hi = lo
+			}
+		}
+		for n := lo; n <= hi; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time after the given time that matches the
+// schedule, or the zero time if there is none within five years.
+func (s *Schedule) Next(after time.Time) time.Time {
+	loc := s.Location
+	t := after.In(loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	// skipping ahead can land in a daylight saving gap, which time.Date
+	// may resolve to an earlier time; then go a minute at a time instead
+	advance := func(next time.Time) {
+		if next.After(t) {
+			t = next
+		} else {
+			t = t.Add(time.Minute)
+		}
+	}
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			advance(time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+		case !s.dayMatches(t):
+			advance(time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			advance(time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc))
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseSchedule builds the cron schedule of a task from its specials;
+// it returns nil if the task has no cron schedule.
+func parseSchedule(specials map[string]interface{}) (*Schedule, error) {
+	spec, _ := specials["cron"].(string)
+	if spec == "" {
+		return nil, nil
+	}
+	loc := time.Local
+	if tz, _ := specials["timezone"].(string); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return nil, errors.Wrap(err, "timezone")
+		}
+	}
+	return ParseSchedule(spec, loc)
+}
+
+// parseOverlap checks the overlap policy of a task
+func parseOverlap(v interface{}) (string, error) {
+	overlap, _ := v.(string)
+	switch overlap {
+	case "":
+		return OverlapSkip, nil
+	case OverlapSkip, OverlapQueue, OverlapKill:
+		return overlap, nil
+	}
+	return "", errors.New("unknown overlap policy " + overlap)
+}
+
+// NextRuns returns the next n times a periodic task is scheduled to run,
+// not counting jitter. For a task with a fixed interval, the times assume
+// each run takes no time.
+func (t *Task) NextRuns(from time.Time, n int) []time.Time {
+	var runs []time.Time
+	for i := 0; i < n; i++ {
+		switch {
+		case t.Schedule != nil:
+			from = t.Schedule.Next(from)
+			if from.IsZero() {
+				return runs
+			}
+		case t.Periodic != 0:
+			from = from.Add(t.Periodic)
+		default:
+			return runs
+		}
+		runs = append(runs, from)
+	}
+	return runs
+}
+
+// nextWait returns how long to wait before the next run of a periodic task,
+// including jitter.
+func (t *Task) nextWait(now time.Time) time.Duration {
+	tasksLock.RLock()
+	runs := t.NextRuns(now, 1)
+	jitter := t.Jitter
+	tasksLock.RUnlock()
+
+	if len(runs) == 0 {
+		// nothing to wait for; check again later in case of a reload
+		return 24 * time.Hour
+	}
+	wait := runs[0].Sub(now)
+	if jitter > 0 {
+		wait += time.Duration(rand.Int63n(int64(jitter)))
+	}
+	t.mu.Lock()
+	t.nextRun = now.Add(wait)
+	t.mu.Unlock()
+	return wait
+}
+
+// NextRun returns when a periodic task is next due to run, or the zero time
+func (t *Task) NextRun() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.nextRun
+}
+
+// scheduledRunner runs a scheduled task according to its overlap policy
+type scheduledRunner struct {
+	task *Task
+	run  func()
+
+	mu      sync.Mutex
+	running bool
+	queued  bool
+	done    chan struct{}
+}
+
+// trigger starts a run of the task, unless the overlap policy says otherwise
+func (s *scheduledRunner) trigger() {
+	t := s.task
+	logger := t.Logger.WithField("task", t.Name)
+
+	s.mu.Lock()
+	if s.running {
+		switch t.Overlap {
+		case OverlapQueue:
+			if s.queued {
+				logger.Warn("previous scheduled run still running and another is queued; skipping")
+			} else {
+				logger.Warn("previous scheduled run still running; queueing")
+				s.queued = true
+			}
+			s.mu.Unlock()
+			return
+		case OverlapKill:
+			done := s.done
+			s.mu.Unlock()
+			logger.Warn("previous scheduled run still running; killing it")
+			t.killRun(done)
+			s.mu.Lock()
+		default:
+			logger.Warn("previous scheduled run still running; skipping")
+			s.mu.Unlock()
+			return
+		}
+	}
+	s.running = true
+	s.done = make(chan struct{})
+	done := s.done
+	s.mu.Unlock()
+
+	go func() {
+		for {
+			s.run()
+			s.mu.Lock()
+			if !s.queued {
+				s.running = false
+				close(done)
+				s.mu.Unlock()
+				return
+			}
+			s.queued = false
+			s.mu.Unlock()
+		}
+	}()
+}
+
+// wait waits until the run in progress, if any, and any run queued after
+// it have finished
+func (s *scheduledRunner) wait() {
+	s.mu.Lock()
+	running, done := s.running, s.done
+	s.mu.Unlock()
+	if running {
+		<-done
+	}
+}
+
+// setRunner records the runner of a periodic task, through which it is run
+// on demand as well as on schedule
+func (t *Task) setRunner(s *scheduledRunner) {
+	t.mu.Lock()
+	t.runner = s
+	t.mu.Unlock()
+}
+
+// periodicRunner returns the runner of a periodic task, or nil
+func (t *Task) periodicRunner() *scheduledRunner {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.runner
+}
+
+// killRun stops the running process of a onetime task, and waits until
+// done is closed
+func (t *Task) killRun(done chan struct{}) {
+	signal := func(sig syscall.Signal) {
+		t.mu.Lock()
+		cmd, pid := t.cmd, t.pid
+		t.mu.Unlock()
+		// the pid is recorded once the process has started
+		if pid != 0 {
+			cmd.Process.Signal(sig)
+		}
+	}
+	signal(t.stopSignal())
+	select {
+	case <-done:
+		return
+	case <-time.After(t.MaxShutdown):
+		t.Logger.WithField("task", t.Name).Warn("scheduled run did not stop; killing it")
+		signal(syscall.SIGKILL)
+	}
+	<-done
+}
+
+// printSchedule writes the next n scheduled runs of each periodic task in
+// the config, without building or starting any tasks.
+func printSchedule(cfg Config, n int, w io.Writer) error {
+	now := time.Now()
+	for _, ct := range cfg.Task {
+		t := &Task{Name: ct.Name}
+		var err error
+		if t.Periodic, err = parseDuration(ct.Specials["periodic"], 0); err != nil {
+			return errors.Wrap(err, ct.Name+": periodic")
+		}
+		if t.Schedule, err = parseSchedule(ct.Specials); err != nil {
+			return errors.Wrap(err, ct.Name+": cron")
+		}
+		if t.Jitter, err = parseDuration(ct.Specials["jitter"], 0); err != nil {
+			return errors.Wrap(err, ct.Name+": jitter")
+		}
+		if t.Overlap, err = parseOverlap(ct.Specials["overlap"]); err != nil {
+			return errors.Wrap(err, ct.Name)
+		}
+
+		switch {
+		case t.Schedule != nil:
+			fmt.Fprintf(w, "%s: cron %q in %s, overlap %s", t.Name, t.Schedule.Spec, t.Schedule.Location, t.Overlap)
+		case t.Periodic != 0:
+			fmt.Fprintf(w, "%s: every %s after the previous run ends", t.Name, t.Periodic)
+		default:
+			continue
+		}
+		if t.Jitter != 0 {
+			fmt.Fprintf(w, ", plus up to %s of jitter", t.Jitter)
+		}
+		fmt.Fprintln(w)
+		runs := t.NextRuns(now, n)
+		if len(runs) == 0 {
+			fmt.Fprintln(w, "    never")
+		}
+		for _, r := range runs {
+			fmt.Fprintf(w, "    %s\n", r.Format("Mon 2006-01-02 15:04:05 MST"))
+		}
+	}
+	return nil
+}
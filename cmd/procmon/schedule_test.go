@@ -0,0 +1,75 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule_Next(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no timezone database")
+	}
+	at := func(loc *time.Location, s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	tests := []struct {
+		spec    string
+		loc     *time.Location
+		after   string
+		want    string
+		wantErr bool
+	}{
+		{"0 3 * * *", time.UTC, "2019-05-01 02:59", "2019-05-01 03:00", false},
+		{"0 3 * * *", time.UTC, "2019-05-01 03:00", "2019-05-02 03:00", false},
+		{"*/15 * * * *", time.UTC, "2019-05-01 10:07", "2019-05-01 10:15", false},
+		{"5/20 * * * *", time.UTC, "2019-05-01 10:46", "2019-05-01 11:05", false},
+		{"0 9 * * mon-fri", time.UTC, "2019-05-03 10:00", "2019-05-06 09:00", false},
+		{"0 0 * * 7", time.UTC, "2019-05-01 00:00", "2019-05-05 00:00", false},
+		{"0 0 13 * fri", time.UTC, "2019-05-01 00:00", "2019-05-03 00:00", false},
+		{"0 0 31 * *", time.UTC, "2019-05-31 00:00", "2019-07-31 00:00", false},
+		{"0 0 29 feb *", time.UTC, "2019-03-01 00:00", "2020-02-29 00:00", false},
+		{"@hourly", time.UTC, "2019-05-01 10:07", "2019-05-01 11:00", false},
+		{"30 2 * * *", ny, "2019-03-10 00:00", "2019-03-11 02:30", false},
+		{"0 3 * * *", ny, "2019-03-10 00:00", "2019-03-10 03:00", false},
+		{"0 0 30 feb *", time.UTC, "2019-01-01 00:00", "", false},
+		{"0 3 * *", time.UTC, "", "", true},
+		{"60 * * * *", time.UTC, "", "", true},
+		{"0 0 * * 3-1", time.UTC, "", "", true},
+		{"*/0 * * * *", time.UTC, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec+" after "+tt.after, func(t *testing.T) {
+			s, err := ParseSchedule(tt.spec, tt.loc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := s.Next(at(tt.loc, tt.after))
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("Next() = %v, want none", got)
+				}
+				return
+			}
+			if want := at(tt.loc, tt.want); !got.Equal(want) {
+				t.Errorf("Next() = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+// When procmon stops a task, it stops the task's children first, and
+// waits for each of them to exit before it stops the task itself; so a
+// task is always stopped before the task it depends on. How each task
+// is stopped can be set:
+//
+//     [[task]]
+//         name = "redis"
+//         path = "redis-server"
+//         stopsignal = "SIGINT"                    # SIGTERM by default
+//         prestop = ["redis-cli -p $PORT save"]    # shell commands run first
+//         maxshutdown = "10s"
+//
+// The prestop commands run in order, each for at most maxshutdown; one
+// that fails is logged but does not keep the task from being stopped.
+// Then the task is sent its stop signal, and if it is still running after
+// maxshutdown, it is killed.
+
+import (
+	"bytes"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// parseStopSignal parses the signal that stops a task; it is SIGTERM if
+// none is given
+func parseStopSignal(s string) (syscall.Signal, error) {
+	switch s {
+	case "":
+		return syscall.SIGTERM, nil
+	case "SIGQUIT", "QUIT":
+		return syscall.SIGQUIT, nil
+	case "SIGKILL", "KILL":
+		return syscall.SIGKILL, nil
+	}
+	if sig, ok := parseSignal(s).(syscall.Signal); ok {
+		return sig, nil
+	}
+	return 0, errors.New("unknown stop signal " + s)
+}
+
+// stopSignal is the signal that asks the task to stop
+func (t *Task) stopSignal() syscall.Signal {
+	if t.StopSignal == 0 {
+		return syscall.SIGTERM
+	}
+	return t.StopSignal
+}
+
+// runPreStop runs the task's pre-stop hooks in order, giving each of them
+// up to MaxShutdown to finish
+func (t *Task) runPreStop() {
+	for _, hook := range t.PreStop {
+		begin := time.Now()
+		cmd := exec.Command("/bin/sh", "-c", hook)
+		cmd.Env = t.Env
+		cmd.Dir = t.Workdir
+		if t.Credential != nil {
+			cred := *t.Credential
+			cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &cred}
+		}
+		out, leftOpen, err := runBounded(cmd, t.MaxShutdown)
+		logger := t.Logger.WithField("task", t.Name).
+			WithField("hook", hook).
+			WithField("duration", time.Since(begin).String())
+		if leftOpen {
+			logger.Warn("pre-stop hook left a process holding its output open")
+		}
+		if err != nil {
+			logger.WithError(err).WithField("output", string(bytes.TrimSpace(out))).Warn("pre-stop hook failed")
+			continue
+		}
+		logger.Info("pre-stop hook finished")
+	}
+}
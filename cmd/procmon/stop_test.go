@@ -0,0 +1,95 @@
+package main
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stopTestTask returns a task which, when it is signalled to stop, waits
+// for delay and then appends its name to log
+func stopTestTask(name, log string, delay time.Duration, logger logrus.FieldLogger) *Task {
+	script := fmt.Sprintf("trap 'sleep %g; echo %s >> %s; exit 0' TERM; while :; do sleep 0.05; done",
+		delay.Seconds(), name, log)
+	t := NewTask(name, "/bin/sh", "-c", script)
+	t.Logger = logger
+	t.Restart.Policy = RestartNever
+	return t
+}
+
+func TestTask_Kill(t *testing.T) {
+	tests := []struct {
+		name        string
+		prestop     string
+		maxshutdown time.Duration
+		want        string
+		within      time.Duration
+	}{
+		// the children take 0.5s each to stop; in parallel, the tree stops
+		// well before the 1s they would take one after the other
+		{"children first", "", time.Second, "a b p", 900 * time.Millisecond},
+		{"prestop before the signal", "echo prestop >> LOG", time.Second, "a b prestop p", 900 * time.Millisecond},
+		{"hanging prestop", "sleep 10; echo prestop >> LOG", 300 * time.Millisecond, "a b p", 1700 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logrus.New()
+			logger.SetLevel(logrus.FatalLevel)
+			log := filepath.Join(t.TempDir(), "stop.log")
+			root := NewTask(rootTaskName, "")
+			root.Logger = logger
+			root.Stopped = make(chan struct{})
+			p := stopTestTask("p", log, 0, logger)
+			p.MaxShutdown = tt.maxshutdown
+			if tt.prestop != "" {
+				p.PreStop = []string{strings.Replace(tt.prestop, "LOG", log, -1)}
+			}
+			a := stopTestTask("a", log, 500*time.Millisecond, logger)
+			b := stopTestTask("b", log, 500*time.Millisecond, logger)
+			root.AddDependent(p)
+			p.AddDependent(a)
+			p.AddDependent(b)
+
+			if err := startTask(p); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 200 && !(a.Running() && b.Running()); i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if !a.Running() || !b.Running() {
+				t.Fatal("the tree never started")
+			}
+
+			begin := time.Now()
+			close(root.Stopped)
+			p.Kill()
+			took := time.Since(begin)
+			p.waitShutdown()
+
+			// the children stop in either order, but both before the parent
+			got := strings.Fields(readFile(t, log))
+			if len(got) >= 2 && got[0] > got[1] {
+				got[0], got[1] = got[1], got[0]
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("stopped %v, want %s", got, tt.want)
+			}
+			if took > tt.within {
+				t.Errorf("stopping took %s, want at most %s", took, tt.within)
+			}
+		})
+	}
+}
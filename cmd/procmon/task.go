@@ -58,78 +58,129 @@ const (
 // A bit of pseudocode:
 //
 // Start (parentstop):
-//     run prefix tasks
-//     run task
-//     wait for task to start
-//     create stop channel
-//     run master monitor(parentstop, stop)
-//     run task exit monitor (stop)
-//     run all other monitors (stop)
-//     run children (stop)
-//     run
+//
+//	run prefix tasks
+//	run task
+//	wait for task to start
+//	create stop channel
+//	run master monitor(parentstop, stop)
+//	run task exit monitor (stop)
+//	run all other monitors (stop)
+//	run children (stop)
+//	run
 //
 // master monitor:
-//     if status gets Stop message
-//         close taskstop
-//         terminate
-//     if parentstop closed, close taskstop, terminate
+//
+//	if status gets Stop message
+//	    close taskstop
+//	    terminate
+//	if parentstop closed, close taskstop, terminate
 //
 // stopMonitor:
-//     if t.Stopped is closed:
-//         kill task
-//         terminate
+//
+//	if t.Stopped is closed:
+//	    kill task
+//	    terminate
 //
 // task exit monitor(status, stop):
-//     if task exits send stop on status
+//
+//	if task exits send stop on status
 //
 // behavior monitors:
-//     if task fails send stop on status
-//     if stop closed terminate
+//
+//	if task fails send stop on status
+//	if stop closed terminate
 //
 // child monitor:
-//     if child's stop is closed:
-//         record it
-//         wait for fallback time
-//         call child.Start() only if parent task is not Stopped
+//
+//	if child's stop is closed:
+//	    record it
+//	    wait for fallback time
+//	    call child.Start() only if parent task is not Stopped
 type Task struct {
-	Name         string
-	Path         string
-	Args         []string
-	Env          []string
-	Onetime      bool
-	Periodic     time.Duration
-	Terminate    bool
-	Shutdown     bool
-	MaxShutdown  time.Duration
-	MaxStartup   time.Duration
-	Status       chan Eventer
-	Stopped      chan struct{}
-	Ready        func() Eventer
-	Stdout       io.Writer
-	Stderr       io.Writer
-	Logger       logrus.FieldLogger
-	FailCount    int
-	RestartDelay time.Duration
-	Monitors     []*FailMonitor
-	Prerun       []*Task
-	Dependents   []*Task
-
-	cmd   *exec.Cmd
-	dying bool
+	Name        string
+	Path        string
+	Args        []string
+	Env         []string
+	Onetime     bool
+	Periodic    time.Duration
+	Schedule    *Schedule
+	Jitter      time.Duration
+	Overlap     string
+	Terminate   bool
+	Shutdown    bool
+	MaxShutdown time.Duration
+	MaxStartup  time.Duration
+	StopSignal  syscall.Signal
+	PreStop     []string
+	Status      chan Eventer
+	Stopped     chan struct{}
+	Ready       func() Eventer
+	Stdout      io.Writer
+	Stderr      io.Writer
+	Output      OutputOptions
+	Workdir     string
+	Credential  *syscall.Credential
+	Limits      Limits
+	Logger      logrus.FieldLogger
+	// FailCount is guarded by mu
+	FailCount  int
+	Restart    RestartPolicy
+	Monitors   []*FailMonitor
+	Prerun     []*Task
+	Dependents []*Task
+
+	cmd     *exec.Cmd
+	parent  *Task
+	recent  *ringBuffer
+	pipes   []*os.File
+	copying *sync.WaitGroup
+
+	// mu guards the fields below, which the control API reads and sets,
+	// and cmd, Status, Stopped and FailCount when they are set
+	mu           sync.Mutex
+	pid          int
+	exitState    *os.ProcessState
+	done         chan struct{}
+	restartNow   bool
+	inRun        int
+	retired      []io.Writer
+	pending      *Task
+	successor    *Task
+	runner       *scheduledRunner
+	dying        bool
+	killed       chan struct{}
+	started      time.Time
+	held         bool
+	runs         runStats
+	cleanExit    bool
+	backoff      int
+	restarts     []time.Time
+	crashLooping bool
+	nextRun      time.Time
+}
+
+// runStats records the runs of a onetime task
+type runStats struct {
+	count    int
+	total    time.Duration
+	last     time.Duration
+	exitCode int
 }
 
 // NewTask creates a Task (but does not start it)
 // The default Ready() function simply returns true
 func NewTask(name string, path string, args ...string) *Task {
 	return &Task{
-		Name:         name,
-		Path:         path,
-		Args:         args,
-		MaxStartup:   10 * time.Second,
-		MaxShutdown:  5 * time.Second,
-		Ready:        func() Eventer { return OK },
-		Monitors:     make([]*FailMonitor, 0),
-		RestartDelay: time.Second,
+		Name:        name,
+		Path:        path,
+		Args:        args,
+		MaxStartup:  10 * time.Second,
+		MaxShutdown: 5 * time.Second,
+		StopSignal:  syscall.SIGTERM,
+		Ready:       func() Eventer { return OK },
+		Monitors:    make([]*FailMonitor, 0),
+		Restart:     DefaultRestartPolicy(),
 	}
 }
 
@@ -138,19 +189,20 @@ func NewTask(name string, path string, args ...string) *Task {
 // is the only place the t.Stopped channel is closed.
 // If the parent task's Stopped channel is closed,
 // it closes this task's Stopped channel also.
-func (t *Task) masterMonitor(parentstop chan struct{}) {
+// The channels are those of the run which started it.
+func (t *Task) masterMonitor(parentstop chan struct{}, status chan Eventer, stopped chan struct{}) {
 	for {
 		select {
 		case <-parentstop:
 			t.Logger.WithField("task", t.Name).Info("parent task stopped; shutting down")
-			close(t.Stopped)
+			close(stopped)
 			time.Sleep(50 * time.Millisecond)
 			return
-		case e := <-t.Status:
+		case e := <-status:
 			t.Logger.WithField("status", e).WithField("task", t.Name).Debug("event")
 			if e == Stop {
 				t.Logger.WithField("task", t.Name).Warn("received Stop message; shutting down")
-				close(t.Stopped)
+				close(stopped)
 				time.Sleep(50 * time.Millisecond)
 				return
 			}
@@ -162,61 +214,84 @@ func (t *Task) masterMonitor(parentstop chan struct{}) {
 // and then sends the Stop message on its Status channel.
 // It should be launched as a goroutine and will not
 // terminate until the task does.
-func (t *Task) exitMonitor() {
-	// make a copy of the Status chan so
-	// if it gets recreated we don't send a message on
-	// the new one by mistake
-	status := t.Status
-	err := t.cmd.Wait()
+// It is given the command and Status chan of the run which started it,
+// so that if they get recreated we don't wait on or send a message on
+// the new ones by mistake.
+// It closes waited once it is done with the task's state.
+func (t *Task) exitMonitor(cmd *exec.Cmd, status chan Eventer, waited chan struct{}) {
+	err := cmd.Wait()
+	// a task that we didn't kill and that exited with status 0 didn't fail
+	t.mu.Lock()
+	dying := t.dying
+	t.cleanExit = err == nil && !dying
+	t.exitState = cmd.ProcessState
+	t.mu.Unlock()
 	if err != nil {
 		t.Logger.WithField("task", t.Name).WithError(err).Error("task terminated")
+		if !dying {
+			t.reportCrash(err)
+		}
 	} else {
 		t.Logger.WithField("task", t.Name).Warn("terminated")
 	}
+	close(waited)
 	status <- Stop
 }
 
 // The childMonitor is given a child task;
 // If the child task's Stopped channel is closed
 // before the current task, childMonitor:
-// * waits the child's startDelay amount of time
-// * increases the child's startDelay (to try to slow down
-//   a task that's flapping)
+// * asks the child's restart policy whether and when to restart it
+// * waits that long
 // * call child.Start()
-// If the current task's Stopped channel is closed, this
-// monitor terminates
-// It also reduces restart time if a task is well-behaved after having failed.
+// If the current task's Stopped channel is closed, or the child
+// is not to be restarted, this monitor terminates
 func (t *Task) childMonitor(child *Task) {
-	// allow the default restart delay to be overridden in the environment
-	var defaultRestartDelay = 10 * time.Second
-	if s := os.Getenv("DEFAULT_RESTART_DELAY"); s != "" {
-		if drd, err := time.ParseDuration(s); err == nil {
-			defaultRestartDelay = drd
-		}
-	}
 	for {
 		select {
-		case <-time.After(t.RestartDelay):
-			// we double the RestartDelay every time the task restarts,
-			// and we reduce it asymptotically to the default value
-			// as the task lives longer without restarting
-			t.RestartDelay = defaultRestartDelay +
-				((t.RestartDelay-defaultRestartDelay)*9)/10
 		case <-t.Stopped:
 			return
 		case <-child.Stopped:
+			// a task that is being killed has stopped its children on
+			// purpose
+			if t.stopping() {
+				return
+			}
+			// a child stopped through the control API stays stopped
+			// until it is started again, which starts a new childMonitor
+			if child.Held() {
+				t.Logger.WithField("task", t.Name).WithField("child", child.Name).
+					Info("childmonitor detected child stop but child is held")
+				return
+			}
+			// the child's last run must be shut down before it is started
+			// again
+			child.waitShutdown()
+			// a restart asked for through the control API is not a
+			// failure, so it skips the restart policy
+			manual := child.takeRestartNow()
+			delay, ok := time.Duration(0), true
+			if !manual {
+				delay, ok = child.nextRestart()
+			}
+			if !ok {
+				return
+			}
 			// we want to delay for the sleep time but
 			// we don't want to miss it if our task is stopped
 			// because we don't want to restart the child
 			// if its parent is restarting
 			select {
-			case <-time.After(child.RestartDelay):
-				child.FailCount++
-				child.RestartDelay *= 2
+			case <-time.After(delay):
+				// it may have been held while we waited
+				if child.Held() || t.stopping() {
+					return
+				}
+				failcount := child.countFailure(!manual)
 				// this will replace the child's Stopped channel
 				t.Logger.WithField("task", t.Name).WithField("child", child.Name).
-					WithField("delay", child.RestartDelay).
-					WithField("failcount", child.FailCount).
+					WithField("delay", delay).
+					WithField("failcount", failcount).
 					Debugf("childmonitor restarting child")
 				child.Start(t.Stopped)
 			case <-t.Stopped:
@@ -229,45 +304,124 @@ func (t *Task) childMonitor(child *Task) {
 }
 
 // stopMonitor is the one that listens to the Stopped channel
-// and shuts the task down when it's stopped
-func (t *Task) stopMonitor() {
+// and shuts the task down when it's stopped. It closes done once the
+// task has been shut down and its exitMonitor is finished.
+func (t *Task) stopMonitor(stopped, waited, done chan struct{}) {
 	select {
-	case <-t.Stopped:
+	case <-stopped:
 		t.Logger.WithField("task", t.Name).Info("Stopped channel closed; killing task")
 		t.Kill()
+		<-waited
+		t.endRun()
+		close(done)
 		return
 	}
 }
 
+// waitShutdown waits until the task's last run has been shut down.
+func (t *Task) waitShutdown() {
+	t.mu.Lock()
+	done := t.done
+	t.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// setOutputStreams connects the task's output to its writers through
+// pipes, so that it can be split into lines, prefixed, and remembered for
+// the crash report.
 func (t *Task) setOutputStreams() {
-	// streamCopy is meant to be run as a goroutine and it simply runs, copying
-	// src to dst until src is closed. It's used for logging.
-	streamCopy := func(dst io.Writer, src io.Reader) {
-		// we want a small buffer so it keeps the output current with the input
-		buf := make([]byte, 100)
-		// copy until we got nothing left
-		io.CopyBuffer(dst, src, buf)
+	if t.recent == nil || len(t.recent.lines) != t.Output.Buffer {
+		t.recent = newRingBuffer(t.Output.Buffer)
 	}
+	t.recent.reset()
+	t.pipes = nil
+	// the copying of a run's output can outlast the run, so each run has
+	// its own WaitGroup
+	copying := &sync.WaitGroup{}
+	t.copying = copying
 
-	if t.Stdout != nil {
-		pipe, err := t.cmd.StdoutPipe()
+	prefix := ""
+	if t.Output.Prefix {
+		prefix = t.Name
+	}
+	// capture copies a pipe to dst until the process and its children
+	// have closed it, and returns the end of the pipe for the process
+	capture := func(stream string, dst io.Writer) *os.File {
+		pr, pw, err := os.Pipe()
 		if err != nil {
-			t.Logger.WithField("task", t.Name).WithError(err).Error("could not construct stdout pipe")
-		} else {
-			go streamCopy(t.Stdout, pipe)
+			t.Logger.WithField("task", t.Name).WithError(err).Error("could not construct " + stream + " pipe")
+			return nil
 		}
+		lw := &lineWriter{dst: dst, prefix: prefix, stream: stream, recent: t.recent}
+		t.pipes = append(t.pipes, pw)
+		copying.Add(1)
+		go func() {
+			// we want a small buffer so it keeps the output current with the input
+			io.CopyBuffer(lw, pr, make([]byte, 100))
+			lw.Flush()
+			pr.Close()
+			copying.Done()
+		}()
+		return pw
 	}
 
+	if t.Stdout != nil {
+		if pw := capture("stdout", t.Stdout); pw != nil {
+			t.cmd.Stdout = pw
+		}
+	}
 	if t.Stderr != nil {
-		pipe, err := t.cmd.StderrPipe()
-		if err != nil {
-			t.Logger.WithField("task", t.Name).WithError(err).Error("could not construct stderr pipe")
-		} else {
-			go streamCopy(t.Stderr, pipe)
+		if pw := capture("stderr", t.Stderr); pw != nil {
+			t.cmd.Stderr = pw
 		}
 	}
 }
 
+// closePipes closes our copies of the ends of the output pipes that the
+// process writes to, once it has started (or failed to)
+func (t *Task) closePipes() {
+	for _, pw := range t.pipes {
+		pw.Close()
+	}
+	t.pipes = nil
+}
+
+// waitCopying waits briefly for the last of a task's output to be copied
+// to its writers
+func (t *Task) waitCopying() {
+	copying := t.copying
+	if copying == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		copying.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+}
+
+// reportCrash waits briefly for the last of a task's output, and then
+// writes the lines it remembers to procmon's log
+func (t *Task) reportCrash(err error) {
+	t.waitCopying()
+
+	lines := t.recent.Lines()
+	if len(lines) == 0 {
+		return
+	}
+	logger := t.Logger.WithField("task", t.Name)
+	logger.WithError(err).WithField("lines", len(lines)).Error("task failed; its recent output follows")
+	for _, line := range lines {
+		logger.Warn(line)
+	}
+}
+
 // Start begins a new version of the task.
 func (t *Task) Start(parentstop chan struct{}) {
 	// run the prerun tasks first
@@ -281,7 +435,23 @@ func (t *Task) Start(parentstop chan struct{}) {
 	}
 
 	t.Logger.WithField("task", t.Name).Info("Starting")
+	// the run ends here unless the task is left running, in which case its
+	// stopMonitor ends it
+	t.beginRun()
+	leftRunning := false
+	defer func() {
+		if !leftRunning {
+			t.endRun()
+		}
+	}()
+	t.mu.Lock()
+	t.started = time.Time{}
+	t.pid = 0
+	t.restartNow = false
+	failcount := t.FailCount
 	t.cmd = exec.Command(t.Path, t.Args...)
+	t.exitState = nil
+	t.mu.Unlock()
 	t.setOutputStreams()
 
 	// start the task and wait for it to be ready
@@ -289,17 +459,32 @@ func (t *Task) Start(parentstop chan struct{}) {
 	t.Logger.WithField("task", t.Name).
 		WithField("path", t.Path).
 		WithField("args", t.Args).
-		WithField("failcount", t.FailCount).
+		WithField("failcount", failcount).
 		Debug("task info")
 	t.cmd.Env = t.Env
+	if err := t.prepareCommand(); err != nil {
+		t.closePipes()
+		t.Logger.WithField("task", t.Name).WithError(err).Error("errored on startup")
+		return
+	}
+	t.mu.Lock()
 	t.dying = false
+	t.mu.Unlock()
 
 	// if it's a onetime task, just run it and be done
 	if t.Onetime {
 		t.Logger.WithField("task", t.Name).Debug("running onetime task")
-		err := t.cmd.Run()
+		begin := time.Now()
+		err := t.cmd.Start()
+		t.closePipes()
+		if err == nil {
+			t.setPID()
+			err = t.cmd.Wait()
+		}
+		t.recordRun(time.Since(begin))
 		if err != nil {
 			t.Logger.WithField("task", t.Name).WithError(err).Error("onetime task failed")
+			t.reportCrash(err)
 		} else {
 			t.Logger.WithField("task", t.Name).Debug("onetime task succeeded")
 		}
@@ -307,10 +492,12 @@ func (t *Task) Start(parentstop chan struct{}) {
 	}
 
 	err := t.cmd.Start()
+	t.closePipes()
 	if err != nil {
 		t.Logger.WithField("task", t.Name).WithError(err).Error("errored on startup")
 		return
 	}
+	t.setPID()
 
 	if t.cmd != nil && t.cmd.Process != nil {
 		t.Logger.WithField("task", t.Name).WithField("pid", t.cmd.Process.Pid).WithField("task", t.Name).Info("waiting for ready")
@@ -333,18 +520,26 @@ func (t *Task) Start(parentstop chan struct{}) {
 		}
 	}
 	t.Logger.WithField("task", t.Name).WithField("pid", t.cmd.Process.Pid).Debug("task started and is ready")
+	// now we need the Status channel, a Stopped channel, and a channel
+	// to tell when this run has been shut down
+	status := make(chan Eventer, 1)
+	stopped := make(chan struct{})
+	waited := make(chan struct{})
+	done := make(chan struct{})
+	t.mu.Lock()
+	t.started = time.Now()
+	t.Status = status
+	t.Stopped = stopped
+	t.done = done
+	t.mu.Unlock()
 
-	// now we need the Status channel
-	t.Status = make(chan Eventer, 1)
-	// make a Stopped channel
-	t.Stopped = make(chan struct{})
-
+	leftRunning = true
 	// run the masterMonitor
-	go t.masterMonitor(parentstop)
+	go t.masterMonitor(parentstop, status, stopped)
 	// spin off a goroutine that will tell us if it dies
-	go t.exitMonitor()
+	go t.exitMonitor(t.cmd, status, waited)
 	// finally, we need to start a monitor to listen to the status channel
-	go t.stopMonitor()
+	go t.stopMonitor(stopped, waited, done)
 	// the task is running now, start all the behavior monitors
 	t.startBehaviorMonitors()
 	// now we can start any dependent children
@@ -361,6 +556,10 @@ func (t *Task) StartChildren() {
 	for _, ch := range t.Dependents {
 		// copy ch
 		ch := ch
+		if ch.Held() {
+			t.Logger.WithField("task", t.Name).WithField("child", ch.Name).Info("not starting held child")
+			continue
+		}
 		wg.Add(1)
 		go func() {
 			ch.Start(t.Stopped)
@@ -375,62 +574,97 @@ func (t *Task) StartChildren() {
 
 // startBehaviorMonitors starts all the monitors
 // that watch the task for bad behavior
+// The task's Status channel is new for every run, so the monitors
+// are pointed at it here.
 func (t *Task) startBehaviorMonitors() {
 	for _, m := range t.Monitors {
+		m.Status = t.Status
 		go m.Listen(t.Stopped)
 	}
 	t.Logger.WithField("task", t.Name).WithField("monitorcount", len(t.Monitors)).Debug("behavior monitors started")
 }
 
 // waitForShutdown assumes the task has already begun shutdown and
-// that we just have to wait for it.
-func (t *Task) waitForShutdown() {
-	looptime := t.MaxShutdown / 64
-	looptimer := time.NewTimer(looptime)
+// that we just have to wait for it. It returns false if the task had to
+// be destroyed because it took longer than MaxShutdown.
+func (t *Task) waitForShutdown() bool {
+	clean := true
+	// check often, as a parent is only stopped once its children are
+	loopticker := time.NewTicker(50 * time.Millisecond)
 	toolong := time.NewTimer(t.MaxShutdown)
 	for !t.Exited() {
 		select {
-		case <-looptimer.C:
+		case <-loopticker.C:
 			// go check again
-			looptime *= 2
-			looptimer.Reset(looptime)
 		case <-toolong.C:
 			t.Logger.WithField("task", t.Name).Error("did not shut down nicely, killing it")
 			t.Destroy()
+			clean = false
 		}
 	}
-	looptimer.Stop()
+	loopticker.Stop()
 	toolong.Stop()
+	return clean
 }
 
-// Kill ends a running task and all of its children
-// In a properly functioning system, child tasks should
-// have already been notified, but this will ensure
-// that they shut down and that we wait for it
+// Kill ends a running task and all of its children.
+// The children are stopped first, and each of them is fully stopped
+// before its parent is, so that no task loses something it depends on
+// while it is shutting down. If the task is already being stopped, Kill
+// waits for that to finish.
 func (t *Task) Kill() {
-	// if we're already shutting down, just wait for it
+	t.mu.Lock()
 	if t.dying {
-		t.waitForShutdown()
+		killed := t.killed
+		t.mu.Unlock()
+		if killed != nil {
+			<-killed
+		}
 		return
 	}
-
 	// record that we're stopping
 	t.dying = true
+	t.killed = make(chan struct{})
+	killed := t.killed
+	t.mu.Unlock()
+	defer close(killed)
+
 	t.Logger.WithField("task", t.Name).Warn("starting to kill process")
 	t.killDependents()
+	if t.cmd == nil {
+		// a placeholder, like the root task, has no process of its own
+		return
+	}
+	begin := time.Now()
+	outcome := "already exited"
 	if !t.Exited() {
 		t.Logger.WithField("task", t.Name).Info("shutting down")
-		t.cmd.Process.Signal(syscall.SIGTERM)
-		t.waitForShutdown()
+		t.runPreStop()
+		t.cmd.Process.Signal(t.stopSignal())
+		outcome = "stopped"
+		if !t.waitForShutdown() {
+			outcome = "killed"
+		}
 	}
-	t.Logger.WithField("task", t.Name).Debug("done killing")
-	return
+	t.Logger.WithField("task", t.Name).
+		WithField("signal", t.stopSignal().String()).
+		WithField("outcome", outcome).
+		WithField("duration", time.Since(begin).String()).
+		Info("task stopped")
+}
+
+// stopping tells if the task is being killed
+func (t *Task) stopping() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dying
 }
 
 // killDependents ends the dependents of a running task
 // but doesn't touch the task itself.
-// The tasks are killed in parallel and this function only returns when
-// they have all died.
+// The dependents don't depend on each other, so they are stopped in
+// parallel, and this function only returns when they and all of their own
+// dependents have stopped.
 func (t *Task) killDependents() {
 	if len(t.Dependents) == 0 {
 		return
@@ -449,36 +683,374 @@ func (t *Task) killDependents() {
 	return
 }
 
+// exited tells if a task has terminated, without logging about it
+func (t *Task) exited() bool {
+	t.mu.Lock()
+	cmd, state := t.cmd, t.exitState
+	t.mu.Unlock()
+	if cmd == nil || cmd.Process == nil || state != nil {
+		return true
+	}
+	return cmd.Process.Signal(syscall.Signal(0)) != nil
+}
+
+// treeExited tells if a task and all of its dependents have terminated
+func (t *Task) treeExited() bool {
+	for _, ch := range t.Dependents {
+		if !ch.treeExited() {
+			return false
+		}
+	}
+	return t.exited()
+}
+
+// shutdownTime is the longest a task and its dependents should take to shut down
+func (t *Task) shutdownTime() time.Duration {
+	d := t.MaxShutdown
+	for _, ch := range t.Dependents {
+		d += ch.shutdownTime()
+	}
+	return d
+}
+
+// stopAndWait holds a task, stops it if it is running, and waits for it and
+// its dependents to exit.
+func (t *Task) stopAndWait() {
+	t.setHeld(true)
+	if !t.Running() {
+		return
+	}
+	requestStop(t)
+	<-t.Stopped
+	deadline := time.Now().Add(t.shutdownTime() + time.Second)
+	for !t.treeExited() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
 // Exited tells if a task has terminated for any reason
 func (t *Task) Exited() bool {
-	if t.cmd == nil {
+	t.mu.Lock()
+	cmd, state := t.cmd, t.exitState
+	t.mu.Unlock()
+	if cmd == nil {
 		return true
 	}
-	if t.cmd.ProcessState == nil {
-		if t.cmd.Process == nil {
+	if state == nil {
+		if cmd.Process == nil {
 			t.Logger.WithField("task", t.Name).Error("process was nil")
 			return true
 		}
-		if err := t.cmd.Process.Signal(syscall.Signal(0)); err != nil {
+		if err := cmd.Process.Signal(syscall.Signal(0)); err != nil {
 			t.Logger.WithField("task", t.Name).Error("process did not respond")
 			return true
 		}
 		return false
 	}
-	b := t.cmd.ProcessState.Exited()
-	if b {
-		t.Logger.WithField("task", t.Name).Warn("process exited")
-	}
-	return b
+	// ProcessState.Exited() is false for a process terminated by a signal,
+	// but once there is a ProcessState the process has been waited for
+	t.Logger.WithField("task", t.Name).WithField("state", state).Warn("process exited")
+	return true
 }
 
 // AddDependent adds a new dependent task. If this task terminates, all dependent tasks
 // will also be terminated. Similarly, when this task is started, after the task
 // is alive, its children will also be started.
 func (t *Task) AddDependent(ch *Task) {
+	ch.parent = t
 	t.Dependents = append(t.Dependents, ch)
 }
 
+// removeDependent undoes AddDependent
+func (t *Task) removeDependent(ch *Task) {
+	for i, d := range t.Dependents {
+		if d == ch {
+			t.Dependents = append(t.Dependents[:i:i], t.Dependents[i+1:]...)
+			return
+		}
+	}
+}
+
+// updateFrom replaces a task's definition with that of another task,
+// built from a reloaded config. The task's place in the tree and its
+// state are kept; its prerun and alert tasks are resolved by the caller.
+// If the task is being run, it is updated when the run ends.
+func (t *Task) updateFrom(nt *Task) {
+	t.mu.Lock()
+	if t.inRun > 0 {
+		superseded := t.pending
+		t.pending = nt
+		t.mu.Unlock()
+		if superseded != nil {
+			superseded.closeOutputs()
+		}
+		t.Logger.WithField("task", t.Name).Info("task is running; it will be updated when the run ends")
+		return
+	}
+	t.mu.Unlock()
+	t.applyUpdate(nt)
+}
+
+// applyUpdate does the work of updateFrom
+func (t *Task) applyUpdate(nt *Task) {
+	t.closeOutputs()
+	t.Path = nt.Path
+	t.Args = nt.Args
+	t.Env = nt.Env
+	t.Onetime = nt.Onetime
+	t.Periodic = nt.Periodic
+	t.Schedule = nt.Schedule
+	t.Jitter = nt.Jitter
+	t.Overlap = nt.Overlap
+	t.Terminate = nt.Terminate
+	t.Shutdown = nt.Shutdown
+	t.MaxShutdown = nt.MaxShutdown
+	t.StopSignal = nt.StopSignal
+	t.PreStop = nt.PreStop
+	t.MaxStartup = nt.MaxStartup
+	t.Ready = nt.Ready
+	t.Stdout = nt.Stdout
+	t.Stderr = nt.Stderr
+	t.Output = nt.Output
+	t.Workdir = nt.Workdir
+	t.Credential = nt.Credential
+	t.Limits = nt.Limits
+	t.Logger = nt.Logger
+	t.Restart = nt.Restart
+	t.Monitors = nt.Monitors
+	t.Prerun = nt.Prerun
+
+	// nt's monitors watch nt's process, which is now t's
+	nt.mu.Lock()
+	nt.successor = t
+	nt.mu.Unlock()
+}
+
+// closeOutputs closes any files the task's output is written to
+func (t *Task) closeOutputs() {
+	closeWriters(t.Stdout, t.Stderr)
+}
+
+// closeWriters closes those of ws which are files, other than procmon's
+// own stdout and stderr
+func closeWriters(ws ...io.Writer) {
+	for _, w := range ws {
+		if f, ok := w.(*os.File); ok && (f == os.Stdout || f == os.Stderr) {
+			continue
+		}
+		if c, ok := w.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// retireOutputs closes the files the task's output is written to, or if
+// the task is being run, has them closed when the run ends. It is used
+// for a task which a reload removes.
+func (t *Task) retireOutputs() {
+	t.mu.Lock()
+	if t.inRun > 0 {
+		t.retired = append(t.retired, t.Stdout, t.Stderr)
+		t.mu.Unlock()
+		return
+	}
+	t.mu.Unlock()
+	t.closeOutputs()
+}
+
+// beginRun records that a run of the task has begun
+func (t *Task) beginRun() {
+	t.mu.Lock()
+	t.inRun++
+	t.mu.Unlock()
+}
+
+// endRun records that a run of the task has ended. Once no run is left,
+// it applies any update made by a reload while the task was running, and
+// closes the outputs which were retired meanwhile.
+func (t *Task) endRun() {
+	t.mu.Lock()
+	t.inRun--
+	var retired []io.Writer
+	var pending *Task
+	if t.inRun == 0 {
+		retired, t.retired = t.retired, nil
+		pending, t.pending = t.pending, nil
+	}
+	t.mu.Unlock()
+	if len(retired) == 0 && pending == nil {
+		return
+	}
+	// the last of the run's output may still be on its way
+	t.waitCopying()
+	closeWriters(retired...)
+	if pending != nil {
+		tasksLock.Lock()
+		t.applyUpdate(pending)
+		tasksLock.Unlock()
+		t.Logger.WithField("task", t.Name).Info("task updated after its run")
+	}
+}
+
+// Held tells if a task was stopped through the control API, and should
+// not be restarted until it is started again.
+func (t *Task) Held() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.held
+}
+
+func (t *Task) setHeld(held bool) {
+	t.mu.Lock()
+	t.held = held
+	t.mu.Unlock()
+}
+
+// recordRun records the duration and exit code of a onetime task's run.
+// A task which could not be run has exit code -1.
+func (t *Task) recordRun(d time.Duration) {
+	code := -1
+	if t.cmd.ProcessState != nil {
+		code = t.cmd.ProcessState.ExitCode()
+	}
+	t.mu.Lock()
+	t.runs.count++
+	t.runs.total += d
+	t.runs.last = d
+	t.runs.exitCode = code
+	t.mu.Unlock()
+}
+
+// Runs returns the statistics for a onetime task's runs.
+func (t *Task) Runs() runStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.runs
+}
+
+// Started returns the time at which the task last became ready.
+func (t *Task) Started() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.started
+}
+
+// Running tells if the task has been started and has not yet been stopped.
+func (t *Task) Running() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.running()
+}
+
+// running is Running for callers which hold t.mu
+func (t *Task) running() bool {
+	if t.Stopped == nil {
+		return false
+	}
+	select {
+	case <-t.Stopped:
+		return false
+	default:
+		return true
+	}
+}
+
+// PID returns the task's process id, or 0 if it has no process.
+func (t *Task) PID() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pid
+}
+
+// monitoredPID returns the process id for a task's monitors to watch. A
+// task built by a reload is never started; its settings are applied to the
+// running task, and then its monitors watch that task's process.
+func (t *Task) monitoredPID() int {
+	t.mu.Lock()
+	successor := t.successor
+	t.mu.Unlock()
+	if successor != nil {
+		return successor.monitoredPID()
+	}
+	return t.PID()
+}
+
+// setPID records the process id of a task which has just been started
+func (t *Task) setPID() {
+	t.mu.Lock()
+	t.pid = t.cmd.Process.Pid
+	t.mu.Unlock()
+}
+
+// countFailure counts a restart of the task if it was a failure, and
+// returns the task's failure count
+func (t *Task) countFailure(failed bool) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if failed {
+		t.FailCount++
+	}
+	return t.FailCount
+}
+
+// statusChan returns the task's current Status channel
+func (t *Task) statusChan() chan Eventer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Status
+}
+
+// stoppedChan returns the task's current Stopped channel
+func (t *Task) stoppedChan() chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Stopped
+}
+
+// setRestartNow asks that the task be restarted at once when it next
+// stops, regardless of its restart policy
+func (t *Task) setRestartNow() {
+	t.mu.Lock()
+	t.restartNow = true
+	t.mu.Unlock()
+}
+
+// takeRestartNow tells if an immediate restart was asked for, and clears
+// the request
+func (t *Task) takeRestartNow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.restartNow
+	t.restartNow = false
+	return now
+}
+
+// taskState is a consistent view of a task's state, for reporting
+type taskState struct {
+	running      bool
+	held         bool
+	crashLooping bool
+	failCount    int
+	pid          int
+	started      time.Time
+	nextRun      time.Time
+}
+
+// state returns the task's current state
+func (t *Task) state() taskState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return taskState{
+		running:      t.running(),
+		held:         t.held,
+		crashLooping: t.crashLooping,
+		failCount:    t.FailCount,
+		pid:          t.pid,
+		started:      t.started,
+		nextRun:      t.nextRun,
+	}
+}
+
 // Destroy does an os-level kill on a task and all its dependents
 // Use only as a last resort.
 func (t *Task) Destroy() {
@@ -6,19 +6,18 @@ import (
 	"os"
 
 	"github.com/alexflint/go-arg"
-	"github.com/ndau/msgp-well-known-types/wkt"
-	"github.com/ndau/ndaumath/pkg/address"
+	"github.com/ndau/commands/cmd/sysvar/sysvar"
 	math "github.com/ndau/ndaumath/pkg/types"
 )
 
 type args struct {
-	Address  []address.Address `arg:"-a,separate" help:"encode this ndau address"`
-	Bytes    []string          `arg:"-b,separate" help:"encode these base64'd bytes (i.e. chaincode)"`
-	Duration []math.Duration   `arg:"-d,separate" help:"encode this duration"`
-	Int64    []int64           `arg:"-i,separate" help:"encode this signed integer"`
-	Ndau     []math.Ndau       `arg:"-n,separate" help:"encode this qty of ndau"`
-	String   []string          `arg:"-s,separate" help:"encode this string"`
-	Uint64   []uint64          `arg:"-u,separate" help:"encode this unsigned integer"`
+	Address  []string    `arg:"-a,separate" help:"encode this ndau address"`
+	Bytes    []string    `arg:"-b,separate" help:"encode these base64'd bytes (i.e. chaincode)"`
+	Duration []string    `arg:"-d,separate" help:"encode this duration"`
+	Int64    []string    `arg:"-i,separate" help:"encode this signed integer"`
+	Ndau     []math.Ndau `arg:"-n,separate" help:"encode this qty of ndau"`
+	String   []string    `arg:"-s,separate" help:"encode this string"`
+	Uint64   []string    `arg:"-u,separate" help:"encode this unsigned integer"`
 }
 
 func (args) Description() string {
@@ -45,45 +44,28 @@ func output(obj interface{}, bytes []byte) {
 	fmt.Printf("%s\n", base64.StdEncoding.EncodeToString(bytes))
 }
 
+func encode(typ string, values []string) {
+	for _, v := range values {
+		bytes, err := sysvar.Encode(typ, v)
+		check(err, "encoding %s", typ)
+		output(v, bytes)
+	}
+}
+
 func main() {
 	var args args
 	arg.MustParse(&args)
 
-	for _, v := range args.Address {
-		bytes, err := v.MarshalMsg(nil)
-		check(err, "msgp marshaling address")
-		output(v.String(), bytes)
-	}
-	for _, v := range args.Bytes {
-		bytes, err := base64.StdEncoding.DecodeString(v)
-		check(err, "decoding base64 encoding")
-		bytes, err = wkt.Bytes(bytes).MarshalMsg(nil)
-		check(err, "msgp marshaling bytes")
-		output(v, bytes)
-	}
-	for _, v := range args.Duration {
-		bytes, err := v.MarshalMsg(nil)
-		check(err, "msgp marshaling duration")
-		output(v, bytes)
-	}
-	for _, v := range args.Int64 {
-		bytes, err := wkt.Int64(v).MarshalMsg(nil)
-		check(err, "msgp marshaling int64")
-		output(v, bytes)
-	}
+	encode("address", args.Address)
+	encode("bytes", args.Bytes)
+	encode("duration", args.Duration)
+	encode("int64", args.Int64)
+	// ndau are given in napu, which sysvar.Encode would take to be decimal ndau
 	for _, v := range args.Ndau {
-		bytes, err := v.MarshalMsg(nil)
-		check(err, "msgp marshaling ndau")
-		output(v, bytes)
-	}
-	for _, v := range args.String {
-		bytes, err := wkt.String(v).MarshalMsg(nil)
-		check(err, "msgp marshaling string")
-		output(v, bytes)
-	}
-	for _, v := range args.Uint64 {
-		bytes, err := wkt.Uint64(v).MarshalMsg(nil)
-		check(err, "msgp marshaling uint64")
+		bytes, err := sysvar.Encode("ndau", v)
+		check(err, "encoding ndau")
 		output(v, bytes)
 	}
+	encode("string", args.String)
+	encode("uint64", args.Uint64)
 }
@@ -0,0 +1,355 @@
+// Package sysvar encodes values for SetSysvar txs.
+//
+// System variables are stored as msgp, and the precise encoding depends on
+// the type of the variable: JSON can't distinguish signed from unsigned
+// integers, or strings from byte arrays. Values can be encoded either as an
+// explicit type, or like an existing encoding of the same variable.
+package sysvar
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ndau/json2msgp"
+	"github.com/ndau/msgp-well-known-types/wkt"
+	"github.com/ndau/ndaumath/pkg/address"
+	math "github.com/ndau/ndaumath/pkg/types"
+	"github.com/pkg/errors"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Types are the explicit types which Encode understands
+var Types = []string{"address", "bytes", "duration", "int64", "ndau", "string", "uint64", "json"}
+
+// CanonicalJSON normalizes a JSON-compatible value into compact JSON with
+// sorted keys
+func CanonicalJSON(v interface{}) (string, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	var out interface{}
+	d := json.NewDecoder(bytes.NewReader(js))
+	d.UseNumber()
+	err = d.Decode(&out)
+	if err != nil {
+		return "", err
+	}
+	js, err = json.Marshal(out)
+	return string(js), err
+}
+
+// MsgpJSON renders msgp-encoded data as canonical JSON, exactly as
+// `ndau sysvar get` does
+func MsgpJSON(data []byte) (string, error) {
+	var buf bytes.Buffer
+	_, err := msgp.UnmarshalAsJSON(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	if buf.Len() == 0 {
+		return `""`, nil
+	}
+	var v interface{}
+	d := json.NewDecoder(&buf)
+	d.UseNumber()
+	err = d.Decode(&v)
+	if err != nil {
+		return "", err
+	}
+	return CanonicalJSON(v)
+}
+
+// Encode encodes a value as an explicit type
+//
+// Scalars may be given as strings or as JSON numbers; JSON numbers are
+// taken to be napu for ndau, and microseconds for durations. The "json"
+// type encodes any JSON-compatible value with json2msgp.
+func Encode(typ string, v interface{}) ([]byte, error) {
+	s := fmt.Sprint(v)
+	switch typ {
+	case "address":
+		a, err := address.Validate(s)
+		if err != nil {
+			return nil, err
+		}
+		return a.MarshalMsg(nil)
+	case "bytes":
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding base64")
+		}
+		return wkt.Bytes(b).MarshalMsg(nil)
+	case "duration":
+		if n, ok := v.(json.Number); ok {
+			i, err := n.Int64()
+			if err != nil {
+				return nil, err
+			}
+			return math.Duration(i).MarshalMsg(nil)
+		}
+		d, err := math.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+		return d.MarshalMsg(nil)
+	case "int64":
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return wkt.Int64(i).MarshalMsg(nil)
+	case "ndau":
+		switch n := v.(type) {
+		case math.Ndau:
+			return n.MarshalMsg(nil)
+		case json.Number:
+			i, err := n.Int64()
+			if err != nil {
+				return nil, err
+			}
+			return math.Ndau(i).MarshalMsg(nil)
+		}
+		n, err := math.ParseNdau(s)
+		if err != nil {
+			return nil, err
+		}
+		return n.MarshalMsg(nil)
+	case "string":
+		return wkt.String(s).MarshalMsg(nil)
+	case "uint64":
+		u, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return wkt.Uint64(u).MarshalMsg(nil)
+	case "json":
+		return convert(v)
+	}
+	return nil, fmt.Errorf("unknown type %q: must be one of %s", typ, strings.Join(Types, ", "))
+}
+
+// EncodeLike encodes v into msgp using the same msgp types as the template
+//
+// This preserves the encoding of existing sysvars: JSON can't distinguish
+// signed from unsigned integers, or strings from byte arrays, but the
+// current on-chain value can. Where the template has no counterpart for part
+// of v, json2msgp's heuristics are used.
+func EncodeLike(template []byte, v interface{}, out []byte) ([]byte, error) {
+	if len(template) == 0 {
+		enc, err := convert(v)
+		return append(out, enc...), err
+	}
+
+	mismatch := func(want string) error {
+		return fmt.Errorf("expected %s, got %T (%v)", want, v, v)
+	}
+
+	switch msgp.NextType(template) {
+	case msgp.MapType:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return out, mismatch("a map")
+		}
+		sz, rest, err := msgp.ReadMapHeaderBytes(template)
+		if err != nil {
+			return out, err
+		}
+		fields := make(map[string][]byte, sz)
+		for i := uint32(0); i < sz; i++ {
+			var key []byte
+			key, rest, err = msgp.ReadMapKeyZC(rest)
+			if err != nil {
+				return out, err
+			}
+			var val []byte
+			val, rest, err = skip(rest)
+			if err != nil {
+				return out, err
+			}
+			fields[string(key)] = val
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out = msgp.AppendMapHeader(out, uint32(len(keys)))
+		for _, k := range keys {
+			out = msgp.AppendString(out, k)
+			out, err = EncodeLike(fields[k], m[k], out)
+			if err != nil {
+				return out, errors.Wrap(err, k)
+			}
+		}
+		return out, nil
+	case msgp.ArrayType:
+		a, ok := v.([]interface{})
+		if !ok {
+			return out, mismatch("an array")
+		}
+		sz, rest, err := msgp.ReadArrayHeaderBytes(template)
+		if err != nil {
+			return out, err
+		}
+		elems := make([][]byte, 0, sz)
+		for i := uint32(0); i < sz; i++ {
+			var val []byte
+			val, rest, err = skip(rest)
+			if err != nil {
+				return out, err
+			}
+			elems = append(elems, val)
+		}
+		out = msgp.AppendArrayHeader(out, uint32(len(a)))
+		for idx, elem := range a {
+			// new elements are encoded like the first existing one
+			var t []byte
+			switch {
+			case idx < len(elems):
+				t = elems[idx]
+			case len(elems) > 0:
+				t = elems[0]
+			}
+			out, err = EncodeLike(t, elem, out)
+			if err != nil {
+				return out, errors.Wrap(err, fmt.Sprintf("[%d]", idx))
+			}
+		}
+		return out, nil
+	case msgp.IntType:
+		n, ok := v.(json.Number)
+		if !ok {
+			return out, mismatch("an integer")
+		}
+		i, err := n.Int64()
+		return msgp.AppendInt64(out, i), err
+	case msgp.UintType:
+		n, ok := v.(json.Number)
+		if !ok {
+			return out, mismatch("an unsigned integer")
+		}
+		u, err := strconv.ParseUint(n.String(), 10, 64)
+		return msgp.AppendUint64(out, u), err
+	case msgp.Float64Type, msgp.Float32Type:
+		n, ok := v.(json.Number)
+		if !ok {
+			return out, mismatch("a number")
+		}
+		f, err := n.Float64()
+		if msgp.NextType(template) == msgp.Float32Type {
+			return msgp.AppendFloat32(out, float32(f)), err
+		}
+		return msgp.AppendFloat64(out, f), err
+	case msgp.StrType:
+		s, ok := v.(string)
+		if !ok {
+			return out, mismatch("a string")
+		}
+		return msgp.AppendString(out, s), nil
+	case msgp.BinType:
+		s, ok := v.(string)
+		if !ok {
+			return out, mismatch("base64-encoded bytes")
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		return msgp.AppendBytes(out, b), err
+	case msgp.BoolType:
+		b, ok := v.(bool)
+		if !ok {
+			return out, mismatch("a boolean")
+		}
+		return msgp.AppendBool(out, b), nil
+	case msgp.NilType:
+		if v == nil {
+			return msgp.AppendNil(out), nil
+		}
+		enc, err := convert(v)
+		return append(out, enc...), err
+	default:
+		// extensions and the like can't be reconstructed from JSON, but
+		// can be kept if they're unchanged
+		tjs, err := MsgpJSON(template)
+		if err != nil {
+			return out, err
+		}
+		vjs, err := CanonicalJSON(v)
+		if err != nil {
+			return out, err
+		}
+		if tjs != vjs {
+			return out, fmt.Errorf("cannot encode changes to msgp %s values", msgp.NextType(template))
+		}
+		return append(out, template...), nil
+	}
+}
+
+// convert encodes v with json2msgp's heuristics
+//
+// json2msgp expects numbers as float64, so json.Numbers are converted first:
+// integers to int64 or uint64, so that they keep their precision, and
+// everything else to float64.
+func convert(v interface{}) ([]byte, error) {
+	var plain func(v interface{}) (interface{}, error)
+	plain = func(v interface{}) (interface{}, error) {
+		switch x := v.(type) {
+		case json.Number:
+			if i, err := x.Int64(); err == nil {
+				return i, nil
+			}
+			if u, err := strconv.ParseUint(x.String(), 10, 64); err == nil {
+				return u, nil
+			}
+			return x.Float64()
+		case map[string]interface{}:
+			m := make(map[string]interface{}, len(x))
+			for k, e := range x {
+				p, err := plain(e)
+				if err != nil {
+					return nil, err
+				}
+				m[k] = p
+			}
+			return m, nil
+		case []interface{}:
+			a := make([]interface{}, len(x))
+			for i, e := range x {
+				p, err := plain(e)
+				if err != nil {
+					return nil, err
+				}
+				a[i] = p
+			}
+			return a, nil
+		}
+		return v, nil
+	}
+	p, err := plain(v)
+	if err != nil {
+		return nil, err
+	}
+	return json2msgp.Convert(p, nil)
+}
+
+// skip returns the next msgp object and the remainder
+func skip(b []byte) ([]byte, []byte, error) {
+	rest, err := msgp.Skip(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b[:len(b)-len(rest)], rest, nil
+}
@@ -0,0 +1,194 @@
+package sysvar
+
+// ----- ---- --- -- -
+// Copyright 2019 Oneiro NA, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/ndau/msgp-well-known-types/wkt"
+	"github.com/ndau/ndaumath/pkg/address"
+	math "github.com/ndau/ndaumath/pkg/types"
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+)
+
+type marshaler interface {
+	MarshalMsg([]byte) ([]byte, error)
+}
+
+// fromJSON decodes JSON the way sysvar state files are decoded
+func fromJSON(t *testing.T, js string) interface{} {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader([]byte(js)))
+	d.UseNumber()
+	require.NoError(t, d.Decode(&v))
+	return v
+}
+
+func TestEncode(t *testing.T) {
+	addr, err := address.Generate(address.KindUser, bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		typ     string
+		v       interface{}
+		want    marshaler
+		wantErr bool
+	}{
+		{"address", "address", addr.String(), addr, false},
+		{"bad address", "address", "ndanotanaddress", nil, true},
+		{"bytes", "bytes", "AAE=", wkt.Bytes{0, 1}, false},
+		{"bad bytes", "bytes", "not base64!", nil, true},
+		{"duration string", "duration", "1d", math.Duration(math.Day), false},
+		{"duration number", "duration", json.Number("60000000"), math.Duration(60 * math.Second), false},
+		{"int64", "int64", "-3", wkt.Int64(-3), false},
+		{"int64 number", "int64", json.Number("-3"), wkt.Int64(-3), false},
+		{"bad int64", "int64", "1.5", nil, true},
+		{"ndau string", "ndau", "1.5", math.Ndau(150000000), false},
+		{"ndau number", "ndau", json.Number("150000000"), math.Ndau(150000000), false},
+		{"ndau typed", "ndau", math.Ndau(7), math.Ndau(7), false},
+		{"string", "string", "hi", wkt.String("hi"), false},
+		{"uint64", "uint64", "4", wkt.Uint64(4), false},
+		{"negative uint64", "uint64", "-4", nil, true},
+		{"unknown type", "float", "1.5", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Encode(tt.typ, tt.v)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			want, err := tt.want.MarshalMsg(nil)
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		})
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	got, err := Encode("json", fromJSON(t, `{"a": [1, "b"]}`))
+	require.NoError(t, err)
+	js, err := MsgpJSON(got)
+	require.NoError(t, err)
+	require.Equal(t, `{"a":[1,"b"]}`, js)
+}
+
+func TestEncodeLike(t *testing.T) {
+	template := func(build func([]byte) []byte) []byte { return build(nil) }
+
+	tests := []struct {
+		name     string
+		template []byte
+		v        string
+		want     []byte
+		wantErr  bool
+	}{
+		{
+			"unsigned stays unsigned",
+			msgp.AppendUint64(nil, 1<<63),
+			`18446744073709551615`,
+			msgp.AppendUint64(nil, 1<<64-1),
+			false,
+		},
+		{
+			"signed stays signed",
+			msgp.AppendInt64(nil, -1),
+			`-1000`,
+			msgp.AppendInt64(nil, -1000),
+			false,
+		},
+		{
+			"bytes stay bytes",
+			msgp.AppendBytes(nil, []byte{0}),
+			`"AAE="`,
+			msgp.AppendBytes(nil, []byte{0, 1}),
+			false,
+		},
+		{
+			"strings stay strings",
+			msgp.AppendString(nil, "a"),
+			`"AAE="`,
+			msgp.AppendString(nil, "AAE="),
+			false,
+		},
+		{
+			"map fields keep their types",
+			template(func(b []byte) []byte {
+				b = msgp.AppendMapHeader(b, 2)
+				b = msgp.AppendString(b, "n")
+				b = msgp.AppendUint64(b, 1)
+				b = msgp.AppendString(b, "s")
+				return msgp.AppendBytes(b, nil)
+			}),
+			`{"s": "AQ==", "n": 5}`,
+			template(func(b []byte) []byte {
+				b = msgp.AppendMapHeader(b, 2)
+				b = msgp.AppendString(b, "n")
+				b = msgp.AppendUint64(b, 5)
+				b = msgp.AppendString(b, "s")
+				return msgp.AppendBytes(b, []byte{1})
+			}),
+			false,
+		},
+		{
+			"new array elements are encoded like the first",
+			template(func(b []byte) []byte {
+				b = msgp.AppendArrayHeader(b, 1)
+				return msgp.AppendUint64(b, 1)
+			}),
+			`[1, 2]`,
+			template(func(b []byte) []byte {
+				b = msgp.AppendArrayHeader(b, 2)
+				b = msgp.AppendUint64(b, 1)
+				return msgp.AppendUint64(b, 2)
+			}),
+			false,
+		},
+		{
+			"type mismatch",
+			msgp.AppendUint64(nil, 1),
+			`"one"`,
+			nil,
+			true,
+		},
+		{
+			"negative unsigned",
+			msgp.AppendUint64(nil, 1<<63),
+			`-1`,
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EncodeLike(tt.template, fromJSON(t, tt.v), nil)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEncodeLikeWithoutTemplate(t *testing.T) {
+	// with nothing to go on, json2msgp's heuristics apply
+	got, err := EncodeLike(nil, fromJSON(t, `{"a": 1, "b": 18446744073709551615}`), nil)
+	require.NoError(t, err)
+	js, err := MsgpJSON(got)
+	require.NoError(t, err)
+	require.Equal(t, `{"a":1,"b":18446744073709551615}`, js)
+}